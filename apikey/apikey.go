@@ -0,0 +1,203 @@
+// Package apikey manages the API keys clients authenticate with. Keys are random and only their
+// SHA-256 hash is stored, so a leaked database does not leak usable keys; their high entropy
+// makes a slow password hash unnecessary.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Client is a partner using the API, stored DNA and stats queries are attributed to it
+type Client struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// Key describes an API key without revealing it
+type Key struct {
+	ID         int64      `json:"id"`
+	ClientID   int64      `json:"client_id"`
+	ClientName string     `json:"client_name"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Errors returned by the functions of this package
+var (
+	ErrInvalidKey    = errors.New("Invalid API key")
+	ErrNotFound      = errors.New("API key not found")
+	ErrInvalidClient = errors.New("Client name is required")
+)
+
+// keyPrefix tells mutants API keys apart from other secrets, such as in secret scanners
+const keyPrefix = "mk_"
+
+// randRead fills keys with random bytes, tests replace it
+var randRead = rand.Read
+
+// Generate returns a new random key, its prefix, which identifies it in listings, and its hash.
+// It fails when the system has no randomness to give, rather than returning a guessable key.
+func Generate() (key, prefix, hashed string, err error) {
+	secret := make([]byte, 24)
+	if _, err := randRead(secret); err != nil {
+		return "", "", "", fmt.Errorf("Could not generate API key: %s", err.Error())
+	}
+
+	key = keyPrefix + hex.EncodeToString(secret)
+	prefix = key[:len(keyPrefix)+8]
+
+	return key, prefix, Hash(key), nil
+}
+
+// Hash returns the hash a key is stored and looked up by
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Create issues a key to the client with given name, the client is created when new. The key is
+// returned once, only its hash is stored.
+func Create(ctx context.Context, db *sql.DB, clientName string) (string, Key, error) {
+	clientName = strings.TrimSpace(clientName)
+	if clientName == "" {
+		return "", Key{}, ErrInvalidClient
+	}
+
+	key, prefix, hashed, err := Generate()
+	if err != nil {
+		return "", Key{}, err
+	}
+
+	created := Key{ClientName: clientName, Prefix: prefix}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", Key{}, errors.New("Failed to create API key")
+	}
+	defer tx.Rollback()
+
+	// The no-op update makes returning work for existing clients as well
+	err = tx.QueryRowContext(ctx,
+		"insert into clients(name) values($1) on conflict (name) do update set name = clients.name returning id",
+		clientName,
+	).Scan(&created.ClientID)
+	if err != nil {
+		return "", Key{}, errors.New("Failed to create client")
+	}
+
+	err = tx.QueryRowContext(ctx,
+		"insert into api_keys(client_id, prefix, hashed) values($1, $2, $3) returning id, created_at",
+		created.ClientID, prefix, hashed,
+	).Scan(&created.ID, &created.CreatedAt)
+	if err != nil {
+		return "", Key{}, errors.New("Failed to create API key")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", Key{}, errors.New("Failed to create API key")
+	}
+
+	return key, created, nil
+}
+
+// List returns every key, newest first
+func List(ctx context.Context, db *sql.DB) ([]Key, error) {
+	rows, err := db.QueryContext(ctx,
+		"select k.id, k.client_id, c.name, k.prefix, k.created_at, k.revoked_at from api_keys k "+
+			"join clients c on c.id = k.client_id order by k.id desc",
+	)
+	if err != nil {
+		return nil, errors.New("Failed to list API keys")
+	}
+	defer rows.Close()
+
+	keys := []Key{}
+	for rows.Next() {
+		var key Key
+		var revokedAt sql.NullTime
+
+		if err := rows.Scan(&key.ID, &key.ClientID, &key.ClientName, &key.Prefix, &key.CreatedAt, &revokedAt); err != nil {
+			return nil, errors.New("Failed to list API keys")
+		}
+
+		if revokedAt.Valid {
+			key.RevokedAt = &revokedAt.Time
+		}
+
+		keys = append(keys, key)
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.New("Failed to list API keys")
+	}
+
+	return keys, nil
+}
+
+// Revoke revokes the key with given prefix
+func Revoke(ctx context.Context, db *sql.DB, prefix string) error {
+	result, err := db.ExecContext(ctx, "update api_keys set revoked_at = now() where prefix = $1 and revoked_at is null", prefix)
+	if err != nil {
+		return errors.New("Failed to revoke API key")
+	}
+
+	return expectAffected(result)
+}
+
+// RevokeClient revokes a client, none of its keys is accepted anymore
+func RevokeClient(ctx context.Context, db *sql.DB, clientName string) error {
+	result, err := db.ExecContext(ctx, "update clients set revoked_at = now() where name = $1 and revoked_at is null", clientName)
+	if err != nil {
+		return errors.New("Failed to revoke client")
+	}
+
+	return expectAffected(result)
+}
+
+func expectAffected(result sql.Result) error {
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// lookup returns the client owning an active key
+func lookup(ctx context.Context, db *sql.DB, key string) (Client, error) {
+	var client Client
+
+	err := db.QueryRowContext(ctx,
+		"select c.id, c.name from api_keys k join clients c on c.id = k.client_id "+
+			"where k.hashed = $1 and k.revoked_at is null and c.revoked_at is null",
+		Hash(key),
+	).Scan(&client.ID, &client.Name)
+	if err == sql.ErrNoRows {
+		return Client{}, ErrInvalidKey
+	}
+
+	return client, err
+}
+
+type clientKey struct{}
+
+// WithClient returns a context carrying the authenticated client
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFromContext returns the client authenticated for the request, if any
+func ClientFromContext(ctx context.Context) (Client, bool) {
+	client, ok := ctx.Value(clientKey{}).(Client)
+
+	return client, ok
+}
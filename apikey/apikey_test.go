@@ -0,0 +1,129 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+func TestGenerate(t *testing.T) {
+	key, prefix, hashed, err := Generate()
+	other, _, _, _ := Generate()
+
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(key, "mk_"))
+	assert.Len(t, key, 51)
+	assert.Equal(t, key[:11], prefix)
+	assert.Equal(t, Hash(key), hashed)
+	assert.Len(t, hashed, 64)
+	assert.NotEqual(t, key, other)
+}
+
+func TestCreateFailsWithoutRandomness(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	defer func(previous func([]byte) (int, error)) { randRead = previous }(randRead)
+	randRead = func([]byte) (int, error) { return 0, errors.New("entropy exhausted") }
+
+	key, _, err := Create(context.Background(), db, "acme")
+
+	assert.Equal(t, "", key)
+	assert.EqualError(t, err, "Could not generate API key: entropy exhausted")
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+// Computed with: printf 'mk_test' | sha256sum
+func TestHash(t *testing.T) {
+	assert.Equal(t, "ee2d3cffda660faaf8a6b91e292b029ce15a08076e7fc733e288239a11a5a46d", Hash("mk_test"))
+}
+
+func TestCreate(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into clients\\(name\\) values\\(\\$1\\) on conflict \\(name\\) do update set name = clients.name returning id").
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.
+		ExpectQuery("insert into api_keys\\(client_id, prefix, hashed\\) values\\(\\$1, \\$2, \\$3\\) returning id, created_at").
+		WithArgs(3, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, now))
+	mock.ExpectCommit()
+
+	key, created, err := Create(context.Background(), db, " acme ")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Key{ID: 7, ClientID: 3, ClientName: "acme", Prefix: key[:11], CreatedAt: now}, created)
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	_, _, err = Create(context.Background(), db, " ")
+	assert.Equal(t, ErrInvalidClient, err)
+}
+
+func TestList(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.
+		ExpectQuery("select k.id, k.client_id, c.name, k.prefix, k.created_at, k.revoked_at from api_keys k join clients c").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "client_id", "name", "prefix", "created_at", "revoked_at"}).
+				AddRow(2, 1, "acme", "mk_0a1b2c3d", now, now).
+				AddRow(1, 1, "acme", "mk_9f8e7d6c", now, nil),
+		)
+
+	keys, err := List(context.Background(), db)
+
+	assert.Nil(t, err)
+	assert.Len(t, keys, 2)
+	assert.Equal(t, now, *keys[0].RevokedAt)
+	assert.Nil(t, keys[1].RevokedAt)
+}
+
+func TestRevoke(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.
+		ExpectExec("update api_keys set revoked_at = now\\(\\) where prefix = \\$1 and revoked_at is null").
+		WithArgs("mk_0a1b2c3d").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("update api_keys").
+		WithArgs("mk_unknown").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	assert.Nil(t, Revoke(context.Background(), db, "mk_0a1b2c3d"))
+	assert.Equal(t, ErrNotFound, Revoke(context.Background(), db, "mk_unknown"))
+}
+
+func TestRevokeClient(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.
+		ExpectExec("update clients set revoked_at = now\\(\\) where name = \\$1 and revoked_at is null").
+		WithArgs("abuser").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.Nil(t, RevokeClient(context.Background(), db, "abuser"))
+}
+
+func TestClientFromContext(t *testing.T) {
+	_, ok := ClientFromContext(context.Background())
+	assert.False(t, ok)
+
+	client, ok := ClientFromContext(WithClient(context.Background(), Client{ID: 1, Name: "acme"}))
+	assert.True(t, ok)
+	assert.Equal(t, Client{ID: 1, Name: "acme"}, client)
+}
@@ -0,0 +1,132 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/logging"
+	"github.com/felipefill/mutants/server"
+	"github.com/felipefill/mutants/utils"
+)
+
+// Header carries the API key of a request
+const Header = "X-Api-Key"
+
+// maxCached bounds the keys remembered by an authenticator, the cache starts over when full
+const maxCached = 1024
+
+// Authenticator resolves keys to clients, results are cached for TTL so most requests do not
+// touch the database. Revoking a key takes up to TTL to reach warm containers.
+type Authenticator struct {
+	TTL time.Duration
+	Now func() time.Time
+	// Stale keeps answering with expired results while the database cannot be reached, for
+	// degraded mode. Keys the container never looked up, as in a cold one, still fail.
+	Stale bool
+
+	mutex sync.Mutex
+	cache map[string]cached
+}
+
+type cached struct {
+	client  Client
+	err     error
+	expires time.Time
+}
+
+// Authenticate returns the client owning given key, or ErrInvalidKey when it is unknown or revoked
+func (authenticator *Authenticator) Authenticate(ctx context.Context, key string) (Client, error) {
+	now := time.Now()
+	if authenticator.Now != nil {
+		now = authenticator.Now()
+	}
+
+	hashed := Hash(key)
+
+	authenticator.mutex.Lock()
+	found, ok := authenticator.cache[hashed]
+	authenticator.mutex.Unlock()
+
+	if ok && now.Before(found.expires) {
+		return found.client, found.err
+	}
+
+	db, err := utils.ConnectDB()
+	if err != nil {
+		return authenticator.fallback(found, ok, err)
+	}
+
+	// An unknown key is an answer, not a failure, it is cached like any other
+	var client Client
+	err = utils.WithRetry(ctx, func(ctx context.Context) error {
+		found, err := lookup(ctx, db, key)
+		if err != nil && err != ErrInvalidKey {
+			return err
+		}
+
+		client = found
+		return nil
+	})
+	if err != nil {
+		return authenticator.fallback(found, ok, utils.DatabaseError(ctx, err, "Failed to look API key up"))
+	}
+
+	result := cached{client: client, expires: now.Add(authenticator.TTL)}
+	if client.ID == 0 {
+		result.err = ErrInvalidKey
+	}
+
+	authenticator.mutex.Lock()
+	if authenticator.cache == nil || len(authenticator.cache) >= maxCached {
+		authenticator.cache = map[string]cached{}
+	}
+	authenticator.cache[hashed] = result
+	authenticator.mutex.Unlock()
+
+	return result.client, result.err
+}
+
+// fallback answers with the expired result of a key when the database could not be reached and
+// stale results are allowed, otherwise with err
+func (authenticator *Authenticator) fallback(expired cached, ok bool, err error) (Client, error) {
+	if authenticator.Stale && ok && errors.Is(err, utils.ErrUnavailable) {
+		return expired.client, expired.err
+	}
+
+	return Client{}, err
+}
+
+// Require wraps a handler so it only runs for requests with a valid key, the client is put in the
+// context for the handler to attribute its work. Requests to public resources, such as probes,
+// are let through.
+func Require(authenticator *Authenticator, handler server.Handler, public ...string) server.Handler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		for _, resource := range public {
+			if request.Resource == resource {
+				return handler(ctx, request)
+			}
+		}
+
+		key := server.HeaderValue(request.Headers, Header)
+		if key == "" {
+			return server.Reject(ctx, request, events.APIGatewayProxyResponse{Body: "Missing API key", StatusCode: 401}, nil), nil
+		}
+
+		client, err := authenticator.Authenticate(ctx, key)
+		if err == ErrInvalidKey {
+			return server.Reject(ctx, request, events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: 401}, nil), nil
+		}
+
+		if err != nil {
+			return server.Reject(ctx, request, events.APIGatewayProxyResponse{Body: "Could not verify API key", StatusCode: 503}, err), nil
+		}
+
+		ctx = WithClient(ctx, client)
+		ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("client_id", client.ID))
+
+		return handler(ctx, request)
+	}
+}
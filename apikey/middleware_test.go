@@ -0,0 +1,159 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+const lookupQuery = "select c.id, c.name from api_keys k join clients c on c.id = k.client_id where k.hashed = \\$1 and k.revoked_at is null and c.revoked_at is null"
+
+func echoClient(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	client, ok := ClientFromContext(ctx)
+	if !ok {
+		return events.APIGatewayProxyResponse{Body: "anonymous", StatusCode: 200}, nil
+	}
+
+	return events.APIGatewayProxyResponse{Body: client.Name, StatusCode: 200}, nil
+}
+
+func withKey(key string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{Resource: "/mutant", Headers: map[string]string{"x-api-key": key}}
+}
+
+func TestRequireLetsValidKeysThrough(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery(lookupQuery).
+		WithArgs(Hash("mk_valid")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(4, "acme"))
+
+	handler := Require(&Authenticator{TTL: time.Minute}, echoClient)
+
+	response, err := handler(context.Background(), withKey("mk_valid"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, events.APIGatewayProxyResponse{Body: "acme", StatusCode: 200}, response)
+
+	// The key is cached, the database is not queried again
+	response, _ = handler(context.Background(), withKey("mk_valid"))
+
+	assert.Equal(t, "acme", response.Body)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRequireRejectsMissingAndUnknownKeys(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery(lookupQuery).
+		WithArgs(Hash("mk_revoked")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	handler := Require(&Authenticator{TTL: time.Minute}, echoClient)
+
+	response, err := handler(context.Background(), events.APIGatewayProxyRequest{Resource: "/mutant"})
+	assert.Nil(t, err)
+	assert.Equal(t, events.APIGatewayProxyResponse{Body: "Missing API key", StatusCode: 401}, response)
+
+	response, err = handler(context.Background(), withKey("mk_revoked"))
+	assert.Nil(t, err)
+	assert.Equal(t, events.APIGatewayProxyResponse{Body: "Invalid API key", StatusCode: 401}, response)
+
+	response, _ = handler(context.Background(), withKey("mk_revoked"))
+	assert.Equal(t, 401, response.StatusCode)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRequireAnswers503WhenKeysCannotBeChecked(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery(lookupQuery).
+		WillReturnError(errors.New("relation \"api_keys\" does not exist"))
+
+	handler := Require(&Authenticator{TTL: time.Minute}, echoClient)
+	response, err := handler(context.Background(), withKey("mk_valid"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, events.APIGatewayProxyResponse{Body: "Could not verify API key", StatusCode: 503}, response)
+}
+
+func TestRequireLetsPublicResourcesThrough(t *testing.T) {
+	handler := Require(&Authenticator{}, echoClient, "/healthz")
+
+	response, _ := handler(context.Background(), events.APIGatewayProxyRequest{Resource: "/healthz"})
+
+	assert.Equal(t, events.APIGatewayProxyResponse{Body: "anonymous", StatusCode: 200}, response)
+}
+
+func TestAuthenticatorCacheExpires(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.ExpectQuery(lookupQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(4, "acme"))
+	mock.ExpectQuery(lookupQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	clock := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	authenticator := &Authenticator{TTL: time.Minute, Now: func() time.Time { return clock }}
+
+	client, err := authenticator.Authenticate(context.Background(), "mk_valid")
+	assert.Nil(t, err)
+	assert.Equal(t, Client{ID: 4, Name: "acme"}, client)
+
+	clock = clock.Add(2 * time.Minute)
+
+	_, err = authenticator.Authenticate(context.Background(), "mk_valid")
+	assert.Equal(t, ErrInvalidKey, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestAuthenticatorAnswersWithExpiredResultsWhileTheDatabaseIsUnavailable(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.ExpectQuery(lookupQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(4, "acme"))
+	mock.ExpectQuery(lookupQuery).WillReturnError(sql.ErrConnDone)
+	mock.ExpectQuery(lookupQuery).WillReturnError(sql.ErrConnDone)
+	mock.ExpectQuery(lookupQuery).WillReturnError(sql.ErrConnDone)
+
+	clock := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	authenticator := &Authenticator{TTL: time.Minute, Now: func() time.Time { return clock }, Stale: true}
+
+	authenticator.Authenticate(context.Background(), "mk_valid")
+	clock = clock.Add(2 * time.Minute)
+
+	client, err := authenticator.Authenticate(context.Background(), "mk_valid")
+	assert.Nil(t, err)
+	assert.Equal(t, Client{ID: 4, Name: "acme"}, client)
+
+	_, err = authenticator.Authenticate(context.Background(), "mk_unseen")
+	assert.True(t, errors.Is(err, utils.ErrUnavailable))
+
+	authenticator.Stale = false
+
+	_, err = authenticator.Authenticate(context.Background(), "mk_valid")
+	assert.True(t, errors.Is(err, utils.ErrUnavailable))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/felipefill/mutants/apikey"
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/utils"
+)
+
+const usage = `Manages the API keys clients authenticate with
+
+Usage:
+  apikeys create -client <name>         Issues a key, the client is created when new
+  apikeys list                          Lists keys, without revealing them
+  apikeys revoke -prefix <prefix>       Revokes a key
+  apikeys revoke-client -client <name>  Revokes every key of a client
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fail(err)
+	}
+
+	config.Set(cfg)
+
+	if err := run(context.Background(), utils.GetDB(), os.Stdout, os.Args[1], os.Args[2:]); err != nil {
+		fail(err)
+	}
+}
+
+func run(ctx context.Context, db *sql.DB, out io.Writer, command string, args []string) error {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	client := flags.String("client", "", "Client name")
+	prefix := flags.String("prefix", "", "Key prefix, as shown by list")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	switch command {
+	case "create":
+		key, created, err := apikey.Create(ctx, db, *client)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "Created key %s for client %s (id %d), it will not be shown again:\n%s\n", created.Prefix, created.ClientName, created.ClientID, key)
+	case "list":
+		keys, err := apikey.List(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "PREFIX\tCLIENT\tCLIENT ID\tCREATED\tREVOKED")
+		for _, key := range keys {
+			revoked := "-"
+			if key.RevokedAt != nil {
+				revoked = key.RevokedAt.Format("2006-01-02 15:04")
+			}
+
+			fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%s\n", key.Prefix, key.ClientName, key.ClientID, key.CreatedAt.Format("2006-01-02 15:04"), revoked)
+		}
+
+		return table.Flush()
+	case "revoke":
+		if err := apikey.Revoke(ctx, db, *prefix); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "Revoked key %s\n", *prefix)
+	case "revoke-client":
+		if err := apikey.RevokeClient(ctx, db, *client); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "Revoked client %s\n", *client)
+	default:
+		return fmt.Errorf("Unknown command %s\n\n%s", command, usage)
+	}
+
+	return nil
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(1)
+}
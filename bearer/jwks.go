@@ -0,0 +1,172 @@
+// Package bearer validates the JWT bearer tokens issued by an OpenID Connect provider and checks
+// the scopes they grant. Signing keys come from a JSON Web Key Set, read from a local file or given
+// inline, so validating a token never calls the provider.
+package bearer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// KeySet holds the public keys tokens are signed with, by key id
+type KeySet struct {
+	keys map[string]key
+}
+
+type key struct {
+	public    crypto.PublicKey
+	algorithm string
+}
+
+// jwk is a JSON Web Key, only the members of RSA and EC public keys are read
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+// StaticKeySet builds a key set from keys already at hand, such as in tests. Any algorithm
+// matching the type of each key is accepted.
+func StaticKeySet(keys map[string]crypto.PublicKey) *KeySet {
+	set := &KeySet{keys: map[string]key{}}
+	for id, public := range keys {
+		set.keys[id] = key{public: public}
+	}
+
+	return set
+}
+
+// ParseKeySet reads a JSON Web Key Set. Keys meant for encryption are skipped, as are key types
+// that cannot verify the algorithms this package supports.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	var document struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, errors.New("Could not parse JWKS")
+	}
+
+	set := &KeySet{keys: map[string]key{}}
+	for _, candidate := range document.Keys {
+		if candidate.Use != "" && candidate.Use != "sig" {
+			continue
+		}
+
+		public, err := candidate.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("Could not read JWKS key %q: %s", candidate.KeyID, err.Error())
+		}
+
+		if public != nil {
+			set.keys[candidate.KeyID] = key{public: public, algorithm: candidate.Algorithm}
+		}
+	}
+
+	if len(set.keys) == 0 {
+		return nil, errors.New("JWKS has no signing keys")
+	}
+
+	return set, nil
+}
+
+// LoadKeySet reads a JSON Web Key Set from a file
+func LoadKeySet(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read JWKS file: %s", err.Error())
+	}
+
+	return ParseKeySet(data)
+}
+
+// Load reads the key set given inline, or else from a file. It returns nil when neither is set,
+// bearer tokens are not accepted then.
+func Load(inline, path string) (*KeySet, error) {
+	switch {
+	case inline != "":
+		return ParseKeySet([]byte(inline))
+	case path != "":
+		return LoadKeySet(path)
+	default:
+		return nil, nil
+	}
+}
+
+func (set *KeySet) lookup(id string) (key, bool) {
+	found, ok := set.keys[id]
+
+	return found, ok
+}
+
+func (candidate jwk) publicKey() (crypto.PublicKey, error) {
+	switch candidate.KeyType {
+	case "RSA":
+		n, err := decodeInt(candidate.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeInt(candidate.E)
+		if err != nil {
+			return nil, err
+		}
+
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch candidate.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, nil
+		}
+
+		x, err := decodeInt(candidate.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeInt(candidate.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(bytes) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+
+	return new(big.Int).SetBytes(bytes), nil
+}
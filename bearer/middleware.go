@@ -0,0 +1,95 @@
+package bearer
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/logging"
+	"github.com/felipefill/mutants/server"
+)
+
+// Scopes granted by tokens
+const (
+	ScopeClassify = "mutants:classify"
+	ScopeStats    = "mutants:stats"
+	ScopeAdmin    = "mutants:admin"
+)
+
+// Require wraps a handler so requests with a bearer token only reach it when the token is valid
+// and grants the scope scopes maps their resource to. Requests without a token go to fallback,
+// such as the handler behind API key authentication, or are rejected when it is nil. Resources
+// missing from scopes, such as probes, are let through.
+func Require(validator *Validator, scopes map[string]string, handler server.Handler, fallback server.Handler) server.Handler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		scope, protected := scopes[request.Resource]
+		if !protected {
+			return handler(ctx, request)
+		}
+
+		token, found := bearerToken(request.Headers)
+		if !found && fallback != nil {
+			return fallback(ctx, request)
+		}
+
+		if token == "" {
+			return reject(ctx, request, 401, `Bearer`, "Missing bearer token", nil), nil
+		}
+
+		claims, err := validator.Validate(token)
+		if err != nil {
+			return reject(ctx, request, 401, `Bearer error="invalid_token"`, "Invalid bearer token", err), nil
+		}
+
+		if !claims.HasScope(scope) {
+			return reject(ctx, request, 403, `Bearer error="insufficient_scope", scope="`+scope+`"`, "Insufficient scope", errors.New("Token lacks the "+scope+" scope")), nil
+		}
+
+		ctx = WithClaims(ctx, claims)
+		ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("subject", claims.Subject))
+
+		return handler(ctx, request)
+	}
+}
+
+// reject answers with a challenge. The reason a token was rejected is logged but not sent back,
+// it would help forging tokens.
+func reject(ctx context.Context, request events.APIGatewayProxyRequest, statusCode int, challenge, body string, reason error) events.APIGatewayProxyResponse {
+	return server.Reject(ctx, request, events.APIGatewayProxyResponse{
+		Body:       body,
+		StatusCode: statusCode,
+		Headers:    map[string]string{"WWW-Authenticate": challenge},
+	}, reason)
+}
+
+// bearerToken reads the Authorization header, found tells whether it uses the Bearer scheme at all
+func bearerToken(headers map[string]string) (string, bool) {
+	scheme, token, _ := strings.Cut(server.HeaderValue(headers, "Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	return strings.TrimSpace(token), true
+}
+
+type claimsKey struct{}
+
+// WithClaims returns a context carrying the claims of the token the request was made with
+func WithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims of the token the request was made with, if any
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(Claims)
+
+	return claims, ok
+}
+
+// Granted tells whether the request was made with a token granting given scope
+func Granted(ctx context.Context, scope string) bool {
+	claims, ok := ClaimsFromContext(ctx)
+
+	return ok && claims.HasScope(scope)
+}
@@ -0,0 +1,93 @@
+package bearer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+var routes = map[string]string{"/mutant": ScopeClassify, "/admin/purge": ScopeAdmin}
+
+func echoSubject(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return events.APIGatewayProxyResponse{Body: "anonymous", StatusCode: 200}, nil
+	}
+
+	return events.APIGatewayProxyResponse{Body: claims.Subject, StatusCode: 200}, nil
+}
+
+func withToken(resource, token string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{Resource: resource, Headers: map[string]string{"authorization": "Bearer " + token}}
+}
+
+func TestRequireLetsGrantedTokensThrough(t *testing.T) {
+	handler := Require(testValidator(), routes, echoSubject, nil)
+
+	response, err := handler(context.Background(), withToken("/mutant", sign(t, "RS256", "rsa", claims(nil))))
+
+	assert.Nil(t, err)
+	assert.Equal(t, events.APIGatewayProxyResponse{Body: "partner-7", StatusCode: 200}, response)
+}
+
+func TestRequireRejectsTokensWithoutTheScope(t *testing.T) {
+	handler := Require(testValidator(), routes, echoSubject, nil)
+
+	response, _ := handler(context.Background(), withToken("/admin/purge", sign(t, "RS256", "rsa", claims(nil))))
+
+	assert.Equal(t, events.APIGatewayProxyResponse{
+		Body:       "Insufficient scope",
+		StatusCode: 403,
+		Headers:    map[string]string{"WWW-Authenticate": `Bearer error="insufficient_scope", scope="mutants:admin"`},
+	}, response)
+}
+
+func TestRequireRejectsInvalidAndMissingTokens(t *testing.T) {
+	handler := Require(testValidator(), routes, echoSubject, nil)
+
+	expired := sign(t, "RS256", "rsa", claims(map[string]interface{}{"exp": now.Add(-2 * time.Hour).Unix()}))
+	response, _ := handler(context.Background(), withToken("/mutant", expired))
+
+	assert.Equal(t, 401, response.StatusCode)
+	assert.Equal(t, "Invalid bearer token", response.Body)
+	assert.Equal(t, `Bearer error="invalid_token"`, response.Headers["WWW-Authenticate"])
+
+	response, _ = handler(context.Background(), events.APIGatewayProxyRequest{Resource: "/mutant"})
+
+	assert.Equal(t, 401, response.StatusCode)
+	assert.Equal(t, "Missing bearer token", response.Body)
+}
+
+func TestRequireFallsBackWithoutToken(t *testing.T) {
+	fallback := func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{Body: "api key", StatusCode: 200}, nil
+	}
+
+	handler := Require(testValidator(), routes, echoSubject, fallback)
+
+	response, _ := handler(context.Background(), events.APIGatewayProxyRequest{Resource: "/mutant", Headers: map[string]string{"X-Api-Key": "mk_valid"}})
+	assert.Equal(t, "api key", response.Body)
+
+	// A token is never handed to the fallback, even an invalid one
+	response, _ = handler(context.Background(), withToken("/mutant", "abc"))
+	assert.Equal(t, 401, response.StatusCode)
+}
+
+func TestRequireLetsUnlistedResourcesThrough(t *testing.T) {
+	handler := Require(testValidator(), routes, echoSubject, nil)
+
+	response, _ := handler(context.Background(), events.APIGatewayProxyRequest{Resource: "/healthz"})
+
+	assert.Equal(t, events.APIGatewayProxyResponse{Body: "anonymous", StatusCode: 200}, response)
+}
+
+func TestGranted(t *testing.T) {
+	ctx := WithClaims(context.Background(), Claims{Scopes: []string{ScopeAdmin}})
+
+	assert.True(t, Granted(ctx, ScopeAdmin))
+	assert.False(t, Granted(ctx, ScopeStats))
+	assert.False(t, Granted(context.Background(), ScopeAdmin))
+}
@@ -0,0 +1,260 @@
+package bearer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Errors returned by Validate, they tell why a token was rejected
+var (
+	ErrMalformed     = errors.New("Malformed bearer token")
+	ErrAlgorithm     = errors.New("Unsupported token algorithm")
+	ErrUnknownKey    = errors.New("Token signed with an unknown key")
+	ErrSignature     = errors.New("Invalid token signature")
+	ErrExpired       = errors.New("Token expired")
+	ErrNotYetValid   = errors.New("Token not valid yet")
+	ErrIssuer        = errors.New("Unexpected token issuer")
+	ErrAudience      = errors.New("Unexpected token audience")
+	ErrMissingExpiry = errors.New("Token has no expiry")
+)
+
+// defaultLeeway tolerates clocks that drift apart from the provider's
+const defaultLeeway = time.Minute
+
+// Claims are the validated claims of a token
+type Claims struct {
+	Subject   string
+	Issuer    string
+	Audience  []string
+	ExpiresAt time.Time
+	Scopes    []string
+}
+
+// HasScope tells whether the token grants given scope
+func (claims Claims) HasScope(scope string) bool {
+	for _, granted := range claims.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validator checks tokens are signed by one of its keys and are meant for this API. Issuer and
+// Audience are only checked when set.
+type Validator struct {
+	Keys     *KeySet
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+	Now      func() time.Time
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// payload holds the registered claims along with the two usual ways of granting scopes: a space
+// separated scope claim, as in RFC 8693, or an scp array
+type payload struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Scope     string          `json:"scope"`
+	Scp       []string        `json:"scp"`
+}
+
+// Validate verifies the signature of a compact JWT and checks its claims
+func (validator *Validator) Validate(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+
+	var head header
+	if err := decodeSegment(parts[0], &head); err != nil {
+		return Claims{}, ErrMalformed
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+
+	found, ok := validator.Keys.lookup(head.KeyID)
+	if !ok {
+		return Claims{}, ErrUnknownKey
+	}
+
+	// The algorithm named by the key wins over the one the token claims, so a token cannot pick a
+	// weaker one
+	if found.algorithm != "" && found.algorithm != head.Algorithm {
+		return Claims{}, ErrAlgorithm
+	}
+
+	if err := verify(head.Algorithm, found.public, parts[0]+"."+parts[1], signature); err != nil {
+		return Claims{}, err
+	}
+
+	var body payload
+	if err := decodeSegment(parts[1], &body); err != nil {
+		return Claims{}, ErrMalformed
+	}
+
+	return validator.check(body)
+}
+
+func (validator *Validator) check(body payload) (Claims, error) {
+	now := time.Now()
+	if validator.Now != nil {
+		now = validator.Now()
+	}
+
+	leeway := validator.Leeway
+	if leeway == 0 {
+		leeway = defaultLeeway
+	}
+
+	if body.ExpiresAt == nil {
+		return Claims{}, ErrMissingExpiry
+	}
+
+	expiresAt := seconds(*body.ExpiresAt)
+	if !now.Before(expiresAt.Add(leeway)) {
+		return Claims{}, ErrExpired
+	}
+
+	if body.NotBefore != nil && now.Add(leeway).Before(seconds(*body.NotBefore)) {
+		return Claims{}, ErrNotYetValid
+	}
+
+	if validator.Issuer != "" && body.Issuer != validator.Issuer {
+		return Claims{}, ErrIssuer
+	}
+
+	audience, err := audiences(body.Audience)
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+
+	if validator.Audience != "" && !contains(audience, validator.Audience) {
+		return Claims{}, ErrAudience
+	}
+
+	scopes := strings.Fields(body.Scope)
+	scopes = append(scopes, body.Scp...)
+
+	return Claims{
+		Subject:   body.Subject,
+		Issuer:    body.Issuer,
+		Audience:  audience,
+		ExpiresAt: expiresAt,
+		Scopes:    scopes,
+	}, nil
+}
+
+// verify checks the signature with the key, which must match the algorithm family. Symmetric
+// algorithms and none are never accepted.
+func verify(algorithm string, public crypto.PublicKey, signed string, signature []byte) error {
+	switch algorithm {
+	case "RS256", "RS384", "RS512":
+		rsaKey, ok := public.(*rsa.PublicKey)
+		if !ok {
+			return ErrAlgorithm
+		}
+
+		hash, digest := digest(algorithm, signed)
+		if rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature) != nil {
+			return ErrSignature
+		}
+	case "ES256", "ES384":
+		ecKey, ok := public.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrAlgorithm
+		}
+
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ErrSignature
+		}
+
+		_, digest := digest(algorithm, signed)
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return ErrSignature
+		}
+	default:
+		return ErrAlgorithm
+	}
+
+	return nil
+}
+
+func digest(algorithm, signed string) (crypto.Hash, []byte) {
+	switch algorithm[2:] {
+	case "384":
+		sum := sha512.Sum384([]byte(signed))
+		return crypto.SHA384, sum[:]
+	case "512":
+		sum := sha512.Sum512([]byte(signed))
+		return crypto.SHA512, sum[:]
+	default:
+		sum := sha256.Sum256([]byte(signed))
+		return crypto.SHA256, sum[:]
+	}
+}
+
+func decodeSegment(segment string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
+
+// audiences reads the aud claim, which is either a string or an array of them
+func audiences(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}, nil
+	}
+
+	var many []string
+	err := json.Unmarshal(raw, &many)
+
+	return many, err
+}
+
+func seconds(value float64) time.Time {
+	return time.Unix(0, int64(value*float64(time.Second)))
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
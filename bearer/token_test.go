@@ -0,0 +1,178 @@
+package bearer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now       = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+)
+
+func sign(t *testing.T, algorithm, keyID string, claims map[string]interface{}) string {
+	head, _ := json.Marshal(map[string]string{"alg": algorithm, "kid": keyID, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(head) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch algorithm {
+	case "RS256":
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		assert.Nil(t, err)
+
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func claims(extra map[string]interface{}) map[string]interface{} {
+	all := map[string]interface{}{
+		"sub":   "partner-7",
+		"iss":   "https://auth.example.com/",
+		"aud":   "mutants",
+		"exp":   now.Add(time.Hour).Unix(),
+		"scope": "mutants:classify mutants:stats",
+	}
+
+	for name, value := range extra {
+		if value == nil {
+			delete(all, name)
+			continue
+		}
+
+		all[name] = value
+	}
+
+	return all
+}
+
+func testValidator() *Validator {
+	return &Validator{
+		Keys:     StaticKeySet(map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}),
+		Issuer:   "https://auth.example.com/",
+		Audience: "mutants",
+		Now:      func() time.Time { return now },
+	}
+}
+
+func TestValidateRS256(t *testing.T) {
+	validated, err := testValidator().Validate(sign(t, "RS256", "rsa", claims(nil)))
+
+	assert.Nil(t, err)
+	assert.Equal(t, Claims{
+		Subject:   "partner-7",
+		Issuer:    "https://auth.example.com/",
+		Audience:  []string{"mutants"},
+		ExpiresAt: time.Unix(now.Add(time.Hour).Unix(), 0),
+		Scopes:    []string{"mutants:classify", "mutants:stats"},
+	}, validated)
+	assert.True(t, validated.HasScope("mutants:stats"))
+	assert.False(t, validated.HasScope("mutants:admin"))
+}
+
+func TestValidateES256WithScpClaim(t *testing.T) {
+	token := sign(t, "ES256", "ec", claims(map[string]interface{}{
+		"scope": nil,
+		"scp":   []string{"mutants:admin"},
+		"aud":   []string{"other", "mutants"},
+	}))
+
+	validated, err := testValidator().Validate(token)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"mutants:admin"}, validated.Scopes)
+}
+
+func TestValidateRejectsBadTokens(t *testing.T) {
+	valid := sign(t, "RS256", "rsa", claims(nil))
+
+	cases := map[string]struct {
+		token string
+		err   error
+	}{
+		"not a jwt":       {"abc", ErrMalformed},
+		"unknown key":     {sign(t, "RS256", "gone", claims(nil)), ErrUnknownKey},
+		"none":            {sign(t, "none", "rsa", claims(nil)), ErrAlgorithm},
+		"wrong key type":  {sign(t, "ES256", "rsa", claims(nil)), ErrAlgorithm},
+		"tampered":        {valid[:len(valid)-4] + "AAAA", ErrSignature},
+		"expired":         {sign(t, "RS256", "rsa", claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), ErrExpired},
+		"no expiry":       {sign(t, "RS256", "rsa", claims(map[string]interface{}{"exp": nil})), ErrMissingExpiry},
+		"not yet valid":   {sign(t, "RS256", "rsa", claims(map[string]interface{}{"nbf": now.Add(5 * time.Minute).Unix()})), ErrNotYetValid},
+		"other issuer":    {sign(t, "RS256", "rsa", claims(map[string]interface{}{"iss": "https://evil.example.com/"})), ErrIssuer},
+		"other audience":  {sign(t, "RS256", "rsa", claims(map[string]interface{}{"aud": "billing"})), ErrAudience},
+		"within leeway":   {sign(t, "RS256", "rsa", claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), nil},
+		"empty signature": {valid[:strings.LastIndex(valid, ".")+1], ErrSignature},
+	}
+
+	for name, test := range cases {
+		_, err := testValidator().Validate(test.token)
+		assert.Equal(t, test.err, err, name)
+	}
+}
+
+func TestParseKeySet(t *testing.T) {
+	encode := func(value *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(value.Bytes())
+	}
+
+	document, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "use": "sig", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(ecKey.X), "y": encode(ecKey.Y)},
+		{"kty": "RSA", "kid": "encryption", "use": "enc", "n": encode(rsaKey.N), "e": "AQAB"},
+		{"kty": "oct", "kid": "shared", "k": "c2VjcmV0"},
+	}})
+
+	keys, err := ParseKeySet(document)
+	assert.Nil(t, err)
+
+	validator := testValidator()
+	validator.Keys = keys
+
+	_, err = validator.Validate(sign(t, "RS256", "rsa", claims(nil)))
+	assert.Nil(t, err)
+
+	_, err = validator.Validate(sign(t, "ES256", "ec", claims(nil)))
+	assert.Nil(t, err)
+
+	_, err = validator.Validate(sign(t, "RS256", "encryption", claims(nil)))
+	assert.Equal(t, ErrUnknownKey, err)
+}
+
+func TestParseKeySetFailures(t *testing.T) {
+	_, err := ParseKeySet([]byte("not json"))
+	assert.EqualError(t, err, "Could not parse JWKS")
+
+	_, err = ParseKeySet([]byte(`{"keys": [{"kty": "oct", "k": "c2VjcmV0"}]}`))
+	assert.EqualError(t, err, "JWKS has no signing keys")
+
+	_, err = ParseKeySet([]byte(`{"keys": [{"kty": "EC", "kid": "bad", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`))
+	assert.EqualError(t, err, `Could not read JWKS key "bad": point is not on the curve`)
+}
+
+func TestLoad(t *testing.T) {
+	keys, err := Load("", "")
+	assert.Nil(t, keys)
+	assert.Nil(t, err)
+
+	_, err = Load("", "/nonexistent/jwks.json")
+	assert.Contains(t, err.Error(), "Could not read JWKS file")
+}
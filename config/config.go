@@ -0,0 +1,466 @@
+// Package config loads the application settings from environment variables and an optional
+// YAML or JSON file, environment variables take precedence over the file
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felipefill/mutants/fingerprint"
+	"github.com/felipefill/mutants/logging"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config holds every setting of the application
+type Config struct {
+	Database  Database  `json:"database" yaml:"database"`
+	Detection Detection `json:"detection" yaml:"detection"`
+	Features  Features  `json:"features" yaml:"features"`
+	Logging   Logging   `json:"logging" yaml:"logging"`
+	Server    Server    `json:"server" yaml:"server"`
+	Tracing   Tracing   `json:"tracing" yaml:"tracing"`
+	Auth      Auth      `json:"auth" yaml:"auth"`
+	RateLimit RateLimit `json:"rate_limit" yaml:"rate_limit"`
+	Limits    Limits    `json:"limits" yaml:"limits"`
+}
+
+// Database holds the connection and pool settings
+type Database struct {
+	Host            string   `json:"host" yaml:"host"`
+	Port            int      `json:"port" yaml:"port"`
+	Name            string   `json:"name" yaml:"name"`
+	User            string   `json:"user" yaml:"user"`
+	Password        string   `json:"password" yaml:"password"`
+	SSLMode         string   `json:"sslmode" yaml:"sslmode"`
+	MaxOpenConns    int      `json:"max_open_conns" yaml:"max_open_conns"`
+	MaxIdleConns    int      `json:"max_idle_conns" yaml:"max_idle_conns"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime" yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime Duration `json:"conn_max_idle_time" yaml:"conn_max_idle_time"`
+	QueryTimeout    Duration `json:"query_timeout" yaml:"query_timeout"`
+	RetryAttempts   int      `json:"retry_attempts" yaml:"retry_attempts"`
+	RetryBaseDelay  Duration `json:"retry_base_delay" yaml:"retry_base_delay"`
+	RetryMaxDelay   Duration `json:"retry_max_delay" yaml:"retry_max_delay"`
+	PendingWrites   string   `json:"pending_writes" yaml:"pending_writes"`
+}
+
+// Detection holds the rules used to tell mutants apart
+type Detection struct {
+	SequenceLength    int    `json:"sequence_length" yaml:"sequence_length"`
+	RequiredSequences int    `json:"required_sequences" yaml:"required_sequences"`
+	HashAlgorithm     string `json:"hash_algorithm" yaml:"hash_algorithm"`
+}
+
+// Features holds toggles for optional behavior
+type Features struct {
+	CanonicalHash bool `json:"canonical_hash" yaml:"canonical_hash"`
+	DegradedMode  bool `json:"degraded_mode" yaml:"degraded_mode"`
+}
+
+// Logging holds the log settings
+type Logging struct {
+	Level string `json:"level" yaml:"level"`
+}
+
+// Server holds the settings of the HTTP server mode
+type Server struct {
+	// Addr makes the functions listen on it instead of running on Lambda, such as ":8080"
+	Addr string `json:"addr" yaml:"addr"`
+}
+
+// Tracing holds where spans are exported to
+type Tracing struct {
+	Exporter string `json:"exporter" yaml:"exporter"`
+	Endpoint string `json:"endpoint" yaml:"endpoint"`
+	Service  string `json:"service" yaml:"service"`
+}
+
+// Auth holds the API key and bearer token settings. Bearer tokens are only accepted when a key
+// set is given, either inline or as a file.
+type Auth struct {
+	Required bool     `json:"required" yaml:"required"`
+	CacheTTL Duration `json:"cache_ttl" yaml:"cache_ttl"`
+	JWKS     string   `json:"jwks" yaml:"jwks"`
+	JWKSFile string   `json:"jwks_file" yaml:"jwks_file"`
+	Issuer   string   `json:"issuer" yaml:"issuer"`
+	Audience string   `json:"audience" yaml:"audience"`
+}
+
+// RateLimit holds the limits each client is held to on the classification endpoint. Store is
+// none, memory for limits per instance, or database for limits shared by every instance.
+type RateLimit struct {
+	Store      string `json:"store" yaml:"store"`
+	PerMinute  int    `json:"per_minute" yaml:"per_minute"`
+	Burst      int    `json:"burst" yaml:"burst"`
+	DailyQuota int    `json:"daily_quota" yaml:"daily_quota"`
+}
+
+// Limits bound the size of the DNA a request can send, MaxSize is the largest N of an NxN DNA
+type Limits struct {
+	MaxBodyBytes int `json:"max_body_bytes" yaml:"max_body_bytes"`
+	MaxSize      int `json:"max_size" yaml:"max_size"`
+	MaxBases     int `json:"max_bases" yaml:"max_bases"`
+}
+
+// Duration is a time.Duration written as "30s" or "5m" in config files
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (duration *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	return duration.parse(value)
+}
+
+// UnmarshalYAML parses a duration string
+func (duration *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+
+	return duration.parse(value)
+}
+
+func (duration *Duration) parse(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*duration = Duration(parsed)
+	return nil
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (err *ValidationError) Error() string {
+	return "Invalid configuration: " + strings.Join(err.Problems, "; ")
+}
+
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+var tracingExporters = []string{"none", "stdout", "otlp"}
+
+var rateLimitStores = []string{"none", "memory", "database"}
+
+// Default returns the settings used when nothing else is given
+func Default() *Config {
+	return &Config{
+		Database: Database{
+			Port:            5432,
+			SSLMode:         "require",
+			MaxOpenConns:    2,
+			MaxIdleConns:    2,
+			ConnMaxLifetime: Duration(5 * time.Minute),
+			ConnMaxIdleTime: Duration(time.Minute),
+			QueryTimeout:    Duration(3 * time.Second),
+			RetryAttempts:   3,
+			RetryBaseDelay:  Duration(50 * time.Millisecond),
+			RetryMaxDelay:   Duration(time.Second),
+			PendingWrites:   "/tmp/mutants-pending-writes.jsonl",
+		},
+		Detection: Detection{
+			SequenceLength:    4,
+			RequiredSequences: 2,
+			HashAlgorithm:     fingerprint.SHA256,
+		},
+		Features: Features{
+			DegradedMode: false,
+		},
+		Logging: Logging{
+			Level: "info",
+		},
+		Tracing: Tracing{
+			Exporter: "none",
+			Service:  "mutants",
+		},
+		Auth: Auth{
+			Required: true,
+			CacheTTL: Duration(time.Minute),
+		},
+		RateLimit: RateLimit{
+			Store:     "memory",
+			PerMinute: 600,
+			Burst:     60,
+		},
+		Limits: Limits{
+			MaxBodyBytes: 2 << 20,
+			MaxSize:      1000,
+			MaxBases:     1000000,
+		},
+	}
+}
+
+// Load reads the file pointed by CONFIG_FILE, if any, then the environment variables,
+// and validates the result reporting every problem at once
+func Load() (*Config, error) {
+	config := Default()
+	problems := []string{}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := config.readFile(path); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	problems = append(problems, config.readEnv()...)
+	problems = append(problems, config.validate()...)
+
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	return config, nil
+}
+
+var (
+	current *Config
+	mutex   sync.Mutex
+)
+
+// Get returns the configuration in use, loading it on first call. A configuration that fails to
+// load is loaded again on the next call. Entry points should still call Load and Set on startup,
+// so that problems are reported before serving.
+func Get() (*Config, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if current == nil {
+		config, err := Load()
+		if err != nil {
+			return nil, err
+		}
+
+		current = config
+	}
+
+	return current, nil
+}
+
+// Set replaces the configuration in use
+func Set(config *Config) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	current = config
+}
+
+// URL returns the connection string for the Postgres driver
+func (database Database) URL() string {
+	connection := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(database.User, database.Password),
+		Host:     fmt.Sprintf("%s:%d", database.Host, database.Port),
+		Path:     "/" + database.Name,
+		RawQuery: "sslmode=" + url.QueryEscape(database.SSLMode),
+	}
+
+	return connection.String()
+}
+
+func (config *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Could not read config file %s", path)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(config)
+	case ".yml", ".yaml":
+		err = yaml.UnmarshalStrict(data, config)
+	default:
+		return fmt.Errorf("Config file %s must be .json, .yml or .yaml", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Could not parse config file %s: %s", path, err.Error())
+	}
+
+	return nil
+}
+
+func (config *Config) readEnv() []string {
+	env := envReader{}
+
+	env.string("DB_HOST", &config.Database.Host)
+	env.int("DB_PORT", &config.Database.Port)
+	env.string("DB_NAME", &config.Database.Name)
+	env.string("DB_USER", &config.Database.User)
+	env.string("DB_PSWD", &config.Database.Password)
+	env.string("DB_SSLMODE", &config.Database.SSLMode)
+	env.int("DB_MAX_OPEN_CONNS", &config.Database.MaxOpenConns)
+	env.int("DB_MAX_IDLE_CONNS", &config.Database.MaxIdleConns)
+	env.duration("DB_CONN_MAX_LIFETIME", &config.Database.ConnMaxLifetime)
+	env.duration("DB_CONN_MAX_IDLE_TIME", &config.Database.ConnMaxIdleTime)
+	env.duration("DB_QUERY_TIMEOUT", &config.Database.QueryTimeout)
+	env.int("DB_RETRY_ATTEMPTS", &config.Database.RetryAttempts)
+	env.duration("DB_RETRY_BASE_DELAY", &config.Database.RetryBaseDelay)
+	env.duration("DB_RETRY_MAX_DELAY", &config.Database.RetryMaxDelay)
+	env.string("DB_PENDING_WRITES", &config.Database.PendingWrites)
+
+	env.int("DNA_SEQUENCE_LENGTH", &config.Detection.SequenceLength)
+	env.int("DNA_REQUIRED_SEQUENCES", &config.Detection.RequiredSequences)
+	env.string("DNA_HASH_ALGORITHM", &config.Detection.HashAlgorithm)
+
+	env.bool("DNA_CANONICAL_HASH", &config.Features.CanonicalHash)
+	env.bool("DNA_DEGRADED_MODE", &config.Features.DegradedMode)
+
+	env.string("LOG_LEVEL", &config.Logging.Level)
+
+	env.string("HTTP_ADDR", &config.Server.Addr)
+
+	env.string("TRACING_EXPORTER", &config.Tracing.Exporter)
+	env.string("OTEL_EXPORTER_OTLP_ENDPOINT", &config.Tracing.Endpoint)
+	env.string("OTEL_SERVICE_NAME", &config.Tracing.Service)
+
+	env.bool("AUTH_REQUIRED", &config.Auth.Required)
+	env.duration("AUTH_CACHE_TTL", &config.Auth.CacheTTL)
+	env.string("AUTH_JWKS", &config.Auth.JWKS)
+	env.string("AUTH_JWKS_FILE", &config.Auth.JWKSFile)
+	env.string("AUTH_ISSUER", &config.Auth.Issuer)
+	env.string("AUTH_AUDIENCE", &config.Auth.Audience)
+
+	env.string("RATE_LIMIT_STORE", &config.RateLimit.Store)
+	env.int("RATE_LIMIT_PER_MINUTE", &config.RateLimit.PerMinute)
+	env.int("RATE_LIMIT_BURST", &config.RateLimit.Burst)
+	env.int("RATE_LIMIT_DAILY_QUOTA", &config.RateLimit.DailyQuota)
+
+	env.int("DNA_MAX_BODY_BYTES", &config.Limits.MaxBodyBytes)
+	env.int("DNA_MAX_SIZE", &config.Limits.MaxSize)
+	env.int("DNA_MAX_BASES", &config.Limits.MaxBases)
+
+	return env.problems
+}
+
+func (config *Config) validate() []string {
+	problems := []string{}
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+
+	database := config.Database
+	check(database.Host != "", "database host is required (DB_HOST)")
+	check(database.Name != "", "database name is required (DB_NAME)")
+	check(database.User != "", "database user is required (DB_USER)")
+	check(database.Password != "", "database password is required (DB_PSWD)")
+	check(database.Port > 0 && database.Port < 65536, fmt.Sprintf("database port %d is out of range", database.Port))
+	check(contains(sslModes, database.SSLMode), fmt.Sprintf("database sslmode must be one of %s", strings.Join(sslModes, ", ")))
+	check(database.MaxOpenConns >= 0, "database max open connections cannot be negative")
+	check(database.MaxIdleConns >= 0, "database max idle connections cannot be negative")
+	check(database.MaxOpenConns == 0 || database.MaxIdleConns <= database.MaxOpenConns, "database max idle connections cannot exceed max open connections")
+	check(database.ConnMaxLifetime >= 0, "database connection max lifetime cannot be negative")
+	check(database.ConnMaxIdleTime >= 0, "database connection max idle time cannot be negative")
+	check(database.QueryTimeout > 0, "database query timeout must be positive")
+	check(database.RetryAttempts >= 1, "database retry attempts must be at least 1")
+	check(database.RetryBaseDelay >= 0 && database.RetryMaxDelay >= 0, "database retry delays cannot be negative")
+	check(!config.Features.DegradedMode || database.PendingWrites != "", "database pending writes file is required in degraded mode (DB_PENDING_WRITES)")
+
+	detection := config.Detection
+	check(detection.SequenceLength >= 2, "detection sequence length must be at least 2")
+	check(detection.RequiredSequences >= 1, "detection required sequences must be at least 1")
+	check(fingerprint.Supported(detection.HashAlgorithm), fmt.Sprintf("detection hash algorithm %s is not supported", detection.HashAlgorithm))
+
+	_, validLevel := logging.ParseLevel(config.Logging.Level)
+	check(validLevel, fmt.Sprintf("log level %s must be one of debug, info, warn, error", config.Logging.Level))
+
+	tracing := config.Tracing
+	check(contains(tracingExporters, tracing.Exporter), fmt.Sprintf("tracing exporter must be one of %s", strings.Join(tracingExporters, ", ")))
+	check(tracing.Exporter != "otlp" || tracing.Endpoint != "", "tracing endpoint is required with the otlp exporter (OTEL_EXPORTER_OTLP_ENDPOINT)")
+
+	check(config.Auth.CacheTTL >= 0, "auth cache ttl cannot be negative")
+	check(config.Auth.JWKS == "" || config.Auth.JWKSFile == "", "auth jwks and jwks file cannot both be set (AUTH_JWKS, AUTH_JWKS_FILE)")
+
+	// Without them any token signed by the provider would do, including ones meant for other APIs
+	bearerTokens := config.Auth.JWKS != "" || config.Auth.JWKSFile != ""
+	check(!bearerTokens || config.Auth.Issuer != "", "auth issuer is required with a jwks (AUTH_ISSUER)")
+	check(!bearerTokens || config.Auth.Audience != "", "auth audience is required with a jwks (AUTH_AUDIENCE)")
+
+	rateLimit := config.RateLimit
+	check(contains(rateLimitStores, rateLimit.Store), fmt.Sprintf("rate limit store must be one of %s", strings.Join(rateLimitStores, ", ")))
+	check(rateLimit.Store == "none" || (rateLimit.PerMinute >= 1 && rateLimit.Burst >= 1), "rate limit per minute and burst must be at least 1")
+	check(rateLimit.DailyQuota >= 0, "rate limit daily quota cannot be negative")
+
+	limits := config.Limits
+	check(limits.MaxBodyBytes >= 1 && limits.MaxSize >= 1 && limits.MaxBases >= 1, "limits on body bytes, size and bases must be at least 1")
+
+	return problems
+}
+
+// envReader parses environment variables into typed fields, collecting problems instead of stopping at the first one
+type envReader struct {
+	problems []string
+}
+
+func (env *envReader) string(name string, target *string) {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		*target = value
+	}
+}
+
+func (env *envReader) int(name string, target *int) {
+	env.parse(name, func(value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err == nil {
+			*target = parsed
+		}
+
+		return err
+	})
+}
+
+func (env *envReader) bool(name string, target *bool) {
+	env.parse(name, func(value string) error {
+		parsed, err := strconv.ParseBool(value)
+		if err == nil {
+			*target = parsed
+		}
+
+		return err
+	})
+}
+
+func (env *envReader) duration(name string, target *Duration) {
+	env.parse(name, func(value string) error {
+		return target.parse(value)
+	})
+}
+
+func (env *envReader) parse(name string, parse func(string) error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return
+	}
+
+	if err := parse(value); err != nil {
+		env.problems = append(env.problems, fmt.Sprintf("%s has an invalid value %q", name, value))
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
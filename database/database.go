@@ -0,0 +1,283 @@
+// Package database holds the schema and the migrations applied on top of it
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/felipefill/mutants/fingerprint"
+	"github.com/felipefill/mutants/matrix"
+)
+
+// batchSize is how many rows Canonicalize and Pack handle per query
+const batchSize = 500
+
+//go:embed create.sql
+var createScript string
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a numbered SQL script, applied once and in order
+type Migration struct {
+	Version int
+	Name    string
+	Script  string
+}
+
+// Migrations returns every known migration sorted by version
+func Migrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []Migration{}
+	for _, entry := range entries {
+		prefix := strings.SplitN(entry.Name(), "_", 2)[0]
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("Migration %s does not start with a version number", entry.Name())
+		}
+
+		script, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: entry.Name(), Script: string(script)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// SchemaVersion returns the version of the last migration shipped with this build
+func SchemaVersion() int {
+	migrations, err := Migrations()
+	if err != nil || len(migrations) == 0 {
+		return 0
+	}
+
+	return migrations[len(migrations)-1].Version
+}
+
+// AppliedVersion returns the version of the last migration applied to the database
+func AppliedVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, "select coalesce(max(version), 0) from schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, errors.New("Failed to retrieve schema version")
+	}
+
+	return version, nil
+}
+
+// Migrate creates the schema and applies pending migrations, it returns how many were applied
+func Migrate(db *sql.DB) (int, error) {
+	if _, err := db.Exec(createScript); err != nil {
+		return 0, errors.New("Failed to create schema")
+	}
+
+	_, err := db.Exec("create table if not exists schema_migrations(version integer primary key, applied_at timestamp not null default now())")
+	if err != nil {
+		return 0, errors.New("Failed to create migrations table")
+	}
+
+	var current int
+	err = db.QueryRow("select coalesce(max(version), 0) from schema_migrations").Scan(&current)
+	if err != nil {
+		return 0, errors.New("Failed to retrieve schema version")
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+
+		if err := apply(db, migration); err != nil {
+			return applied, err
+		}
+
+		applied++
+	}
+
+	return applied, nil
+}
+
+func apply(db *sql.DB, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Failed to apply migration %s", migration.Name)
+	}
+
+	if _, err := tx.Exec(migration.Script); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Failed to apply migration %s", migration.Name)
+	}
+
+	if _, err := tx.Exec("insert into schema_migrations(version) values($1)", migration.Version); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Failed to record migration %s", migration.Name)
+	}
+
+	return tx.Commit()
+}
+
+type storedDNA struct {
+	id  int
+	dna []string
+}
+
+// Canonicalize rehashes rows stored before canonical hashing was enabled using given hash
+// algorithm. Rows that turn out to be transformed duplicates of an already canonical row are
+// removed, since they necessarily share its verdict, so stats count them once from then on. Rows
+// are handled in batches, each in a transaction, so a failure never leaves a batch half rehashed.
+// It returns how many rows were rehashed and removed by the batches that were committed.
+func Canonicalize(db *sql.DB, algorithm string) (rehashed int, removed int, err error) {
+	for {
+		batchRehashed, batchRemoved, more, err := canonicalizeBatch(db, algorithm)
+		if err != nil {
+			return rehashed, removed, err
+		}
+
+		rehashed += batchRehashed
+		removed += batchRemoved
+
+		if !more {
+			return rehashed, removed, nil
+		}
+	}
+}
+
+func canonicalizeBatch(db *sql.DB, algorithm string) (rehashed int, removed int, more bool, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, false, errors.New("Failed to query database")
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("select id, data, packed from dna where not canonical order by id limit $1 for update", batchSize)
+	if err != nil {
+		return 0, 0, false, errors.New("Failed to query database")
+	}
+
+	pending := []storedDNA{}
+	for rows.Next() {
+		var row storedDNA
+		var data, packedData []byte
+
+		if err := rows.Scan(&row.id, &data, &packedData); err != nil {
+			rows.Close()
+			return 0, 0, false, errors.New("Failed to retrieve DNA")
+		}
+
+		packed, err := matrix.Load(packedData, data)
+		if err != nil {
+			rows.Close()
+			return 0, 0, false, fmt.Errorf("DNA %d could not be read", row.id)
+		}
+
+		row.dna = packed.Rows()
+		pending = append(pending, row)
+	}
+	rows.Close()
+
+	for _, row := range pending {
+		hash, err := fingerprint.Sum(algorithm, fingerprint.Canonical(row.dna))
+		if err != nil {
+			return 0, 0, false, err
+		}
+
+		var existing int
+		err = tx.QueryRow("select id from dna where hashed=$1 and id<>$2", hash, row.id).Scan(&existing)
+
+		switch {
+		case err == sql.ErrNoRows:
+			if _, err := tx.Exec("update dna set hashed=$1, hash_algorithm=$2, canonical=true where id=$3", hash, algorithm, row.id); err != nil {
+				return 0, 0, false, fmt.Errorf("Failed to rehash DNA %d", row.id)
+			}
+
+			rehashed++
+		case err == nil:
+			// The metadata of its submissions goes to the row that is kept
+			if _, err := tx.Exec("update dna_metadata set dna_id=$1 where dna_id=$2", existing, row.id); err != nil {
+				return 0, 0, false, fmt.Errorf("Failed to move metadata of duplicate DNA %d", row.id)
+			}
+
+			if _, err := tx.Exec("delete from dna where id=$1", row.id); err != nil {
+				return 0, 0, false, fmt.Errorf("Failed to remove duplicate DNA %d", row.id)
+			}
+
+			removed++
+		default:
+			return 0, 0, false, errors.New("Failed to query database")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, false, errors.New("Failed to commit rehashed DNA")
+	}
+
+	return rehashed, removed, len(pending) == batchSize, nil
+}
+
+// Pack converts rows stored as JSON before DNA was packed, clearing their JSON. The space is
+// reclaimed once the table is vacuumed. Rows are converted in batches, it returns how many were.
+func Pack(db *sql.DB) (int, error) {
+	converted := 0
+
+	for {
+		rows, err := db.Query("select id, data from dna where packed is null order by id limit $1", batchSize)
+		if err != nil {
+			return converted, errors.New("Failed to query database")
+		}
+
+		batch := map[int][]byte{}
+		ids := []int{}
+		for rows.Next() {
+			var id int
+			var data []byte
+
+			if err := rows.Scan(&id, &data); err != nil {
+				rows.Close()
+				return converted, errors.New("Failed to retrieve DNA")
+			}
+
+			packed, err := matrix.Load(nil, data)
+			if err != nil {
+				rows.Close()
+				return converted, fmt.Errorf("DNA %d could not be read", id)
+			}
+
+			batch[id], _ = packed.MarshalBinary()
+			ids = append(ids, id)
+		}
+		rows.Close()
+
+		for _, id := range ids {
+			if _, err := db.Exec("update dna set packed=$1, data=null where id=$2", batch[id], id); err != nil {
+				return converted, fmt.Errorf("Failed to pack DNA %d", id)
+			}
+
+			converted++
+		}
+
+		if len(ids) < batchSize {
+			return converted, nil
+		}
+	}
+}
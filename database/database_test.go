@@ -0,0 +1,256 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/felipefill/mutants/fingerprint"
+	"github.com/felipefill/mutants/matrix"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationsAreSortedAndVersioned(t *testing.T) {
+	migrations, err := Migrations()
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, migrations)
+
+	for i, migration := range migrations {
+		assert.Equal(t, i+1, migration.Version, "Migrations should be numbered without gaps")
+		assert.NotEmpty(t, migration.Script)
+	}
+
+	assert.Equal(t, migrations[len(migrations)-1].Version, SchemaVersion())
+}
+
+func TestAppliedVersion(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.
+		ExpectQuery("select coalesce\\(max\\(version\\), 0\\) from schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.
+		ExpectQuery("select coalesce\\(max\\(version\\), 0\\) from schema_migrations").
+		WillReturnError(errors.New("relation \"schema_migrations\" does not exist"))
+
+	version, err := AppliedVersion(context.Background(), db)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, version)
+
+	_, err = AppliedVersion(context.Background(), db)
+	assert.Equal(t, errors.New("Failed to retrieve schema version"), err)
+}
+
+func TestMigrateAppliesPendingMigrations(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	migrations, _ := Migrations()
+
+	mock.ExpectExec("create table if not exists dna").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("create table if not exists schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectQuery("select coalesce\\(max\\(version\\), 0\\) from schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+
+	for _, migration := range migrations {
+		mock.ExpectBegin()
+		mock.ExpectExec("").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("insert into schema_migrations").WithArgs(migration.Version).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	applied, err := Migrate(db)
+
+	assert.Nil(t, err)
+	assert.Equal(t, len(migrations), applied)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrateSkipsAppliedMigrations(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.ExpectExec("create table if not exists dna").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("create table if not exists schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectQuery("select coalesce\\(max\\(version\\), 0\\) from schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(SchemaVersion()))
+
+	applied, err := Migrate(db)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, applied)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrateRollsBackFailedMigration(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	migrations, _ := Migrations()
+
+	mock.ExpectExec("create table if not exists dna").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("create table if not exists schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectQuery("select coalesce\\(max\\(version\\), 0\\) from schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectExec("").WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	applied, err := Migrate(db)
+
+	assert.Equal(t, errors.New("Failed to apply migration "+migrations[0].Name), err)
+	assert.Equal(t, 0, applied)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestCanonicalize(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	original := []string{"ATCG", "TTGA", "GTAC", "AAAT"}
+	canonicalHash, _ := fingerprint.Sum(fingerprint.SHA256, fingerprint.Canonical(original))
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("select id, data, packed from dna where not canonical order by id limit \\$1 for update").
+		WithArgs(batchSize).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "data", "packed"}).
+				AddRow(1, `["ATCG", "TTGA", "GTAC", "AAAT"]`, nil).
+				AddRow(2, nil, packed([]string{"ATGA", "TTTA", "CGAA", "GACT"})),
+		)
+
+	// The first row is rehashed
+	mock.
+		ExpectQuery("select id from dna where hashed").
+		WithArgs(canonicalHash, 1).
+		WillReturnError(sql.ErrNoRows)
+	mock.
+		ExpectExec("update dna set hashed").
+		WithArgs(canonicalHash, fingerprint.SHA256, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// The second one is its transpose, so it is a duplicate
+	mock.
+		ExpectQuery("select id from dna where hashed").
+		WithArgs(canonicalHash, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.
+		ExpectExec("update dna_metadata set dna_id").
+		WithArgs(1, 2).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.
+		ExpectExec("delete from dna").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rehashed, removed, err := Canonicalize(db, fingerprint.SHA256)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, rehashed)
+	assert.Equal(t, 1, removed)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestCanonicalizeFailsWithInvalidData(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("select id, data, packed from dna where not canonical").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "data", "packed"}).AddRow(7, `{"not": "an array"}`, nil))
+	mock.ExpectRollback()
+
+	_, _, err := Canonicalize(db, fingerprint.SHA256)
+
+	assert.Equal(t, errors.New("DNA 7 could not be read"), err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestCanonicalizeRollsBackFailedBatches(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("select id, data, packed from dna where not canonical").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "data", "packed"}).
+			AddRow(1, `["AT", "GC"]`, nil).
+			AddRow(2, `["AA", "TT"]`, nil))
+	mock.
+		ExpectQuery("select id from dna where hashed").
+		WillReturnError(sql.ErrNoRows)
+	mock.
+		ExpectExec("update dna set hashed").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectQuery("select id from dna where hashed").
+		WillReturnError(sql.ErrNoRows)
+	mock.
+		ExpectExec("update dna set hashed").
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	// The first row was rehashed within the batch, which is rolled back as a whole
+	rehashed, removed, err := Canonicalize(db, fingerprint.SHA256)
+
+	assert.Equal(t, errors.New("Failed to rehash DNA 2"), err)
+	assert.Equal(t, 0, rehashed)
+	assert.Equal(t, 0, removed)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func packed(dna []string) []byte {
+	packed, _ := matrix.PackRows(dna)
+	data, _ := packed.MarshalBinary()
+
+	return data
+}
+
+func TestPack(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.
+		ExpectQuery("select id, data from dna where packed is null order by id limit \\$1").
+		WithArgs(batchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "data"}).
+			AddRow(3, `["ATCG", "TTGA", "GTAC", "AAAT"]`).
+			AddRow(5, `["AT", "GC"]`))
+	mock.
+		ExpectExec("update dna set packed=\\$1, data=null where id=\\$2").
+		WithArgs(packed([]string{"ATCG", "TTGA", "GTAC", "AAAT"}), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("update dna set packed=\\$1, data=null where id=\\$2").
+		WithArgs(packed([]string{"AT", "GC"}), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	converted, err := Pack(db)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, converted)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestPackFailsWithInvalidData(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.
+		ExpectQuery("select id, data from dna where packed is null").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "data"}).AddRow(7, `["ATG"]`))
+
+	_, err := Pack(db)
+
+	assert.Equal(t, errors.New("DNA 7 could not be read"), err)
+}
@@ -0,0 +1,2 @@
+-- Rows hashed from the canonical form of the DNA (smallest rotation or reflection)
+alter table dna add column if not exists canonical boolean not null default false;
@@ -0,0 +1,2 @@
+-- Rows stored before this migration were hashed with SHA1, they are rehashed as they are looked up
+alter table dna add column if not exists hash_algorithm varchar(16) not null default 'sha1';
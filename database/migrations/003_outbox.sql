@@ -0,0 +1,15 @@
+-- Events waiting to be published by the dispatcher, see the outbox package
+create table if not exists outbox(
+  id bigserial primary key,
+  event_type varchar(64) not null,
+  payload jsonb not null,
+  created_at timestamp not null default now(),
+  status varchar(16) not null default 'pending',
+  next_attempt_at timestamp not null default now(),
+  published_at timestamp,
+  attempts integer not null default 0,
+  last_error text
+);
+
+create index if not exists outbox_due on outbox(next_attempt_at) where status = 'pending';
+create index if not exists outbox_published on outbox(published_at) where status = 'published';
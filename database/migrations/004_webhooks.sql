@@ -0,0 +1,24 @@
+-- Subscriptions notified of new mutants and the deliveries made to them, see the webhook package
+create table if not exists webhooks(
+  id serial primary key,
+  url text not null,
+  secret varchar(128) not null,
+  active boolean not null default true,
+  created_at timestamp not null default now()
+);
+
+create table if not exists webhook_deliveries(
+  id bigserial primary key,
+  webhook_id integer not null references webhooks(id) on delete cascade,
+  event_type varchar(64) not null,
+  payload jsonb not null,
+  status varchar(16) not null default 'pending',
+  attempts integer not null default 0,
+  next_attempt_at timestamp not null default now(),
+  last_status_code integer,
+  last_error text,
+  created_at timestamp not null default now(),
+  delivered_at timestamp
+);
+
+create index if not exists webhook_deliveries_due on webhook_deliveries(next_attempt_at) where status = 'pending';
@@ -0,0 +1,28 @@
+-- Clients of the API and their keys, see the apikey package. Keys are stored hashed.
+create table if not exists clients(
+  id serial primary key,
+  name varchar(128) not null unique,
+  created_at timestamp not null default now(),
+  revoked_at timestamp
+);
+
+create table if not exists api_keys(
+  id serial primary key,
+  client_id integer not null references clients(id),
+  prefix varchar(16) not null,
+  hashed varchar(64) not null unique,
+  created_at timestamp not null default now(),
+  revoked_at timestamp
+);
+
+-- DNA is attributed to the client that first submitted it, rows stored before keys existed have none
+alter table dna add column if not exists client_id integer references clients(id);
+create index if not exists dna_client_id on dna(client_id);
+
+create table if not exists stats_queries(
+  id bigserial primary key,
+  client_id integer not null references clients(id),
+  queried_at timestamp not null default now()
+);
+
+create index if not exists stats_queries_client_id on stats_queries(client_id, queried_at);
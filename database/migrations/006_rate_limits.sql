@@ -0,0 +1,9 @@
+-- Token buckets and daily quotas shared by every instance, see the ratelimit package. Keys are
+-- client ids, token subjects or source IPs.
+create table if not exists rate_limits(
+  key varchar(160) primary key,
+  tokens double precision not null,
+  updated_at timestamp not null,
+  quota_day date not null,
+  quota_used integer not null default 0
+);
@@ -0,0 +1,5 @@
+-- DNA is stored packed at 2 bits per base, see the matrix package. Rows stored before keep their
+-- JSON data until they are packed by migrate -pack, every row has one or the other.
+alter table dna add column if not exists packed bytea;
+alter table dna alter column data drop not null;
+alter table dna add constraint dna_data_or_packed check (data is not null or packed is not null);
@@ -0,0 +1,10 @@
+-- Metadata sent with each v2 submission, a stored DNA can be submitted again for other samples
+create table if not exists dna_metadata(
+  id bigserial primary key,
+  dna_id integer not null references dna(id) on delete cascade,
+  client_id integer references clients(id),
+  metadata jsonb not null,
+  submitted_at timestamp not null default now()
+);
+
+create index if not exists dna_metadata_dna_id on dna_metadata(dna_id);
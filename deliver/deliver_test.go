@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/utils"
+	"github.com/felipefill/mutants/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliverAllStopsWhenNothingIsDue(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("select d.id").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "payload", "attempts", "created_at", "url", "secret"}))
+	mock.ExpectRollback()
+
+	attempted, err := deliverAll(context.Background(), &webhook.Deliverer{DB: db})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, attempted)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDeliverAllStopsCloseToTheDeadline(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	attempted, err := deliverAll(ctx, &webhook.Deliverer{DB: db})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, attempted)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestHandlerFailsWithoutDatabase(t *testing.T) {
+	t.Setenv("DB_HOST", "")
+	config.Set(nil)
+	utils.InjectDatabase(nil)
+
+	attempted, err := Handler(context.Background(), events.CloudWatchEvent{})
+
+	assert.Equal(t, 0, attempted)
+	assert.Contains(t, err.Error(), "Could not connect to database")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/logging"
+	"github.com/felipefill/mutants/metrics"
+	"github.com/felipefill/mutants/utils"
+	"github.com/felipefill/mutants/webhook"
+)
+
+// margin is left before the Lambda deadline so the batch in flight can be recorded
+const margin = 15 * time.Second
+
+// Handler runs on a schedule, it attempts due webhook deliveries until none is left or time is
+// short. When the database cannot be had the run fails, the next one tries again.
+func Handler(ctx context.Context, event events.CloudWatchEvent) (int, error) {
+	db, err := utils.ConnectDB()
+	if err != nil {
+		return 0, err
+	}
+
+	deliverer := webhook.Deliverer{DB: db}
+
+	return deliverAll(ctx, &deliverer)
+}
+
+func deliverAll(ctx context.Context, deliverer *webhook.Deliverer) (int, error) {
+	total := 0
+
+	for {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < margin {
+			return total, nil
+		}
+
+		attempted, err := deliverer.DeliverPending(ctx)
+		total += attempted
+
+		if err != nil || attempted == 0 {
+			return total, err
+		}
+	}
+}
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	config.Set(cfg)
+	logging.Configure(cfg.Logging.Level)
+
+	if err := utils.PingDB(context.Background()); err != nil {
+		logging.Default().Error("Startup check failed", "error", err.Error())
+		os.Exit(1)
+	}
+
+	lambda.Start(metrics.WithEMF(Handler))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/outbox"
+	"github.com/felipefill/mutants/utils"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		fail(err)
+	}
+
+	config.Set(cfg)
+
+	sinkName := flag.String("sink", "stdout", "Where events are published: stdout, file, webhook or sqs")
+	path := flag.String("file", "events.jsonl", "File events are appended to, for the file sink")
+	webhookURL := flag.String("url", "", "URL events are posted to, for the webhook sink")
+	queueURL := flag.String("queue-url", "", "Queue URL, for the sqs sink")
+	region := flag.String("region", os.Getenv("AWS_REGION"), "AWS region, for the sqs sink")
+	batchSize := flag.Int("batch", 100, "Events published at a time")
+	maxAttempts := flag.Int("max-attempts", 10, "Attempts before an event is marked failed")
+	retention := flag.Duration("retention", 7*24*time.Hour, "How long published events are kept")
+	interval := flag.Duration("interval", 5*time.Second, "Wait between polls once the outbox is drained")
+	once := flag.Bool("once", false, "Drain the outbox and exit")
+	flag.Parse()
+
+	sink, err := newSink(*sinkName, *path, *webhookURL, *queueURL, *region)
+	if err != nil {
+		fail(err)
+	}
+
+	dispatcher := outbox.Dispatcher{DB: utils.GetDB(), Sink: sink, BatchSize: *batchSize, MaxAttempts: *maxAttempts, Retention: *retention}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if !*once {
+		dispatcher.Run(ctx, *interval, func(err error) {
+			fmt.Fprintln(os.Stderr, err.Error())
+		})
+
+		return
+	}
+
+	total := 0
+	for {
+		published, err := dispatcher.DispatchOnce(ctx)
+		if err != nil {
+			fail(err)
+		}
+
+		total += published
+		if published < *batchSize {
+			break
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Published %d event(s)\n", total)
+}
+
+func newSink(name, path, webhookURL, queueURL, region string) (outbox.Sink, error) {
+	switch name {
+	case "stdout":
+		return outbox.NewStdoutSink(), nil
+	case "file":
+		return outbox.NewFileSink(path)
+	case "webhook":
+		if webhookURL == "" {
+			return nil, fmt.Errorf("The webhook sink needs -url")
+		}
+
+		return &outbox.WebhookSink{URL: webhookURL}, nil
+	case "sqs":
+		if queueURL == "" || region == "" {
+			return nil, fmt.Errorf("The sqs sink needs -queue-url and -region")
+		}
+
+		return &outbox.SQSSink{QueueURL: queueURL, Region: region, Credentials: outbox.CredentialsFromEnv()}, nil
+	default:
+		return nil, fmt.Errorf("Unknown sink %s", name)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/generator"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		fail(err)
+	}
+
+	size := flag.Int("size", 6, "Number of rows and columns of each matrix")
+	count := flag.Int("count", 1, "Number of matrices to generate")
+	seed := flag.Int64("seed", 1, "Seed for the random generator, the same seed yields the same output")
+	horizontal := flag.Int("horizontal", 0, "Number of horizontal sequences in each matrix")
+	vertical := flag.Int("vertical", 0, "Number of vertical sequences in each matrix")
+	diagonal := flag.Int("diagonal", 0, "Number of diagonal sequences in each matrix")
+	probability := flag.Float64("probability", -1, "Probability of each matrix being a mutant, overrides the number of sequences")
+	length := flag.Int("length", cfg.Detection.SequenceLength, "Number of equal bases in a row that make a sequence")
+	flag.Parse()
+
+	gen := generator.New(*seed, *length)
+	encoder := json.NewEncoder(os.Stdout)
+
+	for i := 0; i < *count; i++ {
+		var dna []string
+		var err error
+
+		if *probability >= 0 {
+			dna, _, err = gen.GenerateWithProbability(*size, *probability)
+		} else {
+			dna, err = gen.Generate(*size, generator.Runs{Horizontal: *horizontal, Vertical: *vertical, Diagonal: *diagonal})
+		}
+
+		if err != nil {
+			fail(err)
+		}
+
+		encoder.Encode(generator.Check{DNA: dna})
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(1)
+}
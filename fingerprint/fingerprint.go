@@ -0,0 +1,123 @@
+// Package fingerprint identifies DNA matrices, it is shared by the mutant function and the migration tool
+package fingerprint
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Supported hash algorithms, every one of them fits the 64 characters of the hashed column
+const (
+	SHA1    = "sha1"
+	SHA256  = "sha256"
+	BLAKE2b = "blake2b"
+)
+
+// Algorithms lists every supported hash algorithm, rows may have been stored with any of them
+var Algorithms = []string{SHA256, BLAKE2b, SHA1}
+
+// Supported checks whether given hash algorithm is known
+func Supported(algorithm string) bool {
+	switch algorithm {
+	case SHA1, SHA256, BLAKE2b:
+		return true
+	default:
+		return false
+	}
+}
+
+// Hash returns the legacy SHA1 hash of the rows joined by commas
+func Hash(dna []string) string {
+	hash, _ := Sum(SHA1, dna)
+	return hash
+}
+
+// Sum returns a hash of the rows joined by commas using given algorithm, which must be supported
+func Sum(algorithm string, dna []string) (string, error) {
+	sequenceAsOneString := strings.Join(dna, ",")
+
+	var hasher hash.Hash
+	switch algorithm {
+	case SHA1:
+		hasher = sha1.New()
+	case SHA256:
+		hasher = sha256.New()
+	case BLAKE2b:
+		hasher, _ = blake2b.New256(nil)
+	default:
+		return "", fmt.Errorf("Unknown hash algorithm %s", algorithm)
+	}
+
+	hasher.Write([]byte(sequenceAsOneString))
+
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// Canonical returns the smallest of the 8 rotations and reflections of given NxN matrix,
+// so every transformed duplicate ends up with the same representation
+func Canonical(dna []string) []string {
+	smallest := dna
+	smallestAsOneString := strings.Join(dna, ",")
+
+	for _, transformed := range Transforms(dna) {
+		transformedAsOneString := strings.Join(transformed, ",")
+		if transformedAsOneString < smallestAsOneString {
+			smallest = transformed
+			smallestAsOneString = transformedAsOneString
+		}
+	}
+
+	return smallest
+}
+
+// Transforms returns the 8 symmetries of the square (dihedral group), the first one being the matrix itself
+func Transforms(dna []string) [][]string {
+	transforms := make([][]string, 0, 8)
+	current := dna
+
+	for rotation := 0; rotation < 4; rotation++ {
+		transforms = append(transforms, current, transpose(current))
+		current = rotate(current)
+	}
+
+	return transforms
+}
+
+// transpose swaps rows and columns, which is a reflection over the main diagonal
+func transpose(dna []string) []string {
+	size := len(dna)
+	transposed := make([]string, size)
+
+	for column := 0; column < size; column++ {
+		line := make([]byte, size)
+		for row := 0; row < size; row++ {
+			line[row] = dna[row][column]
+		}
+
+		transposed[column] = string(line)
+	}
+
+	return transposed
+}
+
+// rotate turns the matrix 90 degrees clockwise
+func rotate(dna []string) []string {
+	size := len(dna)
+	rotated := make([]string, size)
+
+	for row := 0; row < size; row++ {
+		line := make([]byte, size)
+		for column := 0; column < size; column++ {
+			line[column] = dna[size-1-column][row]
+		}
+
+		rotated[row] = string(line)
+	}
+
+	return rotated
+}
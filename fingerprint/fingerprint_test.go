@@ -0,0 +1,77 @@
+package fingerprint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var sample = []string{"ATCG", "TTGA", "GTAC", "AAAT"}
+
+func TestHash(t *testing.T) {
+	dna := []string{"ATCGAAA", "TTGATGA", "GTACCCG", "AAATAAG", "AATTGGG", "AAACCCG", "GTTACCC"}
+
+	assert.Equal(t, "f7bad0e12c11a6a23852bee23d64cc753bb51d83", Hash(dna))
+}
+
+func TestSum(t *testing.T) {
+	dna := []string{"ATCGAAA", "TTGATGA", "GTACCCG", "AAATAAG", "AATTGGG", "AAACCCG", "GTTACCC"}
+
+	for algorithm, expected := range map[string]string{
+		SHA1:    "f7bad0e12c11a6a23852bee23d64cc753bb51d83",
+		SHA256:  "51229b46e726787530fae4539740afe767ecd8bede023406dcdea6f59b051c46",
+		BLAKE2b: "b6ca250c47c0935884ff9e5f1fc933e859149883653e0b016a000608eb25dbc5",
+	} {
+		hash, err := Sum(algorithm, dna)
+
+		assert.Nil(t, err, algorithm)
+		assert.Equal(t, expected, hash, algorithm)
+	}
+
+	_, err := Sum("md5", dna)
+	assert.EqualError(t, err, "Unknown hash algorithm md5")
+}
+
+func TestAlgorithmsAreSupported(t *testing.T) {
+	for _, algorithm := range Algorithms {
+		assert.True(t, Supported(algorithm), algorithm)
+	}
+}
+
+func TestSupported(t *testing.T) {
+	assert.Equal(t, true, Supported(SHA1))
+	assert.Equal(t, true, Supported(SHA256))
+	assert.Equal(t, true, Supported(BLAKE2b))
+	assert.Equal(t, false, Supported("md5"))
+	assert.Equal(t, false, Supported(""))
+}
+
+func TestTransforms(t *testing.T) {
+	transforms := Transforms(sample)
+
+	assert.Equal(t, 8, len(transforms))
+	assert.Equal(t, sample, transforms[0])
+	assert.Contains(t, transforms, []string{"ATGA", "TTTA", "CGAA", "GACT"}, "Should contain the transpose")
+	assert.Contains(t, transforms, []string{"AGTA", "ATTT", "AAGC", "TCAG"}, "Should contain the clockwise rotation")
+	assert.Contains(t, transforms, []string{"GCTA", "AGTT", "CATG", "TAAA"}, "Should contain the mirror")
+
+	for i := range transforms {
+		for j := i + 1; j < len(transforms); j++ {
+			assert.NotEqual(t, transforms[i], transforms[j], "Transforms of an asymmetric matrix should differ")
+		}
+	}
+}
+
+func TestCanonicalIsSharedByEveryTransform(t *testing.T) {
+	expected := Canonical(sample)
+
+	for _, transformed := range Transforms(sample) {
+		assert.Equal(t, expected, Canonical(transformed))
+		assert.Equal(t, Hash(expected), Hash(Canonical(transformed)))
+	}
+}
+
+func TestCanonicalIsTheSmallestTransform(t *testing.T) {
+	assert.Equal(t, []string{"AAAT", "GTAC", "TTGA", "ATCG"}, Canonical(sample))
+	assert.Equal(t, []string{}, Canonical([]string{}))
+}
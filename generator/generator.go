@@ -0,0 +1,312 @@
+// Package generator produces synthetic NxN DNA matrices for tests, fixtures and load tests
+package generator
+
+import (
+	"errors"
+	"math/rand"
+)
+
+// Maximum number of tries before giving up on a row or on placing a sequence
+const maxAttempts int = 1000
+
+var bases = []byte{'A', 'T', 'C', 'G'}
+
+// Check mirrors the body accepted by the mutant function
+type Check struct {
+	DNA []string `json:"Dna"`
+}
+
+// Runs holds how many sequences of equal bases exist in each direction
+type Runs struct {
+	Horizontal int
+	Vertical   int
+	Diagonal   int
+}
+
+// Total returns the number of sequences regardless of direction
+func (runs Runs) Total() int {
+	return runs.Horizontal + runs.Vertical + runs.Diagonal
+}
+
+// Generator creates random DNA matrices, the same seed always yields the same matrices
+type Generator struct {
+	rand   *rand.Rand
+	length int
+}
+
+type direction struct {
+	rowStep    int
+	columnStep int
+}
+
+var (
+	right        = direction{0, 1}
+	down         = direction{1, 0}
+	diagonalLeft = direction{1, -1}
+	diagonalDown = direction{1, 1}
+)
+
+// New creates a generator seeded with given value, sequenceLength is the number of equal
+// bases in a row that makes a sequence, as set by the detection rules
+func New(seed int64, sequenceLength int) *Generator {
+	return &Generator{rand: rand.New(rand.NewSource(seed)), length: sequenceLength}
+}
+
+// Generate creates a size x size matrix containing exactly the given runs
+func (generator *Generator) Generate(size int, runs Runs) ([]string, error) {
+	if size < 1 {
+		return nil, errors.New("Size must be greater than zero")
+	}
+
+	if generator.length < 2 {
+		return nil, errors.New("Sequence length must be at least 2")
+	}
+
+	if runs.Horizontal < 0 || runs.Vertical < 0 || runs.Diagonal < 0 {
+		return nil, errors.New("Number of runs cannot be negative")
+	}
+
+	if runs.Total() > 0 && size < generator.length {
+		return nil, errors.New("Matrix is too small to hold a sequence")
+	}
+
+	matrix, err := generator.background(size)
+	if err != nil {
+		return nil, err
+	}
+
+	planted := Runs{}
+	for i := 0; i < runs.Horizontal; i++ {
+		planted.Horizontal++
+		if err := generator.plant(matrix, right, planted); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; i < runs.Vertical; i++ {
+		planted.Vertical++
+		if err := generator.plant(matrix, down, planted); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; i < runs.Diagonal; i++ {
+		planted.Diagonal++
+
+		dir := diagonalDown
+		if generator.rand.Intn(2) == 0 {
+			dir = diagonalLeft
+		}
+
+		if err := generator.plant(matrix, dir, planted); err != nil {
+			return nil, err
+		}
+	}
+
+	return toStrings(matrix), nil
+}
+
+// GenerateWithProbability creates a matrix that is a mutant with given probability,
+// it also returns whether the generated matrix is a mutant
+func (generator *Generator) GenerateWithProbability(size int, probability float64) ([]string, bool, error) {
+	if probability < 0 || probability > 1 {
+		return nil, false, errors.New("Probability must be between 0 and 1")
+	}
+
+	// A mutant has at least two sequences, an ordinary human has at most one
+	mutant := generator.rand.Float64() < probability
+	total := generator.rand.Intn(2)
+	if mutant {
+		total = 2 + generator.rand.Intn(2)
+	}
+
+	if size < generator.length {
+		if mutant {
+			return nil, false, errors.New("Matrix is too small to hold a mutant")
+		}
+
+		total = 0
+	}
+
+	runs := Runs{}
+	for i := 0; i < total; i++ {
+		switch generator.rand.Intn(3) {
+		case 0:
+			runs.Horizontal++
+		case 1:
+			runs.Vertical++
+		default:
+			runs.Diagonal++
+		}
+	}
+
+	dna, err := generator.Generate(size, runs)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return dna, mutant, nil
+}
+
+// CountRuns counts sequences of sequenceLength equal bases the same way the mutant function does,
+// so a run one base longer than a sequence counts as two sequences
+func CountRuns(dna []string, sequenceLength int) Runs {
+	matrix := make([][]byte, len(dna))
+	for row := range dna {
+		matrix[row] = []byte(dna[row])
+	}
+
+	return countRuns(matrix, sequenceLength)
+}
+
+func countRuns(matrix [][]byte, sequenceLength int) Runs {
+	runs := Runs{}
+
+	for row := range matrix {
+		for column := range matrix[row] {
+			if hasSequence(matrix, row, column, right, sequenceLength) {
+				runs.Horizontal++
+			}
+
+			if hasSequence(matrix, row, column, down, sequenceLength) {
+				runs.Vertical++
+			}
+
+			if hasSequence(matrix, row, column, diagonalLeft, sequenceLength) {
+				runs.Diagonal++
+			}
+
+			if hasSequence(matrix, row, column, diagonalDown, sequenceLength) {
+				runs.Diagonal++
+			}
+		}
+	}
+
+	return runs
+}
+
+func hasSequence(matrix [][]byte, row, column int, dir direction, sequenceLength int) bool {
+	lastRow := row + dir.rowStep*(sequenceLength-1)
+	lastColumn := column + dir.columnStep*(sequenceLength-1)
+
+	if !inBounds(matrix, row, column) || !inBounds(matrix, lastRow, lastColumn) {
+		return false
+	}
+
+	requiredBase := matrix[row][column]
+	for step := 1; step < sequenceLength; step++ {
+		if matrix[row+dir.rowStep*step][column+dir.columnStep*step] != requiredBase {
+			return false
+		}
+	}
+
+	return true
+}
+
+// background fills a matrix with random bases without any sequence in it
+func (generator *Generator) background(size int) ([][]byte, error) {
+	matrix := make([][]byte, size)
+
+	for row := 0; row < size; row++ {
+		matrix[row] = make([]byte, size)
+
+		filled := false
+		for attempt := 0; attempt < maxAttempts && !filled; attempt++ {
+			filled = generator.fillRow(matrix, row)
+		}
+
+		if !filled {
+			return nil, errors.New("Could not generate a matrix without sequences")
+		}
+	}
+
+	return matrix, nil
+}
+
+// fillRow picks a base for each column that does not complete a sequence,
+// it fails when every base is forbidden and the row must be started over
+func (generator *Generator) fillRow(matrix [][]byte, row int) bool {
+	for column := range matrix[row] {
+		allowed := make([]byte, 0, len(bases))
+
+		for _, base := range bases {
+			matrix[row][column] = base
+			if !endsSequence(matrix, row, column, generator.length) {
+				allowed = append(allowed, base)
+			}
+		}
+
+		if len(allowed) == 0 {
+			return false
+		}
+
+		matrix[row][column] = allowed[generator.rand.Intn(len(allowed))]
+	}
+
+	return true
+}
+
+// endsSequence checks whether given cell is the last one of a sequence, considering only filled cells
+func endsSequence(matrix [][]byte, row, column, sequenceLength int) bool {
+	back := sequenceLength - 1
+
+	return hasSequence(matrix, row, column-back, right, sequenceLength) ||
+		hasSequence(matrix, row-back, column, down, sequenceLength) ||
+		hasSequence(matrix, row-back, column+back, diagonalLeft, sequenceLength) ||
+		hasSequence(matrix, row-back, column-back, diagonalDown, sequenceLength)
+}
+
+// plant writes a sequence in given direction at a random spot, it only keeps it
+// when the matrix ends up with exactly the expected runs
+func (generator *Generator) plant(matrix [][]byte, dir direction, expected Runs) error {
+	size := len(matrix)
+	span := generator.length - 1
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		row := generator.rand.Intn(size - span*dir.rowStep)
+		column := generator.rand.Intn(size - span*abs(dir.columnStep))
+		if dir.columnStep < 0 {
+			column += span
+		}
+
+		base := bases[generator.rand.Intn(len(bases))]
+		previous := make([]byte, generator.length)
+
+		for step := 0; step < generator.length; step++ {
+			r, c := row+dir.rowStep*step, column+dir.columnStep*step
+			previous[step] = matrix[r][c]
+			matrix[r][c] = base
+		}
+
+		if countRuns(matrix, generator.length) == expected {
+			return nil
+		}
+
+		for step := 0; step < generator.length; step++ {
+			matrix[row+dir.rowStep*step][column+dir.columnStep*step] = previous[step]
+		}
+	}
+
+	return errors.New("Could not place the requested sequences")
+}
+
+func inBounds(matrix [][]byte, row, column int) bool {
+	return row >= 0 && row < len(matrix) && column >= 0 && column < len(matrix[row])
+}
+
+func toStrings(matrix [][]byte) []string {
+	dna := make([]string, len(matrix))
+	for row := range matrix {
+		dna[row] = string(matrix[row])
+	}
+
+	return dna
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
@@ -0,0 +1,131 @@
+package generator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sequenceLength matches the default detection rule
+const sequenceLength = 4
+
+func TestGenerateIsNxN(t *testing.T) {
+	dna, err := New(1, sequenceLength).Generate(9, Runs{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 9, len(dna))
+	for _, row := range dna {
+		assert.Equal(t, 9, len(row))
+	}
+}
+
+func TestGenerateOnlyUsesValidBases(t *testing.T) {
+	dna, _ := New(2, sequenceLength).Generate(12, Runs{Horizontal: 1, Vertical: 1, Diagonal: 1})
+
+	for _, row := range dna {
+		for _, base := range row {
+			assert.Contains(t, "ATCG", string(base))
+		}
+	}
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	first, _ := New(42, sequenceLength).Generate(10, Runs{Horizontal: 2, Diagonal: 1})
+	second, _ := New(42, sequenceLength).Generate(10, Runs{Horizontal: 2, Diagonal: 1})
+	other, _ := New(43, sequenceLength).Generate(10, Runs{Horizontal: 2, Diagonal: 1})
+
+	assert.Equal(t, first, second)
+	assert.NotEqual(t, first, other)
+}
+
+func TestGenerateHasExactlyTheRequestedRuns(t *testing.T) {
+	requested := []Runs{
+		{},
+		{Horizontal: 1},
+		{Vertical: 1},
+		{Diagonal: 1},
+		{Horizontal: 1, Vertical: 1},
+		{Horizontal: 2, Vertical: 2, Diagonal: 2},
+	}
+
+	for seed, runs := range requested {
+		for _, size := range []int{6, 7, 8, 15} {
+			dna, err := New(int64(seed), sequenceLength).Generate(size, runs)
+
+			assert.Nil(t, err)
+			assert.Equal(t, runs, CountRuns(dna, sequenceLength), "Runs do not match for size %d", size)
+		}
+	}
+}
+
+func TestGenerateFailsWithInvalidArguments(t *testing.T) {
+	generator := New(1, sequenceLength)
+
+	_, err := generator.Generate(0, Runs{})
+	assert.Equal(t, errors.New("Size must be greater than zero"), err)
+
+	_, err = generator.Generate(5, Runs{Vertical: -1})
+	assert.Equal(t, errors.New("Number of runs cannot be negative"), err)
+
+	_, err = generator.Generate(3, Runs{Horizontal: 1})
+	assert.Equal(t, errors.New("Matrix is too small to hold a sequence"), err)
+
+	_, err = New(1, 1).Generate(5, Runs{})
+	assert.Equal(t, errors.New("Sequence length must be at least 2"), err)
+}
+
+func TestGenerateWithProbability(t *testing.T) {
+	generator := New(7, sequenceLength)
+	mutants := 0
+
+	for i := 0; i < 200; i++ {
+		dna, mutant, err := generator.GenerateWithProbability(8, 0.25)
+		assert.Nil(t, err)
+		assert.Equal(t, mutant, CountRuns(dna, sequenceLength).Total() > 1)
+
+		if mutant {
+			mutants++
+		}
+	}
+
+	assert.InDelta(t, 50, mutants, 20)
+}
+
+func TestGenerateWithProbabilityExtremes(t *testing.T) {
+	generator := New(3, sequenceLength)
+
+	_, mutant, _ := generator.GenerateWithProbability(6, 1)
+	assert.Equal(t, true, mutant)
+
+	_, mutant, _ = generator.GenerateWithProbability(6, 0)
+	assert.Equal(t, false, mutant)
+
+	_, _, err := generator.GenerateWithProbability(6, 1.5)
+	assert.Equal(t, errors.New("Probability must be between 0 and 1"), err)
+
+	_, _, err = generator.GenerateWithProbability(3, 1)
+	assert.Equal(t, errors.New("Matrix is too small to hold a mutant"), err)
+}
+
+func TestCountRuns(t *testing.T) {
+	dna := []string{"AAAAA", "CTGCT", "GCTGA", "TGACG", "CATGC"}
+
+	// The five A's in the first row overlap into two sequences
+	assert.Equal(t, Runs{Horizontal: 2}, CountRuns(dna, sequenceLength))
+	assert.Equal(t, Runs{Diagonal: 1}, CountRuns([]string{"ATCG", "CAGT", "GTAC", "TCGA"}, sequenceLength))
+}
+
+func TestGenerateFollowsTheSequenceLength(t *testing.T) {
+	runs := Runs{Horizontal: 1, Vertical: 1, Diagonal: 1}
+
+	for _, length := range []int{3, 5} {
+		dna, err := New(5, length).Generate(8, runs)
+
+		assert.Nil(t, err)
+		assert.Equal(t, runs, CountRuns(dna, length), "Runs do not match for length %d", length)
+	}
+
+	_, err := New(5, 5).Generate(4, runs)
+	assert.Equal(t, errors.New("Matrix is too small to hold a sequence"), err)
+}
@@ -0,0 +1,125 @@
+// Package health runs the checks behind the readiness endpoint and reports their outcome
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/felipefill/mutants/database"
+)
+
+// Statuses of a report
+const (
+	StatusOK          = "ok"
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+	StatusFailed      = "failed"
+)
+
+// Check is a named dependency check. When a check that is not critical fails the service is
+// reported as degraded instead of unavailable, since it can still answer.
+type Check struct {
+	Name     string
+	Critical bool
+	Run      func(ctx context.Context) error
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status" enum:"ok,failed"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// Report is the outcome of every check
+type Report struct {
+	Status string   `json:"status" enum:"ok,degraded,unavailable"`
+	Checks []Result `json:"checks"`
+}
+
+// StatusCode is 503 when a critical check failed, so load balancers stop sending requests
+func (report Report) StatusCode() int {
+	if report.Status == StatusUnavailable {
+		return 503
+	}
+
+	return 200
+}
+
+// Run runs the checks in order, each one bounded by timeout
+func Run(ctx context.Context, timeout time.Duration, checks ...Check) Report {
+	report := Report{Status: StatusOK, Checks: []Result{}}
+
+	for _, check := range checks {
+		result := run(ctx, timeout, check)
+		report.Checks = append(report.Checks, result)
+
+		if result.Status == StatusOK {
+			continue
+		}
+
+		if check.Critical {
+			report.Status = StatusUnavailable
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+
+	return report
+}
+
+func run(ctx context.Context, timeout time.Duration, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Run(ctx)
+	result := Result{Name: check.Name, Status: StatusOK, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+
+	if err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// Database pings the database through the pool connect returns
+func Database(connect func() (*sql.DB, error), critical bool) Check {
+	return Check{Name: "database", Critical: critical, Run: func(ctx context.Context) error {
+		db, err := connect()
+		if err != nil {
+			return err
+		}
+
+		if err := db.PingContext(ctx); err != nil {
+			return fmt.Errorf("Could not reach database: %s", err.Error())
+		}
+
+		return nil
+	}}
+}
+
+// Schema confirms every migration this build ships with was applied
+func Schema(connect func() (*sql.DB, error), critical bool) Check {
+	return Check{Name: "schema", Critical: critical, Run: func(ctx context.Context) error {
+		db, err := connect()
+		if err != nil {
+			return err
+		}
+
+		applied, err := database.AppliedVersion(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		if expected := database.SchemaVersion(); applied < expected {
+			return fmt.Errorf("Schema is at version %d, this build needs version %d", applied, expected)
+		}
+
+		return nil
+	}}
+}
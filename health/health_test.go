@@ -0,0 +1,89 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/felipefill/mutants/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func passing(name string, critical bool) Check {
+	return Check{Name: name, Critical: critical, Run: func(ctx context.Context) error { return nil }}
+}
+
+func failing(name string, critical bool) Check {
+	return Check{Name: name, Critical: critical, Run: func(ctx context.Context) error { return errors.New("Broken") }}
+}
+
+func TestRunReportsEveryCheck(t *testing.T) {
+	report := Run(context.Background(), time.Second, passing("database", true), passing("self_test", true))
+
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Equal(t, 200, report.StatusCode())
+	assert.Len(t, report.Checks, 2)
+	assert.Equal(t, "database", report.Checks[0].Name)
+	assert.Equal(t, StatusOK, report.Checks[0].Status)
+}
+
+func TestRunWithFailedChecks(t *testing.T) {
+	report := Run(context.Background(), time.Second, failing("database", false), passing("self_test", true))
+
+	assert.Equal(t, StatusDegraded, report.Status)
+	assert.Equal(t, 200, report.StatusCode())
+	assert.Equal(t, Result{Name: "database", Status: StatusFailed, Error: "Broken", LatencyMS: report.Checks[0].LatencyMS}, report.Checks[0])
+
+	report = Run(context.Background(), time.Second, failing("database", false), failing("self_test", true))
+
+	assert.Equal(t, StatusUnavailable, report.Status)
+	assert.Equal(t, 503, report.StatusCode())
+}
+
+func TestRunBoundsChecks(t *testing.T) {
+	slow := Check{Name: "slow", Critical: true, Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	report := Run(context.Background(), time.Millisecond, slow)
+
+	assert.Equal(t, StatusUnavailable, report.Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks[0].Error)
+}
+
+func TestDatabase(t *testing.T) {
+	db, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer db.Close()
+
+	mock.ExpectPing()
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+
+	check := Database(func() (*sql.DB, error) { return db, nil }, true)
+
+	assert.Nil(t, check.Run(context.Background()))
+	assert.Equal(t, errors.New("Could not reach database: connection refused"), check.Run(context.Background()))
+
+	check = Database(func() (*sql.DB, error) { return nil, errors.New("Invalid configuration") }, true)
+	assert.Equal(t, errors.New("Invalid configuration"), check.Run(context.Background()))
+}
+
+func TestSchema(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.
+		ExpectQuery("select coalesce\\(max\\(version\\), 0\\) from schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(database.SchemaVersion()))
+	mock.
+		ExpectQuery("select coalesce\\(max\\(version\\), 0\\) from schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+
+	check := Schema(func() (*sql.DB, error) { return db, nil }, true)
+
+	assert.Nil(t, check.Run(context.Background()))
+	assert.Contains(t, check.Run(context.Background()).Error(), "Schema is at version 1, this build needs version")
+}
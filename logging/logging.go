@@ -0,0 +1,134 @@
+// Package logging writes structured JSON logs. Handlers log one line per request with its
+// outcome, the logger in use can be replaced so tests can assert on what was logged.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+var (
+	current = New(os.Stdout, slog.LevelInfo)
+	mutex   sync.RWMutex
+)
+
+type contextKey struct{}
+
+// New creates a JSON logger writing to given writer
+func New(writer io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(writer, &slog.HandlerOptions{Level: level}))
+}
+
+// ParseLevel reads a level name: debug, info, warn or error
+func ParseLevel(name string) (slog.Level, bool) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.ToUpper(name)))
+
+	return level, err == nil
+}
+
+// Configure makes the logger in use write to the standard output at given level
+func Configure(levelName string) {
+	level, _ := ParseLevel(levelName)
+
+	SetDefault(New(os.Stdout, level))
+}
+
+// Default returns the logger in use
+func Default() *slog.Logger {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return current
+}
+
+// SetDefault replaces the logger in use
+func SetDefault(logger *slog.Logger) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	current = logger
+}
+
+// WithLogger returns a context carrying given logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by the context, or the default one
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return Default()
+}
+
+// ForRequest derives a logger tagged with the API Gateway and Lambda request IDs from the one in
+// ctx, the returned context carries it so everything logged while handling the request is tagged
+func ForRequest(ctx context.Context, request events.APIGatewayProxyRequest) (context.Context, *slog.Logger) {
+	logger := FromContext(ctx).With(
+		"request_id", request.RequestContext.RequestID,
+		"method", request.HTTPMethod,
+		"path", request.Path,
+	)
+
+	if lambdaContext, ok := lambdacontext.FromContext(ctx); ok {
+		logger = logger.With("lambda_request_id", lambdaContext.AwsRequestID)
+	}
+
+	return WithLogger(ctx, logger), logger
+}
+
+// Outcome collects the details of a request and logs them once it is done
+type Outcome struct {
+	logger *slog.Logger
+	start  time.Time
+	attrs  []any
+	err    error
+}
+
+// Start begins timing a request
+func Start(logger *slog.Logger) *Outcome {
+	return &Outcome{logger: logger, start: time.Now()}
+}
+
+// Set adds a detail to the log line
+func (outcome *Outcome) Set(key string, value any) {
+	outcome.attrs = append(outcome.attrs, key, value)
+}
+
+// Fail records the error behind a failed request
+func (outcome *Outcome) Fail(err error) {
+	outcome.err = err
+}
+
+// Done logs the request, at error level for server errors and warn level for other failures
+func (outcome *Outcome) Done(status int) {
+	attrs := append([]any{
+		"status", status,
+		"latency_ms", float64(time.Since(outcome.start).Microseconds()) / 1000,
+	}, outcome.attrs...)
+
+	if outcome.err != nil {
+		attrs = append(attrs, "error", outcome.err.Error())
+	}
+
+	level := slog.LevelInfo
+	switch {
+	case status >= 500:
+		level = slog.LevelError
+	case outcome.err != nil:
+		level = slog.LevelWarn
+	}
+
+	outcome.logger.Log(context.Background(), level, "request", attrs...)
+}
@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/stretchr/testify/assert"
+)
+
+func useBuffer(t *testing.T) *bytes.Buffer {
+	previous := Default()
+	buffer := &bytes.Buffer{}
+
+	SetDefault(New(buffer, slog.LevelInfo))
+	t.Cleanup(func() { SetDefault(previous) })
+
+	return buffer
+}
+
+func lastLine(t *testing.T, buffer *bytes.Buffer) map[string]interface{} {
+	lines := bytes.Split(bytes.TrimSpace(buffer.Bytes()), []byte("\n"))
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(lines[len(lines)-1], &line); err != nil {
+		t.Fatal(err)
+	}
+
+	return line
+}
+
+func TestForRequestTagsRequestIDs(t *testing.T) {
+	buffer := useBuffer(t)
+
+	request := events.APIGatewayProxyRequest{
+		HTTPMethod:     "POST",
+		Path:           "/mutant",
+		RequestContext: events.APIGatewayProxyRequestContext{RequestID: "api-123"},
+	}
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "lambda-456"})
+
+	ctx, _ = ForRequest(ctx, request)
+	FromContext(ctx).Info("Something happened")
+
+	line := lastLine(t, buffer)
+	assert.Equal(t, "api-123", line["request_id"])
+	assert.Equal(t, "lambda-456", line["lambda_request_id"])
+	assert.Equal(t, "POST", line["method"])
+	assert.Equal(t, "/mutant", line["path"])
+}
+
+func TestFromContextFallsBackToDefault(t *testing.T) {
+	buffer := useBuffer(t)
+
+	FromContext(context.Background()).Info("Plain")
+
+	assert.Equal(t, "Plain", lastLine(t, buffer)["msg"])
+}
+
+func TestOutcomeLevels(t *testing.T) {
+	buffer := useBuffer(t)
+
+	outcome := Start(Default())
+	outcome.Set("size", 6)
+	outcome.Done(200)
+
+	line := lastLine(t, buffer)
+	assert.Equal(t, "INFO", line["level"])
+	assert.Equal(t, "request", line["msg"])
+	assert.Equal(t, float64(200), line["status"])
+	assert.Equal(t, float64(6), line["size"])
+	assert.Contains(t, line, "latency_ms")
+
+	outcome = Start(Default())
+	outcome.Fail(errors.New("Invalid DNA"))
+	outcome.Done(400)
+
+	line = lastLine(t, buffer)
+	assert.Equal(t, "WARN", line["level"])
+	assert.Equal(t, "Invalid DNA", line["error"])
+
+	outcome = Start(Default())
+	outcome.Done(500)
+
+	assert.Equal(t, "ERROR", lastLine(t, buffer)["level"])
+}
+
+func TestParseLevel(t *testing.T) {
+	level, ok := ParseLevel("debug")
+	assert.True(t, ok)
+	assert.Equal(t, slog.LevelDebug, level)
+
+	_, ok = ParseLevel("loud")
+	assert.False(t, ok)
+}
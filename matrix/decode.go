@@ -0,0 +1,573 @@
+package matrix
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDepth bounds the nesting of the values skipped next to the DNA
+const maxDepth = 1000
+
+// Decode reads a DNA check, a JSON object with the rows under its dna key, checking rows byte by
+// byte as they are read. Bases are written straight into the matrix, which is sized as soon as the
+// first row tells N. Input over the limits, not a table or with invalid bases is rejected as soon
+// as it is seen, without reading further. As with json.Unmarshal, the dna key is matched
+// regardless of case, other keys are ignored and null stands for an empty DNA.
+func Decode(reader io.Reader, limits Limits) (*Matrix, error) {
+	return DecodeFields(reader, limits, nil)
+}
+
+// Field is handed every key of a DNA check in order. Values of keys other than dna are handed
+// raw, the dna key comes with a nil value once its rows are read into the matrix. An error stops
+// decoding right away.
+type Field func(key string, value json.RawMessage) error
+
+// DecodeFields is Decode handing every key to field, so the rest of the object can be checked
+func DecodeFields(reader io.Reader, limits Limits, field Field) (*Matrix, error) {
+	body := &limitedReader{reader: reader, remaining: limits.MaxBodyBytes}
+	decoder := &decoder{reader: &recordingReader{Reader: bufio.NewReader(body)}, limits: limits, field: field}
+
+	matrix, err := decoder.document()
+	if body.exceeded {
+		return nil, bodyTooLarge(limits.MaxBodyBytes)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return matrix, nil
+}
+
+type decoder struct {
+	reader *recordingReader
+	limits Limits
+	field  Field
+}
+
+func (decoder *decoder) document() (*Matrix, error) {
+	var matrix *Matrix
+	var err error
+
+	next, err := decoder.peek()
+	switch {
+	case err != nil:
+		return nil, ErrParse
+	case next == 'n':
+		matrix, err = &Matrix{}, decoder.literal("null")
+	case next == '{':
+		matrix, err = decoder.object()
+	default:
+		return nil, ErrParse
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Anything but whitespace after the object makes the body invalid JSON
+	if _, err := decoder.peek(); err != io.EOF {
+		return nil, ErrParse
+	}
+
+	return matrix, nil
+}
+
+func (decoder *decoder) object() (*Matrix, error) {
+	matrix := &Matrix{}
+	decoder.reader.ReadByte()
+
+	next, err := decoder.peek()
+	if err != nil {
+		return nil, ErrParse
+	}
+
+	if next == '}' {
+		decoder.reader.ReadByte()
+		return matrix, nil
+	}
+
+	for {
+		key, err := decoder.text(true)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := decoder.expect(':'); err != nil {
+			return nil, err
+		}
+
+		// A repeated key wins over the previous one, as with json.Unmarshal
+		var value json.RawMessage
+		if strings.EqualFold(key, "dna") {
+			matrix, err = decoder.rows()
+		} else {
+			value, err = decoder.value()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if decoder.field != nil {
+			if err := decoder.field(key, value); err != nil {
+				return nil, err
+			}
+		}
+
+		done, err := decoder.separator('}')
+		if err != nil || done {
+			return matrix, err
+		}
+	}
+}
+
+// rows reads the array of rows into a matrix
+func (decoder *decoder) rows() (*Matrix, error) {
+	next, err := decoder.peek()
+	switch {
+	case err != nil:
+		return nil, ErrParse
+	case next == 'n':
+		return &Matrix{}, decoder.literal("null")
+	case next != '[':
+		return nil, ErrParse
+	}
+
+	decoder.reader.ReadByte()
+	builder := newBuilder(decoder.limits)
+
+	next, err = decoder.peek()
+	if err != nil {
+		return nil, ErrParse
+	}
+
+	if next == ']' {
+		decoder.reader.ReadByte()
+		return builder.finish()
+	}
+
+	for {
+		if err := decoder.row(builder); err != nil {
+			return nil, err
+		}
+
+		done, err := decoder.separator(']')
+		if err != nil {
+			return nil, err
+		}
+
+		if done {
+			return builder.finish()
+		}
+	}
+}
+
+// row reads a row string into the matrix being built
+func (decoder *decoder) row(builder *builder) error {
+	if next, err := decoder.peek(); err != nil || next != '"' {
+		return ErrParse
+	}
+
+	decoder.reader.ReadByte()
+
+	for {
+		c, err := decoder.reader.ReadByte()
+		if err != nil {
+			return ErrParse
+		}
+
+		if c == '"' {
+			return builder.endRow()
+		}
+
+		if c == '\\' {
+			if c, err = decoder.escapedByte(); err != nil {
+				return err
+			}
+		} else if c < 0x20 {
+			return ErrParse
+		}
+
+		if err := builder.add(c); err != nil {
+			return err
+		}
+	}
+}
+
+// escapedByte reads the escape after a backslash inside a row. Escapes of anything but ASCII can
+// never be a base, they are kept as a zero byte for the builder to reject.
+func (decoder *decoder) escapedByte() (byte, error) {
+	value, err := decoder.escape()
+	if err != nil {
+		return 0, err
+	}
+
+	if value >= utf8.RuneSelf {
+		return 0, nil
+	}
+
+	return byte(value), nil
+}
+
+func (decoder *decoder) escape() (rune, error) {
+	c, err := decoder.reader.ReadByte()
+	if err != nil {
+		return 0, ErrParse
+	}
+
+	switch c {
+	case '"', '\\', '/':
+		return rune(c), nil
+	case 'b':
+		return '\b', nil
+	case 'f':
+		return '\f', nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case 'u':
+		hex := make([]byte, 4)
+		if _, err := io.ReadFull(decoder.reader, hex); err != nil {
+			return 0, ErrParse
+		}
+
+		value, err := strconv.ParseUint(string(hex), 16, 16)
+		if err != nil {
+			return 0, ErrParse
+		}
+
+		return rune(value), nil
+	default:
+		return 0, ErrParse
+	}
+}
+
+// text reads a string, which is only kept when asked for. Surrogate pairs are not joined since
+// kept strings are only compared to dna.
+func (decoder *decoder) text(keep bool) (string, error) {
+	if next, err := decoder.peek(); err != nil || next != '"' {
+		return "", ErrParse
+	}
+
+	decoder.reader.ReadByte()
+	kept := []byte{}
+
+	for {
+		c, err := decoder.reader.ReadByte()
+		switch {
+		case err != nil || c < 0x20:
+			return "", ErrParse
+		case c == '"':
+			return string(kept), nil
+		case c == '\\':
+			value, err := decoder.escape()
+			if err != nil {
+				return "", err
+			}
+
+			if keep {
+				kept = utf8.AppendRune(kept, value)
+			}
+		case keep:
+			kept = append(kept, c)
+		}
+	}
+}
+
+// value reads a value that is not the DNA, it is only kept when there is a field to hand it to
+func (decoder *decoder) value() (json.RawMessage, error) {
+	if decoder.field == nil {
+		return nil, decoder.skip(0)
+	}
+
+	// Whitespace before the value is read first, so the value starts the recording
+	if _, err := decoder.peek(); err != nil {
+		return nil, ErrParse
+	}
+
+	decoder.reader.recording, decoder.reader.recorded = true, nil
+	err := decoder.skip(0)
+	decoder.reader.recording = false
+
+	return decoder.reader.recorded, err
+}
+
+// skip reads a value that is not the DNA, checking it is valid JSON
+func (decoder *decoder) skip(depth int) error {
+	if depth > maxDepth {
+		return ErrParse
+	}
+
+	next, err := decoder.peek()
+	if err != nil {
+		return ErrParse
+	}
+
+	switch {
+	case next == '"':
+		_, err := decoder.text(false)
+		return err
+	case next == '{':
+		return decoder.container('}', depth, true)
+	case next == '[':
+		return decoder.container(']', depth, false)
+	case next == 't':
+		return decoder.literal("true")
+	case next == 'f':
+		return decoder.literal("false")
+	case next == 'n':
+		return decoder.literal("null")
+	case next == '-' || (next >= '0' && next <= '9'):
+		return decoder.number()
+	default:
+		return ErrParse
+	}
+}
+
+// container skips an object or an array
+func (decoder *decoder) container(closing byte, depth int, keyed bool) error {
+	decoder.reader.ReadByte()
+
+	next, err := decoder.peek()
+	if err != nil {
+		return ErrParse
+	}
+
+	if next == closing {
+		decoder.reader.ReadByte()
+		return nil
+	}
+
+	for {
+		if keyed {
+			if _, err := decoder.text(false); err != nil {
+				return err
+			}
+
+			if err := decoder.expect(':'); err != nil {
+				return err
+			}
+		}
+
+		if err := decoder.skip(depth + 1); err != nil {
+			return err
+		}
+
+		done, err := decoder.separator(closing)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+func (decoder *decoder) number() error {
+	number := []byte{}
+
+	for {
+		next, err := decoder.reader.Peek(1)
+		if err != nil || !strings.ContainsRune("0123456789+-.eE", rune(next[0])) {
+			break
+		}
+
+		decoder.reader.ReadByte()
+		number = append(number, next[0])
+	}
+
+	if !json.Valid(number) {
+		return ErrParse
+	}
+
+	return nil
+}
+
+func (decoder *decoder) literal(literal string) error {
+	read := make([]byte, len(literal))
+	if _, err := io.ReadFull(decoder.reader, read); err != nil || string(read) != literal {
+		return ErrParse
+	}
+
+	return nil
+}
+
+// separator reads what follows an element: a comma, or closing, which ends the container
+func (decoder *decoder) separator(closing byte) (bool, error) {
+	next, err := decoder.peek()
+	if err != nil {
+		return false, ErrParse
+	}
+
+	decoder.reader.ReadByte()
+
+	switch next {
+	case ',':
+		return false, nil
+	case closing:
+		return true, nil
+	default:
+		return false, ErrParse
+	}
+}
+
+func (decoder *decoder) expect(expected byte) error {
+	if next, err := decoder.peek(); err != nil || next != expected {
+		return ErrParse
+	}
+
+	decoder.reader.ReadByte()
+	return nil
+}
+
+// peek skips whitespace and returns the next byte without reading it, io.EOF tells the input ended
+func (decoder *decoder) peek() (byte, error) {
+	for {
+		next, err := decoder.reader.Peek(1)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, io.EOF
+			}
+
+			return 0, ErrParse
+		}
+
+		switch next[0] {
+		case ' ', '\t', '\n', '\r':
+			decoder.reader.ReadByte()
+		default:
+			return next[0], nil
+		}
+	}
+}
+
+// recordingReader keeps what is read while recording, values handed to fields are recorded as
+// they are checked
+type recordingReader struct {
+	*bufio.Reader
+	recording bool
+	recorded  []byte
+}
+
+func (reader *recordingReader) ReadByte() (byte, error) {
+	c, err := reader.Reader.ReadByte()
+	if err == nil && reader.recording {
+		reader.recorded = append(reader.recorded, c)
+	}
+
+	return c, err
+}
+
+func (reader *recordingReader) Read(buffer []byte) (int, error) {
+	read, err := reader.Reader.Read(buffer)
+	if reader.recording {
+		reader.recorded = append(reader.recorded, buffer[:read]...)
+	}
+
+	return read, err
+}
+
+// limitedReader fails once more than remaining bytes are read from it
+type limitedReader struct {
+	reader    io.Reader
+	remaining int
+	exceeded  bool
+}
+
+var errBodyTooLarge = errors.New("Request body is too large")
+
+func (limited *limitedReader) Read(buffer []byte) (int, error) {
+	// Reading one byte past the limit tells a body of exactly the limit apart from a larger one
+	if len(buffer) > limited.remaining+1 {
+		buffer = buffer[:limited.remaining+1]
+	}
+
+	read, err := limited.reader.Read(buffer)
+	limited.remaining -= read
+
+	if limited.remaining < 0 {
+		limited.exceeded = true
+		return read, errBodyTooLarge
+	}
+
+	return read, err
+}
+
+// builder checks rows base by base as they are read, whatever the format, writing them straight
+// into a matrix. The first row sets N, every later one must be as long and there must be N of them.
+// Bases of the first row are checked once it ends, after its length is checked against the
+// limits, those of later rows as they come, after checking they do not make their row too long.
+type builder struct {
+	matrix *Matrix
+	limits Limits
+	rows   int
+	start  int
+}
+
+func newBuilder(limits Limits) *builder {
+	return &builder{matrix: &Matrix{}, limits: limits}
+}
+
+// add appends a base to the current row
+func (builder *builder) add(base byte) error {
+	length := len(builder.matrix.bases) - builder.start + 1
+	if builder.rows == 0 && length > builder.limits.MaxSize {
+		return &LimitError{StatusCode: 422, Message: fmt.Sprintf("DNA has rows longer than %d bases", builder.limits.MaxSize), Limit: builder.limits.MaxSize}
+	}
+
+	if builder.rows > 0 && length > builder.matrix.size {
+		return ErrNotSquare
+	}
+
+	if builder.rows > 0 && !IsBase(base) {
+		return ErrInvalidBases
+	}
+
+	builder.matrix.bases = append(builder.matrix.bases, base)
+	return nil
+}
+
+// endRow ends the current row, the first one tells N
+func (builder *builder) endRow() error {
+	length := len(builder.matrix.bases) - builder.start
+	builder.rows++
+
+	if builder.rows == 1 {
+		if length*length > builder.limits.MaxBases {
+			return &LimitError{StatusCode: 422, Message: fmt.Sprintf("DNA has more than %d bases", builder.limits.MaxBases), Limit: builder.limits.MaxBases}
+		}
+
+		// N is known now, the rest of the rows go in place without growing the matrix again
+		builder.matrix.size = length
+		bases := make([]byte, length, length*length)
+		copy(bases, builder.matrix.bases)
+		builder.matrix.bases = bases
+
+		if err := builder.matrix.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if length != builder.matrix.size || builder.rows > builder.matrix.size {
+		return ErrNotSquare
+	}
+
+	builder.start = len(builder.matrix.bases)
+	return nil
+}
+
+// finish returns the matrix once every row was read
+func (builder *builder) finish() (*Matrix, error) {
+	if builder.rows != builder.matrix.size || len(builder.matrix.bases) != builder.start {
+		return nil, ErrNotSquare
+	}
+
+	return builder.matrix, nil
+}
@@ -0,0 +1,143 @@
+package matrix
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testLimits = Limits{MaxBodyBytes: 64, MaxSize: 3, MaxBases: 9}
+
+func TestDecode(t *testing.T) {
+	cases := map[string][]string{
+		`{"dna": ["ATG", "CAG", "TTA"]}`:                 {"ATG", "CAG", "TTA"},
+		`{"DNA": ["A"], "source": {"lab": [1, -2.5e3]}}`: {"A"},
+		`{"Dna": []}`:                     {},
+		`{"dna": null}`:                   {},
+		`null`:                            {},
+		"  {\"dna\": [\"AT\", \"CG\"]}\n": {"AT", "CG"},
+		`{"source": "lab", "dna": ["A"], "extra": true}`: {"A"},
+		`{"dna": ["AG", "TC"], "dna": ["AT", "CG"]}`:     {"AT", "CG"},
+		`{"dna": ["AT", "CG"]}`:                          {"AT", "CG"},
+		`{"dna": ["A"]}`:                                 {"A"},
+	}
+
+	for data, expected := range cases {
+		matrix, err := Decode(strings.NewReader(data), testLimits)
+
+		assert.Nil(t, err, data)
+		assert.Equal(t, expected, matrix.Rows(), data)
+	}
+}
+
+func TestDecodeRejectsInvalidJSON(t *testing.T) {
+	for _, data := range []string{``, `[]`, `"dna"`, `{"dna": "ATG"}`, `{"dna": [1]}`, `{"dna": [null]}`, `{"dna": ["A"]`, `{"dna": ["A"]} {}`, `{"dna": ["A"]} x`, `{"dna": ["A"], "n": 01}`, `{"dna": ["A"],}`, `{"dna": ["A` + "\n" + `"]}`, `{"dna": ["\x"]}`} {
+		_, err := Decode(strings.NewReader(data), testLimits)
+
+		assert.Equal(t, ErrParse, err, data)
+	}
+}
+
+func TestDecodeRejectsInvalidTables(t *testing.T) {
+	cases := map[string]error{
+		`{"dna": ["A", "C", "G", "T"]}`:  ErrNotSquare,
+		`{"dna": ["ATG", "CAG", "TT"]}`:  ErrNotSquare,
+		`{"dna": ["AT", "CAG"]}`:         ErrNotSquare,
+		`{"dna": ["ATG", "CAG"]}`:        ErrNotSquare,
+		`{"dna": ["ATX", "CAG"]}`:        ErrInvalidBases,
+		`{"dna": ["ATG", "CXG", "TT"]}`:  ErrInvalidBases,
+		`{"dna": ["ATG", "CAGX"]}`:       ErrNotSquare,
+		`{"dna": ["Á"]}`:                 ErrInvalidBases,
+		`{"dna": ["ATG", "CXG", "TTA"]}`: ErrInvalidBases,
+		`{"dna": ["a"]}`:                 ErrInvalidBases,
+		`{"dna": ["\u00c1"]}`:            ErrInvalidBases,
+	}
+
+	for data, expected := range cases {
+		_, err := Decode(strings.NewReader(data), testLimits)
+
+		assert.Equal(t, expected, err, data)
+	}
+}
+
+func TestDecodeEnforcesLimits(t *testing.T) {
+	cases := map[string]*LimitError{
+		`{"dna": ["ATGC"]}`: {StatusCode: 422, Message: "DNA has rows longer than 3 bases", Limit: 3},
+		`{"dna": ["ATG", "CAG", "TTA"], "note": "` + strings.Repeat("x", 40) + `"}`: {StatusCode: 413, Message: "Request body is larger than 64 bytes", Limit: 64},
+	}
+
+	for data, expected := range cases {
+		_, err := Decode(strings.NewReader(data), testLimits)
+
+		assert.Equal(t, expected, err, data)
+	}
+
+	_, err := Decode(strings.NewReader(`{"dna": ["ATG", "CAG", "TTA"]}`), Limits{MaxBodyBytes: 64, MaxSize: 3, MaxBases: 8})
+	assert.Equal(t, &LimitError{StatusCode: 422, Message: "DNA has more than 8 bases", Limit: 8}, err)
+
+	// A body of exactly the limit is fine
+	data := `{"dna": ["ATG", "CAG", "TTA"]}`
+	_, err = Decode(strings.NewReader(data), Limits{MaxBodyBytes: len(data), MaxSize: 3, MaxBases: 9})
+	assert.Nil(t, err)
+}
+
+// The decoder stops at the first row over the limits or not fitting the table, the rest of the body
+// is never read
+func TestDecodeRejectsEarly(t *testing.T) {
+	for _, data := range []string{`{"dna": ["ATGC", "`, `{"dna": ["AT", "CGA`, `{"dna": ["AX", "`, `{"dna": ["AT", "X`} {
+		body := &limitedReader{reader: strings.NewReader(data + strings.Repeat("A", 1<<20) + `"]}`), remaining: 2 << 20}
+
+		_, err := Decode(body, Limits{MaxBodyBytes: 2 << 20, MaxSize: 3, MaxBases: 9})
+
+		assert.NotNil(t, err, data)
+		assert.True(t, body.remaining > 1<<20, data)
+	}
+}
+
+// Rows are written in place, the matrix holds a single block of N×N bases
+func TestDecodeBuildsACompactMatrix(t *testing.T) {
+	matrix, _ := Decode(strings.NewReader(`{"dna": ["ATG", "CAG", "TTA"]}`), testLimits)
+
+	assert.Equal(t, []byte("ATGCAGTTA"), matrix.bases)
+	assert.Equal(t, 9, cap(matrix.bases))
+}
+
+func TestDecodeRejectsDeepNesting(t *testing.T) {
+	data := `{"note": ` + strings.Repeat("[", maxDepth+2) + strings.Repeat("]", maxDepth+2) + `}`
+
+	_, err := Decode(strings.NewReader(data), Limits{MaxBodyBytes: 1 << 20, MaxSize: 3, MaxBases: 9})
+
+	assert.Equal(t, ErrParse, err)
+}
+
+func TestDecodeFieldsHandsEveryKeyInOrder(t *testing.T) {
+	keys := []string{}
+	values := []string{}
+
+	matrix, err := DecodeFields(strings.NewReader(`{"version": 2, "Dna": ["AT", "CG"], "metadata": {"tags": ["a", "b"], "n": -1.5e2}}`), Limits{MaxBodyBytes: 1 << 10, MaxSize: 3, MaxBases: 9}, func(key string, value json.RawMessage) error {
+		keys = append(keys, key)
+		values = append(values, string(value))
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"AT", "CG"}, matrix.Rows())
+	assert.Equal(t, []string{"version", "Dna", "metadata"}, keys)
+	assert.Equal(t, []string{"2", "", `{"tags": ["a", "b"], "n": -1.5e2}`}, values)
+}
+
+// A field that fails stops decoding, the rest of the body is never read
+func TestDecodeFieldsStopsAtTheFirstRejectedField(t *testing.T) {
+	rejected := errors.New("Unknown field")
+	body := &limitedReader{reader: strings.NewReader(`{"extra": true, "dna": ["` + strings.Repeat("A", 1<<20) + `"]}`), remaining: 2 << 20}
+
+	_, err := DecodeFields(body, Limits{MaxBodyBytes: 2 << 20, MaxSize: 1 << 20, MaxBases: 1 << 40}, func(key string, value json.RawMessage) error {
+		return rejected
+	})
+
+	assert.Equal(t, rejected, err)
+	assert.True(t, body.remaining > 1<<20)
+}
@@ -0,0 +1,159 @@
+package matrix
+
+import (
+	"bufio"
+	"io"
+)
+
+// Format is a way DNA can be submitted in
+type Format string
+
+// Formats DNA is read from: a JSON object with the rows under its dna key, plain text with one row
+// per line, or FASTA where every record is a row
+const (
+	JSON  Format = "json"
+	Text  Format = "text"
+	FASTA Format = "fasta"
+)
+
+// DecodeFormat reads DNA in given format. Every format is checked, and limited, the same way as
+// JSON is by Decode.
+func DecodeFormat(reader io.Reader, format Format, limits Limits) (*Matrix, error) {
+	switch format {
+	case Text:
+		return decodeLines(reader, limits, text)
+	case FASTA:
+		return decodeLines(reader, limits, fasta)
+	default:
+		return Decode(reader, limits)
+	}
+}
+
+// lineReader reads a line into the builder
+type lineReader func(reader *bufio.Reader, builder *builder, state *lineState) error
+
+type lineState struct {
+	// inRow tells a row was started and not ended yet, FASTA rows span several lines
+	inRow bool
+}
+
+func decodeLines(reader io.Reader, limits Limits, read lineReader) (*Matrix, error) {
+	body := &limitedReader{reader: reader, remaining: limits.MaxBodyBytes}
+	buffered := bufio.NewReader(body)
+	builder := newBuilder(limits)
+	state := &lineState{}
+
+	var err error
+	for err == nil {
+		if _, peekErr := buffered.Peek(1); peekErr != nil {
+			break
+		}
+
+		err = read(buffered, builder, state)
+	}
+
+	if body.exceeded {
+		return nil, bodyTooLarge(limits.MaxBodyBytes)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if state.inRow {
+		if err := builder.endRow(); err != nil {
+			return nil, err
+		}
+	}
+
+	return builder.finish()
+}
+
+// text reads a line of plain text, which is a row unless it is blank. Line endings may be \n or \r\n.
+func text(reader *bufio.Reader, builder *builder, state *lineState) error {
+	for {
+		c, err := reader.ReadByte()
+		if err == io.EOF || c == '\n' {
+			break
+		}
+
+		if err != nil {
+			return ErrParse
+		}
+
+		if c == '\r' {
+			continue
+		}
+
+		if err := builder.add(c); err != nil {
+			return err
+		}
+
+		state.inRow = true
+	}
+
+	if !state.inRow {
+		return nil
+	}
+
+	state.inRow = false
+	return builder.endRow()
+}
+
+// fasta reads a line of FASTA. A line starting with > is the header of a record, which starts a
+// new row, the lines after it are its sequence. Lines starting with ; are comments, blank ones are
+// ignored. Anything before the first header is not FASTA.
+func fasta(reader *bufio.Reader, builder *builder, state *lineState) error {
+	first, _ := reader.ReadByte()
+
+	switch first {
+	case '>':
+		if state.inRow {
+			if err := builder.endRow(); err != nil {
+				return err
+			}
+		}
+
+		state.inRow = true
+		return skipLine(reader)
+	case ';':
+		return skipLine(reader)
+	case '\n':
+		return nil
+	case '\r':
+		return skipLine(reader)
+	}
+
+	if !state.inRow {
+		return ErrParse
+	}
+
+	for c, err := first, error(nil); err != io.EOF && c != '\n'; c, err = reader.ReadByte() {
+		if err != nil {
+			return ErrParse
+		}
+
+		if c == '\r' {
+			continue
+		}
+
+		if err := builder.add(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func skipLine(reader *bufio.Reader) error {
+	for {
+		c, err := reader.ReadByte()
+		if err == io.EOF || c == '\n' {
+			return nil
+		}
+
+		if err != nil {
+			return ErrParse
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package matrix
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeText(t *testing.T) {
+	cases := map[string][]string{
+		"ATG\nCAG\nTTA\n":   {"ATG", "CAG", "TTA"},
+		"ATG\r\nCAG\r\nTTA": {"ATG", "CAG", "TTA"},
+		"\nAT\n\nCG\n\n":    {"AT", "CG"},
+		"":                  {},
+		"A":                 {"A"},
+	}
+
+	for data, expected := range cases {
+		matrix, err := DecodeFormat(strings.NewReader(data), Text, testLimits)
+
+		assert.Nil(t, err, data)
+		assert.Equal(t, expected, matrix.Rows(), data)
+	}
+}
+
+func TestDecodeFASTA(t *testing.T) {
+	cases := map[string][]string{
+		">row 1\nATG\n>row 2\nCAG\n>row 3\nTTA\n":                {"ATG", "CAG", "TTA"},
+		"; sequencer run 42\n>r1\nAT\nG\n\n>r2\nC\nAG\n>r3\nTTA": {"ATG", "CAG", "TTA"},
+		">r1\r\nAT\r\n>r2\r\nCG\r\n":                             {"AT", "CG"},
+		"":                                                       {},
+	}
+
+	for data, expected := range cases {
+		matrix, err := DecodeFormat(strings.NewReader(data), FASTA, testLimits)
+
+		assert.Nil(t, err, data)
+		assert.Equal(t, expected, matrix.Rows(), data)
+	}
+}
+
+// Every format goes through the same rules as JSON
+func TestDecodeFormatsValidateTheSameWay(t *testing.T) {
+	cases := []struct {
+		format   Format
+		data     string
+		expected error
+	}{
+		{Text, "ATG\nCA\nTTA", ErrNotSquare},
+		{Text, "ATG\nCAG", ErrNotSquare},
+		{Text, "AT\nCG\nTA", ErrNotSquare},
+		{Text, "ATG\nCXG\nTTA", ErrInvalidBases},
+		{Text, "at\ncg", ErrInvalidBases},
+		{Text, "A T\nC G", ErrInvalidBases},
+		{Text, "ATGC", &LimitError{StatusCode: 422, Message: "DNA has rows longer than 3 bases", Limit: 3}},
+		{Text, strings.Repeat("A", 80), &LimitError{StatusCode: 413, Message: "Request body is larger than 64 bytes", Limit: 64}},
+		{FASTA, "ATG\nCAG\nTTA", ErrParse},
+		{FASTA, ">r1\nATG\n>r2\nCAG", ErrNotSquare},
+		{FASTA, ">r1\nAT\n>r2\n>r3\nCG", ErrNotSquare},
+		{FASTA, ">r1\nAT\nGC\n>r2\nCAGT", &LimitError{StatusCode: 422, Message: "DNA has rows longer than 3 bases", Limit: 3}},
+		{FASTA, ">r1\nATN\n>r2\nCAG\n>r3\nTTA", ErrInvalidBases},
+		{FASTA, ">" + strings.Repeat("x", 80), &LimitError{StatusCode: 413, Message: "Request body is larger than 64 bytes", Limit: 64}},
+	}
+
+	for _, test := range cases {
+		_, err := DecodeFormat(strings.NewReader(test.data), test.format, testLimits)
+
+		assert.Equal(t, test.expected, err, test.data)
+	}
+
+	_, err := DecodeFormat(strings.NewReader("ATG\nCAG\nTTA"), Text, Limits{MaxBodyBytes: 64, MaxSize: 3, MaxBases: 8})
+	assert.Equal(t, &LimitError{StatusCode: 422, Message: "DNA has more than 8 bases", Limit: 8}, err)
+}
+
+func TestDecodeFormatDefaultsToJSON(t *testing.T) {
+	matrix, err := DecodeFormat(strings.NewReader(`{"dna": ["AT", "CG"]}`), JSON, testLimits)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"AT", "CG"}, matrix.Rows())
+}
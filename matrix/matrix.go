@@ -0,0 +1,116 @@
+// Package matrix holds NxN DNA tables in a single block of bytes and decodes them from JSON as
+// they are read, so large submissions are validated without holding a copy per row
+package matrix
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// Errors returned when a DNA is not a valid table, their messages are answered to clients
+var (
+	ErrParse        = errors.New("Could not parse DNA check")
+	ErrNotSquare    = errors.New("DNA is not an NxN table")
+	ErrInvalidBases = errors.New("DNA has invalid bases")
+)
+
+// Matrix is an NxN table of bases stored row after row. It is never modified once built, so
+// rows can be handed out without copying them.
+type Matrix struct {
+	size  int
+	bases []byte
+}
+
+// New builds a matrix from its rows, validating them. Every row is at hand, so a table that is
+// not NxN is reported before its bases are checked.
+func New(rows []string) (*Matrix, error) {
+	for _, row := range rows {
+		if len(row) != len(rows) {
+			return nil, ErrNotSquare
+		}
+	}
+
+	matrix := &Matrix{size: len(rows), bases: make([]byte, 0, len(rows)*len(rows))}
+	for _, row := range rows {
+		matrix.bases = append(matrix.bases, row...)
+	}
+
+	if err := matrix.Validate(); err != nil {
+		return nil, err
+	}
+
+	return matrix, nil
+}
+
+// Validate checks every base of the matrix in a single pass over the block
+func (matrix *Matrix) Validate() error {
+	for _, base := range matrix.bases {
+		if !IsBase(base) {
+			return ErrInvalidBases
+		}
+	}
+
+	return nil
+}
+
+// Size is N, both the number of rows and their length
+func (matrix *Matrix) Size() int {
+	return matrix.size
+}
+
+// At returns the base at given row and column
+func (matrix *Matrix) At(row, column int) byte {
+	return matrix.bases[row*matrix.size+column]
+}
+
+// Row returns a row, it must not be modified
+func (matrix *Matrix) Row(row int) []byte {
+	return matrix.bases[row*matrix.size : (row+1)*matrix.size]
+}
+
+// Rows returns the rows as strings that share the bytes of the matrix instead of copying them,
+// which is safe since the matrix never changes
+func (matrix *Matrix) Rows() []string {
+	rows := make([]string, matrix.size)
+	for row := range rows {
+		if matrix.size > 0 {
+			rows[row] = unsafe.String(&matrix.bases[row*matrix.size], matrix.size)
+		}
+	}
+
+	return rows
+}
+
+// IsBase tells whether c is one of the bases a DNA is made of
+func IsBase(c byte) bool {
+	switch c {
+	case 'A', 'T', 'C', 'G':
+		return true
+	default:
+		return false
+	}
+}
+
+// Limits bound what a single DNA may make the decoder hold in memory
+type Limits struct {
+	MaxBodyBytes int
+	MaxSize      int
+	MaxBases     int
+}
+
+// LimitError is returned when input exceeds one of the limits. StatusCode is 413 when the body is
+// too large and 422 when the DNA is, it is answered as JSON.
+type LimitError struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"error"`
+	Limit      int    `json:"limit"`
+}
+
+func (err *LimitError) Error() string {
+	return err.Message
+}
+
+func bodyTooLarge(limit int) *LimitError {
+	return &LimitError{StatusCode: 413, Message: fmt.Sprintf("Request body is larger than %d bytes", limit), Limit: limit}
+}
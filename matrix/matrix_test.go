@@ -0,0 +1,47 @@
+package matrix
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	matrix, err := New([]string{"ATG", "CAG", "TTA"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, matrix.Size())
+	assert.Equal(t, byte('G'), matrix.At(1, 2))
+	assert.Equal(t, []byte("TTA"), matrix.Row(2))
+	assert.Equal(t, []string{"ATG", "CAG", "TTA"}, matrix.Rows())
+}
+
+func TestNewRejectsInvalidTables(t *testing.T) {
+	_, err := New([]string{"ATG", "CA", "TTA"})
+	assert.Equal(t, ErrNotSquare, err)
+
+	_, err = New([]string{"ATG", "CAG", "TTX"})
+	assert.Equal(t, ErrInvalidBases, err)
+
+	_, err = New([]string{"ATX", "CAG", "TT"})
+	assert.Equal(t, ErrNotSquare, err)
+}
+
+func TestRowsShareTheBasesOfTheMatrix(t *testing.T) {
+	matrix, _ := New([]string{"ATG", "CAG", "TTA"})
+	rows := matrix.Rows()
+
+	assert.Equal(t, unsafe.Pointer(&matrix.bases[3]), unsafe.Pointer(unsafe.StringData(rows[1])))
+	assert.Equal(t, []string{}, (&Matrix{}).Rows())
+}
+
+func TestIsBase(t *testing.T) {
+	for _, c := range []byte("ATCG") {
+		assert.True(t, IsBase(c))
+	}
+
+	for _, c := range []byte("atcgEX19# ") {
+		assert.False(t, IsBase(c))
+	}
+}
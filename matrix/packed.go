@@ -0,0 +1,140 @@
+package matrix
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+// packedFormat is the first byte of every packed encoding, it changes if the layout ever does
+const packedFormat = 1
+
+// ErrCorrupted is returned when a packed encoding cannot be read back
+var ErrCorrupted = errors.New("Packed DNA is corrupted")
+
+// codes maps each base to its 2 bit code, bases maps codes back
+var (
+	codes = [256]byte{'A': 0, 'C': 1, 'G': 2, 'T': 3}
+	bases = [4]byte{'A', 'C', 'G', 'T'}
+)
+
+// Packed is an NxN table of bases using 2 bits per base, four bases to a byte, row after row.
+// Base i of the table is held in byte i/4, starting at bit 2*(i%4).
+type Packed struct {
+	size int
+	bits []byte
+}
+
+// Pack packs a matrix
+func Pack(matrix *Matrix) *Packed {
+	packed := &Packed{size: matrix.size, bits: make([]byte, packedBytes(matrix.size))}
+
+	for index, base := range matrix.bases {
+		packed.bits[index>>2] |= codes[base] << ((index & 3) * 2)
+	}
+
+	return packed
+}
+
+// PackRows builds a packed matrix from its rows, validating them
+func PackRows(rows []string) (*Packed, error) {
+	matrix, err := New(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return Pack(matrix), nil
+}
+
+// Size is N, both the number of rows and their length
+func (packed *Packed) Size() int {
+	return packed.size
+}
+
+// At returns the base at given row and column
+func (packed *Packed) At(row, column int) byte {
+	return bases[packed.code(row*packed.size+column)]
+}
+
+func (packed *Packed) code(index int) byte {
+	return packed.bits[index>>2] >> ((index & 3) * 2) & 3
+}
+
+// Unpack returns the matrix with a byte per base
+func (packed *Packed) Unpack() *Matrix {
+	matrix := &Matrix{size: packed.size, bases: make([]byte, packed.size*packed.size)}
+
+	for index := range matrix.bases {
+		matrix.bases[index] = bases[packed.code(index)]
+	}
+
+	return matrix
+}
+
+// Rows returns the rows as strings
+func (packed *Packed) Rows() []string {
+	return packed.Unpack().Rows()
+}
+
+// MarshalBinary encodes the matrix as its format byte, N as a uvarint and the packed bases. A DNA
+// takes N²/4 bytes plus a few, where its JSON form takes at least N² plus quoting.
+func (packed *Packed) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, 1+binary.MaxVarintLen64+len(packed.bits))
+	data = append(data, packedFormat)
+	data = binary.AppendUvarint(data, uint64(packed.size))
+
+	return append(data, packed.bits...), nil
+}
+
+// UnmarshalBinary decodes a matrix encoded by MarshalBinary
+func (packed *Packed) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] != packedFormat {
+		return ErrCorrupted
+	}
+
+	size, read := binary.Uvarint(data[1:])
+	if read <= 0 || size > 1<<20 || uint64(len(data)-1-read) != uint64(packedBytes(int(size))) {
+		return ErrCorrupted
+	}
+
+	packed.size = int(size)
+	packed.bits = append([]byte(nil), data[1+read:]...)
+
+	return nil
+}
+
+// MarshalJSON encodes the matrix as its array of rows, the form DNA is submitted in
+func (packed *Packed) MarshalJSON() ([]byte, error) {
+	return json.Marshal(packed.Rows())
+}
+
+// UnmarshalJSON decodes an array of rows, validating them
+func (packed *Packed) UnmarshalJSON(data []byte) error {
+	var rows []string
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return ErrParse
+	}
+
+	decoded, err := PackRows(rows)
+	if err != nil {
+		return err
+	}
+
+	*packed = *decoded
+	return nil
+}
+
+// Load reads a stored DNA, packed when given or else from its JSON form
+func Load(packedData []byte, jsonData []byte) (*Packed, error) {
+	packed := &Packed{}
+
+	if packedData != nil {
+		return packed, packed.UnmarshalBinary(packedData)
+	}
+
+	return packed, packed.UnmarshalJSON(jsonData)
+}
+
+func packedBytes(size int) int {
+	return (size*size + 3) / 4
+}
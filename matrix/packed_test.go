@@ -0,0 +1,232 @@
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func randomRows(random *rand.Rand, size int) []string {
+	rows := make([]string, size)
+
+	for row := range rows {
+		line := make([]byte, size)
+		for column := range line {
+			line[column] = "ACGT"[random.Intn(4)]
+		}
+
+		rows[row] = string(line)
+	}
+
+	return rows
+}
+
+func TestPack(t *testing.T) {
+	rows := []string{"ATCGA", "CAGTG", "TTATG", "AGAAG", "CCCCT"}
+	packed, err := PackRows(rows)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 5, packed.Size())
+	assert.Len(t, packed.bits, 7)
+	assert.Equal(t, byte('T'), packed.At(1, 3))
+	assert.Equal(t, byte('T'), packed.At(4, 4))
+	assert.Equal(t, rows, packed.Rows())
+
+	_, err = PackRows([]string{"AT", "CX"})
+	assert.Equal(t, ErrInvalidBases, err)
+}
+
+func TestPackedBinaryRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(7))
+
+	for _, size := range []int{0, 1, 2, 3, 4, 5, 17, 100} {
+		rows := randomRows(random, size)
+		packed, _ := PackRows(rows)
+
+		data, err := packed.MarshalBinary()
+		assert.Nil(t, err)
+		assert.Len(t, data, 1+len(packed.bits)+uvarintLength(size), size)
+
+		decoded := &Packed{}
+		assert.Nil(t, decoded.UnmarshalBinary(data), size)
+		assert.Equal(t, rows, decoded.Rows(), size)
+	}
+}
+
+func uvarintLength(value int) int {
+	length := 1
+	for ; value >= 0x80; value >>= 7 {
+		length++
+	}
+
+	return length
+}
+
+func TestUnmarshalBinaryRejectsCorruptedData(t *testing.T) {
+	packed, _ := PackRows([]string{"ATC", "GAT", "CCA"})
+	data, _ := packed.MarshalBinary()
+
+	for _, corrupted := range [][]byte{nil, {}, {2, 0}, {packedFormat}, data[:len(data)-1], append(data, 0), {packedFormat, 0xff, 0xff, 0xff, 0xff, 0x0f}} {
+		assert.Equal(t, ErrCorrupted, (&Packed{}).UnmarshalBinary(corrupted), corrupted)
+	}
+}
+
+func TestPackedJSONRoundTrip(t *testing.T) {
+	packed, _ := PackRows([]string{"ATC", "GAT", "CCA"})
+
+	data, err := json.Marshal(packed)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `["ATC", "GAT", "CCA"]`, string(data))
+
+	decoded := &Packed{}
+	assert.Nil(t, json.Unmarshal(data, decoded))
+	assert.Equal(t, packed, decoded)
+
+	assert.Equal(t, ErrParse, decoded.UnmarshalJSON([]byte(`{"dna": []}`)))
+	assert.Equal(t, ErrNotSquare, decoded.UnmarshalJSON([]byte(`["ATC"]`)))
+}
+
+func TestLoad(t *testing.T) {
+	packed, _ := PackRows([]string{"ATC", "GAT", "CCA"})
+	data, _ := packed.MarshalBinary()
+
+	fromPacked, err := Load(data, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, packed, fromPacked)
+
+	fromJSON, err := Load(nil, []byte(`["ATC", "GAT", "CCA"]`))
+	assert.Nil(t, err)
+	assert.Equal(t, packed, fromJSON)
+
+	_, err = Load(nil, []byte(`["ATC"]`))
+	assert.NotNil(t, err)
+}
+
+// countAt counts sequences the straightforward way, checking every position
+func countAt(packed *Packed, direction Direction, length int) int {
+	count := 0
+
+	for row := 0; row < packed.size; row++ {
+		for column := 0; column < packed.size; column++ {
+			found := true
+			for step := 1; step < length && found; step++ {
+				r, c := row+step*direction.rows, column+step*direction.columns
+				found = packed.inside(r, c) && packed.At(r, c) == packed.At(row, column)
+			}
+
+			if found {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+func TestCount(t *testing.T) {
+	packed, _ := PackRows([]string{
+		"AAAAAG",
+		"CAGTGC",
+		"TTAGTG",
+		"AGGAGG",
+		"CACCTA",
+		"TCACTG",
+	})
+
+	assert.Equal(t, 2, packed.Count(Horizontal, 4, 10))
+	assert.Equal(t, 1, packed.Count(Horizontal, 4, 1))
+	assert.Equal(t, 0, packed.Count(Vertical, 4, 10))
+	assert.Equal(t, 1, packed.Count(DiagonalRight, 4, 10))
+	assert.Equal(t, 1, packed.Count(DiagonalLeft, 4, 10))
+
+	random := rand.New(rand.NewSource(11))
+	for iteration := 0; iteration < 200; iteration++ {
+		packed, _ := PackRows(randomRows(random, random.Intn(12)))
+
+		for _, direction := range []Direction{Horizontal, Vertical, DiagonalLeft, DiagonalRight} {
+			for _, length := range []int{2, 3, 4} {
+				expected := countAt(packed, direction, length)
+
+				assert.Equal(t, expected, packed.Count(direction, length, 1<<30), packed.Rows())
+				assert.Equal(t, min(expected, 3), packed.Count(direction, length, 3), packed.Rows())
+			}
+		}
+	}
+}
+
+func BenchmarkPack(b *testing.B) {
+	for _, size := range []int{10, 100, 1000} {
+		decoded, _ := New(randomRows(rand.New(rand.NewSource(1)), size))
+
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Pack(decoded)
+			}
+		})
+	}
+}
+
+// BenchmarkCount scans random DNA, which holds few sequences, so the whole table is read
+func BenchmarkCount(b *testing.B) {
+	for _, size := range []int{10, 100, 1000} {
+		packed, _ := PackRows(randomRows(rand.New(rand.NewSource(1)), size))
+
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, direction := range []Direction{Horizontal, Vertical, DiagonalLeft, DiagonalRight} {
+					packed.Count(direction, 6, 1<<30)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkEncode compares the binary encoding to JSON, bytes/base is what a row takes in storage
+func BenchmarkEncode(b *testing.B) {
+	for _, size := range []int{10, 100, 1000} {
+		rows := randomRows(rand.New(rand.NewSource(1)), size)
+		packed, _ := PackRows(rows)
+
+		b.Run(fmt.Sprintf("binary/size=%d", size), func(b *testing.B) {
+			var data []byte
+			for i := 0; i < b.N; i++ {
+				data, _ = packed.MarshalBinary()
+			}
+
+			b.ReportMetric(float64(len(data))/float64(size*size), "bytes/base")
+		})
+
+		b.Run(fmt.Sprintf("json/size=%d", size), func(b *testing.B) {
+			var data []byte
+			for i := 0; i < b.N; i++ {
+				data, _ = json.Marshal(rows)
+			}
+
+			b.ReportMetric(float64(len(data))/float64(size*size), "bytes/base")
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, size := range []int{10, 100, 1000} {
+		rows := randomRows(rand.New(rand.NewSource(1)), size)
+		packed, _ := PackRows(rows)
+		binaryData, _ := packed.MarshalBinary()
+		jsonData, _ := json.Marshal(rows)
+
+		b.Run(fmt.Sprintf("binary/size=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Load(binaryData, nil)
+			}
+		})
+
+		b.Run(fmt.Sprintf("json/size=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Load(nil, jsonData)
+			}
+		})
+	}
+}
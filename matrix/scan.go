@@ -0,0 +1,51 @@
+package matrix
+
+// Direction is the step taken from a base to the next one of a sequence
+type Direction struct {
+	rows    int
+	columns int
+}
+
+// Directions sequences are looked for in
+var (
+	Horizontal    = Direction{rows: 0, columns: 1}
+	Vertical      = Direction{rows: 1, columns: 0}
+	DiagonalLeft  = Direction{rows: 1, columns: -1}
+	DiagonalRight = Direction{rows: 1, columns: 1}
+)
+
+// Count counts the positions where length equal bases start going in direction, up to limit.
+// Overlapping sequences count once per start, so five equal bases hold two sequences of four.
+// Bases are read once, row after row, keeping the run of equal bases that ends on each of them for
+// the current and the previous row. Every base whose run reaches length ends one more sequence.
+func (packed *Packed) Count(direction Direction, length, limit int) int {
+	count := 0
+	runs := [2][]int{make([]int, packed.size), make([]int, packed.size)}
+
+	for row := 0; row < packed.size; row++ {
+		for column := 0; column < packed.size; column++ {
+			code := packed.code(row*packed.size + column)
+			run := 1
+
+			from, fromColumn := row-direction.rows, column-direction.columns
+			if packed.inside(from, fromColumn) && packed.code(from*packed.size+fromColumn) == code {
+				run = runs[from&1][fromColumn] + 1
+			}
+
+			runs[row&1][column] = run
+
+			if run >= length {
+				count++
+				if count >= limit {
+					return count
+				}
+			}
+		}
+	}
+
+	return count
+}
+
+func (packed *Packed) inside(row, column int) bool {
+	return row >= 0 && row < packed.size && column >= 0 && column < packed.size
+}
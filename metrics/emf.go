@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// Namespace is the CloudWatch namespace metrics are published under
+const Namespace = "Mutants"
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// WriteEMF writes what was recorded since the last call as embedded metric format log lines,
+// one per series, which CloudWatch Logs turns into metrics without any API call
+//
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+func (registry *Registry) WriteEMF(w io.Writer, now time.Time) error {
+	encoder := json.NewEncoder(w)
+
+	for _, metric := range registry.list() {
+		for _, sample := range metric.drain() {
+			document := map[string]interface{}{
+				"_aws": emfMetadata{
+					Timestamp: now.UnixNano() / int64(time.Millisecond),
+					CloudWatchMetrics: []emfDirective{{
+						Namespace:  Namespace,
+						Dimensions: [][]string{append([]string{}, sample.labels...)},
+						Metrics:    []emfMetric{{Name: sample.name, Unit: sample.unit}},
+					}},
+				},
+			}
+
+			for i, label := range sample.labels {
+				document[label] = sample.labelValues[i]
+			}
+
+			if len(sample.values) == 1 {
+				document[sample.name] = sample.values[0]
+			} else {
+				document[sample.name] = sample.values
+			}
+
+			if err := encoder.Encode(document); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// WithEMF wraps a Lambda handler so the metrics recorded during each invocation are logged
+// to stdout when it returns
+func WithEMF[Request, Response any](handler func(context.Context, Request) (Response, error)) func(context.Context, Request) (Response, error) {
+	return func(ctx context.Context, request Request) (Response, error) {
+		defer Default.WriteEMF(os.Stdout, time.Now())
+
+		return handler(ctx, request)
+	}
+}
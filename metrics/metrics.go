@@ -0,0 +1,320 @@
+// Package metrics keeps counters and histograms in memory and exposes them in the Prometheus
+// text format, for the HTTP server mode, or as CloudWatch embedded metric format logs under Lambda
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets suit latencies in seconds, from 5ms to 10s
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default is the registry the package level NewCounter and NewHistogram add metrics to
+var Default = &Registry{}
+
+// Registry holds metrics in the order they were created
+type Registry struct {
+	mutex    sync.Mutex
+	families []family
+}
+
+type family interface {
+	writePrometheus(w io.Writer)
+	drain() []sample
+}
+
+// sample is what happened to one series since the last embedded metric format flush
+type sample struct {
+	name        string
+	unit        string
+	labels      []string
+	labelValues []string
+	values      []float64
+}
+
+func (registry *Registry) register(metric family) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.families = append(registry.families, metric)
+}
+
+func (registry *Registry) list() []family {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	return append([]family{}, registry.families...)
+}
+
+// WritePrometheus writes every metric in the Prometheus text exposition format
+func (registry *Registry) WritePrometheus(w io.Writer) {
+	for _, metric := range registry.list() {
+		metric.writePrometheus(w)
+	}
+}
+
+// Handler serves the registry on /metrics
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		registry.WritePrometheus(w)
+	})
+}
+
+// series is the data kept for one combination of label values
+type series struct {
+	labelValues []string
+	total       float64
+	counts      []uint64
+	count       uint64
+	pending     []float64
+}
+
+// maxPending is the most values the embedded metric format takes for a metric in one log line,
+// Lambda serves a request at a time so a flush per invocation stays well below it
+const maxPending = 100
+
+type metric struct {
+	name   string
+	help   string
+	unit   string
+	labels []string
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+func newMetric(name, help, unit string, labels []string) metric {
+	return metric{name: name, help: help, unit: unit, labels: labels, series: map[string]*series{}}
+}
+
+// get returns the series for given label values, creating it. Callers must hold the mutex.
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	found, ok := m.series[key]
+	if !ok {
+		found = &series{labelValues: append([]string{}, labelValues...)}
+		m.series[key] = found
+	}
+
+	return found
+}
+
+// lookup reads the total and count of a series without creating it. Callers must hold the mutex.
+func (m *metric) lookup(labelValues []string) (float64, uint64) {
+	found, ok := m.series[strings.Join(labelValues, "\xff")]
+	if !ok {
+		return 0, 0
+	}
+
+	return found.total, found.count
+}
+
+func (m *metric) sorted() []*series {
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sorted := make([]*series, len(keys))
+	for i, key := range keys {
+		sorted[i] = m.series[key]
+	}
+
+	return sorted
+}
+
+func (m *metric) drain() []sample {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	samples := []sample{}
+	for _, found := range m.sorted() {
+		if len(found.pending) == 0 {
+			continue
+		}
+
+		samples = append(samples, sample{name: m.name, unit: m.unit, labels: m.labels, labelValues: found.labelValues, values: found.pending})
+		found.pending = nil
+	}
+
+	return samples
+}
+
+func (m *metric) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, kind)
+}
+
+// Counter is a value that only goes up, such as requests or classifications
+type Counter struct {
+	metric
+}
+
+// NewCounter creates a counter in the default registry
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// NewCounter creates a counter in this registry
+func (registry *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	counter := &Counter{metric: newMetric(name, help, "Count", labels)}
+	registry.register(counter)
+
+	return counter
+}
+
+// Inc adds one to the series with given label values
+func (counter *Counter) Inc(labelValues ...string) {
+	counter.Add(1, labelValues...)
+}
+
+// Add adds delta to the series with given label values
+func (counter *Counter) Add(delta float64, labelValues ...string) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	found := counter.get(labelValues)
+	found.total += delta
+
+	// A counter goes out as a single value, the sum since the last flush
+	if len(found.pending) == 0 {
+		found.pending = []float64{0}
+	}
+	found.pending[0] += delta
+}
+
+// Value returns the total of the series with given label values
+func (counter *Counter) Value(labelValues ...string) float64 {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	total, _ := counter.lookup(labelValues)
+
+	return total
+}
+
+func (counter *Counter) writePrometheus(w io.Writer) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	counter.header(w, "counter")
+	for _, found := range counter.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", counter.name, formatLabels(counter.labels, found.labelValues, "", ""), formatFloat(found.total))
+	}
+}
+
+// Histogram counts observations into buckets, such as latencies or sizes
+type Histogram struct {
+	metric
+	buckets []float64
+}
+
+// NewHistogram creates a histogram in the default registry
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// NewHistogram creates a histogram in this registry, buckets are upper bounds in ascending order.
+// Names ending in _seconds are reported to CloudWatch in seconds.
+func (registry *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	unit := "None"
+	if strings.HasSuffix(name, "_seconds") {
+		unit = "Seconds"
+	}
+
+	histogram := &Histogram{metric: newMetric(name, help, unit, labels), buckets: buckets}
+	registry.register(histogram)
+
+	return histogram
+}
+
+// Observe records a value in the series with given label values
+func (histogram *Histogram) Observe(value float64, labelValues ...string) {
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+
+	found := histogram.get(labelValues)
+	if found.counts == nil {
+		found.counts = make([]uint64, len(histogram.buckets))
+	}
+
+	for i, bound := range histogram.buckets {
+		if value <= bound {
+			found.counts[i]++
+			break
+		}
+	}
+
+	found.count++
+	found.total += value
+
+	if len(found.pending) < maxPending {
+		found.pending = append(found.pending, value)
+	}
+}
+
+// Count returns how many values the series with given label values has seen
+func (histogram *Histogram) Count(labelValues ...string) uint64 {
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+
+	_, count := histogram.lookup(labelValues)
+
+	return count
+}
+
+func (histogram *Histogram) writePrometheus(w io.Writer) {
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+
+	histogram.header(w, "histogram")
+	for _, found := range histogram.sorted() {
+		cumulative := uint64(0)
+		for i, bound := range histogram.buckets {
+			cumulative += found.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", histogram.name, formatLabels(histogram.labels, found.labelValues, "le", formatFloat(bound)), cumulative)
+		}
+
+		fmt.Fprintf(w, "%s_bucket%s %d\n", histogram.name, formatLabels(histogram.labels, found.labelValues, "le", "+Inf"), found.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", histogram.name, formatLabels(histogram.labels, found.labelValues, "", ""), formatFloat(found.total))
+		fmt.Fprintf(w, "%s_count%s %d\n", histogram.name, formatLabels(histogram.labels, found.labelValues, "", ""), found.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels, values []string, extraLabel, extraValue string) string {
+	pairs := []string{}
+	for i, label := range labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, label, labelEscaper.Replace(values[i])))
+	}
+
+	if extraLabel != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraLabel, extraValue))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+func TestWritePrometheus(t *testing.T) {
+	registry := &Registry{}
+	classifications := registry.NewCounter("mutants_classifications_total", "DNA classified", "verdict")
+	latency := registry.NewHistogram("query_seconds", "Query latency", []float64{0.1, 1})
+
+	classifications.Inc("mutant")
+	classifications.Inc("human")
+	classifications.Add(2, "mutant")
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(3)
+
+	var buffer bytes.Buffer
+	registry.WritePrometheus(&buffer)
+
+	assert.Equal(t, `# HELP mutants_classifications_total DNA classified
+# TYPE mutants_classifications_total counter
+mutants_classifications_total{verdict="human"} 1
+mutants_classifications_total{verdict="mutant"} 3
+# HELP query_seconds Query latency
+# TYPE query_seconds histogram
+query_seconds_bucket{le="0.1"} 1
+query_seconds_bucket{le="1"} 2
+query_seconds_bucket{le="+Inf"} 3
+query_seconds_sum 3.55
+query_seconds_count 3
+`, buffer.String())
+}
+
+func TestLabelValuesAreEscaped(t *testing.T) {
+	registry := &Registry{}
+	registry.NewCounter("errors_total", "Errors", "message").Inc("say \"hi\"\n")
+
+	var buffer bytes.Buffer
+	registry.WritePrometheus(&buffer)
+
+	assert.Contains(t, buffer.String(), `errors_total{message="say \"hi\"\n"} 1`)
+}
+
+func TestValueAndCount(t *testing.T) {
+	registry := &Registry{}
+	counter := registry.NewCounter("total", "Total", "result")
+	histogram := registry.NewHistogram("size", "Size", []float64{1})
+
+	counter.Inc("hit")
+	histogram.Observe(4)
+
+	assert.Equal(t, float64(1), counter.Value("hit"))
+	assert.Equal(t, float64(0), counter.Value("miss"))
+	assert.Equal(t, uint64(1), histogram.Count())
+
+	var buffer bytes.Buffer
+	registry.WritePrometheus(&buffer)
+
+	assert.NotContains(t, buffer.String(), "miss")
+}
+
+func TestWrongNumberOfLabelValuesPanics(t *testing.T) {
+	counter := (&Registry{}).NewCounter("total", "Total", "result")
+
+	assert.Panics(t, func() { counter.Inc() })
+}
+
+func TestWriteEMF(t *testing.T) {
+	registry := &Registry{}
+	classifications := registry.NewCounter("mutants_classifications_total", "DNA classified", "verdict")
+	scan := registry.NewHistogram("scan_duration_seconds", "Scan", DefaultBuckets)
+
+	classifications.Inc("mutant")
+	classifications.Inc("mutant")
+	scan.Observe(0.25)
+	scan.Observe(0.5)
+
+	var buffer bytes.Buffer
+	assert.Nil(t, registry.WriteEMF(&buffer, now))
+
+	lines := bytes.Split(bytes.TrimSpace(buffer.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+
+	assert.JSONEq(t, `{
+		"_aws": {
+			"Timestamp": 1792411200000,
+			"CloudWatchMetrics": [{
+				"Namespace": "Mutants",
+				"Dimensions": [["verdict"]],
+				"Metrics": [{"Name": "mutants_classifications_total", "Unit": "Count"}]
+			}]
+		},
+		"verdict": "mutant",
+		"mutants_classifications_total": 2
+	}`, string(lines[0]))
+
+	var document map[string]interface{}
+	json.Unmarshal(lines[1], &document)
+	assert.Equal(t, []interface{}{0.25, 0.5}, document["scan_duration_seconds"])
+	assert.Contains(t, string(lines[1]), `"Unit":"Seconds"`)
+
+	// Only what happened since the last flush goes out
+	buffer.Reset()
+	classifications.Inc("human")
+	assert.Nil(t, registry.WriteEMF(&buffer, now))
+
+	assert.Equal(t, 1, bytes.Count(buffer.Bytes(), []byte("\n")))
+	assert.Contains(t, buffer.String(), `"verdict":"human"`)
+	assert.Equal(t, float64(3), classifications.Value("mutant")+classifications.Value("human"))
+}
+
+func TestHandler(t *testing.T) {
+	registry := &Registry{}
+	registry.NewCounter("requests_total", "Requests").Inc()
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, "text/plain; version=0.0.4", recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Body.String(), "requests_total 1\n")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/database"
+	"github.com/felipefill/mutants/fingerprint"
+	"github.com/felipefill/mutants/utils"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		fail(err)
+	}
+
+	config.Set(cfg)
+
+	algorithm := flag.String("algorithm", cfg.Detection.HashAlgorithm, "Hash algorithm used when rehashing: sha1, sha256 or blake2b")
+	canonicalize := flag.Bool("canonicalize", false, "Rehash stored DNA using its canonical form, needed once DNA_CANONICAL_HASH is enabled")
+	pack := flag.Bool("pack", false, "Pack DNA stored as JSON before it was stored at 2 bits per base")
+	flag.Parse()
+
+	if !fingerprint.Supported(*algorithm) {
+		fail(fmt.Errorf("Unknown hash algorithm %s", *algorithm))
+	}
+
+	db := utils.GetDB()
+
+	applied, err := database.Migrate(db)
+	if err != nil {
+		fail(err)
+	}
+
+	fmt.Printf("Applied %d migration(s), schema is at version %d\n", applied, database.SchemaVersion())
+
+	if *canonicalize {
+		rehashed, removed, err := database.Canonicalize(db, *algorithm)
+		if err != nil {
+			fail(err)
+		}
+
+		fmt.Printf("Rehashed %d DNA(s) and removed %d transformed duplicate(s), stats no longer count the removed ones\n", rehashed, removed)
+	}
+
+	if *pack {
+		packed, err := database.Pack(db)
+		if err != nil {
+			fail(err)
+		}
+
+		fmt.Printf("Packed %d DNA(s)\n", packed)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(1)
+}
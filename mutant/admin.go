@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/bearer"
+	"github.com/felipefill/mutants/logging"
+	"github.com/felipefill/mutants/matrix"
+	"github.com/felipefill/mutants/utils"
+)
+
+// defaultReclassifyBatch and maxReclassifyBatch bound the rows reclassified by one request, so it
+// finishes well within the function timeout
+const (
+	defaultReclassifyBatch = 500
+	maxReclassifyBatch     = 5000
+)
+
+// purgeRequest names either a client or a hash
+type purgeRequest struct {
+	ClientID int64  `json:"client_id,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+type reclassifyRequest struct {
+	AfterID int64 `json:"after_id,omitempty" doc:"Rows with a larger id are checked"`
+	Limit   int   `json:"limit,omitempty" doc:"Rows checked, 500 by default and 5000 at most"`
+}
+
+// purgeResult tells how many DNA were deleted
+type purgeResult struct {
+	Purged int64 `json:"purged"`
+}
+
+// reclassifyResult tells how far reclassification went, NextAfterID is 0 once every row was checked
+type reclassifyResult struct {
+	Checked     int   `json:"checked"`
+	Changed     int   `json:"changed"`
+	NextAfterID int64 `json:"next_after_id" doc:"Where the next batch starts, 0 once every row was checked"`
+}
+
+// admin runs the operations only tokens with the admin scope may run, API keys never grant it
+func admin(ctx context.Context, request events.APIGatewayProxyRequest) events.APIGatewayProxyResponse {
+	ctx, logger := logging.ForRequest(ctx, request)
+	outcome := logging.Start(logger)
+
+	var response events.APIGatewayProxyResponse
+	var err error
+
+	switch {
+	case !bearer.Granted(ctx, bearer.ScopeAdmin):
+		response, err = events.APIGatewayProxyResponse{Body: "Admin scope required", StatusCode: 403}, errors.New("Admin scope required")
+	case request.Resource == "/admin/purge":
+		response, err = purge(ctx, request, outcome)
+	default:
+		response, err = reclassify(ctx, request, outcome)
+	}
+
+	if err != nil {
+		outcome.Fail(err)
+	}
+
+	outcome.Done(response.StatusCode)
+
+	return response
+}
+
+// purge deletes the DNA submitted by a client, such as an abuser, or a single DNA by its hash.
+// Stats stop counting them right away.
+func purge(ctx context.Context, request events.APIGatewayProxyRequest, outcome *logging.Outcome) (events.APIGatewayProxyResponse, error) {
+	var body purgeRequest
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil || (body.ClientID == 0) == (body.Hash == "") {
+		err := errors.New("Purge needs either a client_id or a hash")
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: 400}, err
+	}
+
+	query, argument := "delete from dna where hashed = $1", interface{}(body.Hash)
+	if body.ClientID != 0 {
+		query, argument = "delete from dna where client_id = $1", body.ClientID
+	}
+
+	db, err := utils.ConnectDB()
+	if err != nil {
+		return events.APIGatewayProxyResponse{Body: "Failed to purge DNA", StatusCode: 500}, err
+	}
+
+	var purged int64
+	err = utils.WithRetry(ctx, func(ctx context.Context) error {
+		result, err := db.ExecContext(ctx, query, argument)
+		if err != nil {
+			return err
+		}
+
+		purged, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return databaseFailure(ctx, err, "Failed to purge DNA")
+	}
+
+	outcome.Set("purged", purged)
+
+	return jsonResponse(200, purgeResult{Purged: purged}), nil
+}
+
+// reclassify checks stored DNA again with the rules in use, which is needed after changing them,
+// and updates the verdicts that changed. Rows are walked in batches by id, each request returns
+// where the next one should start.
+func reclassify(ctx context.Context, request events.APIGatewayProxyRequest, outcome *logging.Outcome) (events.APIGatewayProxyResponse, error) {
+	body := reclassifyRequest{Limit: defaultReclassifyBatch}
+	if request.Body != "" {
+		if err := json.Unmarshal([]byte(request.Body), &body); err != nil || body.Limit < 1 || body.Limit > maxReclassifyBatch || body.AfterID < 0 {
+			err := errors.New("Reclassify takes an after_id and a limit between 1 and 5000")
+			return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: 400}, err
+		}
+	}
+
+	db, err := utils.ConnectDB()
+	if err != nil {
+		return events.APIGatewayProxyResponse{Body: "Failed to reclassify DNA", StatusCode: 500}, err
+	}
+
+	var result reclassifyResult
+	err = utils.WithRetry(ctx, func(ctx context.Context) error {
+		result = reclassifyResult{}
+
+		rows, err := db.QueryContext(ctx, "select id, data, packed, type from dna where id > $1 order by id limit $2", body.AfterID, body.Limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		type change struct {
+			id      int64
+			dnaType string
+		}
+
+		changes := []change{}
+		for rows.Next() {
+			var id int64
+			var data, packedData []byte
+			var storedType string
+
+			if err := rows.Scan(&id, &data, &packedData, &storedType); err != nil {
+				return err
+			}
+
+			result.Checked++
+			result.NextAfterID = id
+
+			// Rows stored before DNA was packed only have it as JSON
+			packed, err := matrix.Load(packedData, data)
+			if err != nil {
+				continue
+			}
+
+			check := DNACheck{packed: packed}
+
+			dnaType := "ordinary"
+			if check.hasMutantSequences() {
+				dnaType = "mutant"
+			}
+
+			if dnaType != storedType {
+				changes = append(changes, change{id, dnaType})
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows.Close()
+
+		for _, change := range changes {
+			if _, err := db.ExecContext(ctx, "update dna set type = $1 where id = $2", change.dnaType, change.id); err != nil {
+				return err
+			}
+		}
+
+		result.Changed = len(changes)
+		return nil
+	})
+	if err != nil {
+		return databaseFailure(ctx, err, "Failed to reclassify DNA")
+	}
+
+	if result.Checked < body.Limit {
+		result.NextAfterID = 0
+	}
+
+	outcome.Set("checked", result.Checked)
+	outcome.Set("changed", result.Changed)
+
+	return jsonResponse(200, result), nil
+}
+
+func databaseFailure(ctx context.Context, err error, message string) (events.APIGatewayProxyResponse, error) {
+	err = utils.DatabaseError(ctx, err, message)
+	if err == utils.ErrTimeout {
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: 504}, err
+	}
+
+	return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: 500}, err
+}
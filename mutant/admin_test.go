@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/apikey"
+	"github.com/felipefill/mutants/bearer"
+	"github.com/felipefill/mutants/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func adminContext() context.Context {
+	return bearer.WithClaims(context.Background(), bearer.Claims{Subject: "ops", Scopes: []string{bearer.ScopeAdmin}})
+}
+
+func TestAdminRequiresTheAdminScope(t *testing.T) {
+	// An API key authenticates the client but never grants admin operations
+	ctx := apikey.WithClient(context.Background(), apikey.Client{ID: 4, Name: "acme"})
+
+	response, err := Handler(ctx, events.APIGatewayProxyRequest{Resource: "/admin/purge", Body: `{"client_id": 4}`})
+
+	assert.Nil(t, err)
+	assert.Equal(t, events.APIGatewayProxyResponse{Body: "Admin scope required", StatusCode: 403}, response)
+}
+
+func TestPurgeByClient(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectExec("delete from dna where client_id = \\$1").
+		WithArgs(int64(4)).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+
+	response, err := Handler(adminContext(), events.APIGatewayProxyRequest{Resource: "/admin/purge", Body: `{"client_id": 4}`})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.JSONEq(t, `{"purged":12}`, response.Body)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestPurgeByHash(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectExec("delete from dna where hashed = \\$1").
+		WithArgs("abc123").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	response, _ := Handler(adminContext(), events.APIGatewayProxyRequest{Resource: "/admin/purge", Body: `{"hash": "abc123"}`})
+
+	assert.JSONEq(t, `{"purged":1}`, response.Body)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestPurgeNeedsExactlyOneTarget(t *testing.T) {
+	for _, body := range []string{``, `{}`, `{"client_id": 4, "hash": "abc123"}`} {
+		response, _ := Handler(adminContext(), events.APIGatewayProxyRequest{Resource: "/admin/purge", Body: body})
+
+		assert.Equal(t, events.APIGatewayProxyResponse{Body: "Purge needs either a client_id or a hash", StatusCode: 400}, response, body)
+	}
+}
+
+func TestReclassifyUpdatesChangedVerdicts(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	// The first row was stored before DNA was packed
+	mutant, _ := json.Marshal(mutantDNASequence)
+
+	mock.
+		ExpectQuery("select id, data, packed, type from dna where id > \\$1 order by id limit \\$2").
+		WithArgs(int64(10), 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "data", "packed", "type"}).
+			AddRow(11, mutant, nil, "mutant").
+			AddRow(12, nil, packedData(humanDNASequence), "mutant").
+			AddRow(13, nil, packedData(mutantDNASequence), "ordinary"))
+	mock.
+		ExpectExec("update dna set type = \\$1 where id = \\$2").
+		WithArgs("ordinary", int64(12)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("update dna set type = \\$1 where id = \\$2").
+		WithArgs("mutant", int64(13)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	response, err := Handler(adminContext(), events.APIGatewayProxyRequest{Resource: "/admin/reclassify", Body: `{"after_id": 10, "limit": 3}`})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.JSONEq(t, `{"checked":3,"changed":2,"next_after_id":13}`, response.Body)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestReclassifyReportsTheEnd(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select id, data, packed, type from dna").
+		WithArgs(int64(0), defaultReclassifyBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "data", "packed", "type"}))
+
+	response, _ := Handler(adminContext(), events.APIGatewayProxyRequest{Resource: "/admin/reclassify"})
+
+	assert.JSONEq(t, `{"checked":0,"changed":0,"next_after_id":0}`, response.Body)
+}
+
+func TestReclassifyRejectsLargeBatches(t *testing.T) {
+	response, _ := Handler(adminContext(), events.APIGatewayProxyRequest{Resource: "/admin/reclassify", Body: `{"limit": 50000}`})
+
+	assert.Equal(t, 400, response.StatusCode)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/database"
+	"github.com/felipefill/mutants/fingerprint"
+	"github.com/felipefill/mutants/matrix"
+	"github.com/felipefill/mutants/openapi"
+	"github.com/felipefill/mutants/utils"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// The contract tests run the handler and check its responses against the OpenAPI document, a
+// response it does not describe fails them
+
+func classifyRequest(body string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/mutant", Body: body}
+}
+
+// expectStoredType answers the lookup of a DNA with the type it was stored as
+func expectStoredType(mock sqlmock.Sqlmock, dna []string, dnaType string) {
+	check := DNACheck{DNA: dna}
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "hash_algorithm"}).AddRow(dnaType, fingerprint.SHA256))
+}
+
+func assertDocumented(t *testing.T, method, path string, response events.APIGatewayProxyResponse, statusCode int) {
+	t.Helper()
+
+	assert.Equal(t, statusCode, response.StatusCode, response.Body)
+	assert.Nil(t, openapi.Check(method, path, response))
+}
+
+func TestContractClassify(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	expectStoredType(mock, mutantDNASequence, "mutant")
+	response, _ := Handler(context.Background(), classifyRequest(mutantDNASequenceAsJSONString))
+	assertDocumented(t, "POST", "/mutant", response, 200)
+
+	expectStoredType(mock, humanDNASequence, "ordinary")
+	response, _ = Handler(context.Background(), classifyRequest(humanDNASequenceAsJSONString))
+	assertDocumented(t, "POST", "/mutant", response, 403)
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestContractClassifyWhileDegraded(t *testing.T) {
+	enableDegradedMode(t)
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillReturnError(sql.ErrConnDone)
+
+	response, _ := Handler(context.Background(), classifyRequest(humanDNASequenceAsJSONString))
+
+	assertDocumented(t, "POST", "/mutant", response, 403)
+	assert.Equal(t, "false", response.Headers[persistedHeader])
+}
+
+func TestContractClassifyRejections(t *testing.T) {
+	for _, body := range []string{"", invalidDNASequenceStringWrongBases, `{"dna": ["AT", "CG"], "extra": 1}`, `{"version": 2, "dna": ["AT", "CG"], "metadata": {"tags": [""]}}`} {
+		response, _ := Handler(context.Background(), classifyRequest(body))
+		assertDocumented(t, "POST", "/mutant", response, 400)
+	}
+
+	previous := limits
+	limits = matrix.Limits{MaxBodyBytes: 64, MaxSize: 3, MaxBases: 9}
+	defer func() { limits = previous }()
+
+	response, _ := Handler(context.Background(), classifyRequest(`{"dna": ["ATGC", "CAGT", "TTAT", "AGAC"]}`))
+	assertDocumented(t, "POST", "/mutant", response, 422)
+
+	response, _ = Handler(context.Background(), classifyRequest(strings.Repeat(" ", 65)+`{}`))
+	assertDocumented(t, "POST", "/mutant", response, 413)
+}
+
+func TestContractClassifyFailures(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillReturnError(sql.ErrConnDone)
+
+	response, _ := Handler(context.Background(), classifyRequest(mutantDNASequenceAsJSONString))
+	assertDocumented(t, "POST", "/mutant", response, 500)
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "hash_algorithm"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	response, _ = Handler(ctx, classifyRequest(mutantDNASequenceAsJSONString))
+	assertDocumented(t, "POST", "/mutant", response, 504)
+}
+
+func TestContractProbesAndDocuments(t *testing.T) {
+	for _, path := range []string{"/healthz", "/schema", "/openapi.json"} {
+		response, _ := Handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: path})
+		assertDocumented(t, "GET", path, response, 200)
+	}
+
+	response, _ := Handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/openapi.json"})
+	assert.Equal(t, string(openapi.Document()), response.Body)
+}
+
+func TestContractReadiness(t *testing.T) {
+	db, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	expectReadinessQueries(mock, database.SchemaVersion())
+	response, _ := Handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/readyz"})
+	assertDocumented(t, "GET", "/readyz", response, 200)
+
+	expectReadinessQueries(mock, database.SchemaVersion()-1)
+	response, _ = Handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/readyz"})
+	assertDocumented(t, "GET", "/readyz", response, 503)
+
+	// Failed checks carry their error
+	enableDegradedMode(t)
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.
+		ExpectQuery("select coalesce\\(max\\(version\\), 0\\) from schema_migrations").
+		WillReturnError(errors.New("connection refused"))
+
+	response, _ = Handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/readyz"})
+	assertDocumented(t, "GET", "/readyz", response, 200)
+	assert.Contains(t, response.Body, `"error"`)
+}
+
+func TestContractAdmin(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectExec("delete from dna where client_id = \\$1").
+		WithArgs(int64(4)).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.
+		ExpectQuery("select id, data, packed, type from dna").
+		WithArgs(int64(0), defaultReclassifyBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "data", "packed", "type"}))
+	mock.
+		ExpectExec("delete from dna where hashed = \\$1").
+		WillReturnError(sql.ErrConnDone)
+
+	for _, test := range []struct {
+		ctx        context.Context
+		path       string
+		body       string
+		statusCode int
+	}{
+		{adminContext(), "/admin/purge", `{"client_id": 4}`, 200},
+		{adminContext(), "/admin/reclassify", ``, 200},
+		{adminContext(), "/admin/purge", `{"hash": "abc123"}`, 500},
+		{adminContext(), "/admin/purge", `{}`, 400},
+		{adminContext(), "/admin/reclassify", `{"limit": 50000}`, 400},
+		{context.Background(), "/admin/purge", `{"client_id": 4}`, 403},
+	} {
+		response, _ := Handler(test.ctx, events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: test.path, Body: test.body})
+		assertDocumented(t, "POST", test.path, response, test.statusCode)
+	}
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+// The OpenAPI document is generated from the route table, its fragment must be written again
+// whenever the routes or the types they answer change
+func TestContractDocument(t *testing.T) {
+	openapi.CheckGolden(t, "mutant", routes)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/matrix"
+	"github.com/felipefill/mutants/tracing"
+)
+
+// limits bound the DNA a request can send, they can be changed through configuration
+var limits = matrix.Limits{
+	MaxBodyBytes: config.Default().Limits.MaxBodyBytes,
+	MaxSize:      config.Default().Limits.MaxSize,
+	MaxBases:     config.Default().Limits.MaxBases,
+}
+
+// NewDNACheckFromJSONString creates a DNA check from a json string
+func NewDNACheckFromJSONString(ctx context.Context, data string) (DNACheck, error) {
+	return NewDNACheckFromReader(ctx, strings.NewReader(data), matrix.JSON, len(data))
+}
+
+// NewDNACheckFromReader creates a DNA check from DNA in given format read as it comes. Rows are
+// checked while reading, so input over the limits in use or with invalid bases is rejected,
+// with a *matrix.LimitError for limits, before the rest of it is read. Size is only used for
+// tracing, it is the length of the body when known.
+func NewDNACheckFromReader(ctx context.Context, reader io.Reader, format matrix.Format, size int) (DNACheck, error) {
+	_, span := tracing.Start(ctx, "dna.parse", tracing.Int("dna.bytes", size), tracing.String("dna.format", string(format)))
+	fields := &requestFields{}
+	decoded, err := decodeRequest(reader, format, fields)
+	if err == nil {
+		span.SetAttributes(tracing.Int("dna.size", decoded.Size()))
+	}
+	span.RecordError(err)
+	span.End()
+
+	if err != nil {
+		return DNACheck{}, err
+	}
+
+	_, span = tracing.Start(ctx, "dna.validate", tracing.Int("dna.size", decoded.Size()))
+	var metadata *Metadata
+	if format == matrix.JSON {
+		metadata, err = fields.check()
+	}
+	span.RecordError(err)
+	span.End()
+
+	if err != nil {
+		return DNACheck{}, err
+	}
+
+	return DNACheck{DNA: decoded.Rows(), Metadata: metadata, packed: matrix.Pack(decoded)}, nil
+}
+
+// decodeRequest reads the DNA in given format, the other fields of JSON requests are kept in
+// fields to be checked against the schema of their version. Other formats carry no metadata.
+func decodeRequest(reader io.Reader, format matrix.Format, fields *requestFields) (*matrix.Matrix, error) {
+	if format != matrix.JSON {
+		return matrix.DecodeFormat(reader, format, limits)
+	}
+
+	return matrix.DecodeFields(reader, limits, fields.add)
+}
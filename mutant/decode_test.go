@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/matrix"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDNACheckFromJSONStringDecodesRows(t *testing.T) {
+	cases := map[string][]string{
+		`{"dna": ["ATG", "CAG", "TTA"]}`:             {"ATG", "CAG", "TTA"},
+		`{"DNA": ["A"]}`:                             {"A"},
+		`{"Dna": []}`:                                {},
+		`{"dna": null}`:                              {},
+		"  {\"dna\": [\"AT\", \"CG\"]}\n":            {"AT", "CG"},
+		`{"dna": ["AG", "TC"], "dna": ["AT", "CG"]}`: {"AT", "CG"},
+	}
+
+	for data, expected := range cases {
+		dnaCheck, err := NewDNACheckFromJSONString(context.Background(), data)
+
+		assert.Nil(t, err, data)
+		assert.Equal(t, expected, dnaCheck.DNA, data)
+	}
+}
+
+// A table that is not NxN is reported first, whatever its bases, both when decoding and by validate
+func TestNewDNACheckFromJSONStringReportsTheFirstErrorRead(t *testing.T) {
+	cases := []struct {
+		dna       []string
+		decoded   string
+		validated string
+	}{
+		{[]string{"ATX", "CA", "TTA"}, "DNA has invalid bases", "DNA is not an NxN table"},
+		{[]string{"ATG", "CXG", "TT"}, "DNA has invalid bases", "DNA is not an NxN table"},
+		{[]string{"ATG", "CAGX", "TTA"}, "DNA is not an NxN table", "DNA is not an NxN table"},
+		{tableMxN, "DNA has invalid bases", "DNA is not an NxN table"},
+		{[]string{"ATG", "CXG", "TTA"}, "DNA has invalid bases", "DNA has invalid bases"},
+	}
+
+	for _, test := range cases {
+		data, _ := json.Marshal(DNACheck{DNA: test.dna})
+
+		_, err := NewDNACheckFromJSONString(context.Background(), string(data))
+		assert.EqualError(t, err, test.decoded, string(data))
+
+		dnaCheck := DNACheck{DNA: test.dna}
+		assert.EqualError(t, dnaCheck.validate(), test.validated, string(data))
+	}
+}
+
+func TestHandlerAnswersLimitErrorsAsJSON(t *testing.T) {
+	previous := limits
+	limits = matrix.Limits{MaxBodyBytes: 64, MaxSize: 3, MaxBases: 9}
+	defer func() { limits = previous }()
+
+	response, err := Handler(context.Background(), events.APIGatewayProxyRequest{Resource: "/mutant", Body: `{"dna": ["ATGC", "CAGT", "TTAT", "AGAC"]}`})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 422, response.StatusCode)
+	assert.Equal(t, "application/json", response.Headers["Content-Type"])
+	assert.JSONEq(t, `{"error":"DNA has rows longer than 3 bases","limit":3}`, response.Body)
+
+	response, _ = Handler(context.Background(), events.APIGatewayProxyRequest{Resource: "/mutant", Body: strings.Repeat(" ", 65) + `{}`})
+
+	assert.Equal(t, 413, response.StatusCode)
+	assert.JSONEq(t, `{"error":"Request body is larger than 64 bytes","limit":64}`, response.Body)
+
+	response, _ = Handler(context.Background(), events.APIGatewayProxyRequest{Resource: "/mutant", Body: `{"dna": ["ATG", "CA", "TTA"]}`})
+
+	assert.Equal(t, events.APIGatewayProxyResponse{Body: "DNA is not an NxN table", StatusCode: 400}, response)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/fingerprint"
+	"github.com/felipefill/mutants/pending"
+	"github.com/felipefill/mutants/utils"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// enableDegradedMode turns degraded mode on with an empty queue for the duration of a test, the
+// first successful lookup flushes it
+func enableDegradedMode(t *testing.T) *pending.Queue {
+	previousMode, previousQueue := degradedMode, pendingWrites
+
+	degradedMode = true
+	pendingWrites = pending.NewQueue(filepath.Join(t.TempDir(), "pending.jsonl"))
+	flushes.last = time.Time{}
+
+	t.Cleanup(func() {
+		degradedMode, pendingWrites = previousMode, previousQueue
+	})
+
+	return pendingWrites
+}
+
+func TestClassifyWhenLookupFails(t *testing.T) {
+	queue := enableDegradedMode(t)
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillReturnError(sql.ErrConnDone)
+
+	check := DNACheck{DNA: mutantDNASequence}
+	classification, err := check.Classify(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, Classification{Mutant: true, Persisted: false}, classification)
+
+	queued, _ := queue.Len()
+	assert.Equal(t, 1, queued)
+}
+
+func TestClassifyWhenSaveFails(t *testing.T) {
+	queue := enableDegradedMode(t)
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	check := DNACheck{DNA: humanDNASequence}
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillReturnError(sql.ErrNoRows)
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into dna").
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	classification, err := check.Classify(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, Classification{Mutant: false, Persisted: false}, classification)
+
+	queue.Flush(func(write pending.Write) error {
+		assert.Equal(t, hashOf(check, hashAlgorithm), write.Hash)
+		assert.Equal(t, "ordinary", write.Type)
+		assert.Equal(t, fingerprint.SHA256, write.HashAlgorithm)
+		return nil
+	})
+}
+
+func TestClassifyFlushesPendingWritesOnceDatabaseIsBack(t *testing.T) {
+	queue := enableDegradedMode(t)
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	queued := DNACheck{DNA: humanDNASequence}
+	queue.Append(writeOf(queued, "ordinary"))
+	queuedAsPacked := packedData(queued.DNA)
+
+	check := DNACheck{DNA: mutantDNASequence}
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"type", "hash_algorithm"}).
+				AddRow("mutant", fingerprint.SHA256),
+		)
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into dna").
+		WithArgs(hashOf(queued, hashAlgorithm), "ordinary", queuedAsPacked, false, fingerprint.SHA256, sql.NullInt64{}).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "inserted"}).AddRow("ordinary", true))
+	mock.
+		ExpectExec("insert into outbox").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	classification, err := check.Classify(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, Classification{Mutant: true, Persisted: true, Cached: true}, classification)
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	left, _ := queue.Len()
+	assert.Equal(t, 0, left)
+}
+
+func TestFlushMovesRejectedWritesToDeadLettersAndWaitsForTheInterval(t *testing.T) {
+	queue := enableDegradedMode(t)
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	rejected := DNACheck{DNA: humanDNASequence}
+	queue.Append(writeOf(rejected, "ordinary"))
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into dna").
+		WillReturnError(&pq.Error{Code: "23514", Message: "new row violates check constraint"})
+	mock.ExpectRollback()
+
+	flushPendingWrites(context.Background())
+
+	left, _ := queue.Len()
+	assert.Equal(t, 0, left)
+
+	letters, _ := queue.DeadLetters()
+	if assert.Len(t, letters, 1) {
+		assert.Equal(t, hashOf(rejected, hashAlgorithm), letters[0].Hash)
+	}
+
+	// Until the interval is over nothing is flushed, whatever was queued since
+	queue.Append(writeOf(rejected, "ordinary"))
+	flushPendingWrites(context.Background())
+
+	left, _ = queue.Len()
+	assert.Equal(t, 1, left)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestFlushKeepsWritesWhileDatabaseIsUnavailable(t *testing.T) {
+	queue := enableDegradedMode(t)
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	queued := DNACheck{DNA: humanDNASequence}
+	queue.Append(writeOf(queued, "ordinary"))
+
+	mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
+
+	flushPendingWrites(context.Background())
+
+	left, _ := queue.Len()
+	assert.Equal(t, 1, left)
+
+	letters, _ := queue.DeadLetters()
+	assert.Empty(t, letters)
+}
+
+func TestClassifyWithoutDegradedModeFails(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillReturnError(sql.ErrConnDone)
+
+	check := DNACheck{DNA: mutantDNASequence}
+	_, err := check.Classify(context.Background())
+
+	assert.NotNil(t, err)
+}
+
+func TestHandlerMarksResultsThatWereNotPersisted(t *testing.T) {
+	enableDegradedMode(t)
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillReturnError(sql.ErrConnDone)
+
+	expectedResponse := events.APIGatewayProxyResponse{
+		Body:       "",
+		StatusCode: 403,
+		Headers:    map[string]string{"X-Result-Persisted": "false"},
+	}
+
+	actualResponse, actualError := Handler(context.Background(), events.APIGatewayProxyRequest{Body: humanDNASequenceAsJSONString})
+
+	assert.Nil(t, actualError)
+	assert.Equal(t, expectedResponse, actualResponse)
+}
+
+func TestDegradedModeOnlyWorksAroundAnUnavailableDatabase(t *testing.T) {
+	queue := enableDegradedMode(t)
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "hash_algorithm"}))
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillReturnError(errors.New("relation \"dna\" does not exist"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	response, err := Handler(ctx, events.APIGatewayProxyRequest{Body: humanDNASequenceAsJSONString})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 504, response.StatusCode)
+
+	response, err = Handler(context.Background(), events.APIGatewayProxyRequest{Body: humanDNASequenceAsJSONString})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 500, response.StatusCode)
+
+	queued, _ := queue.Len()
+	assert.Equal(t, 0, queued)
+}
@@ -1,249 +1,566 @@
 package main
 
 import (
-	"crypto/sha1"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"strings"
-
+	"sync"
+	"time"
+
+	"github.com/felipefill/mutants/apikey"
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/fingerprint"
+	"github.com/felipefill/mutants/logging"
+	"github.com/felipefill/mutants/matrix"
+	"github.com/felipefill/mutants/outbox"
+	"github.com/felipefill/mutants/pending"
+	"github.com/felipefill/mutants/tracing"
 	"github.com/felipefill/mutants/utils"
+	"github.com/felipefill/mutants/webhook"
+	"github.com/lib/pq"
 )
 
-const repetitionRequiredForSequence int = 4
+// Detection rules, they can be changed through configuration
+var repetitionRequiredForSequence = 4
+var sequencesRequiredForMutant = 2
+
+// canonicalHashing makes rotated, mirrored and transposed duplicates share the same hash
+var canonicalHashing = false
+
+// hashAlgorithm is used for new rows, rows hashed with another one are rehashed as they are looked up
+var hashAlgorithm = fingerprint.SHA256
+
+// degradedMode keeps classifying while the database is unavailable, writes are queued in
+// pendingWrites and flushed once the database answers again
+var degradedMode = false
+var pendingWrites = pending.NewQueue(config.Default().Database.PendingWrites)
+
+// flushInterval spaces out the flushes of pending writes, which run on the request that finds the
+// database back, so that requests do not each read the queue. Only one flush runs at a time.
+var flushInterval = 30 * time.Second
+var flushes = struct {
+	sync.Mutex
+	last    time.Time
+	running bool
+}{}
 
 // DNACheck represents a DNA check
 type DNACheck struct {
 	DNA []string `json:"Dna"`
+
+	// Metadata was sent along with the DNA, it is nil when none was
+	Metadata *Metadata `json:"-"`
+
+	// packed is the DNA at 2 bits per base, which is what is scanned and stored. It is filled
+	// when decoding and packed from DNA on first use otherwise.
+	packed *matrix.Packed
+
+	// canonical is the canonical form of DNA, computed the first time it is hashed with
+	// canonical hashing on
+	canonical []string
+}
+
+// pack returns the packed DNA, failing when it is not a valid table
+func (dnaCheck *DNACheck) pack() (*matrix.Packed, error) {
+	if dnaCheck.packed == nil {
+		packed, err := matrix.PackRows(dnaCheck.DNA)
+		if err != nil {
+			return nil, err
+		}
+
+		dnaCheck.packed = packed
+	}
+
+	return dnaCheck.packed, nil
 }
 
-// NewDNACheckFromJSONString creates a DNA check from a json string
-func NewDNACheckFromJSONString(data string) (DNACheck, error) {
-	dnaCheck := DNACheck{}
-	err := json.Unmarshal([]byte(data), &dnaCheck)
+// Save stores DNA in our database and returns the stored type. When the same DNA was
+// stored concurrently by another request nothing is overwritten and its type is returned.
+func (dnaCheck *DNACheck) Save(ctx context.Context, dnaType string) (string, error) {
+	ctx, span := tracing.Start(ctx, "dna.save", tracing.String("dna.verdict", verdict(dnaType == "mutant")))
+	defer span.End()
 
+	write, err := dnaCheck.pendingWrite(ctx, dnaType)
 	if err != nil {
-		return DNACheck{}, errors.New("Could not parse DNA check")
+		span.RecordError(err)
+		return "", err
 	}
 
-	err = dnaCheck.validate()
+	storedType, err := storeWrite(ctx, write)
 	if err != nil {
-		return DNACheck{}, err
+		span.RecordError(err)
+		return "", utils.DatabaseError(ctx, err, "Failed to store DNA")
 	}
 
-	return dnaCheck, nil
+	return storedType, nil
 }
 
-// Save stores DNA in our database
-func (dnaCheck *DNACheck) Save(dnaType string) {
-	db := utils.GetDB()
-
-	sequenceAsJSON, _ := json.Marshal(&dnaCheck.DNA)
-
-	_, err := db.Exec("insert into dna(hashed, type, data) values($1, $2, $3)", dnaCheck.Hash(), dnaType, sequenceAsJSON)
+func (dnaCheck *DNACheck) pendingWrite(ctx context.Context, dnaType string) (pending.Write, error) {
+	hash, err := dnaCheck.Hash()
 	if err != nil {
-		panic("Failed to store DNA")
+		return pending.Write{}, err
 	}
-}
 
-// Hash returns a SHA1 hash that identifies this DNA check
-func (dnaCheck *DNACheck) Hash() string {
-	sequenceAsOneString := strings.Join(dnaCheck.DNA, ",")
+	sequenceAsJSON, _ := json.Marshal(&dnaCheck.DNA)
+	client, _ := apikey.ClientFromContext(ctx)
+
+	write := pending.Write{
+		Hash:          hash,
+		Type:          dnaType,
+		Data:          sequenceAsJSON,
+		Canonical:     canonicalHashing,
+		HashAlgorithm: hashAlgorithm,
+		ClientID:      client.ID,
+		QueuedAt:      time.Now().UTC(),
+	}
 
-	hash := sha1.New()
-	hash.Write([]byte(sequenceAsOneString))
+	if dnaCheck.Metadata != nil {
+		write.Metadata, _ = json.Marshal(dnaCheck.Metadata)
+	}
 
-	return fmt.Sprintf("%x", hash.Sum(nil))
+	return write, nil
 }
 
-// IsMutant checks whether this is a DNA sequence from a mutant
-func (dnaCheck *DNACheck) IsMutant() bool {
-	dnaType := dnaCheck.lookDNATypeInDatabase()
-	if dnaType == "mutant" {
-		return true
+// storeWrite inserts a DNA row, it is shared by Save and by the flush of pending writes. A new
+// row records a dna.classified event, and webhook deliveries when it is a mutant, in the same
+// transaction, so they are never missed nor invented. Pending writes hold the DNA as JSON, it is
+// stored packed.
+func storeWrite(ctx context.Context, write pending.Write) (string, error) {
+	packed, err := matrix.Load(nil, write.Data)
+	if err != nil {
+		return "", err
 	}
 
-	if dnaType == "ordinary" {
-		return false
-	}
+	data, _ := packed.MarshalBinary()
 
-	count := 0
+	db, err := utils.ConnectDB()
+	if err != nil {
+		return "", err
+	}
 
-	for row := 0; row < len(dnaCheck.DNA) && count < 2; row++ {
-		for column := 0; column < len(dnaCheck.DNA[row]) && count < 2; column++ {
-			if dnaCheck.CheckSequenceToTheRight(row, column) {
-				count++
-			}
+	var storedType string
+	err = utils.WithRetry(ctx, func(ctx context.Context) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// The no-op update makes returning work for conflicting rows as well, it also makes retries
+		// safe. xmax is only zero for rows this statement inserted. Rows keep the client that
+		// submitted them first, metadata is kept for every submission.
+		var inserted bool
+		err = tx.QueryRowContext(ctx,
+			"insert into dna(hashed, type, packed, canonical, hash_algorithm, client_id) values($1, $2, $3, $4, $5, $6) "+
+				"on conflict (hashed) do update set type = dna.type returning type, (xmax = 0) as inserted",
+			write.Hash, write.Type, data, write.Canonical, write.HashAlgorithm, clientID(write),
+		).Scan(&storedType, &inserted)
+		if err != nil {
+			return err
+		}
 
-			if dnaCheck.CheckSequenceDown(row, column) {
-				count++
-			}
+		if err := insertMetadata(ctx, tx, []string{write.Hash}, write); err != nil {
+			return err
+		}
 
-			if dnaCheck.CheckSequenceDiagonalLeft(row, column) {
-				count++
+		if inserted {
+			event := classifiedEvent(write)
+			if err := outbox.Enqueue(ctx, tx, outbox.DNAClassified, event); err != nil {
+				return err
 			}
 
-			if dnaCheck.CheckSequenceDiagonalRight(row, column) {
-				count++
+			if event.Mutant {
+				if err := webhook.EnqueueDeliveries(ctx, tx, webhook.MutantDetected, event); err != nil {
+					return err
+				}
 			}
 		}
-	}
 
-	dnaType = "ordinary"
-	if count > 1 {
-		dnaType = "mutant"
+		return tx.Commit()
+	})
+
+	return storedType, err
+}
+
+// clientID is null for DNA submitted without an API key
+func clientID(write pending.Write) sql.NullInt64 {
+	return sql.NullInt64{Int64: write.ClientID, Valid: write.ClientID != 0}
+}
+
+// execer runs statements either on the database or in a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertMetadata records the metadata of a submission for the DNA stored under one of hashes,
+// writes without metadata record nothing
+func insertMetadata(ctx context.Context, db execer, hashes []string, write pending.Write) error {
+	if len(write.Metadata) == 0 {
+		return nil
 	}
 
-	dnaCheck.Save(dnaType)
-	return count > 1
+	_, err := db.ExecContext(ctx,
+		"insert into dna_metadata(dna_id, client_id, metadata) select id, $2, $3 from dna where hashed = any($1) limit 1",
+		pq.Array(hashes), clientID(write), string(write.Metadata),
+	)
+
+	return err
 }
 
-// CheckSequenceToTheRight checks whether there's a repetition match to the right of given position
-func (dnaCheck *DNACheck) CheckSequenceToTheRight(row, column int) bool {
-	if len(dnaCheck.DNA[row])-column < repetitionRequiredForSequence {
-		return false
+// storeMetadata records the metadata sent with a DNA that was already stored
+func (dnaCheck *DNACheck) storeMetadata(ctx context.Context) error {
+	if dnaCheck.Metadata == nil {
+		return nil
+	}
+
+	write, err := dnaCheck.pendingWrite(ctx, "")
+	if err != nil {
+		return err
 	}
 
-	requiredBase := dnaCheck.DNA[row][column]
-	c := column + 1
+	hashes, err := dnaCheck.lookupHashes()
+	if err != nil {
+		return err
+	}
 
-	for loop := 0; loop < repetitionRequiredForSequence-1; loop++ {
-		if dnaCheck.DNA[row][c] != requiredBase {
-			return false
-		}
+	db, err := utils.ConnectDB()
+	if err != nil {
+		return err
+	}
 
-		c++
+	err = utils.WithRetry(ctx, func(ctx context.Context) error {
+		return insertMetadata(ctx, db, hashes, write)
+	})
+	if err != nil {
+		return utils.DatabaseError(ctx, err, "Failed to store DNA metadata")
 	}
 
-	return true
+	return nil
 }
 
-// CheckSequenceDown checks whether there's a repetition match looking down of given position
-func (dnaCheck *DNACheck) CheckSequenceDown(row, column int) bool {
-	if len(dnaCheck.DNA)-row < repetitionRequiredForSequence {
-		return false
+func classifiedEvent(write pending.Write) outbox.Classified {
+	var dna []string
+	json.Unmarshal(write.Data, &dna)
+
+	return outbox.Classified{
+		Hash:          write.Hash,
+		HashAlgorithm: write.HashAlgorithm,
+		Type:          write.Type,
+		Mutant:        write.Type == "mutant",
+		Size:          len(dna),
+		ClassifiedAt:  write.QueuedAt,
 	}
+}
 
-	requiredBase := dnaCheck.DNA[row][column]
-	r := row + 1
+// applyConfig sets the detection rules and hashing options
+func applyConfig(cfg *config.Config) {
+	repetitionRequiredForSequence = cfg.Detection.SequenceLength
+	sequencesRequiredForMutant = cfg.Detection.RequiredSequences
+	hashAlgorithm = cfg.Detection.HashAlgorithm
+	canonicalHashing = cfg.Features.CanonicalHash
+	degradedMode = cfg.Features.DegradedMode
+	limits = matrix.Limits{MaxBodyBytes: cfg.Limits.MaxBodyBytes, MaxSize: cfg.Limits.MaxSize, MaxBases: cfg.Limits.MaxBases}
+	pendingWrites = pending.NewQueue(cfg.Database.PendingWrites)
+}
 
-	for loop := 0; loop < repetitionRequiredForSequence-1; loop++ {
-		if dnaCheck.DNA[r][column] != requiredBase {
-			return false
-		}
+// Hash returns a hash that identifies this DNA check, when canonical hashing
+// is enabled every rotation and reflection of the DNA has the same hash
+func (dnaCheck *DNACheck) Hash() (string, error) {
+	return dnaCheck.hashWith(hashAlgorithm)
+}
+
+// hashWith hashes the DNA with given algorithm, failing when it is not supported
+func (dnaCheck *DNACheck) hashWith(algorithm string) (string, error) {
+	return fingerprint.Sum(algorithm, dnaCheck.hashedRows())
+}
+
+// hashedRows are the rows that are hashed, the canonical form of the DNA is only computed once
+// however many algorithms it is hashed with
+func (dnaCheck *DNACheck) hashedRows() []string {
+	if !canonicalHashing {
+		return dnaCheck.DNA
+	}
 
-		r++
+	if dnaCheck.canonical == nil {
+		dnaCheck.canonical = fingerprint.Canonical(dnaCheck.DNA)
 	}
 
-	return true
+	return dnaCheck.canonical
 }
 
-// CheckSequenceDiagonalLeft checks whether there's a repetition match in the left diagonal of given position
-func (dnaCheck *DNACheck) CheckSequenceDiagonalLeft(row, column int) bool {
-	if row >= repetitionRequiredForSequence || column < repetitionRequiredForSequence-1 {
-		return false
+// lookupHashes are the hashes the DNA may have been stored under, one per supported algorithm
+// starting with the one in use, so changing DNA_HASH_ALGORITHM never orphans stored rows
+func (dnaCheck *DNACheck) lookupHashes() ([]string, error) {
+	hash, err := dnaCheck.Hash()
+	if err != nil {
+		return nil, err
 	}
 
-	requiredBase := dnaCheck.DNA[row][column]
-	r := row + 1
-	c := column - 1
-
-	for loop := 0; loop < repetitionRequiredForSequence-1; loop++ {
-		if dnaCheck.DNA[r][c] != requiredBase {
-			return false
+	hashes := []string{hash}
+	for _, algorithm := range fingerprint.Algorithms {
+		// Every one of fingerprint.Algorithms is supported
+		if algorithm != hashAlgorithm {
+			hash, _ := dnaCheck.hashWith(algorithm)
+			hashes = append(hashes, hash)
 		}
+	}
+
+	return hashes, nil
+}
+
+// IsMutant checks whether this is a DNA sequence from a mutant, verdicts are cached in the database
+func (dnaCheck *DNACheck) IsMutant(ctx context.Context) (bool, error) {
+	classification, err := dnaCheck.Classify(ctx)
+
+	return classification.Mutant, err
+}
+
+// Classification is the verdict on a DNA along with whether it is stored and whether it was
+// already stored before this check
+type Classification struct {
+	Mutant    bool
+	Persisted bool
+	Cached    bool
+}
 
-		r++
-		c--
+// Classify looks the DNA up and, when it is new, checks and stores it. In degraded mode a
+// database that cannot be reached does not fail the check, the verdict is computed anyway and
+// the write is queued to be flushed once the database is back. Timeouts and failed queries
+// fail the check either way.
+func (dnaCheck *DNACheck) Classify(ctx context.Context) (Classification, error) {
+	dnaSize.Observe(float64(len(dnaCheck.DNA)))
+
+	classification, err := dnaCheck.classify(ctx)
+	if err == nil {
+		classifications.Inc(verdict(classification.Mutant))
 	}
 
-	return true
+	return classification, err
 }
 
-// CheckSequenceDiagonalRight checks whether there's a repetition match in the right diagonal of given position
-func (dnaCheck *DNACheck) CheckSequenceDiagonalRight(row, column int) bool {
-	if row >= repetitionRequiredForSequence || column >= repetitionRequiredForSequence {
-		return false
+func (dnaCheck *DNACheck) classify(ctx context.Context) (Classification, error) {
+	dnaType, err := dnaCheck.lookDNATypeInDatabase(ctx)
+	if err != nil {
+		lookups.Inc("error")
+		return dnaCheck.degrade(ctx, err)
 	}
 
-	requiredBase := dnaCheck.DNA[row][column]
-	r := row + 1
-	c := column + 1
+	flushPendingWrites(ctx)
+
+	if dnaType == "mutant" || dnaType == "ordinary" {
+		lookups.Inc("hit")
 
-	for loop := 0; loop < repetitionRequiredForSequence-1; loop++ {
-		if dnaCheck.DNA[r][c] != requiredBase {
-			return false
+		// The verdict is known, a submission whose metadata cannot be stored is queued like a new
+		// DNA would be and its metadata recorded once flushed
+		if err := dnaCheck.storeMetadata(ctx); err != nil {
+			return dnaCheck.degrade(ctx, err)
 		}
 
-		r++
-		c++
+		return Classification{Mutant: dnaType == "mutant", Persisted: true, Cached: true}, nil
 	}
 
-	return true
-}
+	lookups.Inc("miss")
 
-func (dnaCheck *DNACheck) validate() error {
-	if !dnaCheck.isValidNxNTable() {
-		return errors.New("DNA is not an NxN table")
+	dnaType = "ordinary"
+	if dnaCheck.scan(ctx) {
+		dnaType = "mutant"
 	}
 
-	if !dnaCheck.validateDNAHasOnlyValidBases() {
-		return errors.New("DNA has invalid bases")
+	storedType, err := dnaCheck.Save(ctx, dnaType)
+	if err != nil {
+		return dnaCheck.degrade(ctx, err)
 	}
 
-	return nil
+	return Classification{Mutant: storedType == "mutant", Persisted: true}, nil
 }
 
-func (dnaCheck *DNACheck) isValidNxNTable() bool {
-	// This will give the number of rows
-	hypothesis := len(dnaCheck.DNA)
+// degrade computes the verdict without the database, queueing its write. Only an unavailable
+// database is worked around, a query that timed out or failed may well succeed if retried.
+func (dnaCheck *DNACheck) degrade(ctx context.Context, err error) (Classification, error) {
+	if !degradedMode || !errors.Is(err, utils.ErrUnavailable) {
+		return Classification{}, err
+	}
 
-	for row := 0; row < hypothesis; row++ {
-		// If we have a number of columns that's different from our hypothesis
-		if len(dnaCheck.DNA[row]) != hypothesis {
-			return false
-		}
+	dnaType := "ordinary"
+	if dnaCheck.scan(ctx) {
+		dnaType = "mutant"
+	}
+
+	write, err := dnaCheck.pendingWrite(ctx, dnaType)
+	if err != nil {
+		return Classification{}, err
+	}
+
+	// Losing the write is better than failing the check, the verdict can always be computed again
+	if queueErr := pendingWrites.Append(write); queueErr != nil {
+		logging.FromContext(ctx).Error("Could not queue DNA after database failure", "cause", err.Error(), "error", queueErr.Error())
 	}
 
-	return true
+	return Classification{Mutant: dnaType == "mutant", Persisted: false}, nil
 }
 
-func (dnaCheck *DNACheck) validateDNAHasOnlyValidBases() bool {
-	//TODO: This could be done while checking DNA
-	for row := 0; row < len(dnaCheck.DNA); row++ {
-		for column := 0; column < len(dnaCheck.DNA[row]); column++ {
-			currentChar := dnaCheck.DNA[row][column]
-			if !isValidDNABase(currentChar) {
-				return false
-			}
+// flushPendingWrites stores the writes queued during an outage, at most once per flushInterval.
+// Writes the database rejects are moved to the dead letters, whatever else fails stays queued.
+func flushPendingWrites(ctx context.Context) {
+	flushes.Lock()
+	if flushes.running || time.Since(flushes.last) < flushInterval {
+		flushes.Unlock()
+		return
+	}
+
+	flushes.running, flushes.last = true, time.Now()
+	flushes.Unlock()
+
+	defer func() {
+		flushes.Lock()
+		flushes.running = false
+		flushes.Unlock()
+	}()
+
+	stored, dead, err := pendingWrites.Flush(func(write pending.Write) error {
+		_, err := storeWrite(ctx, write)
+		if err != nil && err != utils.ErrTimeout && !utils.Unavailable(err) && ctx.Err() == nil {
+			return pending.Permanent(err)
 		}
+
+		return err
+	})
+
+	flushedWrites.Add(float64(stored), "stored")
+	flushedWrites.Add(float64(dead), "dead")
+
+	if dead > 0 {
+		logging.FromContext(ctx).Error("Moved pending writes that cannot be stored to the dead letters", "dead", dead, "path", pendingWrites.DeadLetterPath())
+	}
+
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to flush pending writes", "flushed", stored, "error", err.Error())
 	}
+}
+
+// scan checks the DNA for mutant sequences, timing it
+func (dnaCheck *DNACheck) scan(ctx context.Context) bool {
+	start := time.Now()
+	defer func() { scanDuration.Observe(time.Since(start).Seconds()) }()
+
+	ctx, span := tracing.Start(ctx, "dna.scan", tracing.Int("dna.size", len(dnaCheck.DNA)))
+	defer span.End()
+
+	mutant := dnaCheck.scanDirections(ctx)
+	span.SetAttributes(tracing.String("dna.verdict", verdict(mutant)))
 
-	return true
+	return mutant
 }
 
-func isValidDNABase(c byte) bool {
-	switch c {
-	case 'A', 'T', 'C', 'G':
-		return true
-	default:
+// directions are scanned one after another so each shows up as its own span when tracing
+var directions = []struct {
+	name      string
+	direction matrix.Direction
+}{
+	{"horizontal", matrix.Horizontal},
+	{"vertical", matrix.Vertical},
+	{"diagonal_left", matrix.DiagonalLeft},
+	{"diagonal_right", matrix.DiagonalRight},
+}
+
+// hasMutantSequences scans the DNA without touching the database, it stops as soon as enough sequences are found
+func (dnaCheck *DNACheck) hasMutantSequences() bool {
+	return dnaCheck.scanDirections(context.Background())
+}
+
+// scanDirections scans the packed DNA, a DNA that is not a valid table has no sequences. It is
+// always validated before being scanned.
+func (dnaCheck *DNACheck) scanDirections(ctx context.Context) bool {
+	packed, err := dnaCheck.pack()
+	if err != nil {
 		return false
 	}
+
+	count := 0
+
+	for _, direction := range directions {
+		if count >= sequencesRequiredForMutant {
+			break
+		}
+
+		_, span := tracing.Start(ctx, "dna.scan."+direction.name)
+		found := packed.Count(direction.direction, repetitionRequiredForSequence, sequencesRequiredForMutant-count)
+		span.SetAttributes(tracing.Int("dna.sequences", found))
+		span.End()
+
+		count += found
+	}
+
+	return count >= sequencesRequiredForMutant
 }
 
-func (dnaCheck *DNACheck) lookDNATypeInDatabase() string {
-	db := utils.GetDB()
+// validate applies the rules DNA is decoded with, a table that is not NxN is reported first
+func (dnaCheck *DNACheck) validate() error {
+	_, err := matrix.New(dnaCheck.DNA)
+	return err
+}
+
+func (dnaCheck *DNACheck) lookDNATypeInDatabase(ctx context.Context) (string, error) {
+	ctx, span := tracing.Start(ctx, "dna.lookup")
+	defer span.End()
+
+	hashes, err := dnaCheck.lookupHashes()
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	db, err := utils.ConnectDB()
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	var dnaType, algorithm string
+	err = utils.WithRetry(ctx, func(ctx context.Context) error {
+		return db.QueryRowContext(ctx, "select type, hash_algorithm from dna where hashed = any($1) limit 1", pq.Array(hashes)).Scan(&dnaType, &algorithm)
+	})
+	span.SetAttributes(tracing.Bool("dna.found", err == nil))
 
-	var dnaType string
-	err := db.QueryRow("select type from dna where hashed=$1", dnaCheck.Hash()).Scan(&dnaType)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return "not found"
+			return "not found", nil
 		}
 
-		panic(err)
+		span.RecordError(err)
+		return "", utils.DatabaseError(ctx, err, "Failed to look DNA up")
+	}
+
+	if algorithm != hashAlgorithm {
+		dnaCheck.rehash(ctx, db, algorithm)
+	}
+
+	return dnaType, nil
+}
+
+// rehash migrates a row stored with another algorithm to the one in use. The verdict was found
+// either way, so a failure is only logged and counted, it is tried again on the next lookup.
+func (dnaCheck *DNACheck) rehash(ctx context.Context, db *sql.DB, algorithm string) {
+	previous, err := dnaCheck.hashWith(algorithm)
+	if err != nil {
+		rehashes.Inc("error")
+		logging.FromContext(ctx).Error("Failed to rehash DNA", "hash_algorithm", algorithm, "error", err.Error())
+		return
+	}
+
+	// The DNA was just looked up with this hash, it cannot fail
+	hash, _ := dnaCheck.Hash()
+
+	ctx, cancel := utils.WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err = db.ExecContext(ctx, "update dna set hashed=$1, hash_algorithm=$2 where hashed=$3", hash, hashAlgorithm, previous)
+	if err != nil {
+		rehashes.Inc("error")
+		logging.FromContext(ctx).Error("Failed to rehash DNA", "hash_algorithm", algorithm, "error", err.Error())
+		return
 	}
 
-	return dnaType
+	rehashes.Inc("ok")
 }
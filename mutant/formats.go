@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/matrix"
+	"github.com/felipefill/mutants/server"
+)
+
+// errNoDNAFile is returned for uploads without a file nor a dna field
+var errNoDNAFile = errors.New("Upload has no DNA file")
+
+// mediaFormats are the content types DNA is accepted as
+var mediaFormats = map[string]matrix.Format{
+	"application/json":      matrix.JSON,
+	"text/plain":            matrix.Text,
+	"text/x-fasta":          matrix.FASTA,
+	"text/fasta":            matrix.FASTA,
+	"application/x-fasta":   matrix.FASTA,
+	"chemical/seq-na-fasta": matrix.FASTA,
+}
+
+// extensionFormats tell the format of uploaded files sent without a meaningful content type
+var extensionFormats = map[string]matrix.Format{
+	".json":  matrix.JSON,
+	".txt":   matrix.Text,
+	".fasta": matrix.FASTA,
+	".fas":   matrix.FASTA,
+	".fa":    matrix.FASTA,
+	".fna":   matrix.FASTA,
+}
+
+// NewDNACheckFromRequest creates a DNA check from a request body in the format its content type
+// tells. Multipart uploads are read from their file. Other content types, or none, are read as
+// JSON, which is what every client got before other formats were accepted.
+func NewDNACheckFromRequest(ctx context.Context, request events.APIGatewayProxyRequest) (DNACheck, error) {
+	body := request.Body
+	if request.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return DNACheck{}, matrix.ErrParse
+		}
+
+		body = string(decoded)
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(server.HeaderValue(request.Headers, "Content-Type"))
+	if mediaType == "multipart/form-data" {
+		return newDNACheckFromUpload(ctx, body, params["boundary"])
+	}
+
+	format, ok := mediaFormats[mediaType]
+	if !ok {
+		format = matrix.JSON
+	}
+
+	return NewDNACheckFromReader(ctx, strings.NewReader(body), format, len(body))
+}
+
+// newDNACheckFromUpload reads the first file of a multipart upload, or its dna field
+func newDNACheckFromUpload(ctx context.Context, body string, boundary string) (DNACheck, error) {
+	if boundary == "" {
+		return DNACheck{}, matrix.ErrParse
+	}
+
+	reader := multipart.NewReader(strings.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return DNACheck{}, errNoDNAFile
+		}
+
+		if err != nil {
+			return DNACheck{}, matrix.ErrParse
+		}
+
+		if part.FileName() == "" && part.FormName() != "dna" {
+			continue
+		}
+
+		return NewDNACheckFromReader(ctx, part, uploadFormat(part), len(body))
+	}
+}
+
+// uploadFormat tells the format of an uploaded file by its content type, or else its extension.
+// Anything else is taken as plain text, which is also what a dna field pasted in a form is.
+func uploadFormat(part *multipart.Part) matrix.Format {
+	mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if format, ok := mediaFormats[mediaType]; ok {
+		return format
+	}
+
+	if format, ok := extensionFormats[strings.ToLower(filepath.Ext(part.FileName()))]; ok {
+		return format
+	}
+
+	return matrix.Text
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"mime/multipart"
+	"net/textproto"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/matrix"
+	"github.com/stretchr/testify/assert"
+)
+
+// upload builds a multipart body with a form field and a file, it returns the body and its content type
+func upload(filename, contentType, content string) (string, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	writer.WriteField("lab", "north wing")
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="`+filename+`"`)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	part, _ := writer.CreatePart(header)
+	part.Write([]byte(content))
+	writer.Close()
+
+	return body.String(), writer.FormDataContentType()
+}
+
+func TestNewDNACheckFromRequestNegotiatesTheFormat(t *testing.T) {
+	expected := []string{"ATG", "CAG", "TTA"}
+	fasta, fastaType := upload("run-42.fasta", "application/octet-stream", ">r1\nATG\n>r2\nCAG\n>r3\nTTA\n")
+	text, textType := upload("run-42", "", "ATG\nCAG\nTTA\n")
+	jsonUpload, jsonType := upload("run-42.txt", "application/json", `{"dna": ["ATG", "CAG", "TTA"]}`)
+
+	requests := []events.APIGatewayProxyRequest{
+		{Body: `{"dna": ["ATG", "CAG", "TTA"]}`},
+		{Body: `{"dna": ["ATG", "CAG", "TTA"]}`, Headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"}},
+		{Body: "ATG\nCAG\nTTA\n", Headers: map[string]string{"content-type": "text/plain; charset=utf-8"}},
+		{Body: ">r1\nATG\n>r2\nCAG\n>r3\nTTA\n", Headers: map[string]string{"Content-Type": "text/x-fasta"}},
+		{Body: fasta, Headers: map[string]string{"Content-Type": fastaType}},
+		{Body: text, Headers: map[string]string{"Content-Type": textType}},
+		{Body: jsonUpload, Headers: map[string]string{"Content-Type": jsonType}},
+		{Body: base64.StdEncoding.EncodeToString([]byte(fasta)), IsBase64Encoded: true, Headers: map[string]string{"Content-Type": fastaType}},
+	}
+
+	for _, request := range requests {
+		check, err := NewDNACheckFromRequest(context.Background(), request)
+
+		assert.Nil(t, err, request.Headers)
+		assert.Equal(t, expected, check.DNA, request.Headers)
+	}
+}
+
+func TestNewDNACheckFromRequestReadsTheDNAField(t *testing.T) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("dna", "AT\nCG")
+	writer.Close()
+
+	check, err := NewDNACheckFromRequest(context.Background(), events.APIGatewayProxyRequest{
+		Body:    body.String(),
+		Headers: map[string]string{"Content-Type": writer.FormDataContentType()},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"AT", "CG"}, check.DNA)
+}
+
+func TestNewDNACheckFromRequestRejectsBadUploads(t *testing.T) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("lab", "north wing")
+	writer.Close()
+
+	_, err := NewDNACheckFromRequest(context.Background(), events.APIGatewayProxyRequest{Body: body.String(), Headers: map[string]string{"Content-Type": writer.FormDataContentType()}})
+	assert.Equal(t, errNoDNAFile, err)
+
+	_, err = NewDNACheckFromRequest(context.Background(), events.APIGatewayProxyRequest{Body: "ATG", Headers: map[string]string{"Content-Type": "multipart/form-data"}})
+	assert.Equal(t, matrix.ErrParse, err)
+
+	_, err = NewDNACheckFromRequest(context.Background(), events.APIGatewayProxyRequest{Body: "not base64!", IsBase64Encoded: true})
+	assert.Equal(t, matrix.ErrParse, err)
+}
+
+func TestHandlerValidatesEveryFormatTheSameWay(t *testing.T) {
+	fasta, fastaType := upload("run-42.fa", "", ">r1\nATG\n>r2\nCXG\n>r3\nTTA\n")
+
+	requests := []events.APIGatewayProxyRequest{
+		{Resource: "/mutant", Body: `{"dna": ["ATG", "CXG", "TTA"]}`},
+		{Resource: "/mutant", Body: "ATG\nCXG\nTTA", Headers: map[string]string{"Content-Type": "text/plain"}},
+		{Resource: "/mutant", Body: ">r1\nATG\n>r2\nCXG\n>r3\nTTA", Headers: map[string]string{"Content-Type": "text/x-fasta"}},
+		{Resource: "/mutant", Body: fasta, Headers: map[string]string{"Content-Type": fastaType}},
+	}
+
+	for _, request := range requests {
+		response, err := Handler(context.Background(), request)
+
+		assert.Nil(t, err)
+		assert.Equal(t, events.APIGatewayProxyResponse{Body: "DNA has invalid bases", StatusCode: 400}, response, request.Body)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/health"
+	"github.com/felipefill/mutants/utils"
+)
+
+// checkTimeout bounds each readiness check
+const checkTimeout = 3 * time.Second
+
+// livenessReport is the body of the liveness probe, its status is always ok
+type livenessReport struct {
+	Status string `json:"status" enum:"ok"`
+}
+
+// liveness only tells the function is running, it never touches dependencies
+func liveness() events.APIGatewayProxyResponse {
+	return jsonResponse(200, livenessReport{Status: health.StatusOK})
+}
+
+// readiness checks the database, the schema and the classifier itself. While degraded mode is on
+// the database is not critical, verdicts are still answered without it.
+func readiness(ctx context.Context) events.APIGatewayProxyResponse {
+	report := health.Run(ctx, checkTimeout,
+		health.Database(utils.ConnectDB, !degradedMode),
+		health.Schema(utils.ConnectDB, !degradedMode),
+		health.Check{Name: "self_test", Critical: true, Run: selfTest},
+	)
+
+	return jsonResponse(report.StatusCode(), report)
+}
+
+// selfTest classifies a DNA that must be human and one that must be a mutant under the rules
+// in use, without touching the database
+func selfTest(ctx context.Context) error {
+	human, mutant := selfTestSamples()
+
+	for _, sample := range []struct {
+		dna    []string
+		mutant bool
+	}{{human, false}, {mutant, true}} {
+		check := DNACheck{DNA: sample.dna}
+
+		if err := check.validate(); err != nil {
+			return errors.New("Self-test DNA was rejected: " + err.Error())
+		}
+
+		if check.hasMutantSequences() != sample.mutant {
+			return errors.New("Self-test classified a " + verdict(sample.mutant) + " DNA as " + verdict(!sample.mutant))
+		}
+	}
+
+	return nil
+}
+
+// selfTestSamples builds the self-test DNAs. In the human one every base differs from its
+// neighbors in all directions, so there is no sequence of any length. The mutant one has its
+// first rows made of a single base, one sequence each at least.
+func selfTestSamples() ([]string, []string) {
+	size := repetitionRequiredForSequence
+	if sequencesRequiredForMutant > size {
+		size = sequencesRequiredForMutant
+	}
+
+	bases := "ACGT"
+	human := make([]string, size)
+	mutant := make([]string, size)
+
+	for row := 0; row < size; row++ {
+		line := make([]byte, size)
+		for column := range line {
+			line[column] = bases[(2*row+column)%len(bases)]
+		}
+
+		human[row] = string(line)
+		mutant[row] = string(line)
+
+		if row < sequencesRequiredForMutant {
+			mutant[row] = strings.Repeat("A", size)
+		}
+	}
+
+	return human, mutant
+}
+
+// jsonResponse answers with value as JSON, probes and admin operations must never be cached
+func jsonResponse(statusCode int, value interface{}) events.APIGatewayProxyResponse {
+	body, _ := json.Marshal(value)
+
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json", "Cache-Control": "no-store"},
+	}
+}
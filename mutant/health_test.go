@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/database"
+	"github.com/felipefill/mutants/health"
+	"github.com/felipefill/mutants/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLiveness(t *testing.T) {
+	response, err := Handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/healthz"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.JSONEq(t, `{"status":"ok"}`, response.Body)
+}
+
+func expectReadinessQueries(mock sqlmock.Sqlmock, version int) {
+	mock.ExpectPing()
+	mock.
+		ExpectQuery("select coalesce\\(max\\(version\\), 0\\) from schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+}
+
+func readinessReport(t *testing.T) (int, health.Report) {
+	response, err := Handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/readyz"})
+	assert.Nil(t, err)
+
+	var report health.Report
+	assert.Nil(t, json.Unmarshal([]byte(response.Body), &report))
+
+	return response.StatusCode, report
+}
+
+func TestReadiness(t *testing.T) {
+	db, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+	expectReadinessQueries(mock, database.SchemaVersion())
+
+	statusCode, report := readinessReport(t)
+
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, health.StatusOK, report.Status)
+	assert.Equal(t, []string{"database", "schema", "self_test"}, []string{report.Checks[0].Name, report.Checks[1].Name, report.Checks[2].Name})
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestReadinessWithOutdatedSchema(t *testing.T) {
+	db, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+	expectReadinessQueries(mock, database.SchemaVersion()-1)
+
+	statusCode, report := readinessReport(t)
+
+	assert.Equal(t, 503, statusCode)
+	assert.Equal(t, health.StatusUnavailable, report.Status)
+	assert.Equal(t, health.StatusFailed, report.Checks[1].Status)
+}
+
+func TestReadinessInDegradedMode(t *testing.T) {
+	enableDegradedMode(t)
+
+	db, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.
+		ExpectQuery("select coalesce\\(max\\(version\\), 0\\) from schema_migrations").
+		WillReturnError(errors.New("connection refused"))
+
+	statusCode, report := readinessReport(t)
+
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, health.StatusDegraded, report.Status)
+	assert.Equal(t, health.StatusOK, report.Checks[2].Status)
+}
+
+func TestSelfTestFollowsDetectionRules(t *testing.T) {
+	defer func(length, required int) {
+		repetitionRequiredForSequence, sequencesRequiredForMutant = length, required
+	}(repetitionRequiredForSequence, sequencesRequiredForMutant)
+
+	for _, rules := range [][2]int{{4, 2}, {2, 1}, {5, 3}, {3, 6}} {
+		repetitionRequiredForSequence, sequencesRequiredForMutant = rules[0], rules[1]
+
+		assert.Nil(t, selfTest(context.Background()), "Rules %v", rules)
+	}
+}
@@ -1,8 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/felipefill/mutants/apikey"
+	"github.com/felipefill/mutants/bearer"
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/logging"
+	"github.com/felipefill/mutants/matrix"
+	"github.com/felipefill/mutants/openapi"
+	"github.com/felipefill/mutants/ratelimit"
+	"github.com/felipefill/mutants/server"
+	"github.com/felipefill/mutants/tracing"
+	"github.com/felipefill/mutants/utils"
 )
 
 // Response is of type APIGatewayProxyResponse since we're leveraging the
@@ -11,33 +26,156 @@ import (
 // https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
 type Response events.APIGatewayProxyResponse
 
+// persistedHeader tells clients the verdict was computed while the database was unavailable
+const persistedHeader = "X-Result-Persisted"
+
 // Handler is our lambda handler invoked by the `lambda.Start` function call
-func Handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func Handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// Probes are polled often and the documents never change, they are not logged nor traced
+	switch request.Resource {
+	case "/healthz":
+		return liveness(), nil
+	case "/readyz":
+		return readiness(ctx), nil
+	case "/schema":
+		return schemaResponse(), nil
+	case "/openapi.json":
+		return openapi.Serve(), nil
+	case "/admin/purge", "/admin/reclassify":
+		return admin(ctx, request), nil
+	}
+
+	ctx, logger := logging.ForRequest(ctx, request)
+	outcome := logging.Start(logger)
+
+	ctx, span := tracing.Start(tracing.Extract(ctx, request.Headers), "POST /mutant", tracing.String("http.request.method", request.HTTPMethod), tracing.String("http.route", "/mutant"))
+	defer span.End()
+
+	response := handle(ctx, request, outcome)
+	outcome.Done(response.StatusCode)
+	span.SetAttributes(tracing.Int("http.response.status_code", response.StatusCode))
+
+	return response, nil
+}
+
+func handle(ctx context.Context, request events.APIGatewayProxyRequest, outcome *logging.Outcome) events.APIGatewayProxyResponse {
 	if request.Body == "" {
-		return events.APIGatewayProxyResponse{Body: "Empty body", StatusCode: 400}, nil
+		outcome.Fail(errors.New("Empty body"))
+		return events.APIGatewayProxyResponse{Body: "Empty body", StatusCode: 400}
+	}
+
+	dnaCheck, err := NewDNACheckFromRequest(ctx, request)
+	if limitErr, ok := err.(*matrix.LimitError); ok {
+		outcome.Fail(err)
+		return jsonResponse(limitErr.StatusCode, limitErr)
 	}
 
-	dnaCheck, err := NewDNACheckFromJSONString(request.Body)
 	if err != nil {
-		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: 400}, nil
+		outcome.Fail(err)
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: 400}
+	}
+
+	outcome.Set("size", len(dnaCheck.DNA))
+	tracing.FromContext(ctx).SetAttributes(tracing.Int("dna.size", len(dnaCheck.DNA)))
+
+	classification, err := dnaCheck.Classify(ctx)
+	if err != nil {
+		outcome.Fail(err)
+	}
+
+	if err == utils.ErrTimeout {
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: 504}
+	}
+
+	if err != nil {
+		return events.APIGatewayProxyResponse{Body: "Failed to check DNA", StatusCode: 500}
+	}
+
+	outcome.Set("mutant", classification.Mutant)
+	outcome.Set("cache", cacheResult(classification))
+	tracing.FromContext(ctx).SetAttributes(tracing.String("dna.verdict", verdict(classification.Mutant)), tracing.String("dna.cache", cacheResult(classification)))
+
+	response := events.APIGatewayProxyResponse{Body: "", StatusCode: 200}
+	if !classification.Mutant {
+		response.StatusCode = 403
 	}
 
-	if !isMutant(dnaCheck.DNA) {
-		return events.APIGatewayProxyResponse{Body: "", StatusCode: 403}, nil
+	// The verdict is still right, it just was not stored yet and stats will catch up later
+	if !classification.Persisted {
+		response.Headers = map[string]string{persistedHeader: "false"}
 	}
 
-	return events.APIGatewayProxyResponse{Body: "", StatusCode: 200}, nil
+	return response
+}
+
+// cacheResult tells whether the verdict came from the database, was computed, or was computed
+// while the database was unavailable
+func cacheResult(classification Classification) string {
+	switch {
+	case !classification.Persisted:
+		return "degraded"
+	case classification.Cached:
+		return "hit"
+	default:
+		return "miss"
+	}
 }
 
 func isMutant(data []string) bool {
 	// This is being done this way so that the code complies with the requirements
 	// Which is having a function with this signature
-	// My apprach would be to have it related to DNACheck struct
+	// The handler goes through DNACheck.IsMutant instead, which also caches verdicts
 	dnaCheck := DNACheck{DNA: data}
 
-	return dnaCheck.IsMutant()
+	return dnaCheck.hasMutantSequences()
 }
 
 func main() {
-	lambda.Start(Handler)
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	config.Set(cfg)
+	logging.Configure(cfg.Logging.Level)
+	tracing.Configure(cfg.Tracing.Exporter, cfg.Tracing.Endpoint, cfg.Tracing.Service)
+	applyConfig(cfg)
+
+	// In degraded mode the function starts anyway, it can classify without the database
+	if err := utils.PingDB(context.Background()); err != nil {
+		logging.Default().Error("Startup check failed", "error", err.Error())
+
+		if !degradedMode {
+			os.Exit(1)
+		}
+	}
+
+	keys, err := bearer.Load(cfg.Auth.JWKS, cfg.Auth.JWKSFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	// Limits apply once the request is authenticated, so they are keyed by client
+	limited := server.Handler(Handler)
+	if store := ratelimit.NewStore(cfg.RateLimit.Store, utils.ConnectDB); store != nil {
+		limits := ratelimit.Limits{PerMinute: cfg.RateLimit.PerMinute, Burst: cfg.RateLimit.Burst, DailyQuota: cfg.RateLimit.DailyQuota}
+		limited = ratelimit.Limit(&ratelimit.Limiter{Store: store, Limits: limits}, limited, "/mutant")
+	}
+
+	handler := limited
+	if cfg.Auth.Required {
+		authenticator := &apikey.Authenticator{TTL: time.Duration(cfg.Auth.CacheTTL), Stale: cfg.Features.DegradedMode}
+		handler = apikey.Require(authenticator, limited, openapi.Resources(routes, openapi.Public)...)
+	}
+
+	// Bearer tokens are checked first, requests without one fall back to API keys
+	if keys != nil {
+		validator := &bearer.Validator{Keys: keys, Issuer: cfg.Auth.Issuer, Audience: cfg.Auth.Audience}
+		handler = bearer.Require(validator, openapi.Scopes(routes), limited, handler)
+	}
+
+	server.MaxBodyBytes = int64(cfg.Limits.MaxBodyBytes)
+	server.Run(cfg.Server.Addr, handler, openapi.Routes(routes)...)
 }
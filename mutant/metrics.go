@@ -0,0 +1,21 @@
+package main
+
+import "github.com/felipefill/mutants/metrics"
+
+var (
+	classifications = metrics.NewCounter("mutants_classifications_total", "DNA classified, by verdict", "verdict")
+	lookups         = metrics.NewCounter("mutants_lookups_total", "DNA looked up in the database, by result: hit, miss or error", "result")
+	flushedWrites   = metrics.NewCounter("mutants_pending_writes_flushed_total", "Writes queued in degraded mode that were flushed, by result: stored or dead for those moved to the dead letters", "result")
+	rehashes        = metrics.NewCounter("mutants_rehashes_total", "Rows rehashed with the algorithm in use as they were looked up, by result: ok or error", "result")
+	dnaSize         = metrics.NewHistogram("mutants_dna_size", "Rows of the DNA matrices checked", []float64{4, 6, 8, 16, 32, 64, 128, 256, 512, 1024})
+	scanDuration    = metrics.NewHistogram("mutants_scan_duration_seconds", "Time spent scanning DNA for sequences", []float64{0.00001, 0.0001, 0.001, 0.01, 0.1, 1})
+)
+
+// verdict is the label classifications are counted by
+func verdict(mutant bool) string {
+	if mutant {
+		return "mutant"
+	}
+
+	return "human"
+}
@@ -1,54 +1,91 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/apikey"
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/fingerprint"
+	"github.com/felipefill/mutants/generator"
+	"github.com/felipefill/mutants/logging"
+	"github.com/felipefill/mutants/matrix"
+	"github.com/felipefill/mutants/outbox"
+	"github.com/felipefill/mutants/pending"
+	"github.com/felipefill/mutants/tracing"
 	"github.com/felipefill/mutants/utils"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestIsValidDNABase(t *testing.T) {
-	assert.Equal(t, true, isValidDNABase('A'), "DNA base should be valid")
-	assert.Equal(t, true, isValidDNABase('T'), "DNA base should be valid")
-	assert.Equal(t, true, isValidDNABase('C'), "DNA base should be valid")
-	assert.Equal(t, true, isValidDNABase('G'), "DNA base should be valid")
+// testConfig makes database failures surface, degraded mode is covered in degraded_test.go
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.Features.DegradedMode = false
+	cfg.Database.PendingWrites = filepath.Join(os.TempDir(), "mutants-test-pending-writes.jsonl")
 
-	assert.Equal(t, false, isValidDNABase('E'), "DNA base should not be valid")
-	assert.Equal(t, false, isValidDNABase('X'), "DNA base should not be valid")
-	assert.Equal(t, false, isValidDNABase('1'), "DNA base should not be valid")
-	assert.Equal(t, false, isValidDNABase('9'), "DNA base should not be valid")
-	assert.Equal(t, false, isValidDNABase('#'), "DNA base should not be valid")
+	return cfg
 }
 
-func TestValidateDNAHasOnlyValidBases(t *testing.T) {
-	validDNA := DNACheck{
-		DNA: validDNASequence,
+// packedData is DNA as it is stored
+func packedData(dna []string) []byte {
+	packed, _ := matrix.PackRows(dna)
+	data, _ := packed.MarshalBinary()
+
+	return data
+}
+
+// hashOf hashes the DNA of dnaCheck with a supported algorithm
+func hashOf(dnaCheck DNACheck, algorithm string) string {
+	hash, err := dnaCheck.hashWith(algorithm)
+	if err != nil {
+		panic(err)
 	}
 
-	invalidDNA := DNACheck{
-		DNA: invalidDNASequence,
+	return hash
+}
+
+// writeOf is the write storing dnaCheck with given type
+func writeOf(dnaCheck DNACheck, dnaType string) pending.Write {
+	write, err := dnaCheck.pendingWrite(context.Background(), dnaType)
+	if err != nil {
+		panic(err)
 	}
 
-	assert.Equal(t, true, validDNA.validateDNAHasOnlyValidBases(), "DNA bases should be valid")
-	assert.Equal(t, false, invalidDNA.validateDNAHasOnlyValidBases(), "DNA bases should not be valid")
+	return write
 }
 
-func TestIsValidNxNTable(t *testing.T) {
-	dnaWithNxN := DNACheck{
-		DNA: tableNxN,
+func lookupHashesOf(dnaCheck DNACheck) []string {
+	hashes, err := dnaCheck.lookupHashes()
+	if err != nil {
+		panic(err)
 	}
 
-	dnaWithMxN := DNACheck{
-		DNA: tableMxN,
+	return hashes
+}
+
+func TestMain(m *testing.M) {
+	applyConfig(testConfig())
+
+	os.Exit(m.Run())
+}
+
+func TestValidateChecksTheShapeBeforeTheBases(t *testing.T) {
+	dnaWithNxN := DNACheck{
+		DNA: tableNxN,
 	}
 
-	assert.Equal(t, true, dnaWithNxN.isValidNxNTable(), "Should be a valid NxN table")
-	assert.Equal(t, false, dnaWithMxN.isValidNxNTable(), "Should not be a valid NxN table")
+	assert.Equal(t, matrix.ErrInvalidBases, dnaWithNxN.validate(), "Should be a valid NxN table with invalid bases")
 }
 
 func TestValidate(t *testing.T) {
@@ -86,10 +123,91 @@ func TestHashFunction(t *testing.T) {
 		DNA: validDNASequence,
 	}
 
-	expected := "f7bad0e12c11a6a23852bee23d64cc753bb51d83"
-	actual := check.Hash()
+	expected := "67463e6b264588bfa2c9b8912ea67cbea8cc0d1f6dcba9daad1cd5085027ff03"
+	actual, err := check.Hash()
 
+	assert.Nil(t, err)
 	assert.Equal(t, expected, actual, "Hashes do not match")
+	assert.Equal(t, "6d772a493893d7922c5bbf92b9a57d0c5e6f13ce", hashOf(check, fingerprint.SHA1), "Legacy hashes do not match")
+}
+
+func TestHashFailsWithUnknownAlgorithm(t *testing.T) {
+	check := DNACheck{
+		DNA: validDNASequence,
+	}
+
+	_, err := check.hashWith("md5")
+
+	assert.EqualError(t, err, "Unknown hash algorithm md5")
+}
+
+func TestCanonicalHashFunction(t *testing.T) {
+	canonicalHashing = true
+	defer func() { canonicalHashing = false }()
+
+	check := DNACheck{
+		DNA: validDNASequence,
+	}
+
+	transposed := DNACheck{
+		DNA: transpose(validDNASequence),
+	}
+
+	assert.Equal(t, hashOf(check, hashAlgorithm), hashOf(transposed, hashAlgorithm), "Transposed DNA should have the same hash")
+	assert.NotEqual(t, "67463e6b264588bfa2c9b8912ea67cbea8cc0d1f6dcba9daad1cd5085027ff03", hashOf(check, hashAlgorithm), "Hash should come from the canonical form")
+}
+
+func TestSaveDNAWithCanonicalHash(t *testing.T) {
+	canonicalHashing = true
+	defer func() { canonicalHashing = false }()
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	check := DNACheck{
+		DNA: mutantDNASequence,
+	}
+
+	sequenceAsPacked := packedData(check.DNA)
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into dna").
+		WithArgs(hashOf(check, hashAlgorithm), "mutant", sequenceAsPacked, true, fingerprint.SHA256, sql.NullInt64{}).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "inserted"}).AddRow("mutant", true))
+	mock.
+		ExpectExec("insert into outbox").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.
+		ExpectExec("insert into webhook_deliveries").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	check.Save(context.Background(), "mutant")
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyConfig(t *testing.T) {
+	cfg := testConfig()
+	cfg.Detection.SequenceLength = 3
+	cfg.Detection.RequiredSequences = 1
+	cfg.Detection.HashAlgorithm = fingerprint.BLAKE2b
+	cfg.Features.CanonicalHash = true
+
+	applyConfig(cfg)
+	defer applyConfig(testConfig())
+
+	assert.Equal(t, 3, repetitionRequiredForSequence)
+	assert.Equal(t, 1, sequencesRequiredForMutant)
+	assert.Equal(t, fingerprint.BLAKE2b, hashAlgorithm)
+	assert.Equal(t, true, canonicalHashing)
+
+	// A single run of three is enough with these rules
+	check := DNACheck{DNA: []string{"AAAT", "CGTC", "TCGA", "GTCA"}}
+	assert.Equal(t, true, check.hasMutantSequences())
 }
 
 func TestLookDNATypeInDatabaseFoundDNAType(t *testing.T) {
@@ -103,20 +221,21 @@ func TestLookDNATypeInDatabaseFoundDNAType(t *testing.T) {
 	}
 
 	mock.
-		ExpectQuery("select type from dna").
-		WithArgs(check.Hash()).
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
 		WillReturnRows(
-			sqlmock.NewRows([]string{"type"}).
-				AddRow("mutant"),
+			sqlmock.NewRows([]string{"type", "hash_algorithm"}).
+				AddRow("mutant", fingerprint.SHA256),
 		)
 
 	expected := "mutant"
-	actual := check.lookDNATypeInDatabase()
+	actual, err := check.lookDNATypeInDatabase(context.Background())
 
+	assert.Nil(t, err)
 	assert.Equal(t, expected, actual, "Should have found DNA type in DB")
 }
 
-func TestLookDNATypeInDatabaseNotFound(t *testing.T) {
+func TestLookDNATypeInDatabaseRetriesSerializationFailures(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	defer db.Close()
 
@@ -127,17 +246,25 @@ func TestLookDNATypeInDatabaseNotFound(t *testing.T) {
 	}
 
 	mock.
-		ExpectQuery("select type from dna").
-		WithArgs(check.Hash()).
-		WillReturnError(sql.ErrNoRows)
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillReturnError(&pq.Error{Code: "40001"})
 
-	expected := "not found"
-	actual := check.lookDNATypeInDatabase()
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"type", "hash_algorithm"}).
+				AddRow("ordinary", fingerprint.SHA256),
+		)
 
-	assert.Equal(t, expected, actual, "Should not have found DNA type in DB")
+	actual, err := check.lookDNATypeInDatabase(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ordinary", actual)
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
-func TestLookDNATypeInDatabasePanics(t *testing.T) {
+func TestLookDNATypeInDatabaseMigratesLegacyHash(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	defer db.Close()
 
@@ -148,57 +275,167 @@ func TestLookDNATypeInDatabasePanics(t *testing.T) {
 	}
 
 	mock.
-		ExpectQuery("select type from dna").
-		WithArgs(check.Hash()).
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"type", "hash_algorithm"}).
+				AddRow("ordinary", fingerprint.SHA1),
+		)
+
+	mock.
+		ExpectExec("update dna set hashed").
+		WithArgs(hashOf(check, hashAlgorithm), fingerprint.SHA256, hashOf(check, fingerprint.SHA1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	actual, err := check.lookDNATypeInDatabase(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ordinary", actual)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestLookDNATypeInDatabaseFindsRowsOfAPreviousAlgorithm(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	check := DNACheck{DNA: validDNASequence}
+	assert.Equal(t, []string{hashOf(check, hashAlgorithm), hashOf(check, fingerprint.BLAKE2b), hashOf(check, fingerprint.SHA1)}, lookupHashesOf(check))
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "hash_algorithm"}).AddRow("mutant", fingerprint.BLAKE2b))
+	mock.
+		ExpectExec("update dna set hashed").
+		WithArgs(hashOf(check, hashAlgorithm), fingerprint.SHA256, hashOf(check, fingerprint.BLAKE2b)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	actual, err := check.lookDNATypeInDatabase(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "mutant", actual)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestLookDNATypeInDatabaseReportsFailedRehashes(t *testing.T) {
+	var buffer bytes.Buffer
+	logging.SetDefault(logging.New(&buffer, slog.LevelInfo))
+	defer logging.SetDefault(logging.New(os.Stdout, slog.LevelInfo))
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	check := DNACheck{DNA: validDNASequence}
+	failed := rehashes.Value("error")
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "hash_algorithm"}).AddRow("ordinary", fingerprint.SHA1))
+	mock.
+		ExpectExec("update dna set hashed").
 		WillReturnError(sql.ErrConnDone)
 
-	assert.Panics(t, func() { check.lookDNATypeInDatabase() }, "Should have panicked")
+	// The verdict was found, so the lookup still succeeds
+	actual, err := check.lookDNATypeInDatabase(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ordinary", actual)
+	assert.Equal(t, failed+1, rehashes.Value("error"))
+	assert.Contains(t, buffer.String(), "Failed to rehash DNA")
 }
 
-func TestCheckSequenceDiagonalRight(t *testing.T) {
+func TestLookDNATypeInDatabaseNotFound(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
 	check := DNACheck{
-		DNA: mutantWithAllCombinationsDNASequence,
+		DNA: validDNASequence,
 	}
 
-	assert.Equal(t, false, check.CheckSequenceDiagonalRight(4, 0))
-	assert.Equal(t, false, check.CheckSequenceDiagonalRight(0, 4))
-	assert.Equal(t, false, check.CheckSequenceDiagonalRight(0, 0))
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
+		WillReturnError(sql.ErrNoRows)
+
+	expected := "not found"
+	actual, err := check.lookDNATypeInDatabase(context.Background())
 
-	assert.Equal(t, true, check.CheckSequenceDiagonalRight(3, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual, "Should not have found DNA type in DB")
 }
 
-func TestCheckSequenceDiagonalLeft(t *testing.T) {
+func TestLookDNATypeInDatabaseFails(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
 	check := DNACheck{
-		DNA: mutantWithAllCombinationsDNASequence,
+		DNA: validDNASequence,
 	}
 
-	assert.Equal(t, false, check.CheckSequenceDiagonalLeft(4, 0))
-	assert.Equal(t, false, check.CheckSequenceDiagonalLeft(0, 0))
-	assert.Equal(t, false, check.CheckSequenceDiagonalLeft(0, 5))
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
+		WillReturnError(sql.ErrConnDone)
+
+	_, err := check.lookDNATypeInDatabase(context.Background())
 
-	assert.Equal(t, true, check.CheckSequenceDiagonalLeft(0, 4))
+	assert.EqualError(t, err, "Failed to look DNA up")
 }
 
-func TestCheckSequenceDown(t *testing.T) {
+func TestLookDNATypeInDatabaseTimesOut(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
 	check := DNACheck{
-		DNA: mutantWithAllCombinationsDNASequence,
+		DNA: validDNASequence,
 	}
 
-	assert.Equal(t, false, check.CheckSequenceDown(5, 0))
-	assert.Equal(t, false, check.CheckSequenceDown(0, 5))
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "hash_algorithm"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
 
-	assert.Equal(t, true, check.CheckSequenceDown(2, 6))
+	_, err := check.lookDNATypeInDatabase(ctx)
+
+	assert.Equal(t, utils.ErrTimeout, err)
 }
 
-func TestCheckSequenceRight(t *testing.T) {
+// sequencesIn counts every sequence of dna going in direction
+func sequencesIn(t *testing.T, dna []string, direction matrix.Direction) int {
+	packed, err := matrix.PackRows(dna)
+	assert.Nil(t, err)
+
+	return packed.Count(direction, repetitionRequiredForSequence, len(dna)*len(dna))
+}
+
+func TestScanFindsSequencesInEveryDirection(t *testing.T) {
+	dna := mutantWithAllCombinationsDNASequence
+	runs := generator.CountRuns(dna, repetitionRequiredForSequence)
+
+	assert.Equal(t, runs.Horizontal, sequencesIn(t, dna, matrix.Horizontal))
+	assert.Equal(t, runs.Vertical, sequencesIn(t, dna, matrix.Vertical))
+	assert.Equal(t, runs.Diagonal, sequencesIn(t, dna, matrix.DiagonalLeft)+sequencesIn(t, dna, matrix.DiagonalRight))
+
 	check := DNACheck{
 		DNA: mutantWithAllCombinationsDNASequence,
 	}
 
-	assert.Equal(t, false, check.CheckSequenceToTheRight(0, 5))
-	assert.Equal(t, false, check.CheckSequenceToTheRight(0, 0))
-
-	assert.Equal(t, true, check.CheckSequenceToTheRight(6, 3))
+	assert.Equal(t, true, check.scanDirections(context.Background()))
 }
 
 func TestIsMutantFindingMutantInDatabase(t *testing.T) {
@@ -212,14 +449,17 @@ func TestIsMutantFindingMutantInDatabase(t *testing.T) {
 	}
 
 	mock.
-		ExpectQuery("select type from dna").
-		WithArgs(check.Hash()).
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
 		WillReturnRows(
-			sqlmock.NewRows([]string{"type"}).
-				AddRow("mutant"),
+			sqlmock.NewRows([]string{"type", "hash_algorithm"}).
+				AddRow("mutant", fingerprint.SHA256),
 		)
 
-	assert.Equal(t, true, check.IsMutant())
+	mutant, err := check.IsMutant(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, true, mutant)
 }
 
 func TestIsMutantFindingHumanInDatabase(t *testing.T) {
@@ -233,14 +473,17 @@ func TestIsMutantFindingHumanInDatabase(t *testing.T) {
 	}
 
 	mock.
-		ExpectQuery("select type from dna").
-		WithArgs(check.Hash()).
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
 		WillReturnRows(
-			sqlmock.NewRows([]string{"type"}).
-				AddRow("ordinary"),
+			sqlmock.NewRows([]string{"type", "hash_algorithm"}).
+				AddRow("ordinary", fingerprint.SHA256),
 		)
 
-	assert.Equal(t, false, check.IsMutant())
+	mutant, err := check.IsMutant(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, false, mutant)
 }
 
 func TestIsMutantFalse(t *testing.T) {
@@ -253,19 +496,27 @@ func TestIsMutantFalse(t *testing.T) {
 		DNA: humanDNASequence,
 	}
 
-	sequenceAsJSON, _ := json.Marshal(&check.DNA)
+	sequenceAsPacked := packedData(check.DNA)
 
 	mock.
-		ExpectQuery("select type from dna").
-		WithArgs(check.Hash()).
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
 		WillReturnError(sql.ErrNoRows)
 
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into dna").
+		WithArgs(hashOf(check, hashAlgorithm), "ordinary", sequenceAsPacked, false, fingerprint.SHA256, sql.NullInt64{}).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "inserted"}).AddRow("ordinary", true))
 	mock.
-		ExpectExec("insert into dna").
-		WithArgs(check.Hash(), "ordinary", sequenceAsJSON).
+		ExpectExec("insert into outbox").
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
-	assert.Equal(t, false, check.IsMutant())
+	mutant, err := check.IsMutant(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, false, mutant)
 }
 
 func TestIsMutantTrue(t *testing.T) {
@@ -278,22 +529,33 @@ func TestIsMutantTrue(t *testing.T) {
 		DNA: mutantDNASequence,
 	}
 
-	sequenceAsJSON, _ := json.Marshal(&check.DNA)
+	sequenceAsPacked := packedData(check.DNA)
 
 	mock.
-		ExpectQuery("select type from dna").
-		WithArgs(check.Hash()).
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
 		WillReturnError(sql.ErrNoRows)
 
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into dna").
+		WithArgs(hashOf(check, hashAlgorithm), "mutant", sequenceAsPacked, false, fingerprint.SHA256, sql.NullInt64{}).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "inserted"}).AddRow("mutant", true))
 	mock.
-		ExpectExec("insert into dna").
-		WithArgs(check.Hash(), "mutant", sequenceAsJSON).
+		ExpectExec("insert into outbox").
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.
+		ExpectExec("insert into webhook_deliveries").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
 
-	assert.Equal(t, true, check.IsMutant())
+	mutant, err := check.IsMutant(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, true, mutant)
 }
 
-func TestIsMutantPanics(t *testing.T) {
+func TestIsMutantFailsToSave(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	defer db.Close()
 
@@ -303,19 +565,23 @@ func TestIsMutantPanics(t *testing.T) {
 		DNA: mutantDNASequence,
 	}
 
-	sequenceAsJSON, _ := json.Marshal(&check.DNA)
+	sequenceAsPacked := packedData(check.DNA)
 
 	mock.
-		ExpectQuery("select type from dna").
-		WithArgs(check.Hash()).
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
 		WillReturnError(sql.ErrNoRows)
 
+	mock.ExpectBegin()
 	mock.
-		ExpectExec("insert into dna").
-		WithArgs(check.Hash(), "mutant", sequenceAsJSON).
+		ExpectQuery("insert into dna").
+		WithArgs(hashOf(check, hashAlgorithm), "mutant", sequenceAsPacked, false, fingerprint.SHA256, sql.NullInt64{}).
 		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	_, err := check.IsMutant(context.Background())
 
-	assert.Panics(t, func() { check.IsMutant() })
+	assert.EqualError(t, err, "Failed to store DNA")
 }
 
 func TestSaveDNA(t *testing.T) {
@@ -328,17 +594,28 @@ func TestSaveDNA(t *testing.T) {
 		DNA: mutantDNASequence,
 	}
 
-	sequenceAsJSON, _ := json.Marshal(&check.DNA)
+	sequenceAsPacked := packedData(check.DNA)
 
+	mock.ExpectBegin()
 	mock.
-		ExpectExec("insert into dna").
-		WithArgs(check.Hash(), "mutant", sequenceAsJSON).
+		ExpectQuery("insert into dna").
+		WithArgs(hashOf(check, hashAlgorithm), "mutant", sequenceAsPacked, false, fingerprint.SHA256, sql.NullInt64{}).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "inserted"}).AddRow("mutant", true))
+	mock.
+		ExpectExec("insert into outbox").
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.
+		ExpectExec("insert into webhook_deliveries").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	storedType, err := check.Save(context.Background(), "mutant")
 
-	check.Save("mutant")
+	assert.Nil(t, err)
+	assert.Equal(t, "mutant", storedType)
 }
 
-func TestSaveDNAPanics(t *testing.T) {
+func TestSaveDNAAttributesItToTheClient(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	defer db.Close()
 
@@ -348,14 +625,160 @@ func TestSaveDNAPanics(t *testing.T) {
 		DNA: mutantDNASequence,
 	}
 
-	sequenceAsJSON, _ := json.Marshal(&check.DNA)
+	sequenceAsPacked := packedData(check.DNA)
 
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into dna").
+		WithArgs(hashOf(check, hashAlgorithm), "mutant", sequenceAsPacked, false, fingerprint.SHA256, sql.NullInt64{Int64: 4, Valid: true}).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "inserted"}).AddRow("mutant", true))
+	mock.
+		ExpectExec("insert into outbox").
+		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.
-		ExpectExec("insert into dna").
-		WithArgs(check.Hash(), "mutant", sequenceAsJSON).
+		ExpectExec("insert into webhook_deliveries").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	ctx := apikey.WithClient(context.Background(), apikey.Client{ID: 4, Name: "acme"})
+	_, err := check.Save(ctx, "mutant")
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveDNAReturnsAlreadyStoredType(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	check := DNACheck{
+		DNA: mutantDNASequence,
+	}
+
+	sequenceAsPacked := packedData(check.DNA)
+
+	// Another request stored this DNA between our lookup and our insert
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into dna\\(.+\\) values\\(.+\\) on conflict \\(hashed\\) do update set type = dna.type returning type").
+		WithArgs(hashOf(check, hashAlgorithm), "mutant", sequenceAsPacked, false, fingerprint.SHA256, sql.NullInt64{}).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "inserted"}).AddRow("ordinary", false))
+	mock.ExpectCommit()
+
+	storedType, err := check.Save(context.Background(), "mutant")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ordinary", storedType)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveDNAFailsWhenEventCannotBeRecorded(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	check := DNACheck{
+		DNA: mutantDNASequence,
+	}
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into dna").
+		WillReturnRows(sqlmock.NewRows([]string{"type", "inserted"}).AddRow("mutant", true))
+	mock.
+		ExpectExec("insert into outbox").
+		WithArgs(outbox.DNAClassified, sqlmock.AnyArg()).
 		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
 
-	assert.Panics(t, func() { check.Save("mutant") })
+	_, err := check.Save(context.Background(), "mutant")
+
+	assert.EqualError(t, err, "Failed to store DNA")
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestClassifiedEvent(t *testing.T) {
+	check := DNACheck{
+		DNA: mutantDNASequence,
+	}
+
+	write := writeOf(check, "mutant")
+	event := classifiedEvent(write)
+
+	assert.Equal(t, outbox.Classified{
+		Hash:          hashOf(check, hashAlgorithm),
+		HashAlgorithm: fingerprint.SHA256,
+		Type:          "mutant",
+		Mutant:        true,
+		Size:          len(mutantDNASequence),
+		ClassifiedAt:  write.QueuedAt,
+	}, event)
+}
+
+func TestSaveDNAFails(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	check := DNACheck{
+		DNA: mutantDNASequence,
+	}
+
+	sequenceAsPacked := packedData(check.DNA)
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into dna").
+		WithArgs(hashOf(check, hashAlgorithm), "mutant", sequenceAsPacked, false, fingerprint.SHA256, sql.NullInt64{}).
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	_, err := check.Save(context.Background(), "mutant")
+
+	assert.EqualError(t, err, "Failed to store DNA")
+}
+
+func TestSaveDNARetriesAfterFailover(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	check := DNACheck{
+		DNA: mutantDNASequence,
+	}
+
+	sequenceAsPacked := packedData(check.DNA)
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into dna").
+		WithArgs(hashOf(check, hashAlgorithm), "mutant", sequenceAsPacked, false, fingerprint.SHA256, sql.NullInt64{}).
+		WillReturnError(&pq.Error{Code: "57P01"})
+	mock.ExpectRollback()
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into dna").
+		WithArgs(hashOf(check, hashAlgorithm), "mutant", sequenceAsPacked, false, fingerprint.SHA256, sql.NullInt64{}).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "inserted"}).AddRow("mutant", true))
+	mock.
+		ExpectExec("insert into outbox").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.
+		ExpectExec("insert into webhook_deliveries").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	storedType, err := check.Save(context.Background(), "mutant")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "mutant", storedType)
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
 func TestNewDNACheckFromJSONString(t *testing.T) {
@@ -364,7 +787,10 @@ func TestNewDNACheckFromJSONString(t *testing.T) {
 		DNA: validDNASequence,
 	}
 
-	actualCheck, actualError := NewDNACheckFromJSONString(validDNASequenceString)
+	actualCheck, actualError := NewDNACheckFromJSONString(context.Background(), validDNASequenceString)
+
+	// Decoding packs the DNA right away
+	expectedCheck.packed, _ = matrix.PackRows(validDNASequence)
 
 	assert.Equal(t, expectedCheck, actualCheck)
 	assert.Equal(t, expectedError, actualError)
@@ -374,7 +800,7 @@ func TestNewDNACheckFromJSONStringFailsInvalidFormat(t *testing.T) {
 	expectedError := errors.New("Could not parse DNA check")
 	expectedCheck := DNACheck{}
 
-	actualCheck, actualError := NewDNACheckFromJSONString(invalidDNASequenceStringNotEvenAJSON)
+	actualCheck, actualError := NewDNACheckFromJSONString(context.Background(), invalidDNASequenceStringNotEvenAJSON)
 
 	assert.Equal(t, expectedCheck, actualCheck)
 	assert.Equal(t, expectedError, actualError)
@@ -384,7 +810,7 @@ func TestNewDNACheckFromJSONStringFailsInvalid(t *testing.T) {
 	expectedError := errors.New("DNA has invalid bases")
 	expectedCheck := DNACheck{}
 
-	actualCheck, actualError := NewDNACheckFromJSONString(invalidDNASequenceStringWrongBases)
+	actualCheck, actualError := NewDNACheckFromJSONString(context.Background(), invalidDNASequenceStringWrongBases)
 
 	assert.Equal(t, expectedCheck, actualCheck)
 	assert.Equal(t, expectedError, actualError)
@@ -401,11 +827,11 @@ func TestHandlerMutantDNA(t *testing.T) {
 	}
 
 	mock.
-		ExpectQuery("select type from dna").
-		WithArgs(check.Hash()).
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
 		WillReturnRows(
-			sqlmock.NewRows([]string{"type"}).
-				AddRow("mutant"),
+			sqlmock.NewRows([]string{"type", "hash_algorithm"}).
+				AddRow("mutant", fingerprint.SHA256),
 		)
 
 	request := events.APIGatewayProxyRequest{
@@ -418,12 +844,157 @@ func TestHandlerMutantDNA(t *testing.T) {
 		StatusCode: 200,
 	}
 
-	actualResponde, actualError := Handler(request)
+	actualResponde, actualError := Handler(context.Background(), request)
 
 	assert.Equal(t, expectedError, actualError)
 	assert.Equal(t, expectedResponse, actualResponde)
 }
 
+func TestHandlerLogsRequest(t *testing.T) {
+	var buffer bytes.Buffer
+	logging.SetDefault(logging.New(&buffer, slog.LevelInfo))
+	defer logging.SetDefault(logging.New(os.Stdout, slog.LevelInfo))
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	check := DNACheck{
+		DNA: mutantDNASequence,
+	}
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"type", "hash_algorithm"}).
+				AddRow("mutant", fingerprint.SHA256),
+		)
+
+	request := events.APIGatewayProxyRequest{
+		HTTPMethod:     "POST",
+		Path:           "/mutant",
+		Body:           mutantDNASequenceAsJSONString,
+		RequestContext: events.APIGatewayProxyRequestContext{RequestID: "c6af9ac6-7b61"},
+	}
+
+	Handler(context.Background(), request)
+
+	var line map[string]interface{}
+	assert.Nil(t, json.Unmarshal(buffer.Bytes(), &line))
+	assert.Equal(t, "request", line["msg"])
+	assert.Equal(t, "c6af9ac6-7b61", line["request_id"])
+	assert.Equal(t, "/mutant", line["path"])
+	assert.Equal(t, float64(200), line["status"])
+	assert.Equal(t, float64(len(mutantDNASequence)), line["size"])
+	assert.Equal(t, true, line["mutant"])
+	assert.Equal(t, "hit", line["cache"])
+	assert.Contains(t, line, "latency_ms")
+}
+
+func TestClassifyRecordsMetrics(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	check := DNACheck{
+		DNA: mutantDNASequence,
+	}
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"type", "hash_algorithm"}).
+				AddRow("mutant", fingerprint.SHA256),
+		)
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillReturnError(errors.New("relation \"dna\" does not exist"))
+
+	mutants, hits, errored, sizes := classifications.Value("mutant"), lookups.Value("hit"), lookups.Value("error"), dnaSize.Count()
+
+	check.Classify(context.Background())
+	check.Classify(context.Background())
+
+	assert.Equal(t, mutants+1, classifications.Value("mutant"))
+	assert.Equal(t, hits+1, lookups.Value("hit"))
+	assert.Equal(t, errored+1, lookups.Value("error"))
+	assert.Equal(t, sizes+2, dnaSize.Count())
+}
+
+func TestHandlerRecordsSpans(t *testing.T) {
+	exporter := &tracing.InMemoryExporter{}
+	tracing.SetExporter(exporter)
+	defer tracing.SetExporter(nil)
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"type", "hash_algorithm"}).
+				AddRow("mutant", fingerprint.SHA256),
+		)
+
+	Handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "POST", Body: mutantDNASequenceAsJSONString})
+	tracing.Flush(context.Background())
+
+	names := []string{}
+	for _, span := range exporter.Spans() {
+		names = append(names, span.Name)
+	}
+
+	assert.Equal(t, []string{"dna.parse", "dna.validate", "dna.lookup", "POST /mutant"}, names)
+
+	root, _ := exporter.Named("POST /mutant")
+	assert.Equal(t, int64(len(mutantDNASequence)), root.Attribute("dna.size"))
+	assert.Equal(t, "mutant", root.Attribute("dna.verdict"))
+	assert.Equal(t, "hit", root.Attribute("dna.cache"))
+	assert.Equal(t, int64(200), root.Attribute("http.response.status_code"))
+
+	lookup, _ := exporter.Named("dna.lookup")
+	assert.Equal(t, root.SpanID, lookup.ParentSpanID)
+	assert.Equal(t, true, lookup.Attribute("dna.found"))
+}
+
+func TestScanRecordsASpanPerDirection(t *testing.T) {
+	exporter := &tracing.InMemoryExporter{}
+	tracing.SetExporter(exporter)
+	defer tracing.SetExporter(nil)
+
+	check := DNACheck{DNA: humanDNASequence}
+
+	assert.False(t, check.scan(context.Background()))
+	tracing.Flush(context.Background())
+
+	spans := exporter.Spans()
+	assert.Len(t, spans, 5)
+
+	horizontal, _ := exporter.Named("dna.scan.horizontal")
+	assert.Equal(t, int64(1), horizontal.Attribute("dna.sequences"))
+
+	scan, _ := exporter.Named("dna.scan")
+	assert.Equal(t, "human", scan.Attribute("dna.verdict"))
+	assert.Equal(t, scan.SpanID, horizontal.ParentSpanID)
+
+	// Once enough sequences are found the remaining directions are skipped
+	exporter = &tracing.InMemoryExporter{}
+	tracing.SetExporter(exporter)
+
+	check = DNACheck{DNA: mutantWithAllCombinationsDNASequence}
+
+	assert.True(t, check.scan(context.Background()))
+	tracing.Flush(context.Background())
+
+	_, skipped := exporter.Named("dna.scan.diagonal_right")
+	assert.False(t, skipped)
+}
+
 func TestHandlerHumanDNA(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	defer db.Close()
@@ -435,11 +1006,11 @@ func TestHandlerHumanDNA(t *testing.T) {
 	}
 
 	mock.
-		ExpectQuery("select type from dna").
-		WithArgs(check.Hash()).
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
 		WillReturnRows(
-			sqlmock.NewRows([]string{"type"}).
-				AddRow("ordinary"),
+			sqlmock.NewRows([]string{"type", "hash_algorithm"}).
+				AddRow("ordinary", fingerprint.SHA256),
 		)
 
 	request := events.APIGatewayProxyRequest{
@@ -452,7 +1023,7 @@ func TestHandlerHumanDNA(t *testing.T) {
 		StatusCode: 403,
 	}
 
-	actualResponde, actualError := Handler(request)
+	actualResponde, actualError := Handler(context.Background(), request)
 
 	assert.Equal(t, expectedError, actualError)
 	assert.Equal(t, expectedResponse, actualResponde)
@@ -467,7 +1038,7 @@ func TestHandlerEmptyBody(t *testing.T) {
 		StatusCode: 400,
 	}
 
-	actualResponde, actualError := Handler(request)
+	actualResponde, actualError := Handler(context.Background(), request)
 
 	assert.Equal(t, expectedError, actualError)
 	assert.Equal(t, expectedResponse, actualResponde)
@@ -484,8 +1055,62 @@ func TestHandlerInvalidDNA(t *testing.T) {
 		StatusCode: 400,
 	}
 
-	actualResponde, actualError := Handler(request)
+	actualResponde, actualError := Handler(context.Background(), request)
 
 	assert.Equal(t, expectedError, actualError)
 	assert.Equal(t, expectedResponse, actualResponde)
 }
+
+func TestHandlerDatabaseTimeout(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "hash_algorithm"}))
+
+	request := events.APIGatewayProxyRequest{
+		Body: mutantDNASequenceAsJSONString,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	expectedResponse := events.APIGatewayProxyResponse{
+		Body:       "Database operation timed out",
+		StatusCode: 504,
+	}
+
+	actualResponse, actualError := Handler(ctx, request)
+
+	assert.Nil(t, actualError)
+	assert.Equal(t, expectedResponse, actualResponse)
+}
+
+func TestHandlerDatabaseFailure(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillReturnError(sql.ErrConnDone)
+
+	request := events.APIGatewayProxyRequest{
+		Body: mutantDNASequenceAsJSONString,
+	}
+
+	expectedResponse := events.APIGatewayProxyResponse{
+		Body:       "Failed to check DNA",
+		StatusCode: 500,
+	}
+
+	actualResponse, actualError := Handler(context.Background(), request)
+
+	assert.Nil(t, actualError)
+	assert.Equal(t, expectedResponse, actualResponse)
+}
@@ -1,18 +1,38 @@
 package main
 
-var validDNASequence = []string{"ATCGAAA", "TTGATGA", "GTACCCG", "AAATAAG", "AATTGGG", "AAACCCG", "GTTACCC"}
+import (
+	"encoding/json"
+
+	"github.com/felipefill/mutants/generator"
+)
+
+var validDNASequence = mustGenerate(3, 7, generator.Runs{})
 var invalidDNASequence = []string{"ATCGAAA", "TTGATGG", "GTCCCCA", "ATAT$AT", "AATTG2C", "AAACCCG", "GTTACCX"}
 
-var mutantWithAllCombinationsDNASequence = []string{"ATCGAAA", "TTGATGA", "GTACCCG", "AAATAAG", "AATTGGG", "AAACCCG", "GTTAAAA"}
+var mutantWithAllCombinationsDNASequence = mustGenerate(4, 7, generator.Runs{Horizontal: 1, Vertical: 1, Diagonal: 3})
 
-var mutantDNASequence = []string{"ATCGAAA", "TTGATGA", "GTACCCG", "AAATAAG", "AATTGGG", "AAACCCG", "GTTAAAA"}
-var mutantDNASequenceAsJSONString = "{\"Dna\": [\"ATCGAAA\", \"TTGATGA\", \"GTACCCG\", \"AAATAAG\", \"AATTGGG\", \"AAACCCG\", \"GTTAAAA\"]}"
-var humanDNASequence = []string{"ATGCGA", "CAGTGC", "TTATTT", "AGACGG", "GCGTCA", "TCACTG"}
-var humanDNASequenceAsJSONString = "{\"Dna\": [\"ATGCGA\", \"CAGTGC\", \"TTATTT\", \"AGACGG\", \"GCGTCA\", \"TCACTG\"]}"
+var mutantDNASequence = mustGenerate(1, 7, generator.Runs{Horizontal: 1, Vertical: 1, Diagonal: 1})
+var mutantDNASequenceAsJSONString = asJSONString(mutantDNASequence)
+var humanDNASequence = mustGenerate(2, 7, generator.Runs{Horizontal: 1})
+var humanDNASequenceAsJSONString = asJSONString(humanDNASequence)
 
-var validDNASequenceString = "{\"Dna\": [\"ATCGAAA\", \"TTGATGA\", \"GTACCCG\", \"AAATAAG\", \"AATTGGG\", \"AAACCCG\", \"GTTACCC\"]}"
+var validDNASequenceString = asJSONString(validDNASequence)
 var invalidDNASequenceStringNotEvenAJSON = "This is clearly not a DNA sequence"
 var invalidDNASequenceStringWrongBases = "{\"Dna\": [\"ATGCXA\", \"CAGTGC\", \"TTATTT\", \"AGACGG\", \"GCGTCA\", \"TCACTG\"]}"
 
 var tableNxN = []string{"123", "321", "213"}
 var tableMxN = []string{"XXXX", "YYY", "ZZ"}
+
+func mustGenerate(seed int64, size int, runs generator.Runs) []string {
+	dna, err := generator.New(seed, repetitionRequiredForSequence).Generate(size, runs)
+	if err != nil {
+		panic(err)
+	}
+
+	return dna
+}
+
+func asJSONString(dna []string) string {
+	data, _ := json.Marshal(generator.Check{DNA: dna})
+	return string(data)
+}
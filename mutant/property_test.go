@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/felipefill/mutants/generator"
+	"github.com/felipefill/mutants/matrix"
+	"github.com/stretchr/testify/assert"
+)
+
+const propertyIterations = 300
+
+// Bases are picked from the fuzzer's bytes so any input becomes a valid NxN DNA
+func dnaFromBytes(size int, data []byte) []string {
+	dna := make([]string, size)
+
+	for row := 0; row < size; row++ {
+		line := make([]byte, size)
+		for column := 0; column < size; column++ {
+			index := row*size + column
+			if index < len(data) {
+				line[column] = "ATCG"[data[index]%4]
+			} else {
+				line[column] = 'A'
+			}
+		}
+
+		dna[row] = string(line)
+	}
+
+	return dna
+}
+
+func randomDNA(random *rand.Rand) []string {
+	size := 5 + random.Intn(8)
+	runs := generator.Runs{
+		Horizontal: random.Intn(2),
+		Vertical:   random.Intn(2),
+		Diagonal:   random.Intn(2),
+	}
+
+	dna, err := generator.New(random.Int63(), repetitionRequiredForSequence).Generate(size, runs)
+	if err != nil {
+		panic(err)
+	}
+
+	return dna
+}
+
+func transpose(dna []string) []string {
+	transposed := make([]string, len(dna))
+
+	for column := range dna {
+		line := make([]byte, len(dna))
+		for row := range dna {
+			line[row] = dna[row][column]
+		}
+
+		transposed[column] = string(line)
+	}
+
+	return transposed
+}
+
+func mirror(dna []string) []string {
+	mirrored := make([]string, len(dna))
+
+	for row := range dna {
+		line := []byte(dna[row])
+		for left, right := 0, len(line)-1; left < right; left, right = left+1, right-1 {
+			line[left], line[right] = line[right], line[left]
+		}
+
+		mirrored[row] = string(line)
+	}
+
+	return mirrored
+}
+
+func isMutantWithoutDatabase(dna []string) bool {
+	check := DNACheck{DNA: dna}
+	return check.hasMutantSequences()
+}
+
+func TestPropertyVerdictMatchesReferenceCount(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	for i := 0; i < propertyIterations; i++ {
+		dna := randomDNA(random)
+
+		assert.Equal(t, generator.CountRuns(dna, repetitionRequiredForSequence).Total() > 1, isMutantWithoutDatabase(dna), "Verdict is wrong for %v", dna)
+	}
+}
+
+func TestPropertyTransformationsKeepVerdict(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+
+	for i := 0; i < propertyIterations; i++ {
+		dna := randomDNA(random)
+		expected := isMutantWithoutDatabase(dna)
+
+		assert.Equal(t, expected, isMutantWithoutDatabase(transpose(dna)), "Transposing changed the verdict of %v", dna)
+		assert.Equal(t, expected, isMutantWithoutDatabase(mirror(dna)), "Mirroring changed the verdict of %v", dna)
+		assert.Equal(t, expected, isMutantWithoutDatabase(mirror(transpose(dna))), "Rotating changed the verdict of %v", dna)
+	}
+}
+
+func TestPropertyAddingSequenceKeepsMutant(t *testing.T) {
+	random := rand.New(rand.NewSource(3))
+
+	for i := 0; i < propertyIterations; i++ {
+		size := 8 + random.Intn(5)
+		dna, err := generator.New(random.Int63(), repetitionRequiredForSequence).Generate(size, generator.Runs{Horizontal: 1, Vertical: 1})
+		assert.Nil(t, err)
+
+		// Overwriting a whole row may break the existing sequences, so only rows
+		// without any of them are used for the new one
+		rows := []int{}
+		for row := range dna {
+			if generator.CountRuns([]string{dna[row]}, repetitionRequiredForSequence).Total() == 0 && !crossesVerticalSequence(dna, row) {
+				rows = append(rows, row)
+			}
+		}
+
+		if len(rows) == 0 {
+			continue
+		}
+
+		row := rows[random.Intn(len(rows))]
+		line := []byte(dna[row])
+		for column := 0; column < repetitionRequiredForSequence; column++ {
+			line[column] = 'G'
+		}
+
+		changed := append([]string{}, dna...)
+		changed[row] = string(line)
+
+		assert.Equal(t, true, isMutantWithoutDatabase(dna))
+		assert.Equal(t, true, isMutantWithoutDatabase(changed), "Adding a sequence turned %v into a human", dna)
+	}
+}
+
+// crossesVerticalSequence tells whether a sequence goes down through row in the columns a new
+// sequence would take, every one of them would hold the row within a sequence's length above and below it
+func crossesVerticalSequence(dna []string, row int) bool {
+	span := repetitionRequiredForSequence - 1
+
+	for column := 0; column < repetitionRequiredForSequence; column++ {
+		bases := []byte{}
+		for r := row - span; r <= row+span; r++ {
+			if r >= 0 && r < len(dna) {
+				bases = append(bases, dna[r][column])
+			}
+		}
+
+		if generator.CountRuns([]string{string(bases)}, repetitionRequiredForSequence).Total() > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestDiagonalsNearTheBottomRightCorner(t *testing.T) {
+	// Sequences that start after the fourth row or column used to be ignored
+	dna := []string{
+		"ATCGATCG",
+		"TCGATCGA",
+		"CGATCGAT",
+		"GATCGATC",
+		"ATCGCTCG",
+		"TCGATCGA",
+		"CGATCGCT",
+		"GATCGATC",
+	}
+
+	// Five equal bases from the fourth row and column to the corner hold two sequences
+	assert.Equal(t, 2, sequencesIn(t, dna, matrix.DiagonalRight))
+	assert.Equal(t, generator.CountRuns(dna, repetitionRequiredForSequence).Diagonal-2, sequencesIn(t, dna, matrix.DiagonalLeft))
+}
+
+func FuzzNewDNACheckFromJSONString(f *testing.F) {
+	f.Add(validDNASequenceString)
+	f.Add(mutantDNASequenceAsJSONString)
+	f.Add(invalidDNASequenceStringNotEvenAJSON)
+	f.Add(invalidDNASequenceStringWrongBases)
+	f.Add(`{"Dna": ["AAAA", "CCC", "GG"]}`)
+	f.Add(`{"Dna": []}`)
+
+	f.Fuzz(func(t *testing.T, data string) {
+		check, err := NewDNACheckFromJSONString(context.Background(), data)
+		if err != nil {
+			return
+		}
+
+		if check.validate() != nil {
+			t.Fatalf("Accepted an invalid DNA: %v", check.DNA)
+		}
+
+		if check.hasMutantSequences() != (generator.CountRuns(check.DNA, repetitionRequiredForSequence).Total() > 1) {
+			t.Fatalf("Wrong verdict for %v", check.DNA)
+		}
+	})
+}
+
+func FuzzScan(f *testing.F) {
+	f.Add(uint8(4), []byte{0, 0, 0, 0, 1, 1, 1, 1, 2, 3, 2, 3, 3, 2, 3, 2})
+	f.Add(uint8(6), []byte("a diagonal near the corner used to go out of bounds"))
+	f.Add(uint8(8), []byte{})
+
+	f.Fuzz(func(t *testing.T, size uint8, data []byte) {
+		dna := dnaFromBytes(int(size%32), data)
+		check := DNACheck{DNA: dna}
+		runs := generator.CountRuns(dna, repetitionRequiredForSequence)
+
+		if len(dna) > 0 {
+			horizontal, vertical := sequencesIn(t, dna, matrix.Horizontal), sequencesIn(t, dna, matrix.Vertical)
+			diagonal := sequencesIn(t, dna, matrix.DiagonalLeft) + sequencesIn(t, dna, matrix.DiagonalRight)
+
+			if horizontal != runs.Horizontal || vertical != runs.Vertical || diagonal != runs.Diagonal {
+				t.Fatalf("Counted %d, %d and %d sequences in %v, expected %+v", horizontal, vertical, diagonal, dna, runs)
+			}
+		}
+
+		if check.hasMutantSequences() != (generator.CountRuns(dna, repetitionRequiredForSequence).Total() > 1) {
+			t.Fatalf("Wrong verdict for %v", dna)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/felipefill/mutants/bearer"
+	"github.com/felipefill/mutants/health"
+	"github.com/felipefill/mutants/matrix"
+	"github.com/felipefill/mutants/openapi"
+)
+
+// persisted tells the verdict was computed while the database was unavailable
+var persisted = openapi.Header{
+	Name:        persistedHeader,
+	Description: "false when the verdict was computed while the database was unavailable",
+	Schema:      openapi.Schema{"type": "string", "enum": []string{"false"}},
+}
+
+var limitExceeded = openapi.Response{
+	Name:        "LimitExceeded",
+	Description: "The body, with 413, or the DNA, with 422, is larger than allowed",
+	Headers:     []openapi.Header{openapi.CacheControl},
+	Body:        matrix.LimitError{},
+}
+
+var readinessResponse = openapi.Response{
+	Name:        "Readiness",
+	Description: "Every check, 503 when a critical one failed",
+	Headers:     []openapi.Header{openapi.CacheControl},
+	Body:        health.Report{},
+}
+
+var fasta = openapi.Schema{"type": "string", "description": "One record per row"}
+
+// routes are the operations of the function, the OpenAPI document is generated from them. Run
+// go test -update after changing them or the types they answer.
+var routes = []openapi.Operation{
+	{
+		Route:       "POST /mutant",
+		Summary:     "Classify a DNA",
+		Description: "The verdict is the status code. Other content types than the ones listed are read as JSON.",
+		Security:    openapi.Optional(bearer.ScopeClassify),
+		Request: &openapi.Request{Required: true, Content: map[string]interface{}{
+			"application/json":      openapi.Component{Name: "DNACheck", Schema: json.RawMessage(schema)},
+			"text/plain":            openapi.Schema{"type": "string", "description": "One row per line"},
+			"text/x-fasta":          fasta,
+			"text/fasta":            fasta,
+			"application/x-fasta":   fasta,
+			"chemical/seq-na-fasta": fasta,
+			"multipart/form-data": openapi.Schema{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"file": map[string]interface{}{"type": "string", "contentMediaType": "application/octet-stream", "description": "Its format comes from its content type, or else its extension"},
+					"dna":  map[string]interface{}{"type": "string", "description": "Rows, one per line"},
+				},
+			},
+		}},
+		Responses: map[int]openapi.Response{
+			200: {Description: "The DNA is a mutant's", Headers: []openapi.Header{persisted}},
+			400: openapi.BadRequest,
+			401: openapi.Unauthorized,
+			403: {
+				Description: "The DNA is human, with an empty body, or the token lacks the classify scope",
+				Headers:     []openapi.Header{persisted, openapi.WWWAuthenticate},
+				ContentType: "text/plain",
+				Body:        openapi.PlainText,
+			},
+			413: limitExceeded,
+			422: limitExceeded,
+			429: openapi.TooMany,
+			500: openapi.Error,
+			503: openapi.Error,
+			504: openapi.Error,
+		},
+	},
+	{
+		Route:    "GET /healthz",
+		Summary:  "Liveness probe",
+		Security: openapi.Public,
+		Responses: map[int]openapi.Response{
+			200: {Description: "The function is running", Headers: []openapi.Header{openapi.CacheControl}, Body: livenessReport{}},
+		},
+	},
+	{
+		Route:     "GET /readyz",
+		Summary:   "Readiness probe",
+		Security:  openapi.Public,
+		Responses: map[int]openapi.Response{200: readinessResponse, 503: readinessResponse},
+	},
+	{
+		Route:    "GET /schema",
+		Summary:  "JSON Schema of classification requests",
+		Security: openapi.Public,
+		Responses: map[int]openapi.Response{
+			200: {Description: "The schema", ContentType: "application/schema+json", Body: openapi.Schema{"type": "object"}},
+		},
+	},
+	{
+		Route:    "GET /openapi.json",
+		Summary:  "This document",
+		Security: openapi.Public,
+		Responses: map[int]openapi.Response{
+			200: {Description: "The document", Body: openapi.Schema{"type": "object"}},
+		},
+	},
+	{
+		Route:    "POST /admin/purge",
+		Summary:  "Delete the DNA of a client, or a single DNA by its hash",
+		Security: openapi.Bearer(bearer.ScopeAdmin),
+		Request:  &openapi.Request{Required: true, Content: map[string]interface{}{"application/json": purgeRequest{}}},
+		Responses: map[int]openapi.Response{
+			200: {Description: "How many DNA were deleted", Headers: []openapi.Header{openapi.CacheControl}, Body: purgeResult{}},
+			400: openapi.BadRequest,
+			401: openapi.Unauthorized,
+			403: openapi.Forbidden,
+			500: openapi.Error,
+			504: openapi.Error,
+		},
+	},
+	{
+		Route:    "POST /admin/reclassify",
+		Summary:  "Check a batch of stored DNA again with the rules in use",
+		Security: openapi.Bearer(bearer.ScopeAdmin),
+		Request:  &openapi.Request{Content: map[string]interface{}{"application/json": reclassifyRequest{}}},
+		Responses: map[int]openapi.Response{
+			200: {Description: "What was checked and where the next batch starts", Headers: []openapi.Header{openapi.CacheControl}, Body: reclassifyResult{}},
+			400: openapi.BadRequest,
+			401: openapi.Unauthorized,
+			403: openapi.Forbidden,
+			500: openapi.Error,
+			504: openapi.Error,
+		},
+	},
+}
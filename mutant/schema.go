@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// schema describes JSON requests, it is served at /schema
+//
+//go:embed schema.json
+var schema string
+
+// Bounds of the metadata, schema.json states them as well
+const (
+	maxReferenceLength = 128
+	maxTags            = 20
+	maxTagLength       = 64
+)
+
+// Metadata describes the sample a DNA comes from, v2 requests may send it and it is stored with
+// the DNA
+type Metadata struct {
+	SampleID        string   `json:"sample_id,omitempty"`
+	ClientReference string   `json:"client_reference,omitempty"`
+	Tags            []string `json:"tags,omitempty"`
+}
+
+// requestFields collects the keys of a JSON request besides the rows of its DNA
+type requestFields struct {
+	dnaKey   string
+	version  json.RawMessage
+	metadata json.RawMessage
+}
+
+// add is handed every key of the request, keys that no version has are rejected right away
+func (fields *requestFields) add(key string, value json.RawMessage) error {
+	switch {
+	case value == nil:
+		fields.dnaKey = key
+	case key == "version":
+		fields.version = value
+	case key == "metadata":
+		fields.metadata = value
+	default:
+		return fmt.Errorf("Unknown field %q", key)
+	}
+
+	return nil
+}
+
+// check validates the request against the schema of its version, returning its metadata
+func (fields *requestFields) check() (*Metadata, error) {
+	version := 1
+	if fields.version != nil && (json.Unmarshal(fields.version, &version) != nil || version < 1 || version > 2) {
+		return nil, errors.New("Unsupported schema version, it must be 1 or 2")
+	}
+
+	if fields.dnaKey == "" {
+		return nil, errors.New(`Missing field "dna"`)
+	}
+
+	if version == 1 {
+		if fields.metadata != nil {
+			return nil, errors.New(`Field "metadata" needs schema version 2`)
+		}
+
+		return nil, nil
+	}
+
+	// Only v1 takes the DNA under any spelling, as it always did
+	if fields.dnaKey != "dna" {
+		return nil, fmt.Errorf(`Unknown field %q, schema version 2 expects "dna"`, fields.dnaKey)
+	}
+
+	if fields.metadata == nil {
+		return nil, nil
+	}
+
+	return decodeMetadata(fields.metadata)
+}
+
+func decodeMetadata(data json.RawMessage) (*Metadata, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	metadata := &Metadata{}
+	if err := decoder.Decode(metadata); err != nil {
+		return nil, fmt.Errorf("Invalid metadata: %s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+
+	if utf8.RuneCountInString(metadata.SampleID) > maxReferenceLength || utf8.RuneCountInString(metadata.ClientReference) > maxReferenceLength {
+		return nil, fmt.Errorf("Invalid metadata: sample_id and client_reference must be at most %d characters", maxReferenceLength)
+	}
+
+	if len(metadata.Tags) > maxTags {
+		return nil, fmt.Errorf("Invalid metadata: at most %d tags are allowed", maxTags)
+	}
+
+	for _, tag := range metadata.Tags {
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("Invalid metadata: tags must be 1 to %d characters", maxTagLength)
+		}
+	}
+
+	return metadata, nil
+}
+
+// schemaResponse serves the JSON Schema of requests
+func schemaResponse() events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		Body:       schema,
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/schema+json"},
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/apikey"
+	"github.com/felipefill/mutants/fingerprint"
+	"github.com/felipefill/mutants/utils"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaV1(t *testing.T) {
+	for _, data := range []string{
+		`{"dna": ["AT", "CG"]}`,
+		`{"Dna": ["AT", "CG"]}`,
+		`{"DNA": ["AT", "CG"]}`,
+		`{"version": 1, "dna": ["AT", "CG"]}`,
+		`{"dna": ["AT", "CG"], "version": 1}`,
+	} {
+		check, err := NewDNACheckFromJSONString(context.Background(), data)
+
+		assert.Nil(t, err, data)
+		assert.Equal(t, []string{"AT", "CG"}, check.DNA, data)
+		assert.Nil(t, check.Metadata, data)
+	}
+}
+
+func TestSchemaV2(t *testing.T) {
+	check, err := NewDNACheckFromJSONString(context.Background(), `{
+		"version": 2,
+		"dna": ["AT", "CG"],
+		"metadata": {"sample_id": "S-1", "client_reference": "lab/42", "tags": ["north", "urgent"]}
+	}`)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"AT", "CG"}, check.DNA)
+	assert.Equal(t, &Metadata{SampleID: "S-1", ClientReference: "lab/42", Tags: []string{"north", "urgent"}}, check.Metadata)
+
+	check, err = NewDNACheckFromJSONString(context.Background(), `{"version": 2, "dna": ["AT", "CG"]}`)
+
+	assert.Nil(t, err)
+	assert.Nil(t, check.Metadata)
+}
+
+func TestSchemaRejectsWhatItDoesNotDescribe(t *testing.T) {
+	cases := map[string]string{
+		`{"dna": ["AT", "CG"], "source": "lab"}`:                  `Unknown field "source"`,
+		`{"Version": 2, "dna": ["AT", "CG"]}`:                     `Unknown field "Version"`,
+		`{"dna": ["AT", "CG"], "metadata": {"sample_id": "S-1"}}`: `Field "metadata" needs schema version 2`,
+		`{"version": 3, "dna": ["AT", "CG"]}`:                     "Unsupported schema version, it must be 1 or 2",
+		`{"version": "2", "dna": ["AT", "CG"]}`:                   "Unsupported schema version, it must be 1 or 2",
+		`{"version": 2}`:                                          `Missing field "dna"`,
+		`{}`:                                                      `Missing field "dna"`,
+		`null`:                                                    `Missing field "dna"`,
+		`{"version": 2, "Dna": ["AT", "CG"]}`:                     `Unknown field "Dna", schema version 2 expects "dna"`,
+		`{"version": 2, "dna": ["AT", "CG"], "metadata": {"lab": "north"}}`:                                        `Invalid metadata: unknown field "lab"`,
+		`{"version": 2, "dna": ["AT", "CG"], "metadata": {"tags": "north"}}`:                                       "Invalid metadata: cannot unmarshal string into Go struct field Metadata.tags of type []string",
+		`{"version": 2, "dna": ["AT", "CG"], "metadata": {"tags": [""]}}`:                                          "Invalid metadata: tags must be 1 to 64 characters",
+		`{"version": 2, "dna": ["AT", "CG"], "metadata": {"tags": ["` + strings.Repeat("x", 65) + `"]}}`:           "Invalid metadata: tags must be 1 to 64 characters",
+		`{"version": 2, "dna": ["AT", "CG"], "metadata": {"sample_id": "` + strings.Repeat("x", 129) + `"}}`:       "Invalid metadata: sample_id and client_reference must be at most 128 characters",
+		`{"version": 2, "dna": ["AT", "CG"], "metadata": {"tags": ["a"` + strings.Repeat(`, "a"`, maxTags) + `]}}`: "Invalid metadata: at most 20 tags are allowed",
+	}
+
+	for data, expected := range cases {
+		_, err := NewDNACheckFromJSONString(context.Background(), data)
+
+		assert.Equal(t, errors.New(expected), err, data)
+	}
+}
+
+func TestHandlerServesTheSchema(t *testing.T) {
+	response, err := Handler(context.Background(), events.APIGatewayProxyRequest{Resource: "/schema", HTTPMethod: "GET"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Equal(t, "application/schema+json", response.Headers["Content-Type"])
+
+	var document struct {
+		Defs struct {
+			Metadata struct {
+				Properties struct {
+					SampleID        struct{ MaxLength int } `json:"sample_id"`
+					ClientReference struct{ MaxLength int } `json:"client_reference"`
+					Tags            struct {
+						MaxItems int
+						Items    struct{ MaxLength int }
+					} `json:"tags"`
+				} `json:"properties"`
+			} `json:"metadata"`
+		} `json:"$defs"`
+	}
+
+	// The bounds it states are the ones enforced
+	assert.Nil(t, json.Unmarshal([]byte(response.Body), &document))
+	assert.Equal(t, maxReferenceLength, document.Defs.Metadata.Properties.SampleID.MaxLength)
+	assert.Equal(t, maxReferenceLength, document.Defs.Metadata.Properties.ClientReference.MaxLength)
+	assert.Equal(t, maxTags, document.Defs.Metadata.Properties.Tags.MaxItems)
+	assert.Equal(t, maxTagLength, document.Defs.Metadata.Properties.Tags.Items.MaxLength)
+}
+
+func TestSaveDNAStoresItsMetadata(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	check := DNACheck{
+		DNA:      humanDNASequence,
+		Metadata: &Metadata{SampleID: "S-1", Tags: []string{"north"}},
+	}
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into dna").
+		WithArgs(hashOf(check, hashAlgorithm), "ordinary", packedData(check.DNA), false, fingerprint.SHA256, sql.NullInt64{}).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "inserted"}).AddRow("ordinary", true))
+	mock.
+		ExpectExec("insert into dna_metadata").
+		WithArgs(pq.Array([]string{hashOf(check, hashAlgorithm)}), sql.NullInt64{}, `{"sample_id":"S-1","tags":["north"]}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.
+		ExpectExec("insert into outbox").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	storedType, err := check.Save(context.Background(), "ordinary")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ordinary", storedType)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+// Every submission keeps its metadata, whether the DNA was stored concurrently or already known
+func TestResubmittedDNAStoresItsMetadata(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	check := DNACheck{
+		DNA:      humanDNASequence,
+		Metadata: &Metadata{SampleID: "S-2"},
+	}
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("insert into dna").
+		WillReturnRows(sqlmock.NewRows([]string{"type", "inserted"}).AddRow("ordinary", false))
+	mock.
+		ExpectExec("insert into dna_metadata").
+		WithArgs(pq.Array([]string{hashOf(check, hashAlgorithm)}), sql.NullInt64{}, `{"sample_id":"S-2"}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	_, err := check.Save(context.Background(), "ordinary")
+	assert.Nil(t, err)
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WithArgs(pq.Array(lookupHashesOf(check))).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "hash_algorithm"}).AddRow("ordinary", fingerprint.SHA256))
+	mock.
+		ExpectExec("insert into dna_metadata").
+		WithArgs(pq.Array(lookupHashesOf(check)), sql.NullInt64{Int64: 4, Valid: true}, `{"sample_id":"S-2"}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	ctx := apikey.WithClient(context.Background(), apikey.Client{ID: 4})
+	classification, err := check.Classify(ctx)
+
+	assert.Nil(t, err)
+	assert.Equal(t, Classification{Mutant: false, Persisted: true, Cached: true}, classification)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestCachedDNAFailsWhenItsMetadataCannotBeStored(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	check := DNACheck{
+		DNA:      humanDNASequence,
+		Metadata: &Metadata{SampleID: "S-2"},
+	}
+
+	mock.
+		ExpectQuery("select type, hash_algorithm from dna").
+		WillReturnRows(sqlmock.NewRows([]string{"type", "hash_algorithm"}).AddRow("ordinary", fingerprint.SHA256))
+	mock.
+		ExpectExec("insert into dna_metadata").
+		WillReturnError(errors.New("relation \"dna_metadata\" does not exist"))
+
+	_, err := check.Classify(context.Background())
+
+	assert.EqualError(t, err, "Failed to store DNA metadata")
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,402 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/server"
+)
+
+// spec is a decoded OpenAPI document, responses are checked against what it states
+type spec map[string]interface{}
+
+// served is the document answered at /openapi.json
+var served = parse(document)
+
+func parse(data []byte) spec {
+	var parsed spec
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		panic("openapi: invalid document: " + err.Error())
+	}
+
+	return parsed
+}
+
+// Check tells whether response is one the served document states for method and path. Its status
+// must be listed, every header it sets but Content-Type declared and its body must match the
+// schema of its content type. Responses without a Content-Type are taken as plain text.
+func Check(method, path string, response events.APIGatewayProxyResponse) error {
+	return served.check(method, path, response)
+}
+
+func (s spec) check(method, path string, response events.APIGatewayProxyResponse) error {
+	operation, _ := lookup(map[string]interface{}(s), "paths", path, strings.ToLower(method)).(map[string]interface{})
+	if operation == nil {
+		return fmt.Errorf("%s %s is not documented", method, path)
+	}
+
+	documented := s.resolve(lookup(operation, "responses", strconv.Itoa(response.StatusCode)))
+	if documented == nil {
+		return fmt.Errorf("%s %s answered %d, which is not documented", method, path, response.StatusCode)
+	}
+
+	if err := s.checkHeaders(documented, response.Headers); err != nil {
+		return fmt.Errorf("%s %s answered %d with %s", method, path, response.StatusCode, err)
+	}
+
+	if err := s.checkBody(documented, response); err != nil {
+		return fmt.Errorf("%s %s answered %d with %s", method, path, response.StatusCode, err)
+	}
+
+	return nil
+}
+
+func (s spec) checkHeaders(documented map[string]interface{}, headers map[string]string) error {
+	declared, _ := documented["headers"].(map[string]interface{})
+
+	for name, value := range headers {
+		if strings.EqualFold(name, "Content-Type") {
+			continue
+		}
+
+		var header map[string]interface{}
+		for declaredName, described := range declared {
+			if strings.EqualFold(declaredName, name) {
+				header = s.resolve(described)
+			}
+		}
+
+		if header == nil {
+			return fmt.Errorf("header %s, which is not declared", name)
+		}
+
+		schema := s.resolve(header["schema"])
+		if err := s.validate(schema, headerValue(schema, value), "header "+name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// headerValue reads a header the way its schema types it, values that do not parse stay strings
+// so that validating them tells why
+func headerValue(schema map[string]interface{}, value string) interface{} {
+	types := typesOf(schema)
+
+	if contains(types, "integer") || contains(types, "number") {
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return number
+		}
+	}
+
+	if contains(types, "boolean") {
+		if boolean, err := strconv.ParseBool(value); err == nil {
+			return boolean
+		}
+	}
+
+	return value
+}
+
+func (s spec) checkBody(documented map[string]interface{}, response events.APIGatewayProxyResponse) error {
+	content, _ := documented["content"].(map[string]interface{})
+	if len(content) == 0 {
+		if response.Body != "" {
+			return fmt.Errorf("a body, none is documented")
+		}
+
+		return nil
+	}
+
+	contentType := server.HeaderValue(response.Headers, "Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	contentType = strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	described, ok := content[contentType].(map[string]interface{})
+	if !ok {
+		documentedTypes := make([]string, 0, len(content))
+		for documentedType := range content {
+			documentedTypes = append(documentedTypes, documentedType)
+		}
+
+		sort.Strings(documentedTypes)
+		return fmt.Errorf("content type %s, documented is %s", contentType, strings.Join(documentedTypes, " or "))
+	}
+
+	var body interface{} = response.Body
+	if isJSON(contentType) {
+		if err := json.Unmarshal([]byte(response.Body), &body); err != nil {
+			return fmt.Errorf("a body that is not JSON")
+		}
+	}
+
+	return s.validate(described["schema"], body, "body")
+}
+
+// validate tells whether value, as decoded from JSON, matches the schema node describes. It knows
+// the keywords the generated fragments and the hand written schemas use, the others are ignored.
+func (s spec) validate(node interface{}, value interface{}, at string) error {
+	schema := s.resolve(node)
+	if schema == nil {
+		return nil
+	}
+
+	if types := typesOf(schema); len(types) > 0 && !contains(types, typeOf(value)) && !(contains(types, "number") && typeOf(value) == "integer") {
+		return fmt.Errorf("%s: %s is not of type %s", at, show(value), strings.Join(types, " or "))
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok && !member(enum, value) {
+		shown := make([]string, len(enum))
+		for i, allowed := range enum {
+			shown[i] = show(allowed)
+		}
+
+		return fmt.Errorf("%s: %s is not one of %s", at, show(value), strings.Join(shown, ", "))
+	}
+
+	if constant, ok := schema["const"]; ok && !member([]interface{}{constant}, value) {
+		return fmt.Errorf("%s: %s is not %s", at, show(value), show(constant))
+	}
+
+	var err error
+	switch value := value.(type) {
+	case string:
+		err = validateString(schema, value, at)
+	case []interface{}:
+		err = s.validateArray(schema, value, at)
+	case map[string]interface{}:
+		err = s.validateObject(schema, value, at)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return s.validateCombinations(schema, value, at)
+}
+
+func validateString(schema map[string]interface{}, value string, at string) error {
+	if schema["format"] == "date-time" {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("%s: %s is not a date-time", at, show(value))
+		}
+	}
+
+	if maxLength, ok := schema["maxLength"].(float64); ok && utf8.RuneCountInString(value) > int(maxLength) {
+		return fmt.Errorf("%s: %s is longer than %d", at, show(value), int(maxLength))
+	}
+
+	if minLength, ok := schema["minLength"].(float64); ok && utf8.RuneCountInString(value) < int(minLength) {
+		return fmt.Errorf("%s: %s is shorter than %d", at, show(value), int(minLength))
+	}
+
+	if pattern, ok := schema["pattern"].(string); ok {
+		expression, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: pattern %s is invalid", at, pattern)
+		}
+
+		if !expression.MatchString(value) {
+			return fmt.Errorf("%s: %s does not match %s", at, show(value), pattern)
+		}
+	}
+
+	return nil
+}
+
+func (s spec) validateArray(schema map[string]interface{}, items []interface{}, at string) error {
+	if maxItems, ok := schema["maxItems"].(float64); ok && len(items) > int(maxItems) {
+		return fmt.Errorf("%s has more than %d items", at, int(maxItems))
+	}
+
+	if minItems, ok := schema["minItems"].(float64); ok && len(items) < int(minItems) {
+		return fmt.Errorf("%s has fewer than %d items", at, int(minItems))
+	}
+
+	for i, item := range items {
+		if err := s.validate(schema["items"], item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s spec) validateObject(schema map[string]interface{}, object map[string]interface{}, at string) error {
+	required, _ := schema["required"].([]interface{})
+	for _, name := range required {
+		if _, ok := object[name.(string)]; !ok {
+			return fmt.Errorf("%s.%s is missing", at, name)
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		described, ok := properties[name]
+		if !ok {
+			if schema["additionalProperties"] == false {
+				return fmt.Errorf("%s has unknown field %q", at, name)
+			}
+
+			described = schema["additionalProperties"]
+		}
+
+		if err := s.validate(described, object[name], at+"."+name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s spec) validateCombinations(schema map[string]interface{}, value interface{}, at string) error {
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, node := range allOf {
+			if err := s.validate(node, value, at); err != nil {
+				return err
+			}
+		}
+	}
+
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && s.matches(anyOf, value, at) == 0 {
+		return fmt.Errorf("%s: %s matches none of its schemas", at, show(value))
+	}
+
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		if matched := s.matches(oneOf, value, at); matched != 1 {
+			return fmt.Errorf("%s: %s matches %d of its schemas, not one", at, show(value), matched)
+		}
+	}
+
+	return nil
+}
+
+func (s spec) matches(nodes []interface{}, value interface{}, at string) int {
+	matched := 0
+	for _, node := range nodes {
+		if s.validate(node, value, at) == nil {
+			matched++
+		}
+	}
+
+	return matched
+}
+
+// resolve follows the references node makes to the components of the document, nil when node is
+// not an object or a reference leads nowhere
+func (s spec) resolve(node interface{}) map[string]interface{} {
+	object, _ := node.(map[string]interface{})
+
+	for object != nil {
+		ref, ok := object["$ref"].(string)
+		if !ok {
+			return object
+		}
+
+		object, _ = lookup(map[string]interface{}(s), strings.Split(strings.TrimPrefix(ref, "#/"), "/")...).(map[string]interface{})
+	}
+
+	return nil
+}
+
+// lookup walks node down given keys, nil when any is missing
+func lookup(node interface{}, path ...string) interface{} {
+	for _, key := range path {
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		node = object[key]
+	}
+
+	return node
+}
+
+func typesOf(schema map[string]interface{}) []string {
+	switch kind := schema["type"].(type) {
+	case string:
+		return []string{kind}
+	case []interface{}:
+		types := make([]string, 0, len(kind))
+		for _, name := range kind {
+			if name, ok := name.(string); ok {
+				types = append(types, name)
+			}
+		}
+
+		return types
+	}
+
+	return nil
+}
+
+// typeOf names the JSON type of a decoded value, whole numbers are integers
+func typeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func member(values []interface{}, value interface{}) bool {
+	for _, candidate := range values {
+		if show(candidate) == show(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// show writes value as JSON, so that errors quote strings and spell null
+func show(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func isJSON(contentType string) bool {
+	return contentType == "application/json" || strings.HasSuffix(contentType, "+json")
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,355 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Fragment generates the paths of operations and the components they use, it is merged with the
+// fragments of the other functions into the document
+func Fragment(operations []Operation) ([]byte, error) {
+	g := &generator{
+		sections: map[string]map[string]interface{}{"schemas": {}, "responses": {}, "headers": {}},
+		types:    map[string]reflect.Type{},
+	}
+
+	paths := map[string]map[string]interface{}{}
+	for _, operation := range operations {
+		method, path := strings.ToLower(operation.Route[:strings.Index(operation.Route, " ")]), resource(operation.Route)
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+
+		paths[path][method] = g.operation(operation)
+	}
+
+	components := map[string]interface{}{}
+	for name, section := range g.sections {
+		if len(section) > 0 {
+			components[name] = section
+		}
+	}
+
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	data, err := json.MarshalIndent(map[string]interface{}{"paths": paths, "components": components}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
+
+type generator struct {
+	sections map[string]map[string]interface{}
+	// types tells which Go type each schema component was generated from, two cannot share a name
+	types map[string]reflect.Type
+	err   error
+}
+
+func (g *generator) operation(operation Operation) map[string]interface{} {
+	described := map[string]interface{}{}
+	if operation.Summary != "" {
+		described["summary"] = operation.Summary
+	}
+
+	if operation.Description != "" {
+		described["description"] = operation.Description
+	}
+
+	if operation.Security != nil {
+		described["security"] = operation.Security
+	}
+
+	if len(operation.Parameters) > 0 {
+		names := make([]string, 0, len(operation.Parameters))
+		for name := range operation.Parameters {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		parameters := []interface{}{}
+		for _, name := range names {
+			schema := g.schema(reflect.TypeOf(operation.Parameters[name]))
+			parameters = append(parameters, map[string]interface{}{"name": name, "in": "path", "required": true, "schema": schema})
+		}
+
+		described["parameters"] = parameters
+	}
+
+	if operation.Request != nil {
+		content := map[string]interface{}{}
+		for contentType, body := range operation.Request.Content {
+			content[contentType] = map[string]interface{}{"schema": g.body(body)}
+		}
+
+		request := map[string]interface{}{"content": content}
+		if operation.Request.Required {
+			request["required"] = true
+		}
+
+		described["requestBody"] = request
+	}
+
+	responses := map[string]interface{}{}
+	for statusCode, response := range operation.Responses {
+		responses[strconv.Itoa(statusCode)] = g.response(response)
+	}
+
+	described["responses"] = responses
+
+	return described
+}
+
+func (g *generator) response(response Response) map[string]interface{} {
+	described := map[string]interface{}{"description": response.Description}
+
+	if len(response.Headers) > 0 {
+		headers := map[string]interface{}{}
+		for _, header := range response.Headers {
+			headers[header.Name] = g.header(header)
+		}
+
+		described["headers"] = headers
+	}
+
+	if response.Body != nil {
+		described["content"] = map[string]interface{}{response.contentType(): map[string]interface{}{"schema": g.body(response.Body)}}
+	}
+
+	if response.Name == "" {
+		return described
+	}
+
+	return g.share("responses", response.Name, described)
+}
+
+func (g *generator) header(header Header) map[string]interface{} {
+	described := map[string]interface{}{"schema": header.Schema}
+	if header.Description != "" {
+		described["description"] = header.Description
+	}
+
+	return g.share("headers", header.Name, described)
+}
+
+// share writes described in a section of the components and returns a reference to it
+func (g *generator) share(section, name string, described map[string]interface{}) map[string]interface{} {
+	if existing, ok := g.sections[section][name]; ok && !reflect.DeepEqual(existing, described) {
+		g.fail(fmt.Errorf("%s %s is described twice, differently", section, name))
+	}
+
+	g.sections[section][name] = described
+
+	return map[string]interface{}{"$ref": "#/components/" + section + "/" + name}
+}
+
+func (g *generator) body(body interface{}) map[string]interface{} {
+	switch body := body.(type) {
+	case Schema:
+		return body
+	case Component:
+		return g.component(body)
+	default:
+		return g.schema(reflect.TypeOf(body))
+	}
+}
+
+// component shares a schema document, its $defs are shared as well and references to them
+// rewritten, since they would otherwise point into the OpenAPI document
+func (g *generator) component(component Component) map[string]interface{} {
+	var document map[string]interface{}
+	if err := json.Unmarshal(rewriteDefs(component.Schema, component.Name), &document); err != nil {
+		g.fail(fmt.Errorf("schema %s is not valid JSON: %s", component.Name, err))
+		return nil
+	}
+
+	defs, _ := document["$defs"].(map[string]interface{})
+	for name, def := range defs {
+		described, _ := def.(map[string]interface{})
+		g.share("schemas", component.Name+exported(name), described)
+	}
+
+	delete(document, "$defs")
+	delete(document, "$schema")
+
+	return g.share("schemas", component.Name, document)
+}
+
+func rewriteDefs(schema json.RawMessage, name string) []byte {
+	var document struct {
+		Defs map[string]json.RawMessage `json:"$defs"`
+	}
+	json.Unmarshal(schema, &document)
+
+	rewritten := string(schema)
+	for def := range document.Defs {
+		rewritten = strings.ReplaceAll(rewritten, `"#/$defs/`+def+`"`, `"#/components/schemas/`+name+exported(def)+`"`)
+	}
+
+	return []byte(rewritten)
+}
+
+// schema describes how kind is encoded to JSON. Named structs are shared, named after their
+// type and, unless it is main or already starts the name, their package.
+func (g *generator) schema(kind reflect.Type) map[string]interface{} {
+	if kind == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch kind.Kind() {
+	case reflect.Ptr:
+		described := g.schema(kind.Elem())
+		if _, ok := described["$ref"]; ok {
+			return map[string]interface{}{"anyOf": []interface{}{described, map[string]interface{}{"type": "null"}}}
+		}
+
+		described["type"] = []interface{}{described["type"], "null"}
+		return described
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if kind.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string"}
+		}
+
+		return map[string]interface{}{"type": "array", "items": g.schema(kind.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(kind.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if kind.Name() == "" {
+			return g.object(kind)
+		}
+
+		name := componentName(kind)
+		if existing, ok := g.types[name]; ok {
+			if existing != kind {
+				g.fail(fmt.Errorf("%s and %s would share schema %s", existing, kind, name))
+			}
+
+			return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		}
+
+		g.types[name] = kind
+		return g.share("schemas", name, g.object(kind))
+	}
+
+	g.fail(fmt.Errorf("%s cannot be described", kind))
+	return nil
+}
+
+func (g *generator) object(kind reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	for _, field := range fields(kind) {
+		described := g.schema(field.kind)
+		if field.doc != "" {
+			described["description"] = field.doc
+		}
+
+		if len(field.enum) > 0 {
+			described["enum"] = field.enum
+		}
+
+		properties[field.name] = described
+		if field.required {
+			required = append(required, field.name)
+		}
+	}
+
+	described := map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+	if len(required) > 0 {
+		described["required"] = required
+	}
+
+	return described
+}
+
+func (g *generator) fail(err error) {
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+// field is a struct field as encoding/json sees it. A doc tag describes it and an enum tag lists
+// the values it takes, separated by commas.
+type field struct {
+	name     string
+	kind     reflect.Type
+	required bool
+	doc      string
+	enum     []string
+}
+
+func fields(kind reflect.Type) []field {
+	found := []field{}
+	for i := 0; i < kind.NumField(); i++ {
+		structField := kind.Field(i)
+		tag := structField.Tag.Get("json")
+		if structField.PkgPath != "" || tag == "-" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = structField.Name
+		}
+
+		described := field{
+			name:     name,
+			kind:     structField.Type,
+			required: !strings.Contains(","+options+",", ",omitempty,"),
+			doc:      structField.Tag.Get("doc"),
+		}
+
+		if enum := structField.Tag.Get("enum"); enum != "" {
+			described.enum = strings.Split(enum, ",")
+		}
+
+		found = append(found, described)
+	}
+
+	return found
+}
+
+func componentName(kind reflect.Type) string {
+	name := exported(kind.Name())
+	pkg := exported(strings.SplitN(kind.String(), ".", 2)[0])
+
+	if pkg == "Main" || strings.HasPrefix(name, pkg) {
+		return name
+	}
+
+	return pkg + name
+}
+
+func exported(name string) string {
+	if name == "" {
+		return name
+	}
+
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+
+	return string(runes)
+}
@@ -0,0 +1,34 @@
+package openapi
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "write the OpenAPI fragments generated from the route tables")
+
+// CheckGolden fails t unless the fragment named name, embedded in the document, is the one
+// generated from operations. Running the tests with -update writes it instead, which is needed
+// whenever the routes or the types they answer change.
+func CheckGolden(t *testing.T, name string, operations []Operation) {
+	t.Helper()
+
+	generated, err := Fragment(operations)
+	assert.Nil(t, err)
+
+	// The fragments sit next to this file, whichever package the test runs in
+	_, file, _, _ := runtime.Caller(0)
+	fragment := filepath.Join(filepath.Dir(file), "fragments", name+".json")
+
+	if *update {
+		assert.Nil(t, os.WriteFile(fragment, generated, 0644))
+	}
+
+	written, _ := os.ReadFile(fragment)
+	assert.Equal(t, string(generated), string(written), "run go test -update to write it")
+}
@@ -0,0 +1,232 @@
+// Package openapi describes the API with route tables declared by each function next to its
+// handler. The OpenAPI document served at /openapi.json is generated from them and from the Go
+// types they answer, and responses are checked against that document so handlers cannot drift
+// from it.
+package openapi
+
+import (
+	"embed"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Operation is a route of a function, with everything it may answer
+type Operation struct {
+	// Route is the method and the API Gateway resource, such as "GET /webhooks/{id}"
+	Route       string
+	Summary     string
+	Description string
+	// Security is left out of the document when nil, the default of the document applies then
+	Security Security
+	// Parameters maps path parameters to a value of their Go type
+	Parameters map[string]interface{}
+	Request    *Request
+	Responses  map[int]Response
+}
+
+// Security lists the ways an operation may be authenticated, any one of them is enough
+type Security []Requirement
+
+// Requirement maps security schemes to the scopes they must grant, an empty one needs nothing
+type Requirement map[string][]string
+
+// Public is the security of operations that need no credentials at all
+var Public = Security{}
+
+// Optional is the security of operations that take an API key or a token granting scope, or
+// nothing when the deployment does not require authentication
+func Optional(scope string) Security {
+	return Security{{}, {"apiKey": {}}, {"bearer": {scope}}}
+}
+
+// Bearer is the security of operations that only take a token granting scope
+func Bearer(scope string) Security {
+	return Security{{"bearer": {scope}}}
+}
+
+// Request is the body an operation takes, by content type. Bodies are described like responses.
+type Request struct {
+	Required bool
+	Content  map[string]interface{}
+}
+
+// Response is what an operation answers with a status code. Body is nil for responses without
+// one, a Schema, a Component or a value whose Go type describes the JSON body.
+type Response struct {
+	// Name is set on shared responses, which are written once in the components of the document
+	Name        string
+	Description string
+	Headers     []Header
+	// ContentType is application/json when empty and there is a body
+	ContentType string
+	Body        interface{}
+}
+
+// Header is a response header, every one a response sets but Content-Type must be declared
+type Header struct {
+	Name        string
+	Description string
+	Schema      Schema
+}
+
+// Schema is a JSON schema written by hand, for bodies that have no Go type
+type Schema map[string]interface{}
+
+// Component is a JSON schema document written by hand and shared under Name. Its $defs become
+// components of their own, named after it.
+type Component struct {
+	Name   string
+	Schema json.RawMessage
+}
+
+// PlainText is the body of text/plain responses
+var PlainText = Schema{"type": "string"}
+
+// Headers and responses shared by the functions
+var (
+	CacheControl    = Header{Name: "Cache-Control", Schema: Schema{"type": "string", "enum": []string{"no-store"}}}
+	WWWAuthenticate = Header{Name: "WWW-Authenticate", Description: "Bearer challenge telling why the token was refused", Schema: Schema{"type": "string"}}
+	RetryAfter      = Header{Name: "Retry-After", Description: "Seconds until a request would be allowed", Schema: Schema{"type": "integer"}}
+
+	BadRequest   = Response{Name: "BadRequest", Description: "The request is invalid", ContentType: "text/plain", Body: PlainText}
+	Unauthorized = Response{Name: "Unauthorized", Description: "Missing or invalid API key or bearer token", Headers: []Header{WWWAuthenticate}, ContentType: "text/plain", Body: PlainText}
+	Forbidden    = Response{Name: "Forbidden", Description: "The token lacks the scope needed", Headers: []Header{WWWAuthenticate}, ContentType: "text/plain", Body: PlainText}
+	NotFound     = Response{Name: "NotFound", Description: "No such resource", ContentType: "text/plain", Body: PlainText}
+	TooMany      = Response{Name: "TooManyRequests", Description: "Rate limit or daily quota exceeded", Headers: []Header{RetryAfter}, ContentType: "text/plain", Body: PlainText}
+	Error        = Response{Name: "Error", Description: "The database failed, could not verify the credentials or timed out", ContentType: "text/plain", Body: PlainText}
+)
+
+// base is the part of the document no function owns
+var base = map[string]interface{}{
+	"openapi": "3.1.0",
+	"info": map[string]interface{}{
+		"title":       "Mutants",
+		"version":     "1.0.0",
+		"description": "Tells whether a DNA is a mutant's. Requests are authenticated with an API key or a bearer token when the deployment requires it. Errors other than limits answer a plain text message.",
+	},
+	"security": Security{{}, {"apiKey": {}}, {"bearer": {}}},
+	"components": map[string]interface{}{
+		"securitySchemes": map[string]interface{}{
+			"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-Api-Key"},
+			"bearer": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+		},
+	},
+}
+
+// fragments hold the paths and components generated from the route table of each function, their
+// contract tests write them
+//
+//go:embed fragments/*.json
+var fragments embed.FS
+
+var document = assemble()
+
+func assemble() []byte {
+	merged := map[string]interface{}{}
+	merge(merged, base)
+
+	entries, err := fragments.ReadDir("fragments")
+	if err != nil {
+		panic("openapi: " + err.Error())
+	}
+
+	for _, entry := range entries {
+		data, _ := fragments.ReadFile("fragments/" + entry.Name())
+
+		var fragment map[string]interface{}
+		if err := json.Unmarshal(data, &fragment); err != nil {
+			panic("openapi: invalid fragment " + entry.Name() + ": " + err.Error())
+		}
+
+		merge(merged, fragment)
+	}
+
+	assembled, _ := json.MarshalIndent(merged, "", "  ")
+	return append(assembled, '\n')
+}
+
+// merge copies from into to, objects present in both are merged key by key
+func merge(to, from map[string]interface{}) {
+	for key, value := range from {
+		existing, ok := to[key].(map[string]interface{})
+		object, isObject := value.(map[string]interface{})
+
+		if ok && isObject {
+			merge(existing, object)
+			continue
+		}
+
+		if isObject {
+			copied := map[string]interface{}{}
+			merge(copied, object)
+			value = copied
+		}
+
+		to[key] = value
+	}
+}
+
+// Document returns the OpenAPI document
+func Document() []byte {
+	return document
+}
+
+// Serve answers the OpenAPI document
+func Serve() events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		Body:       string(document),
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}
+}
+
+// Routes returns the route of every operation, as server.Run takes them
+func Routes(operations []Operation) []string {
+	routes := make([]string, 0, len(operations))
+	for _, operation := range operations {
+		routes = append(routes, operation.Route)
+	}
+
+	return routes
+}
+
+// Resources returns the resources of the operations with given security, such as Public
+func Resources(operations []Operation, security Security) []string {
+	resources := []string{}
+	for _, operation := range operations {
+		if operation.Security != nil && reflect.DeepEqual(operation.Security, security) {
+			resources = append(resources, resource(operation.Route))
+		}
+	}
+
+	return resources
+}
+
+// Scopes maps the resource of every operation that takes a bearer token to the scope it needs
+func Scopes(operations []Operation) map[string]string {
+	scopes := map[string]string{}
+	for _, operation := range operations {
+		for _, requirement := range operation.Security {
+			if granted := requirement["bearer"]; len(granted) > 0 {
+				scopes[resource(operation.Route)] = granted[0]
+			}
+		}
+	}
+
+	return scopes
+}
+
+func resource(route string) string {
+	return route[strings.Index(route, " ")+1:]
+}
+
+func (response Response) contentType() string {
+	if response.ContentType == "" {
+		return "application/json"
+	}
+
+	return response.ContentType
+}
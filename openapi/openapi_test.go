@@ -0,0 +1,272 @@
+package openapi
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+type count struct {
+	Mutants int     `json:"count_mutant_dna"`
+	Humans  int     `json:"count_human_dna" doc:"Every DNA classified"`
+	Ratio   float64 `json:"ratio"`
+	Note    string  `json:"note,omitempty"`
+	hidden  string
+}
+
+type attempt struct {
+	ID          int64      `json:"id"`
+	Status      string     `json:"status" enum:"pending,delivered"`
+	StatusCode  *int       `json:"status_code"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at"`
+	Internal    string     `json:"-"`
+}
+
+var operations = []Operation{
+	{
+		Route:    "GET /counts",
+		Summary:  "Count",
+		Security: Optional("counts:read"),
+		Responses: map[int]Response{
+			200: {Description: "The counts", Headers: []Header{CacheControl}, Body: count{}},
+			429: TooMany,
+			500: Error,
+		},
+	},
+	{
+		Route:    "POST /check",
+		Security: Public,
+		Request: &Request{Required: true, Content: map[string]interface{}{
+			"application/json": Component{Name: "Check", Schema: json.RawMessage(`{"$schema": "x", "oneOf": [{"$ref": "#/$defs/v1"}], "$defs": {"v1": {"type": "object"}}}`)},
+			"text/plain":       PlainText,
+		}},
+		Responses: map[int]Response{
+			200: {Description: "Passed"},
+			403: {Description: "Failed", Headers: []Header{{Name: "X-Persisted", Schema: Schema{"type": "string", "enum": []string{"false"}}}}},
+			500: Error,
+		},
+	},
+	{
+		Route:      "GET /attempts/{id}",
+		Security:   Bearer("attempts:read"),
+		Parameters: map[string]interface{}{"id": 0},
+		Responses: map[int]Response{
+			200: {Description: "The attempts", Body: []attempt{}},
+			404: NotFound,
+		},
+	},
+}
+
+func generate(t *testing.T) map[string]interface{} {
+	t.Helper()
+
+	data, err := Fragment(operations)
+	assert.Nil(t, err)
+
+	var fragment map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &fragment))
+
+	return fragment
+}
+
+func TestFragmentDescribesGoTypes(t *testing.T) {
+	// Types outside main are named after their package as well
+	schemas := lookup(generate(t), "components", "schemas").(map[string]interface{})
+
+	var expected map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"type": "object",
+		"properties": {
+			"count_mutant_dna": {"type": "integer"},
+			"count_human_dna": {"type": "integer", "description": "Every DNA classified"},
+			"ratio": {"type": "number"},
+			"note": {"type": "string"}
+		},
+		"required": ["count_mutant_dna", "count_human_dna", "ratio"],
+		"additionalProperties": false
+	}`), &expected)
+	assert.Equal(t, expected, schemas["OpenapiCount"])
+
+	json.Unmarshal([]byte(`{
+		"type": "object",
+		"properties": {
+			"id": {"type": "integer"},
+			"status": {"type": "string", "enum": ["pending", "delivered"]},
+			"status_code": {"type": ["integer", "null"]},
+			"created_at": {"type": "string", "format": "date-time"},
+			"delivered_at": {"type": ["string", "null"], "format": "date-time"}
+		},
+		"required": ["id", "status", "status_code", "created_at", "delivered_at"],
+		"additionalProperties": false
+	}`), &expected)
+	assert.Equal(t, expected, schemas["OpenapiAttempt"])
+}
+
+func TestFragmentDescribesOperations(t *testing.T) {
+	fragment := generate(t)
+
+	counts := lookup(fragment, "paths", "/counts", "get").(map[string]interface{})
+	assert.Equal(t, "Count", counts["summary"])
+	assert.Equal(t, []interface{}{map[string]interface{}{}, map[string]interface{}{"apiKey": []interface{}{}}, map[string]interface{}{"bearer": []interface{}{"counts:read"}}}, counts["security"])
+	assert.Equal(t, "#/components/schemas/OpenapiCount", lookup(counts, "responses", "200", "content", "application/json", "schema", "$ref"))
+	assert.Equal(t, "#/components/headers/Cache-Control", lookup(counts, "responses", "200", "headers", "Cache-Control", "$ref"))
+	assert.Equal(t, "#/components/responses/TooManyRequests", lookup(counts, "responses", "429", "$ref"))
+	assert.Equal(t, "#/components/headers/Retry-After", lookup(fragment, "components", "responses", "TooManyRequests", "headers", "Retry-After", "$ref"))
+
+	check := lookup(fragment, "paths", "/check", "post").(map[string]interface{})
+	assert.Equal(t, []interface{}{}, check["security"])
+	assert.Equal(t, true, lookup(check, "requestBody", "required"))
+	assert.Equal(t, "#/components/schemas/Check", lookup(check, "requestBody", "content", "application/json", "schema", "$ref"))
+	assert.Equal(t, "string", lookup(check, "requestBody", "content", "text/plain", "schema", "type"))
+	assert.Nil(t, lookup(check, "responses", "200", "content"))
+
+	// The $defs of a schema document are shared next to it
+	assert.Equal(t, map[string]interface{}{"oneOf": []interface{}{map[string]interface{}{"$ref": "#/components/schemas/CheckV1"}}}, lookup(fragment, "components", "schemas", "Check"))
+	assert.Equal(t, map[string]interface{}{"type": "object"}, lookup(fragment, "components", "schemas", "CheckV1"))
+
+	attempts := lookup(fragment, "paths", "/attempts/{id}", "get").(map[string]interface{})
+	assert.Equal(t, []interface{}{map[string]interface{}{"bearer": []interface{}{"attempts:read"}}}, attempts["security"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "integer"}}}, attempts["parameters"])
+	assert.Equal(t, "#/components/schemas/OpenapiAttempt", lookup(attempts, "responses", "200", "content", "application/json", "schema", "items", "$ref"))
+}
+
+func TestFragmentRejectsTypesItCannotDescribe(t *testing.T) {
+	_, err := Fragment([]Operation{{Route: "GET /events", Responses: map[int]Response{200: {Description: "Events", Body: make(chan int)}}}})
+	assert.Equal(t, "chan int cannot be described", err.Error())
+
+	_, err = Fragment([]Operation{
+		{Route: "GET /a", Responses: map[int]Response{500: Error}},
+		{Route: "GET /b", Responses: map[int]Response{500: {Name: "Error", Description: "Something else"}}},
+	})
+	assert.Equal(t, "responses Error is described twice, differently", err.Error())
+}
+
+func TestDocumentReferencesResolve(t *testing.T) {
+	var spec map[string]interface{}
+	assert.Nil(t, json.Unmarshal(Document(), &spec))
+
+	var walk func(node interface{}, at string)
+	walk = func(node interface{}, at string) {
+		switch node := node.(type) {
+		case map[string]interface{}:
+			if ref, ok := node["$ref"].(string); ok {
+				assert.True(t, strings.HasPrefix(ref, "#/components/"), at)
+				assert.NotNil(t, lookup(spec, strings.Split(strings.TrimPrefix(ref, "#/"), "/")...), ref)
+			}
+
+			for key, value := range node {
+				walk(value, at+"/"+key)
+			}
+		case []interface{}:
+			for _, value := range node {
+				walk(value, at)
+			}
+		}
+	}
+
+	walk(spec, "#")
+
+	assert.Equal(t, "3.1.0", spec["openapi"])
+	for _, path := range []string{"/mutant", "/stats", "/webhooks"} {
+		assert.NotNil(t, lookup(spec, "paths", path), path)
+	}
+
+	assert.NotNil(t, lookup(spec, "components", "securitySchemes", "bearer"))
+}
+
+func TestServe(t *testing.T) {
+	response := Serve()
+
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Equal(t, string(Document()), response.Body)
+	assert.Equal(t, "application/json", response.Headers["Content-Type"])
+}
+
+func TestRoutes(t *testing.T) {
+	assert.Equal(t, []string{"GET /counts", "POST /check", "GET /attempts/{id}"}, Routes(operations))
+	assert.Equal(t, []string{"/check"}, Resources(operations, Public))
+	assert.Equal(t, map[string]string{"/counts": "counts:read", "/attempts/{id}": "attempts:read"}, Scopes(operations))
+}
+
+// documentOf assembles a document out of the fragment of operations, as the served one is
+func documentOf(t *testing.T) spec {
+	t.Helper()
+
+	merged := map[string]interface{}{}
+	merge(merged, base)
+	merge(merged, generate(t))
+
+	data, err := json.Marshal(merged)
+	assert.Nil(t, err)
+
+	return parse(data)
+}
+
+func jsonResponse(statusCode int, body string) events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{Body: body, StatusCode: statusCode, Headers: map[string]string{"Content-Type": "application/json"}}
+}
+
+func TestCheck(t *testing.T) {
+	valid := []struct {
+		method   string
+		path     string
+		response events.APIGatewayProxyResponse
+	}{
+		{"GET", "/counts", jsonResponse(200, `{"count_mutant_dna": 40, "count_human_dna": 100, "ratio": 0.4}`)},
+		{"GET", "/counts", jsonResponse(200, `{"count_mutant_dna": 0, "count_human_dna": 0, "ratio": 0, "note": "empty"}`)},
+		{"GET", "/counts", events.APIGatewayProxyResponse{Body: "Failed to count", StatusCode: 500}},
+		{"GET", "/counts", events.APIGatewayProxyResponse{Body: "Too many requests", StatusCode: 429, Headers: map[string]string{"retry-after": "30"}}},
+		{"POST", "/check", events.APIGatewayProxyResponse{StatusCode: 200}},
+		{"POST", "/check", events.APIGatewayProxyResponse{StatusCode: 403, Headers: map[string]string{"X-Persisted": "false"}}},
+		{"GET", "/attempts/{id}", jsonResponse(200, `[{"id": 1, "status": "pending", "status_code": null, "created_at": "2026-01-02T03:04:05Z", "delivered_at": null}]`)},
+	}
+
+	document := documentOf(t)
+	for _, test := range valid {
+		assert.Nil(t, document.check(test.method, test.path, test.response), test.response)
+	}
+
+	invalid := []struct {
+		method   string
+		path     string
+		response events.APIGatewayProxyResponse
+		err      string
+	}{
+		{"GET", "/nowhere", jsonResponse(200, `{}`), "GET /nowhere is not documented"},
+		{"DELETE", "/counts", jsonResponse(200, `{}`), "DELETE /counts is not documented"},
+		{"GET", "/counts", jsonResponse(418, `{}`), "GET /counts answered 418, which is not documented"},
+		{"GET", "/counts", jsonResponse(200, `{"count_mutant_dna": 40, "count_human_dna": 100}`), "body.ratio is missing"},
+		{"GET", "/counts", jsonResponse(200, `{"count_mutant_dna": 40, "count_human_dna": 100, "ratio": null}`), "body.ratio: null is not of type number"},
+		{"GET", "/counts", jsonResponse(200, `{"count_mutant_dna": 40, "count_human_dna": 100, "ratio": 0.4, "total": 140}`), `body has unknown field "total"`},
+		{"GET", "/counts", jsonResponse(200, `{"count_mutant_dna": 0.5, "count_human_dna": 100, "ratio": 0.4}`), "body.count_mutant_dna: 0.5 is not of type integer"},
+		{"GET", "/counts", jsonResponse(200, `{"count_mutant_dna"`), "a body that is not JSON"},
+		{"GET", "/counts", events.APIGatewayProxyResponse{Body: `{}`, StatusCode: 200}, "content type text/plain, documented is application/json"},
+		{"GET", "/counts", jsonResponse(500, `{}`), "content type application/json, documented is text/plain"},
+		{"GET", "/counts", events.APIGatewayProxyResponse{StatusCode: 429, Headers: map[string]string{"Retry-After": "soon"}}, `header Retry-After: "soon" is not of type integer`},
+		{"POST", "/check", events.APIGatewayProxyResponse{Body: "Passed", StatusCode: 200}, "a body, none is documented"},
+		{"POST", "/check", events.APIGatewayProxyResponse{StatusCode: 200, Headers: map[string]string{"X-Cache": "hit"}}, "header X-Cache, which is not declared"},
+		{"POST", "/check", events.APIGatewayProxyResponse{StatusCode: 403, Headers: map[string]string{"X-Persisted": "true"}}, `header X-Persisted: "true" is not one of "false"`},
+		{"GET", "/attempts/{id}", jsonResponse(200, `[{"id": 1, "status": "lost", "status_code": null, "created_at": "2026-01-02T03:04:05Z", "delivered_at": null}]`), `body[0].status: "lost" is not one of "pending", "delivered"`},
+		{"GET", "/attempts/{id}", jsonResponse(200, `[{"id": 1, "status": "pending", "status_code": null, "created_at": "yesterday", "delivered_at": null}]`), `body[0].created_at: "yesterday" is not a date-time`},
+		{"GET", "/attempts/{id}", jsonResponse(200, `[{"id": 1, "status": "pending", "created_at": "2026-01-02T03:04:05Z", "delivered_at": null}]`), "body[0].status_code is missing"},
+	}
+
+	for _, test := range invalid {
+		err := document.check(test.method, test.path, test.response)
+		if assert.NotNil(t, err, test.err) {
+			assert.Contains(t, err.Error(), test.err)
+		}
+	}
+}
+
+func TestCheckUsesTheServedDocument(t *testing.T) {
+	assert.Nil(t, Check("GET", "/openapi.json", Serve()))
+
+	err := Check("GET", "/counts", jsonResponse(200, `{}`))
+	assert.EqualError(t, err, "GET /counts is not documented")
+}
@@ -0,0 +1,285 @@
+// Package outbox records domain events in the same transaction as the change that caused them
+// and publishes them later to a sink, so downstream consumers get a feed of changes. Delivery
+// is at least once, consumers should deduplicate using the event ID.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/felipefill/mutants/logging"
+	"github.com/lib/pq"
+)
+
+// DNAClassified is emitted when a new DNA verdict is stored
+const DNAClassified = "dna.classified"
+
+// Event is a published domain event
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Classified is the payload of DNAClassified events
+type Classified struct {
+	Hash          string    `json:"hash"`
+	HashAlgorithm string    `json:"hash_algorithm"`
+	Type          string    `json:"type"`
+	Mutant        bool      `json:"mutant"`
+	Size          int       `json:"size"`
+	ClassifiedAt  time.Time `json:"classified_at"`
+}
+
+// Execer runs statements, both *sql.DB and *sql.Tx are one
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Enqueue records an event, pass the transaction of the change so both are committed together
+func Enqueue(ctx context.Context, execer Execer, eventType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = execer.ExecContext(ctx, "insert into outbox(event_type, payload) values($1, $2)", eventType, data)
+	return err
+}
+
+// Sink is where events are published to
+type Sink interface {
+	Publish(ctx context.Context, events []Event) error
+}
+
+// Statuses of the events in the outbox
+const (
+	StatusPending   = "pending"
+	StatusPublished = "published"
+	StatusFailed    = "failed"
+)
+
+// Events that fail to publish are tried again after retryDelay, doubling with every attempt up to
+// maxRetryDelay
+const (
+	retryDelay    = 5 * time.Second
+	maxRetryDelay = 10 * time.Minute
+)
+
+// pruneInterval spaces out the deletes of published events by each dispatcher
+const pruneInterval = time.Hour
+
+// Dispatcher publishes pending events in batches, in the order they were recorded. An event that
+// fails is tried again later, after the events recorded since, and is marked failed once it runs
+// out of attempts. Published events are deleted once they are older than Retention.
+type Dispatcher struct {
+	DB          *sql.DB
+	Sink        Sink
+	BatchSize   int
+	MaxAttempts int
+	Retention   time.Duration
+	Now         func() time.Time
+
+	mutex  sync.Mutex
+	pruned time.Time
+}
+
+// DispatchOnce publishes a batch of pending events and returns how many were published. Rows are
+// locked while they are published, so several dispatchers can run side by side. When the sink
+// fails a batch its events are published one at a time, so an event the sink refuses does not
+// hold back the others. Those that still fail have their attempts and last error recorded.
+func (dispatcher *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	published, err := dispatcher.dispatch(ctx)
+
+	if dispatcher.pruneDue(dispatcher.now()) {
+		dispatcher.prune(ctx)
+	}
+
+	return published, err
+}
+
+func (dispatcher *Dispatcher) dispatch(ctx context.Context) (int, error) {
+	tx, err := dispatcher.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.New("Failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	events, err := pendingEvents(ctx, tx, dispatcher.batchSize())
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	failures := map[int64]error{}
+	if publishErr := dispatcher.Sink.Publish(ctx, events); publishErr != nil && len(events) == 1 {
+		failures[events[0].ID] = publishErr
+	} else if publishErr != nil {
+		for _, event := range events {
+			if err := dispatcher.Sink.Publish(ctx, []Event{event}); err != nil {
+				failures[event.ID] = err
+			}
+		}
+	}
+
+	ids := []int64{}
+	var publishErr error
+	for _, event := range events {
+		failure, failed := failures[event.ID]
+		if !failed {
+			ids = append(ids, event.ID)
+			continue
+		}
+
+		if publishErr == nil {
+			publishErr = failure
+		}
+
+		_, err := tx.ExecContext(ctx,
+			"update outbox set attempts = attempts + 1, last_error = $1, "+
+				"status = case when attempts + 1 >= $2 then $3 else status end, "+
+				"next_attempt_at = now() + least($4 * power(2, attempts), $5) * interval '1 second' where id = $6",
+			failure.Error(), dispatcher.maxAttempts(), StatusFailed, int(retryDelay.Seconds()), int(maxRetryDelay.Seconds()), event.ID,
+		)
+		if err != nil {
+			return 0, errors.New("Failed to record publishing failure")
+		}
+	}
+
+	if len(ids) > 0 {
+		_, err = tx.ExecContext(ctx,
+			"update outbox set status = $1, published_at = now(), attempts = attempts + 1, last_error = null where id = any($2)",
+			StatusPublished, pq.Array(ids),
+		)
+		if err != nil {
+			return 0, errors.New("Failed to mark events as published")
+		}
+	}
+
+	// The sink already has the published ones, they will be published again
+	if err := tx.Commit(); err != nil {
+		return 0, errors.New("Failed to mark events as published")
+	}
+
+	if publishErr != nil {
+		return len(ids), fmt.Errorf("Failed to publish events: %s", publishErr.Error())
+	}
+
+	return len(ids), nil
+}
+
+func (dispatcher *Dispatcher) pruneDue(now time.Time) bool {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	if now.Sub(dispatcher.pruned) < pruneInterval {
+		return false
+	}
+
+	dispatcher.pruned = now
+	return true
+}
+
+// prune deletes the events published before the retention period. It only costs a delete per
+// pruneInterval, a failure is logged and the events are pruned next time. Failed events are kept
+// for inspection.
+func (dispatcher *Dispatcher) prune(ctx context.Context) {
+	result, err := dispatcher.DB.ExecContext(ctx,
+		"delete from outbox where status = $1 and published_at < now() - $2 * interval '1 second'",
+		StatusPublished, int(dispatcher.retention().Seconds()),
+	)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to prune outbox", "error", err.Error())
+		return
+	}
+
+	pruned, _ := result.RowsAffected()
+	logging.FromContext(ctx).Debug("Pruned outbox", "rows", pruned)
+}
+
+// Run dispatches until the context is done, it drains the outbox and then waits for given interval
+func (dispatcher *Dispatcher) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	for {
+		published, err := dispatcher.DispatchOnce(ctx)
+		if err != nil && onError != nil {
+			onError(err)
+		}
+
+		if err == nil && published == dispatcher.batchSize() {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (dispatcher *Dispatcher) batchSize() int {
+	if dispatcher.BatchSize < 1 {
+		return 100
+	}
+
+	return dispatcher.BatchSize
+}
+
+func (dispatcher *Dispatcher) maxAttempts() int {
+	if dispatcher.MaxAttempts < 1 {
+		return 10
+	}
+
+	return dispatcher.MaxAttempts
+}
+
+func (dispatcher *Dispatcher) retention() time.Duration {
+	if dispatcher.Retention <= 0 {
+		return 7 * 24 * time.Hour
+	}
+
+	return dispatcher.Retention
+}
+
+func (dispatcher *Dispatcher) now() time.Time {
+	if dispatcher.Now == nil {
+		return time.Now()
+	}
+
+	return dispatcher.Now()
+}
+
+func pendingEvents(ctx context.Context, tx *sql.Tx, limit int) ([]Event, error) {
+	rows, err := tx.QueryContext(ctx,
+		"select id, event_type, payload, created_at from outbox where status = $1 and next_attempt_at <= now() order by id limit $2 for update skip locked",
+		StatusPending, limit,
+	)
+	if err != nil {
+		return nil, errors.New("Failed to query outbox")
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		var payload []byte
+
+		if err := rows.Scan(&event.ID, &event.Type, &payload, &event.CreatedAt); err != nil {
+			return nil, errors.New("Failed to retrieve event")
+		}
+
+		event.Payload = payload
+		events = append(events, event)
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.New("Failed to retrieve event")
+	}
+
+	return events, nil
+}
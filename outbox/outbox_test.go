@@ -0,0 +1,313 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var createdAt = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+func testEvents() []Event {
+	return []Event{
+		{ID: 1, Type: DNAClassified, Payload: json.RawMessage(`{"mutant":true}`), CreatedAt: createdAt},
+		{ID: 2, Type: DNAClassified, Payload: json.RawMessage(`{"mutant":false}`), CreatedAt: createdAt},
+	}
+}
+
+// recordingSink keeps what it was given, failing when told to or given the event it refuses
+type recordingSink struct {
+	published []Event
+	err       error
+	refuse    int64
+}
+
+func (sink *recordingSink) Publish(ctx context.Context, events []Event) error {
+	if sink.err != nil {
+		return sink.err
+	}
+
+	for _, event := range events {
+		if event.ID == sink.refuse {
+			return errors.New("Payload rejected")
+		}
+	}
+
+	sink.published = append(sink.published, events...)
+	return nil
+}
+
+func TestEnqueue(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.
+		ExpectExec("insert into outbox\\(event_type, payload\\) values\\(\\$1, \\$2\\)").
+		WithArgs(DNAClassified, []byte(`{"hash":"abc","hash_algorithm":"sha256","type":"mutant","mutant":true,"size":6,"classified_at":"2026-10-18T12:00:00Z"}`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := Enqueue(context.Background(), db, DNAClassified, Classified{
+		Hash: "abc", HashAlgorithm: "sha256", Type: "mutant", Mutant: true, Size: 6, ClassifiedAt: createdAt,
+	})
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func expectPendingEvents(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("select id, event_type, payload, created_at from outbox where status = \\$1 and next_attempt_at <= now\\(\\) order by id limit \\$2 for update skip locked").
+		WithArgs(StatusPending, 10).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "event_type", "payload", "created_at"}).
+				AddRow(1, DNAClassified, []byte(`{"mutant":true}`), createdAt).
+				AddRow(2, DNAClassified, []byte(`{"mutant":false}`), createdAt),
+		)
+}
+
+func expectFailure(mock sqlmock.Sqlmock, id int64, message string) {
+	mock.
+		ExpectExec("update outbox set attempts = attempts \\+ 1, last_error = \\$1, status = case when attempts \\+ 1 >= \\$2 then \\$3 else status end, "+
+			"next_attempt_at = now\\(\\) \\+ least\\(\\$4 \\* power\\(2, attempts\\), \\$5\\) \\* interval '1 second' where id = \\$6").
+		WithArgs(message, 10, StatusFailed, 5, 600, id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func expectPrune(mock sqlmock.Sqlmock) {
+	mock.
+		ExpectExec("delete from outbox where status = \\$1 and published_at < now\\(\\) - \\$2 \\* interval '1 second'").
+		WithArgs(StatusPublished, 7*24*60*60).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+}
+
+func TestDispatchOnce(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	expectPendingEvents(mock)
+	mock.
+		ExpectExec("update outbox set status = \\$1, published_at = now\\(\\)").
+		WithArgs(StatusPublished, pq.Array([]int64{1, 2})).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	expectPrune(mock)
+
+	sink := &recordingSink{}
+	dispatcher := Dispatcher{DB: db, Sink: sink, BatchSize: 10}
+
+	published, err := dispatcher.DispatchOnce(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, published)
+	assert.Equal(t, testEvents(), sink.published)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDispatchOnceRecordsFailures(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	expectPendingEvents(mock)
+	expectFailure(mock, 1, "Queue is down")
+	expectFailure(mock, 2, "Queue is down")
+	mock.ExpectCommit()
+	expectPrune(mock)
+
+	dispatcher := Dispatcher{DB: db, Sink: &recordingSink{err: errors.New("Queue is down")}, BatchSize: 10}
+
+	published, err := dispatcher.DispatchOnce(context.Background())
+
+	assert.Equal(t, 0, published)
+	assert.Equal(t, errors.New("Failed to publish events: Queue is down"), err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDispatchOncePublishesTheEventsAfterOneTheSinkRefuses(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	expectPendingEvents(mock)
+	expectFailure(mock, 1, "Payload rejected")
+	mock.
+		ExpectExec("update outbox set status = \\$1, published_at = now\\(\\)").
+		WithArgs(StatusPublished, pq.Array([]int64{2})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectPrune(mock)
+
+	sink := &recordingSink{refuse: 1}
+	dispatcher := Dispatcher{DB: db, Sink: sink, BatchSize: 10}
+
+	published, err := dispatcher.DispatchOnce(context.Background())
+
+	assert.Equal(t, 1, published)
+	assert.Equal(t, errors.New("Failed to publish events: Payload rejected"), err)
+	assert.Equal(t, testEvents()[1:], sink.published)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDispatchOncePrunesOncePerInterval(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	now := createdAt
+	for i := 0; i < 3; i++ {
+		mock.ExpectBegin()
+		mock.
+			ExpectQuery("select id, event_type, payload, created_at from outbox").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "payload", "created_at"}))
+		mock.ExpectRollback()
+
+		if i != 1 {
+			expectPrune(mock)
+		}
+	}
+
+	dispatcher := Dispatcher{DB: db, Sink: &recordingSink{}, Now: func() time.Time { return now }}
+
+	for _, elapsed := range []time.Duration{0, time.Minute, time.Hour} {
+		now = createdAt.Add(elapsed)
+		dispatcher.DispatchOnce(context.Background())
+	}
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDispatchOnceWithEmptyOutbox(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("select id, event_type, payload, created_at from outbox").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "payload", "created_at"}))
+	mock.ExpectRollback()
+	expectPrune(mock)
+
+	sink := &recordingSink{}
+	dispatcher := Dispatcher{DB: db, Sink: sink}
+
+	published, err := dispatcher.DispatchOnce(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, published)
+	assert.Empty(t, sink.published)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestWriterSink(t *testing.T) {
+	buffer := bytes.Buffer{}
+	sink := WriterSink{Writer: &buffer}
+
+	err := sink.Publish(context.Background(), testEvents())
+
+	assert.Nil(t, err)
+	assert.Equal(t,
+		`{"id":1,"type":"dna.classified","payload":{"mutant":true},"created_at":"2026-10-18T12:00:00Z"}`+"\n"+
+			`{"id":2,"type":"dna.classified","payload":{"mutant":false},"created_at":"2026-10-18T12:00:00Z"}`+"\n",
+		buffer.String(),
+	)
+}
+
+func TestFileSinkAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+
+	for i := 0; i < 2; i++ {
+		sink, err := NewFileSink(path)
+		assert.Nil(t, err)
+
+		assert.Nil(t, sink.Publish(context.Background(), testEvents()[i:i+1]))
+		sink.Close()
+	}
+
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+}
+
+func TestWebhookSink(t *testing.T) {
+	received := []Event{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		json.NewDecoder(r.Body).Decode(&event)
+
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, DNAClassified, r.Header.Get("X-Event-Type"))
+
+		received = append(received, event)
+	}))
+	defer server.Close()
+
+	sink := WebhookSink{URL: server.URL}
+
+	assert.Nil(t, sink.Publish(context.Background(), testEvents()))
+	assert.Equal(t, testEvents(), received)
+}
+
+func TestWebhookSinkFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Try later", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	sink := WebhookSink{URL: server.URL}
+	err := sink.Publish(context.Background(), testEvents())
+
+	assert.Contains(t, err.Error(), "answered 503: Try later")
+}
+
+func TestSQSSink(t *testing.T) {
+	messages := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		assert.Equal(t, "/123456789012/classifications", r.URL.Path)
+		assert.Equal(t, "SendMessage", r.Form.Get("Action"))
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-east-1/sqs/aws4_request")
+		assert.Equal(t, "token", r.Header.Get("X-Amz-Security-Token"))
+
+		messages = append(messages, r.Form.Get("MessageBody"))
+	}))
+	defer server.Close()
+
+	sink := SQSSink{
+		QueueURL:    server.URL + "/123456789012/classifications",
+		Region:      "us-east-1",
+		Credentials: Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"},
+	}
+
+	assert.Nil(t, sink.Publish(context.Background(), testEvents()))
+	assert.Equal(t, 2, len(messages))
+	assert.Contains(t, messages[0], `"id":1`)
+}
+
+// Example from the AWS Signature Version 4 documentation
+func TestSignV4(t *testing.T) {
+	endpoint, _ := url.Parse("https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08")
+	request := &http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	credentials := Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(request, nil, "iam", "us-east-1", credentials, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	assert.Equal(t,
+		"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, "+
+			"SignedHeaders=content-type;host;x-amz-date, "+
+			"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		request.Header.Get("Authorization"),
+	)
+}
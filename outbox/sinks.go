@@ -0,0 +1,270 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriterSink writes events as JSON lines
+type WriterSink struct {
+	Writer io.Writer
+}
+
+// NewStdoutSink writes events to the standard output
+func NewStdoutSink() *WriterSink {
+	return &WriterSink{Writer: os.Stdout}
+}
+
+// Publish writes every event on its own line
+func (sink *WriterSink) Publish(ctx context.Context, events []Event) error {
+	encoder := json.NewEncoder(sink.Writer)
+
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FileSink appends events as JSON lines to a file
+type FileSink struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// NewFileSink opens, or creates, the file at given path for appending
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open events file: %s", err.Error())
+	}
+
+	return &FileSink{file: file}, nil
+}
+
+// Publish appends the events and syncs them to disk
+func (sink *FileSink) Publish(ctx context.Context, events []Event) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	if err := (&WriterSink{Writer: sink.file}).Publish(ctx, events); err != nil {
+		return err
+	}
+
+	return sink.file.Sync()
+}
+
+// Close closes the file
+func (sink *FileSink) Close() error {
+	return sink.file.Close()
+}
+
+// WebhookSink posts every event as JSON to an URL, any status other than 2xx is a failure
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// Publish posts the events one by one, in order
+func (sink *WebhookSink) Publish(ctx context.Context, events []Event) error {
+	for _, event := range events {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("X-Event-ID", strconv.FormatInt(event.ID, 10))
+		request.Header.Set("X-Event-Type", event.Type)
+
+		if err := send(client(sink.Client), request); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Credentials sign requests to AWS compatible services
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// CredentialsFromEnv reads the credentials Lambda and the AWS tooling expose
+func CredentialsFromEnv() Credentials {
+	return Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// SQSSink sends every event as a message to a SQS queue, or to anything speaking its query API
+// such as ElasticMQ or LocalStack. Requests are signed with AWS Signature Version 4.
+type SQSSink struct {
+	QueueURL    string
+	Region      string
+	Credentials Credentials
+	Client      *http.Client
+}
+
+// Publish sends the events one by one, in order
+func (sink *SQSSink) Publish(ctx context.Context, events []Event) error {
+	for _, event := range events {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		form := url.Values{}
+		form.Set("Action", "SendMessage")
+		form.Set("Version", "2012-11-05")
+		form.Set("MessageBody", string(body))
+		payload := []byte(form.Encode())
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.QueueURL, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+		signV4(request, payload, "sqs", sink.Region, sink.Credentials, time.Now())
+
+		if err := send(client(sink.Client), request); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func client(httpClient *http.Client) *http.Client {
+	if httpClient == nil {
+		return &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return httpClient
+}
+
+func send(httpClient *http.Client, request *http.Request) error {
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("%s answered %d: %s", request.URL.Host, response.StatusCode, strings.TrimSpace(string(detail)))
+	}
+
+	return nil
+}
+
+// signV4 adds the headers of AWS Signature Version 4 to a request,
+// see https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+func signV4(request *http.Request, body []byte, service, region string, credentials Credentials, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := request.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		path,
+		canonicalQuery(request.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func canonicalQuery(query url.Values) string {
+	pairs := []string{}
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, awsEscape(name)+"="+awsEscape(value))
+		}
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape encodes everything but unreserved characters, as RFC 3986 says
+func awsEscape(value string) string {
+	return strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
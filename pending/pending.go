@@ -0,0 +1,237 @@
+// Package pending keeps the writes that could not reach the database in a local append-only
+// file, one JSON line per write, so they can be flushed once the database is back. The file is
+// as durable as the disk it is on, on Lambda that is /tmp which is lost when the container is
+// recycled.
+package pending
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Write is a DNA row waiting to be stored
+type Write struct {
+	Hash          string          `json:"hash"`
+	Type          string          `json:"type"`
+	Data          json.RawMessage `json:"data"`
+	Canonical     bool            `json:"canonical"`
+	HashAlgorithm string          `json:"hash_algorithm"`
+	// ClientID is the client that submitted the DNA, zero when none was authenticated
+	ClientID int64 `json:"client_id,omitempty"`
+	// Metadata is what the client sent about the sample, if anything
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	QueuedAt time.Time       `json:"queued_at"`
+	// Error is why a dead letter could not be stored
+	Error string `json:"error,omitempty"`
+}
+
+// permanentError marks a write that will fail however many times it is tried
+type permanentError struct {
+	err error
+}
+
+func (err permanentError) Error() string {
+	return err.err.Error()
+}
+
+func (err permanentError) Unwrap() error {
+	return err.err
+}
+
+// Permanent wraps the error of a write that can never be stored, such as one the database
+// rejects, so Flush moves it to the dead letters instead of retrying it forever
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Queue is a write-ahead file of pending writes, it is safe for concurrent use within a process.
+// Writes that can never be stored are kept apart, in a dead letter file next to it.
+type Queue struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// NewQueue creates a queue backed by the file at given path, the file is created on first append
+func NewQueue(path string) *Queue {
+	return &Queue{path: path}
+}
+
+// Path returns the file backing the queue
+func (queue *Queue) Path() string {
+	return queue.path
+}
+
+// DeadLetterPath returns the file that keeps the writes that could not be stored
+func (queue *Queue) DeadLetterPath() string {
+	return queue.path + ".dead"
+}
+
+// Append adds a write to the end of the queue, it is synced to disk before returning
+func (queue *Queue) Append(write Write) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return appendTo(queue.path, write)
+}
+
+// DeadLetters returns the writes that could not be stored, with the error each one failed with
+func (queue *Queue) DeadLetters() ([]Write, error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return readFrom(queue.DeadLetterPath())
+}
+
+func appendTo(path string, write Write) error {
+	line, err := json.Marshal(write)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Could not open pending writes file: %s", err.Error())
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("Could not queue pending write: %s", err.Error())
+	}
+
+	return file.Sync()
+}
+
+// Len returns the number of pending writes
+func (queue *Queue) Len() (int, error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	writes, err := queue.read()
+	return len(writes), err
+}
+
+// Flush stores pending writes in order, stopping at the first one that fails unless its error
+// is Permanent, such writes are moved to the dead letters and the flush goes on. Stored and dead
+// writes are removed from the queue, their numbers are returned along with the error, if any.
+func (queue *Queue) Flush(store func(Write) error) (stored, dead int, err error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	writes, err := queue.read()
+	if err != nil || len(writes) == 0 {
+		return 0, 0, err
+	}
+
+	done := 0
+	for _, write := range writes {
+		if err = store(write); err != nil {
+			var permanent permanentError
+			if !errors.As(err, &permanent) {
+				break
+			}
+
+			write.Error = err.Error()
+			if err = appendTo(queue.DeadLetterPath(), write); err != nil {
+				break
+			}
+
+			dead++
+		} else {
+			stored++
+		}
+
+		done++
+	}
+
+	if done == 0 {
+		return 0, 0, err
+	}
+
+	if rewriteErr := queue.rewrite(writes[done:]); rewriteErr != nil {
+		return stored, dead, rewriteErr
+	}
+
+	return stored, dead, err
+}
+
+func (queue *Queue) read() ([]Write, error) {
+	return readFrom(queue.path)
+}
+
+func readFrom(path string) ([]Write, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read pending writes file: %s", err.Error())
+	}
+
+	writes := []Write{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var write Write
+
+		// A line cut short by a crash while appending is skipped
+		if err := json.Unmarshal(scanner.Bytes(), &write); err != nil {
+			continue
+		}
+
+		writes = append(writes, write)
+	}
+
+	return writes, scanner.Err()
+}
+
+// rewrite replaces the file atomically so a crash never loses the writes left
+func (queue *Queue) rewrite(writes []Write) error {
+	if len(writes) == 0 {
+		if err := os.Remove(queue.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	buffer := bytes.Buffer{}
+	for _, write := range writes {
+		line, err := json.Marshal(write)
+		if err != nil {
+			return err
+		}
+
+		buffer.Write(append(line, '\n'))
+	}
+
+	temporary, err := os.CreateTemp(filepath.Dir(queue.path), filepath.Base(queue.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+
+	if _, err := temporary.Write(buffer.Bytes()); err != nil {
+		temporary.Close()
+		return err
+	}
+
+	if err := temporary.Sync(); err != nil {
+		temporary.Close()
+		return err
+	}
+
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temporary.Name(), queue.path)
+}
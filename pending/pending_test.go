@@ -0,0 +1,149 @@
+package pending
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestQueue(t *testing.T) *Queue {
+	return NewQueue(filepath.Join(t.TempDir(), "pending.jsonl"))
+}
+
+func testWrite(hash string) Write {
+	return Write{Hash: hash, Type: "mutant", Data: json.RawMessage(`["ATGC"]`), HashAlgorithm: "sha256"}
+}
+
+func TestAppendAndFlush(t *testing.T) {
+	queue := newTestQueue(t)
+
+	assert.Nil(t, queue.Append(testWrite("a")))
+	assert.Nil(t, queue.Append(testWrite("b")))
+
+	pending, err := queue.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, pending)
+
+	flushed := []string{}
+	stored, dead, err := queue.Flush(func(write Write) error {
+		flushed = append(flushed, write.Hash)
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, stored)
+	assert.Equal(t, 0, dead)
+	assert.Equal(t, []string{"a", "b"}, flushed)
+
+	_, err = os.Stat(queue.Path())
+	assert.True(t, os.IsNotExist(err), "The file should be removed once empty")
+}
+
+func TestFlushKeepsWritesAfterFailure(t *testing.T) {
+	queue := newTestQueue(t)
+
+	queue.Append(testWrite("a"))
+	queue.Append(testWrite("b"))
+	queue.Append(testWrite("c"))
+
+	failure := errors.New("Database is still down")
+	stored, _, err := queue.Flush(func(write Write) error {
+		if write.Hash == "b" {
+			return failure
+		}
+
+		return nil
+	})
+
+	assert.Equal(t, failure, err)
+	assert.Equal(t, 1, stored)
+
+	remaining := []string{}
+	queue.Flush(func(write Write) error {
+		remaining = append(remaining, write.Hash)
+		return nil
+	})
+
+	assert.Equal(t, []string{"b", "c"}, remaining)
+}
+
+func TestFlushEmptyQueue(t *testing.T) {
+	queue := newTestQueue(t)
+
+	stored, dead, err := queue.Flush(func(Write) error {
+		t.Fatal("Nothing should be stored")
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, stored)
+	assert.Equal(t, 0, dead)
+}
+
+func TestFlushMovesPermanentFailuresToDeadLetters(t *testing.T) {
+	queue := newTestQueue(t)
+
+	queue.Append(testWrite("a"))
+	queue.Append(testWrite("b"))
+	queue.Append(testWrite("c"))
+
+	stored, dead, err := queue.Flush(func(write Write) error {
+		if write.Hash == "b" {
+			return Permanent(errors.New("Malformed DNA"))
+		}
+
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, stored)
+	assert.Equal(t, 1, dead)
+
+	pending, _ := queue.Len()
+	assert.Equal(t, 0, pending)
+
+	letters, err := queue.DeadLetters()
+	assert.Nil(t, err)
+	if assert.Len(t, letters, 1) {
+		assert.Equal(t, "b", letters[0].Hash)
+		assert.Equal(t, "Malformed DNA", letters[0].Error)
+	}
+}
+
+func TestReadSkipsTruncatedLines(t *testing.T) {
+	queue := newTestQueue(t)
+
+	queue.Append(testWrite("a"))
+
+	file, _ := os.OpenFile(queue.Path(), os.O_APPEND|os.O_WRONLY, 0600)
+	file.WriteString(`{"hash":"b","ty`)
+	file.Close()
+
+	pending, err := queue.Len()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, pending)
+}
+
+func TestAppendFailsWithUnwritablePath(t *testing.T) {
+	queue := NewQueue(filepath.Join(t.TempDir(), "missing", "pending.jsonl"))
+
+	err := queue.Append(testWrite("a"))
+
+	assert.Contains(t, err.Error(), "Could not open pending writes file")
+}
+
+func TestAppendedLinesAreJSON(t *testing.T) {
+	queue := newTestQueue(t)
+	queue.Append(testWrite("a"))
+
+	data, _ := os.ReadFile(queue.Path())
+
+	var write Write
+	assert.Nil(t, json.Unmarshal(data[:len(data)-1], &write))
+	assert.Equal(t, "a", write.Hash)
+}
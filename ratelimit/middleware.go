@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/apikey"
+	"github.com/felipefill/mutants/bearer"
+	"github.com/felipefill/mutants/logging"
+	"github.com/felipefill/mutants/metrics"
+	"github.com/felipefill/mutants/server"
+)
+
+var rejected = metrics.NewCounter("mutants_rate_limited_total", "Requests rejected by rate limiting", "reason")
+
+// Limiter holds every key to the same limits
+type Limiter struct {
+	Store  Store
+	Limits Limits
+	Now    func() time.Time
+}
+
+// Limit wraps a handler so requests to given resources are rejected with a 429 once their key runs
+// out of tokens or quota. It must run after authentication, requests are keyed by their client or
+// token subject and only anonymous ones by source IP. When the store fails requests are let
+// through, limiting is not worth an outage.
+func Limit(limiter *Limiter, handler server.Handler, resources ...string) server.Handler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		if !limited(request.Resource, resources) {
+			return handler(ctx, request)
+		}
+
+		now := time.Now()
+		if limiter.Now != nil {
+			now = limiter.Now()
+		}
+
+		key := Key(ctx, request)
+		decision, err := limiter.Store.Take(ctx, key, limiter.Limits, now)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Could not check rate limit", "key", key, "error", err.Error())
+			return handler(ctx, request)
+		}
+
+		if !decision.Allowed {
+			return reject(ctx, request, key, decision), nil
+		}
+
+		return handler(ctx, request)
+	}
+}
+
+// Key identifies who a request counts against
+func Key(ctx context.Context, request events.APIGatewayProxyRequest) string {
+	if client, ok := apikey.ClientFromContext(ctx); ok {
+		return "client:" + strconv.FormatInt(client.ID, 10)
+	}
+
+	if claims, ok := bearer.ClaimsFromContext(ctx); ok && claims.Subject != "" {
+		return "subject:" + claims.Subject
+	}
+
+	return "ip:" + request.RequestContext.Identity.SourceIP
+}
+
+func limited(resource string, resources []string) bool {
+	for _, candidate := range resources {
+		if resource == candidate {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reject answers a 429, Retry-After is rounded up to whole seconds as the header requires
+func reject(ctx context.Context, request events.APIGatewayProxyRequest, key string, decision Decision) events.APIGatewayProxyResponse {
+	rejected.Inc(decision.Reason)
+
+	body := "Rate limit exceeded"
+	if decision.Reason == ReasonQuota {
+		body = "Daily quota exceeded"
+	}
+
+	retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	return server.Reject(ctx, request, events.APIGatewayProxyResponse{
+		Body:       body,
+		StatusCode: 429,
+		Headers:    map[string]string{"Retry-After": strconv.Itoa(retryAfter)},
+	}, nil, "rate_limit_key", key)
+}
@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/apikey"
+	"github.com/felipefill/mutants/bearer"
+	"github.com/stretchr/testify/assert"
+)
+
+func ok(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limits Limits, now time.Time) (Decision, error) {
+	return Decision{}, errors.New("connection refused")
+}
+
+func fromIP(ip string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{
+		Resource:       "/mutant",
+		RequestContext: events.APIGatewayProxyRequestContext{Identity: events.APIGatewayRequestIdentity{SourceIP: ip}},
+	}
+}
+
+func TestLimitAnswers429WithRetryAfter(t *testing.T) {
+	limiter := &Limiter{Store: NewMemoryStore(), Limits: Limits{PerMinute: 6, Burst: 1}, Now: func() time.Time { return start }}
+	handler := Limit(limiter, ok, "/mutant")
+
+	response, _ := handler(context.Background(), fromIP("203.0.113.7"))
+	assert.Equal(t, 200, response.StatusCode)
+
+	before := rejected.Value(ReasonRate)
+	response, err := handler(context.Background(), fromIP("203.0.113.7"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, events.APIGatewayProxyResponse{
+		Body:       "Rate limit exceeded",
+		StatusCode: 429,
+		Headers:    map[string]string{"Retry-After": "10"},
+	}, response)
+	assert.Equal(t, before+1, rejected.Value(ReasonRate))
+}
+
+func TestLimitAnswersQuotaExceeded(t *testing.T) {
+	limiter := &Limiter{Store: NewMemoryStore(), Limits: Limits{PerMinute: 60, Burst: 5, DailyQuota: 1}, Now: func() time.Time { return start }}
+	handler := Limit(limiter, ok, "/mutant")
+
+	handler(context.Background(), fromIP("203.0.113.7"))
+	response, _ := handler(context.Background(), fromIP("203.0.113.7"))
+
+	assert.Equal(t, "Daily quota exceeded", response.Body)
+	assert.Equal(t, "60", response.Headers["Retry-After"])
+}
+
+func TestLimitOnlyAppliesToGivenResources(t *testing.T) {
+	limiter := &Limiter{Store: NewMemoryStore(), Limits: Limits{PerMinute: 1, Burst: 1}}
+	handler := Limit(limiter, ok, "/mutant")
+
+	for i := 0; i < 3; i++ {
+		response, _ := handler(context.Background(), events.APIGatewayProxyRequest{Resource: "/healthz"})
+		assert.Equal(t, 200, response.StatusCode)
+	}
+}
+
+func TestLimitLetsRequestsThroughWhenTheStoreFails(t *testing.T) {
+	handler := Limit(&Limiter{Store: failingStore{}, Limits: Limits{PerMinute: 1, Burst: 1}}, ok, "/mutant")
+
+	response, err := handler(context.Background(), fromIP("203.0.113.7"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+}
+
+func TestKey(t *testing.T) {
+	request := fromIP("203.0.113.7")
+
+	assert.Equal(t, "ip:203.0.113.7", Key(context.Background(), request))
+	assert.Equal(t, "client:4", Key(apikey.WithClient(context.Background(), apikey.Client{ID: 4}), request))
+	assert.Equal(t, "subject:partner-7", Key(bearer.WithClaims(context.Background(), bearer.Claims{Subject: "partner-7"}), request))
+}
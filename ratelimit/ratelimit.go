@@ -0,0 +1,87 @@
+// Package ratelimit throttles clients with a token bucket and caps their requests per day. Buckets
+// live in memory, which only limits each instance on its own, or in the database, which limits
+// clients across every instance at the cost of a few queries per request.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limits are the rate, burst and daily quota every key is held to
+type Limits struct {
+	// PerMinute is the rate tokens are added to a bucket at
+	PerMinute int
+	// Burst is the size of a bucket, the requests a key can make at once after being idle
+	Burst int
+	// DailyQuota caps the requests of a key per UTC day, 0 means no quota
+	DailyQuota int
+}
+
+// Reasons a request is rejected for
+const (
+	ReasonRate  = "rate"
+	ReasonQuota = "quota"
+)
+
+// Decision tells whether a request may go on, when it may not RetryAfter tells when it will
+type Decision struct {
+	Allowed    bool
+	Reason     string
+	RetryAfter time.Duration
+}
+
+// Store keeps the bucket of each key
+type Store interface {
+	Take(ctx context.Context, key string, limits Limits, now time.Time) (Decision, error)
+}
+
+// bucket is the state of a key, both stores keep it and share the same arithmetic
+type bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+	QuotaDay  time.Time
+	QuotaUsed int
+}
+
+// newBucket is the state of a key never seen before, its bucket is full
+func newBucket(limits Limits, now time.Time) bucket {
+	return bucket{Tokens: float64(limits.Burst), UpdatedAt: now, QuotaDay: day(now)}
+}
+
+// take refills the bucket for the time elapsed and takes a token from it. Rejected requests do not
+// count against the quota, so a client that backs off is not punished for its retries.
+func (state *bucket) take(limits Limits, now time.Time) Decision {
+	perSecond := float64(limits.PerMinute) / 60
+
+	if elapsed := now.Sub(state.UpdatedAt).Seconds(); elapsed > 0 {
+		state.Tokens = math.Min(float64(limits.Burst), state.Tokens+elapsed*perSecond)
+		state.UpdatedAt = now
+	}
+
+	if today := day(now); !state.QuotaDay.Equal(today) {
+		state.QuotaDay = today
+		state.QuotaUsed = 0
+	}
+
+	if limits.DailyQuota > 0 && state.QuotaUsed >= limits.DailyQuota {
+		return Decision{Reason: ReasonQuota, RetryAfter: state.QuotaDay.AddDate(0, 0, 1).Sub(now)}
+	}
+
+	if state.Tokens < 1 {
+		wait := (1 - state.Tokens) / perSecond
+		return Decision{Reason: ReasonRate, RetryAfter: time.Duration(wait * float64(time.Second))}
+	}
+
+	state.Tokens--
+	state.QuotaUsed++
+
+	return Decision{Allowed: true}
+}
+
+func day(now time.Time) time.Time {
+	year, month, date := now.UTC().Date()
+
+	return time.Date(year, month, date, 0, 0, 0, 0, time.UTC)
+}
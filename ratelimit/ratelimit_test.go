@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/felipefill/mutants/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	start  = time.Date(2026, 10, 19, 23, 59, 0, 0, time.UTC)
+	limits = Limits{PerMinute: 60, Burst: 2, DailyQuota: 3}
+)
+
+func TestBucketRefillsOverTime(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	unlimited := Limits{PerMinute: 60, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		decision, _ := store.Take(ctx, "client:4", unlimited, start)
+		assert.True(t, decision.Allowed)
+	}
+
+	decision, _ := store.Take(ctx, "client:4", unlimited, start)
+	assert.Equal(t, Decision{Reason: ReasonRate, RetryAfter: time.Second}, decision)
+
+	// Other keys have buckets of their own
+	decision, _ = store.Take(ctx, "client:5", unlimited, start)
+	assert.True(t, decision.Allowed)
+
+	decision, _ = store.Take(ctx, "client:4", unlimited, start.Add(500*time.Millisecond))
+	assert.Equal(t, Decision{Reason: ReasonRate, RetryAfter: 500 * time.Millisecond}, decision)
+
+	decision, _ = store.Take(ctx, "client:4", unlimited, start.Add(time.Second))
+	assert.True(t, decision.Allowed)
+}
+
+func TestDailyQuotaResetsAtMidnight(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		decision, _ := store.Take(ctx, "client:4", limits, start.Add(time.Duration(i)*time.Second))
+		assert.True(t, decision.Allowed)
+	}
+
+	decision, _ := store.Take(ctx, "client:4", limits, start.Add(20*time.Second))
+	assert.Equal(t, Decision{Reason: ReasonQuota, RetryAfter: 40 * time.Second}, decision)
+
+	decision, _ = store.Take(ctx, "client:4", limits, start.Add(time.Minute))
+	assert.True(t, decision.Allowed)
+}
+
+func TestRejectedRequestsDoNotUseQuota(t *testing.T) {
+	state := newBucket(limits, start)
+
+	state.take(limits, start)
+	state.take(limits, start)
+	decision := state.take(limits, start)
+
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, 2, state.QuotaUsed)
+}
+
+func TestMemoryStoreStaysBounded(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	for i := 0; i < maxBuckets; i++ {
+		store.Take(ctx, "ip:"+strconv.Itoa(i), Limits{PerMinute: 60, Burst: 1}, start)
+	}
+
+	// Every bucket refilled a second later and no quota applies, they are all forgotten
+	store.Take(ctx, "ip:new", Limits{PerMinute: 60, Burst: 1}, start.Add(time.Second))
+
+	assert.Len(t, store.buckets, 1)
+}
+
+func TestDatabaseStore(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("insert into rate_limits\\(key, tokens, updated_at, quota_day, quota_used\\) values\\(\\$1, \\$2, \\$3, \\$4, 0\\) on conflict \\(key\\) do nothing").
+		WithArgs("client:4", float64(2), start, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectQuery("select tokens, updated_at, quota_day, quota_used from rate_limits where key = \\$1 for update").
+		WithArgs("client:4").
+		WillReturnRows(sqlmock.NewRows([]string{"tokens", "updated_at", "quota_day", "quota_used"}).
+			AddRow(0.5, start.Add(-time.Second), time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), 1))
+	mock.
+		ExpectExec("update rate_limits set tokens = \\$2, updated_at = \\$3, quota_day = \\$4, quota_used = \\$5 where key = \\$1").
+		WithArgs("client:4", 0.5, start, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.
+		ExpectExec("delete from rate_limits where updated_at < \\$1 and \\(quota_day < \\$2 or quota_used = 0\\)").
+		WithArgs(start.Add(-2*time.Second), time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+
+	store := &DatabaseStore{Connect: utils.ConnectDB}
+	decision, err := store.Take(context.Background(), "client:4", limits, start)
+
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseStorePrunesOncePerInterval(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	expectTake := func() {
+		mock.ExpectBegin()
+		mock.ExpectExec("insert into rate_limits").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.
+			ExpectQuery("select tokens, updated_at, quota_day, quota_used from rate_limits").
+			WillReturnRows(sqlmock.NewRows([]string{"tokens", "updated_at", "quota_day", "quota_used"}).
+				AddRow(2.0, start, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), 0))
+		mock.ExpectExec("update rate_limits").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	store := &DatabaseStore{Connect: utils.ConnectDB}
+
+	// A failed prune does not fail the request
+	expectTake()
+	mock.ExpectExec("delete from rate_limits").WillReturnError(errors.New("connection reset"))
+	_, err := store.Take(context.Background(), "client:4", limits, start)
+	assert.Nil(t, err)
+
+	expectTake()
+	_, err = store.Take(context.Background(), "client:4", limits, start.Add(59*time.Minute))
+	assert.Nil(t, err)
+
+	expectTake()
+	mock.ExpectExec("delete from rate_limits").WillReturnResult(sqlmock.NewResult(0, 0))
+	_, err = store.Take(context.Background(), "client:4", limits, start.Add(time.Hour))
+	assert.Nil(t, err)
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestNewStore(t *testing.T) {
+	assert.IsType(t, &MemoryStore{}, NewStore("memory", utils.ConnectDB))
+	assert.IsType(t, &DatabaseStore{}, NewStore("database", utils.ConnectDB))
+	assert.Nil(t, NewStore("none", utils.ConnectDB))
+}
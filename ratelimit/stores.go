@@ -0,0 +1,188 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/felipefill/mutants/logging"
+	"github.com/felipefill/mutants/utils"
+)
+
+// maxBuckets bounds the keys a memory store remembers, idle buckets are dropped first when full
+const maxBuckets = 10000
+
+// MemoryStore keeps buckets in this instance only, each instance enforces the limits on its own
+type MemoryStore struct {
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewMemoryStore creates an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+// Take takes a token from the bucket of key
+func (store *MemoryStore) Take(ctx context.Context, key string, limits Limits, now time.Time) (Decision, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	state, ok := store.buckets[key]
+	if !ok {
+		if len(store.buckets) >= maxBuckets {
+			store.prune(limits, now)
+		}
+
+		fresh := newBucket(limits, now)
+		state = &fresh
+		store.buckets[key] = state
+	}
+
+	return state.take(limits, now), nil
+}
+
+// prune drops buckets that refilled and hold no quota usage, forgetting them changes nothing. When
+// none can be dropped every bucket is, rather than growing without limit.
+func (store *MemoryStore) prune(limits Limits, now time.Time) {
+	for key, state := range store.buckets {
+		refilled := state.Tokens+now.Sub(state.UpdatedAt).Minutes()*float64(limits.PerMinute) >= float64(limits.Burst)
+
+		if refilled && (limits.DailyQuota == 0 || state.QuotaUsed == 0 || !state.QuotaDay.Equal(day(now))) {
+			delete(store.buckets, key)
+		}
+	}
+
+	if len(store.buckets) >= maxBuckets {
+		store.buckets = map[string]*bucket{}
+	}
+}
+
+// pruneInterval spaces out the deletes of idle rate_limits rows by each instance
+const pruneInterval = time.Hour
+
+// DatabaseStore keeps buckets in the rate_limits table so every instance enforces the same limits.
+// Each request costs a transaction of three statements, during which the row of its key is
+// locked, so concurrent requests of the same client wait for one another. Rows that would be
+// created afresh anyway are deleted once per pruneInterval by each instance.
+type DatabaseStore struct {
+	Connect func() (*sql.DB, error)
+
+	mutex  sync.Mutex
+	pruned time.Time
+}
+
+// Take takes a token from the bucket of key
+func (store *DatabaseStore) Take(ctx context.Context, key string, limits Limits, now time.Time) (Decision, error) {
+	db, err := store.Connect()
+	if err != nil {
+		return Decision{}, err
+	}
+
+	now = now.UTC()
+
+	var decision Decision
+	err = utils.WithRetry(ctx, func(ctx context.Context) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		fresh := newBucket(limits, now)
+		_, err = tx.ExecContext(ctx,
+			"insert into rate_limits(key, tokens, updated_at, quota_day, quota_used) values($1, $2, $3, $4, 0) on conflict (key) do nothing",
+			key, fresh.Tokens, fresh.UpdatedAt, fresh.QuotaDay,
+		)
+		if err != nil {
+			return err
+		}
+
+		var state bucket
+		err = tx.QueryRowContext(ctx,
+			"select tokens, updated_at, quota_day, quota_used from rate_limits where key = $1 for update", key,
+		).Scan(&state.Tokens, &state.UpdatedAt, &state.QuotaDay, &state.QuotaUsed)
+		if err != nil {
+			return err
+		}
+
+		// Timestamps come back without a zone, they were stored in UTC
+		state.UpdatedAt = asUTC(state.UpdatedAt)
+		state.QuotaDay = asUTC(state.QuotaDay)
+
+		decision = state.take(limits, now)
+
+		_, err = tx.ExecContext(ctx,
+			"update rate_limits set tokens = $2, updated_at = $3, quota_day = $4, quota_used = $5 where key = $1",
+			key, state.Tokens, state.UpdatedAt, state.QuotaDay, state.QuotaUsed,
+		)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		return decision, err
+	}
+
+	if store.pruneDue(now) {
+		store.prune(ctx, db, limits, now)
+	}
+
+	return decision, nil
+}
+
+func (store *DatabaseStore) pruneDue(now time.Time) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if now.Sub(store.pruned) < pruneInterval {
+		return false
+	}
+
+	store.pruned = now
+	return true
+}
+
+// prune deletes the rows of keys that were idle long enough to refill and whose quota day is over
+// or unused, like the memory store forgets them. It only costs a delete per pruneInterval, a
+// failure is logged and the rows are pruned next time.
+func (store *DatabaseStore) prune(ctx context.Context, db *sql.DB, limits Limits, now time.Time) {
+	idleSince := now
+	if limits.PerMinute > 0 {
+		idleSince = now.Add(-time.Duration(float64(limits.Burst) / float64(limits.PerMinute) * float64(time.Minute)))
+	}
+
+	ctx, cancel := utils.WithQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx,
+		"delete from rate_limits where updated_at < $1 and (quota_day < $2 or quota_used = 0)",
+		idleSince, day(now),
+	)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to prune rate limits", "error", err.Error())
+		return
+	}
+
+	pruned, _ := result.RowsAffected()
+	logging.FromContext(ctx).Debug("Pruned rate limits", "rows", pruned)
+}
+
+func asUTC(value time.Time) time.Time {
+	return time.Date(value.Year(), value.Month(), value.Day(), value.Hour(), value.Minute(), value.Second(), value.Nanosecond(), time.UTC)
+}
+
+// NewStore returns the store with given name: memory, database, or nil for none
+func NewStore(name string, connect func() (*sql.DB, error)) Store {
+	switch name {
+	case "memory":
+		return NewMemoryStore()
+	case "database":
+		return &DatabaseStore{Connect: connect}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,126 @@
+// Package retry runs operations again when they fail for transient reasons, waiting
+// an exponentially growing and randomized delay between attempts
+package retry
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Policy tells how many times and how often an operation is tried
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// Postgres error codes worth trying again, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+var retriableCodes = map[pq.ErrorCode]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+}
+
+// Postgres error codes of a server that cannot take connections, they are retriable as well
+var connectionCodes = map[pq.ErrorCode]bool{
+	"53300": true, // too_many_connections
+	"57P01": true, // admin_shutdown, e.g. during a failover
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// Do runs the operation until it succeeds, fails with an error that is not retriable,
+// runs out of attempts or the context is done. The last error is returned.
+func (policy Policy) Do(ctx context.Context, operation func() error) error {
+	var err error
+
+	for attempt := 0; attempt < policy.attempts(); attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(policy.delay(attempt))
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+
+		err = operation()
+		if err == nil || !Retriable(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (policy Policy) attempts() int {
+	if policy.MaxAttempts < 1 {
+		return 1
+	}
+
+	return policy.MaxAttempts
+}
+
+// delay picks a random wait up to BaseDelay * 2^(attempt-1), capped by MaxDelay ("full jitter")
+func (policy Policy) delay(attempt int) time.Duration {
+	ceiling := policy.MaxDelay
+
+	// Large shifts would overflow, the cap applies to them anyway
+	if shift := attempt - 1; shift < 32 {
+		exponential := policy.BaseDelay << uint(shift)
+		if exponential > 0 && (ceiling <= 0 || exponential < ceiling) {
+			ceiling = exponential
+		}
+	}
+
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Retriable tells whether an error is transient: connection failures, serialization
+// failures, deadlocks and server shutdowns. Context errors are never retriable.
+func Retriable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && retriableCodes[pqErr.Code] {
+		return true
+	}
+
+	return Connection(err)
+}
+
+// Connection tells whether an error means the database could not be reached or dropped the
+// connection, as opposed to an operation it refused. Context errors never do.
+func Connection(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08 holds every connection exception
+		return connectionCodes[pqErr.Code] || pqErr.Code.Class() == "08"
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
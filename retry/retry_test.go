@@ -0,0 +1,172 @@
+package retry
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var fastPolicy = Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestDoSucceedsFirstTime(t *testing.T) {
+	calls := 0
+
+	err := fastPolicy.Do(context.Background(), func() error {
+		calls++
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestDoRetriesTransientErrors(t *testing.T) {
+	calls := 0
+
+	err := fastPolicy.Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return driver.ErrBadConn
+		}
+
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestDoGivesUpAfterMaxAttempts(t *testing.T) {
+	calls := 0
+	failover := &pq.Error{Code: "57P01"}
+
+	err := fastPolicy.Do(context.Background(), func() error {
+		calls++
+		return failover
+	})
+
+	assert.Equal(t, failover, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestDoDoesNotRetryPermanentErrors(t *testing.T) {
+	calls := 0
+
+	err := fastPolicy.Do(context.Background(), func() error {
+		calls++
+		return sql.ErrNoRows
+	})
+
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestDoStopsWhenContextIsDone(t *testing.T) {
+	calls := 0
+	slowPolicy := Policy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := slowPolicy.Do(ctx, func() error {
+		calls++
+		return io.EOF
+	})
+
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestDoRunsOnceWithoutAttempts(t *testing.T) {
+	calls := 0
+
+	Policy{}.Do(context.Background(), func() error {
+		calls++
+		return io.EOF
+	})
+
+	assert.Equal(t, 1, calls)
+}
+
+func TestDelayIsCapped(t *testing.T) {
+	policy := Policy{MaxAttempts: 10, BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+	for attempt := 1; attempt < 40; attempt++ {
+		delay := policy.delay(attempt)
+
+		assert.True(t, delay >= 0)
+		assert.True(t, delay <= 50*time.Millisecond, "Delay %s is above the cap", delay)
+	}
+
+	assert.True(t, policy.delay(1) <= 10*time.Millisecond)
+}
+
+func TestRetriable(t *testing.T) {
+	retriable := []error{
+		&pq.Error{Code: "40001"},
+		&pq.Error{Code: "40P01"},
+		&pq.Error{Code: "08006"},
+		&pq.Error{Code: "57P01"},
+		driver.ErrBadConn,
+		io.EOF,
+		io.ErrUnexpectedEOF,
+		syscall.ECONNRESET,
+		fmt.Errorf("read: %w", syscall.ECONNRESET),
+		&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED},
+	}
+
+	permanent := []error{
+		nil,
+		sql.ErrNoRows,
+		&pq.Error{Code: "23505"},
+		&pq.Error{Code: "42P01"},
+		context.Canceled,
+		context.DeadlineExceeded,
+		errors.New("Something else"),
+	}
+
+	for _, err := range retriable {
+		assert.True(t, Retriable(err), "%v should be retriable", err)
+	}
+
+	for _, err := range permanent {
+		assert.False(t, Retriable(err), "%v should not be retriable", err)
+	}
+}
+
+func TestConnection(t *testing.T) {
+	connection := []error{
+		&pq.Error{Code: "08006"},
+		&pq.Error{Code: "57P03"},
+		driver.ErrBadConn,
+		fmt.Errorf("read: %w", syscall.ECONNRESET),
+		&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED},
+	}
+
+	other := []error{
+		nil,
+		sql.ErrNoRows,
+		&pq.Error{Code: "40001"},
+		&pq.Error{Code: "23505"},
+		context.DeadlineExceeded,
+		errors.New("Something else"),
+	}
+
+	for _, err := range connection {
+		assert.True(t, Connection(err), "%v should be a connection failure", err)
+	}
+
+	for _, err := range other {
+		assert.False(t, Connection(err), "%v should not be a connection failure", err)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/logging"
+)
+
+// HeaderValue looks a header up ignoring case, API Gateway passes them as clients sent them
+func HeaderValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// Reject logs a request turned away by middleware like handlers log theirs, since it never reaches
+// them, and returns response. The reason is logged along with fields, given as key value pairs,
+// but is not sent back. The body is logged as the reason when there is none.
+func Reject(ctx context.Context, request events.APIGatewayProxyRequest, response events.APIGatewayProxyResponse, reason error, fields ...any) events.APIGatewayProxyResponse {
+	if reason == nil {
+		reason = errors.New(response.Body)
+	}
+
+	_, logger := logging.ForRequest(ctx, request)
+	outcome := logging.Start(logger)
+
+	for index := 0; index+1 < len(fields); index += 2 {
+		key, _ := fields[index].(string)
+		outcome.Set(key, fields[index+1])
+	}
+
+	outcome.Fail(reason)
+	outcome.Done(response.StatusCode)
+
+	return response
+}
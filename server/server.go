@@ -0,0 +1,191 @@
+// Package server runs the API Gateway handlers either on Lambda or, when an address is configured,
+// as a plain HTTP server, which is handy locally and in containers
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/felipefill/mutants/logging"
+	"github.com/felipefill/mutants/metrics"
+	"github.com/felipefill/mutants/tracing"
+)
+
+// Handler is the signature of the API Gateway proxy handlers
+type Handler func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// RequestIDHeader carries the request id in the HTTP server mode, it is taken from the request when
+// present and always sent back
+const RequestIDHeader = "X-Request-Id"
+
+// MaxBodyBytes caps request bodies in the HTTP server mode, larger ones are answered with a 413
+// without reaching the handler. API Gateway caps them on Lambda. 0 means no cap.
+var MaxBodyBytes int64
+
+// Run serves handler on given routes over HTTP when addr is set, otherwise it starts the Lambda
+// runtime and logs metrics in the embedded metric format after each invocation. Spans are
+// exported after each request either way. Routes are a method and a path, as in "POST /mutant".
+func Run(addr string, handler Handler, routes ...string) {
+	if addr == "" {
+		lambda.Start(metrics.WithEMF(tracing.Flushing(handler)))
+		return
+	}
+
+	logging.Default().Info("Listening", "addr", addr)
+
+	handlers := map[string]Handler{}
+	for _, route := range routes {
+		handlers[route] = handler
+	}
+
+	if err := http.ListenAndServe(addr, NewMux(handlers)); err != nil {
+		logging.Default().Error("Server stopped", "error", err.Error())
+	}
+}
+
+// NewMux routes each route, a method and a path such as "POST /mutant", to its handler and serves
+// the metrics on /metrics. A path requested with a method it has no route for is answered with a
+// 405 that lists the allowed ones.
+func NewMux(routes map[string]Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+
+	byPath := map[string]map[string]http.Handler{}
+	for route, handler := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		if byPath[path] == nil {
+			byPath[path] = map[string]http.Handler{}
+		}
+
+		byPath[path][method] = Adapt(tracing.Flushing(handler))
+	}
+
+	for path, methods := range byPath {
+		mux.Handle(path, methodHandler(methods))
+	}
+
+	return mux
+}
+
+// methodHandler picks the handler of the request method
+func methodHandler(methods map[string]http.Handler) http.Handler {
+	allowed := make([]string, 0, len(methods))
+	for method := range methods {
+		allowed = append(allowed, method)
+	}
+
+	sort.Strings(allowed)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := methods[r.Method]
+		if !ok {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Adapt turns an HTTP request into the API Gateway proxy request the handler expects
+func Adapt(handler Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if MaxBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, MaxBodyBytes)
+		}
+
+		body, err := io.ReadAll(r.Body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit),
+				"limit": tooLarge.Limit,
+			})
+			return
+		}
+
+		if err != nil {
+			http.Error(w, "Could not read body", http.StatusBadRequest)
+			return
+		}
+
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+
+		request := events.APIGatewayProxyRequest{
+			Resource:              r.URL.Path,
+			Path:                  r.URL.Path,
+			HTTPMethod:            r.Method,
+			Headers:               firstValues(r.Header),
+			QueryStringParameters: firstValues(r.URL.Query()),
+			Body:                  string(body),
+			RequestContext: events.APIGatewayProxyRequestContext{
+				RequestID:  requestID,
+				HTTPMethod: r.Method,
+				Path:       r.URL.Path,
+				Identity:   events.APIGatewayRequestIdentity{SourceIP: sourceIP(r.RemoteAddr)},
+			},
+		}
+
+		response, err := handler(r.Context(), request)
+		w.Header().Set(RequestIDHeader, requestID)
+
+		// API Gateway answers a 502 when the function fails without a response
+		if err != nil && response.StatusCode == 0 {
+			http.Error(w, "Internal server error", http.StatusBadGateway)
+			return
+		}
+
+		for name, value := range response.Headers {
+			w.Header().Set(name, value)
+		}
+
+		w.WriteHeader(response.StatusCode)
+		w.Write([]byte(response.Body))
+	})
+}
+
+func firstValues(values map[string][]string) map[string]string {
+	first := map[string]string{}
+	for name, all := range values {
+		if len(all) > 0 {
+			first[name] = all[0]
+		}
+	}
+
+	return first
+}
+
+// sourceIP drops the port from the remote address, proxies in front are not trusted to tell the
+// client address
+func sourceIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+
+	return host
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
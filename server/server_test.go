@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptBuildsProxyRequest(t *testing.T) {
+	var received events.APIGatewayProxyRequest
+	handler := func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		received = request
+		return events.APIGatewayProxyResponse{Body: "Created", StatusCode: 201, Headers: map[string]string{"X-Result-Persisted": "false"}}, nil
+	}
+
+	request := httptest.NewRequest("POST", "/mutant?verbose=1", strings.NewReader(`{"dna":[]}`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(RequestIDHeader, "abc-123")
+
+	recorder := httptest.NewRecorder()
+	Adapt(handler).ServeHTTP(recorder, request)
+
+	assert.Equal(t, "POST", received.HTTPMethod)
+	assert.Equal(t, "/mutant", received.Path)
+	assert.Equal(t, `{"dna":[]}`, received.Body)
+	assert.Equal(t, "application/json", received.Headers["Content-Type"])
+	assert.Equal(t, "1", received.QueryStringParameters["verbose"])
+	assert.Equal(t, "abc-123", received.RequestContext.RequestID)
+	assert.Equal(t, "192.0.2.1", received.RequestContext.Identity.SourceIP)
+
+	assert.Equal(t, 201, recorder.Code)
+	assert.Equal(t, "Created", recorder.Body.String())
+	assert.Equal(t, "false", recorder.Header().Get("X-Result-Persisted"))
+	assert.Equal(t, "abc-123", recorder.Header().Get(RequestIDHeader))
+}
+
+func TestAdaptGeneratesRequestID(t *testing.T) {
+	var received events.APIGatewayProxyRequest
+	handler := func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		received = request
+		return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+	}
+
+	recorder := httptest.NewRecorder()
+	Adapt(handler).ServeHTTP(recorder, httptest.NewRequest("GET", "/stats", nil))
+
+	assert.Len(t, received.RequestContext.RequestID, 32)
+	assert.Equal(t, received.RequestContext.RequestID, recorder.Header().Get(RequestIDHeader))
+}
+
+func TestAdaptAnswers502WhenHandlerFails(t *testing.T) {
+	handler := func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{}, errors.New("Boom")
+	}
+
+	recorder := httptest.NewRecorder()
+	Adapt(handler).ServeHTTP(recorder, httptest.NewRequest("GET", "/stats", nil))
+
+	assert.Equal(t, 502, recorder.Code)
+}
+
+func TestAdaptAnswers413WhenBodyIsTooLarge(t *testing.T) {
+	MaxBodyBytes = 8
+	defer func() { MaxBodyBytes = 0 }()
+
+	called := false
+	handler := func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		called = true
+		return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+	}
+
+	recorder := httptest.NewRecorder()
+	Adapt(handler).ServeHTTP(recorder, httptest.NewRequest("POST", "/mutant", strings.NewReader(`{"dna":["A"]}`)))
+
+	assert.False(t, called)
+	assert.Equal(t, 413, recorder.Code)
+	assert.JSONEq(t, `{"error":"Request body is larger than 8 bytes","limit":8}`, recorder.Body.String())
+}
+
+func TestNewMuxServesMetrics(t *testing.T) {
+	mux := NewMux(map[string]Handler{})
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, "text/plain; version=0.0.4", recorder.Header().Get("Content-Type"))
+}
+
+func TestNewMuxRoutesByMethod(t *testing.T) {
+	handler := func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{Body: request.HTTPMethod, StatusCode: 200}, nil
+	}
+
+	mux := NewMux(map[string]Handler{"POST /mutant": handler, "GET /mutant": handler, "GET /stats": handler})
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("POST", "/mutant", nil))
+
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, "POST", recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/mutant", nil))
+
+	assert.Equal(t, 405, recorder.Code)
+	assert.Equal(t, "GET, POST", recorder.Header().Get("Allow"))
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("POST", "/stats", nil))
+
+	assert.Equal(t, 405, recorder.Code)
+	assert.Equal(t, "GET", recorder.Header().Get("Allow"))
+}
+
+func TestHeaderValueIgnoresCase(t *testing.T) {
+	headers := map[string]string{"content-type": "text/plain", "X-API-Key": "abc"}
+
+	assert.Equal(t, "text/plain", HeaderValue(headers, "Content-Type"))
+	assert.Equal(t, "abc", HeaderValue(headers, "x-api-key"))
+	assert.Equal(t, "", HeaderValue(headers, "Authorization"))
+	assert.Equal(t, "", HeaderValue(nil, "Authorization"))
+}
+
+func TestRejectLogsTheRequest(t *testing.T) {
+	var buffer bytes.Buffer
+	logging.SetDefault(logging.New(&buffer, slog.LevelInfo))
+	defer logging.SetDefault(logging.New(os.Stdout, slog.LevelInfo))
+
+	request := events.APIGatewayProxyRequest{RequestContext: events.APIGatewayProxyRequestContext{RequestID: "0d3c7f2e-1a44"}}
+	expected := events.APIGatewayProxyResponse{Body: "Rate limit exceeded", StatusCode: 429, Headers: map[string]string{"Retry-After": "2"}}
+
+	response := Reject(context.Background(), request, expected, nil, "rate_limit_key", "client:4")
+
+	assert.Equal(t, expected, response)
+
+	var line map[string]interface{}
+	assert.Nil(t, json.Unmarshal(buffer.Bytes(), &line))
+	assert.Equal(t, "0d3c7f2e-1a44", line["request_id"])
+	assert.Equal(t, "client:4", line["rate_limit_key"])
+	assert.Equal(t, "Rate limit exceeded", line["error"])
+	assert.Equal(t, float64(429), line["status"])
+
+	buffer.Reset()
+	Reject(context.Background(), request, events.APIGatewayProxyResponse{Body: "Invalid bearer token", StatusCode: 401}, errors.New("Token expired"))
+
+	assert.Nil(t, json.Unmarshal(buffer.Bytes(), &line))
+	assert.Equal(t, "Token expired", line["error"])
+}
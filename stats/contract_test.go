@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/openapi"
+	"github.com/felipefill/mutants/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// The contract tests run the handler and check its responses against the OpenAPI document
+
+func TestContract(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select count\\(id\\) count, type from dna group by type").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "type"}).AddRow(10, "mutant").AddRow(40, "ordinary"))
+	mock.
+		ExpectQuery("select count\\(id\\) count, type from dna group by type").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "type"}))
+	mock.
+		ExpectQuery("select count\\(id\\) count, type from dna group by type").
+		WillReturnError(sqlmock.ErrCancelled)
+
+	for _, statusCode := range []int{200, 200, 500} {
+		response, _ := Handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/stats"})
+
+		assert.Equal(t, statusCode, response.StatusCode, response.Body)
+		assert.Nil(t, openapi.Check("GET", "/stats", response))
+	}
+
+	mock.
+		ExpectQuery("select count\\(id\\) count, type from dna group by type").
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "type"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	response, _ := Handler(ctx, events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/stats"})
+
+	assert.Equal(t, 504, response.StatusCode)
+	assert.Nil(t, openapi.Check("GET", "/stats", response))
+}
+
+// The OpenAPI document is generated from the route table, its fragment must be written again
+// whenever the routes or the types they answer change
+func TestContractDocument(t *testing.T) {
+	openapi.CheckGolden(t, "stats", routes)
+}
@@ -1,10 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"os"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/felipefill/mutants/apikey"
+	"github.com/felipefill/mutants/bearer"
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/logging"
+	"github.com/felipefill/mutants/openapi"
+	"github.com/felipefill/mutants/server"
+	"github.com/felipefill/mutants/tracing"
+	"github.com/felipefill/mutants/utils"
 )
 
 // Response is of type APIGatewayProxyResponse since we're leveraging the
@@ -14,17 +25,74 @@ import (
 type Response events.APIGatewayProxyResponse
 
 // Handler is our lambda handler invoked by the `lambda.Start` function call
-func Handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	stats, err := GetStats()
+func Handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, logger := logging.ForRequest(ctx, request)
+	outcome := logging.Start(logger)
+
+	ctx, span := tracing.Start(tracing.Extract(ctx, request.Headers), "GET /stats", tracing.String("http.request.method", request.HTTPMethod), tracing.String("http.route", "/stats"))
+	defer span.End()
+
+	stats, err := GetStats(ctx)
+	if err != nil {
+		outcome.Fail(err)
+		span.RecordError(err)
+	}
+
+	if err == utils.ErrTimeout {
+		outcome.Done(504)
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: 504}, nil
+	}
+
 	if err != nil {
+		outcome.Done(500)
 		return events.APIGatewayProxyResponse{Body: "Failed to retrieve stats", StatusCode: 500}, err
 	}
 
+	if client, ok := apikey.ClientFromContext(ctx); ok {
+		recordQuery(ctx, client)
+	}
+
 	json, _ := json.Marshal(stats)
 
-	return events.APIGatewayProxyResponse{Body: string(json), StatusCode: 200}, nil
+	outcome.Set("mutants", stats.MutantDNACount)
+	outcome.Set("humans", stats.HumanDNACount)
+	outcome.Done(200)
+
+	return events.APIGatewayProxyResponse{Body: string(json), StatusCode: 200, Headers: map[string]string{"Content-Type": "application/json"}}, nil
 }
 
 func main() {
-	lambda.Start(Handler)
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	config.Set(cfg)
+	logging.Configure(cfg.Logging.Level)
+	tracing.Configure(cfg.Tracing.Exporter, cfg.Tracing.Endpoint, cfg.Tracing.Service)
+
+	if err := utils.PingDB(context.Background()); err != nil {
+		logging.Default().Error("Startup check failed", "error", err.Error())
+		os.Exit(1)
+	}
+
+	keys, err := bearer.Load(cfg.Auth.JWKS, cfg.Auth.JWKSFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	handler := server.Handler(Handler)
+	if cfg.Auth.Required {
+		handler = apikey.Require(&apikey.Authenticator{TTL: time.Duration(cfg.Auth.CacheTTL)}, Handler)
+	}
+
+	// Bearer tokens are checked first, requests without one fall back to API keys
+	if keys != nil {
+		validator := &bearer.Validator{Keys: keys, Issuer: cfg.Auth.Issuer, Audience: cfg.Auth.Audience}
+		handler = bearer.Require(validator, openapi.Scopes(routes), Handler, handler)
+	}
+
+	server.Run(cfg.Server.Addr, handler, openapi.Routes(routes)...)
 }
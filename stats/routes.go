@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/felipefill/mutants/bearer"
+	"github.com/felipefill/mutants/openapi"
+)
+
+// routes are the operations of the function, the OpenAPI document is generated from them. Run
+// go test -update after changing them or the types they answer.
+var routes = []openapi.Operation{
+	{
+		Route:    "GET /stats",
+		Summary:  "Count the DNA classified so far",
+		Security: openapi.Optional(bearer.ScopeStats),
+		Responses: map[int]openapi.Response{
+			200: {Description: "Counts and the ratio of mutants to humans", Body: Stats{}},
+			401: openapi.Unauthorized,
+			403: openapi.Forbidden,
+			500: openapi.Error,
+			503: openapi.Error,
+			504: openapi.Error,
+		},
+	},
+}
@@ -1,46 +1,65 @@
 package main
 
 import (
+	"context"
 	"errors"
 
+	"github.com/felipefill/mutants/apikey"
+	"github.com/felipefill/mutants/logging"
 	"github.com/felipefill/mutants/utils"
 )
 
+var errScan = errors.New("Failed to scan stats")
+
 // Stats struct that holds DNA status information
 type Stats struct {
 	MutantDNACount int     `json:"count_mutant_dna"`
-	HumanDNACount  int     `json:"count_human_dna"`
-	Ratio          float64 `json:"ratio"`
+	HumanDNACount  int     `json:"count_human_dna" doc:"Every DNA classified, mutants included"`
+	Ratio          float64 `json:"ratio" doc:"Mutants to humans, 0 when there are none"`
 }
 
 // GetStats retrieve status regarding the number of mutant and ordinary human DNAs
-func GetStats() (*Stats, error) {
+func GetStats(ctx context.Context) (*Stats, error) {
 	db := utils.GetDB()
 
-	rows, err := db.Query("select count(id) count, type from dna group by type")
-	if err != nil {
-		return nil, errors.New("Failed to query database")
-	}
-
 	mutantCount := 0
 	humanCount := 0
 	ratio := float64(0)
 
-	for rows.Next() {
-		var dnaType string
-		var count int
+	// Counts are reset on every attempt so a retry never adds up partial results
+	err := utils.WithRetry(ctx, func(ctx context.Context) error {
+		mutantCount, humanCount = 0, 0
 
-		err = rows.Scan(&count, &dnaType)
+		rows, err := db.QueryContext(ctx, "select count(id) count, type from dna group by type")
 		if err != nil {
-			return nil, errors.New("Failed to retrieve status")
+			return err
 		}
+		defer rows.Close()
 
-		if dnaType == "mutant" {
-			mutantCount = count
-			humanCount = humanCount + count
-		} else {
-			humanCount = humanCount + count
+		for rows.Next() {
+			var dnaType string
+			var count int
+
+			err = rows.Scan(&count, &dnaType)
+			if err != nil {
+				return errScan
+			}
+
+			if dnaType == "mutant" {
+				mutantCount = count
+				humanCount = humanCount + count
+			} else {
+				humanCount = humanCount + count
+			}
 		}
+
+		return rows.Err()
+	})
+	if err == errScan {
+		return nil, errors.New("Failed to retrieve status")
+	}
+	if err != nil {
+		return nil, utils.DatabaseError(ctx, err, "Failed to query database")
 	}
 
 	if humanCount > 0 {
@@ -55,3 +74,17 @@ func GetStats() (*Stats, error) {
 
 	return stats, nil
 }
+
+// recordQuery attributes a stats query to the client that made it, failing to record it does
+// not fail the query
+func recordQuery(ctx context.Context, client apikey.Client) {
+	db := utils.GetDB()
+
+	err := utils.WithRetry(ctx, func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, "insert into stats_queries(client_id) values($1)", client.ID)
+		return err
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to record stats query", "client_id", client.ID, "error", err.Error())
+	}
+}
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"log/slog"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/felipefill/mutants/apikey"
+	"github.com/felipefill/mutants/logging"
 	"github.com/felipefill/mutants/utils"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -32,7 +41,7 @@ func TestGetStatsSuccess(t *testing.T) {
 		Ratio:          0.2,
 	}
 
-	actualStats, actualError := GetStats()
+	actualStats, actualError := GetStats(context.Background())
 
 	assert.EqualValues(t, expectedStats, actualStats, "Stats are not equal")
 	assert.EqualValues(t, expectedError, actualError, "Error was not as expected")
@@ -57,7 +66,7 @@ func TestGetStatsWithEmptyDatabase(t *testing.T) {
 		Ratio:          0,
 	}
 
-	actualStats, actualError := GetStats()
+	actualStats, actualError := GetStats(context.Background())
 
 	assert.EqualValues(t, expectedStats, actualStats, "Stats are not equal")
 	assert.EqualValues(t, expectedError, actualError, "Error was not as expected")
@@ -75,7 +84,7 @@ func TestGetStatsFailsToQueryDatabase(t *testing.T) {
 	var expectedStats *Stats
 	expectedError := errors.New("Failed to query database")
 
-	actualStats, actualError := GetStats()
+	actualStats, actualError := GetStats(context.Background())
 
 	assert.EqualValues(t, expectedStats, actualStats, "Stats are not equal")
 	assert.EqualValues(t, expectedError, actualError, "Error was not as expected")
@@ -97,7 +106,7 @@ func TestGetStatsFailsToParseResult(t *testing.T) {
 	var expectedStats *Stats
 	expectedError := errors.New("Failed to retrieve status")
 
-	actualStats, actualError := GetStats()
+	actualStats, actualError := GetStats(context.Background())
 
 	assert.EqualValues(t, expectedStats, actualStats, "Stats are not equal")
 	assert.EqualValues(t, expectedError, actualError, "Error was not as expected")
@@ -122,14 +131,66 @@ func TestStatsHandlerSuccess(t *testing.T) {
 	expectedResponse := events.APIGatewayProxyResponse{
 		Body:       "{\"count_mutant_dna\":10,\"count_human_dna\":50,\"ratio\":0.2}",
 		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}
 
-	actualResponse, actualError := Handler(request)
+	actualResponse, actualError := Handler(context.Background(), request)
 
 	assert.EqualValues(t, expectedResponse, actualResponse, "Responses are not equal")
 	assert.EqualValues(t, expectedError, actualError, "Error was not as expected")
 }
 
+func TestStatsHandlerRecordsClientQuery(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select count\\(id\\) count, type from dna group by type").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "type"}).AddRow(10, "mutant"))
+	mock.
+		ExpectExec("insert into stats_queries").
+		WithArgs(int64(4)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	ctx := apikey.WithClient(context.Background(), apikey.Client{ID: 4, Name: "acme"})
+	response, err := Handler(ctx, events.APIGatewayProxyRequest{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestStatsHandlerLogsRequest(t *testing.T) {
+	var buffer bytes.Buffer
+	logging.SetDefault(logging.New(&buffer, slog.LevelInfo))
+	defer logging.SetDefault(logging.New(os.Stdout, slog.LevelInfo))
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select count\\(id\\) count, type from dna group by type").
+		WillReturnError(errors.New("relation \"dna\" does not exist"))
+
+	request := events.APIGatewayProxyRequest{
+		RequestContext: events.APIGatewayProxyRequestContext{RequestID: "0d3c7f2e-1a44"},
+	}
+
+	Handler(context.Background(), request)
+
+	var line map[string]interface{}
+	lines := bytes.Split(bytes.TrimSpace(buffer.Bytes()), []byte("\n"))
+	assert.Nil(t, json.Unmarshal(lines[len(lines)-1], &line))
+	assert.Equal(t, "ERROR", line["level"])
+	assert.Equal(t, "0d3c7f2e-1a44", line["request_id"])
+	assert.Equal(t, float64(500), line["status"])
+	assert.Contains(t, line, "error")
+}
+
 func TestStatsHandlerFailsToRetrieveStats(t *testing.T) {
 	request := events.APIGatewayProxyRequest{}
 	db, mock, _ := sqlmock.New()
@@ -146,8 +207,84 @@ func TestStatsHandlerFailsToRetrieveStats(t *testing.T) {
 	}
 	expectedError := errors.New("Failed to query database")
 
-	actualResponse, actualError := Handler(request)
+	actualResponse, actualError := Handler(context.Background(), request)
 
 	assert.EqualValues(t, expectedResponse, actualResponse, "Responses are not equal")
 	assert.EqualValues(t, expectedError, actualError, "Error was not as expected")
 }
+
+func TestGetStatsTimesOut(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select count\\(id\\) count, type from dna group by type").
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "type"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	actualStats, actualError := GetStats(ctx)
+
+	assert.Nil(t, actualStats)
+	assert.Equal(t, utils.ErrTimeout, actualError)
+}
+
+func TestStatsHandlerTimesOut(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select count\\(id\\) count, type from dna group by type").
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "type"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	expectedResponse := events.APIGatewayProxyResponse{
+		Body:       "Database operation timed out",
+		StatusCode: 504,
+	}
+
+	actualResponse, actualError := Handler(ctx, events.APIGatewayProxyRequest{})
+
+	assert.EqualValues(t, expectedResponse, actualResponse, "Responses are not equal")
+	assert.Nil(t, actualError)
+}
+
+func TestGetStatsRetriesDroppedConnections(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select count\\(id\\) count, type from dna group by type").
+		WillReturnError(&pq.Error{Code: "08006"})
+
+	mock.
+		ExpectQuery("select count\\(id\\) count, type from dna group by type").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"count", "type"}).
+				AddRow(1, "mutant").
+				AddRow(3, "ordinary"),
+		)
+
+	expectedStats := &Stats{
+		HumanDNACount:  4,
+		MutantDNACount: 1,
+		Ratio:          0.25,
+	}
+
+	actualStats, actualError := GetStats(context.Background())
+
+	assert.Nil(t, actualError)
+	assert.EqualValues(t, expectedStats, actualStats)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,281 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exporters that can be picked by name through configuration
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Configure sets the exporter named by configuration, endpoint is only used by otlp
+func Configure(name, endpoint, service string) {
+	switch name {
+	case ExporterStdout:
+		SetExporter(NewStdoutExporter(os.Stdout))
+	case ExporterOTLP:
+		SetExporter(&OTLPExporter{Endpoint: endpoint, Service: service})
+	default:
+		SetExporter(nil)
+	}
+}
+
+// StdoutExporter writes a JSON line per span, which ends up in CloudWatch Logs under Lambda
+type StdoutExporter struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// NewStdoutExporter writes spans to w
+func NewStdoutExporter(w io.Writer) *StdoutExporter {
+	return &StdoutExporter{writer: w}
+}
+
+type stdoutSpan struct {
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Name         string                 `json:"name"`
+	Start        time.Time              `json:"start"`
+	DurationMS   float64                `json:"duration_ms"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+// Export writes given spans
+func (exporter *StdoutExporter) Export(ctx context.Context, spans []SpanData) error {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	encoder := json.NewEncoder(exporter.writer)
+	for _, span := range spans {
+		line := stdoutSpan{
+			TraceID:      span.TraceID,
+			SpanID:       span.SpanID,
+			ParentSpanID: span.ParentSpanID,
+			Name:         span.Name,
+			Start:        span.Start,
+			DurationMS:   float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+			Error:        span.Error,
+		}
+
+		if len(span.Attributes) > 0 {
+			line.Attributes = map[string]interface{}{}
+			for _, attribute := range span.Attributes {
+				line.Attributes[attribute.Key] = attribute.Value
+			}
+		}
+
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// InMemoryExporter keeps spans, it is meant for tests
+type InMemoryExporter struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+// Export keeps given spans
+func (exporter *InMemoryExporter) Export(ctx context.Context, spans []SpanData) error {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	exporter.spans = append(exporter.spans, spans...)
+	return nil
+}
+
+// Spans returns the spans exported so far, in the order they ended
+func (exporter *InMemoryExporter) Spans() []SpanData {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	return append([]SpanData{}, exporter.spans...)
+}
+
+// Named returns the first exported span with given name
+func (exporter *InMemoryExporter) Named(name string) (SpanData, bool) {
+	for _, span := range exporter.Spans() {
+		if span.Name == name {
+			return span, true
+		}
+	}
+
+	return SpanData{}, false
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP over HTTP with JSON bodies
+//
+// https://opentelemetry.io/docs/specs/otlp/#otlphttp
+type OTLPExporter struct {
+	// Endpoint is the collector base URL, such as http://localhost:4318, spans go to /v1/traces
+	Endpoint string
+	// Service is reported as the service.name resource attribute
+	Service string
+	Client  *http.Client
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+// OTLP span kinds and status codes
+const (
+	otlpKindInternal = 1
+	otlpKindServer   = 2
+	otlpStatusError  = 2
+)
+
+// Export posts given spans to the collector
+func (exporter *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(exporter.request(spans))
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(exporter.Endpoint, "/")+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	client := exporter.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("Collector answered %d: %s", response.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
+
+func (exporter *OTLPExporter) request(spans []SpanData) otlpRequest {
+	converted := make([]otlpSpan, len(spans))
+
+	for i, span := range spans {
+		kind := otlpKindInternal
+		if span.Root {
+			kind = otlpKindServer
+		}
+
+		converted[i] = otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentSpanID,
+			Name:              span.Name,
+			Kind:              kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+		}
+
+		if span.Error != "" {
+			converted[i].Status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes([]Attribute{String("service.name", exporter.Service)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/felipefill/mutants"}, Spans: converted}},
+	}}}
+}
+
+func otlpAttributes(attributes []Attribute) []otlpAttribute {
+	converted := []otlpAttribute{}
+
+	for _, attribute := range attributes {
+		value := otlpValue{}
+
+		switch typed := attribute.Value.(type) {
+		case string:
+			value.StringValue = &typed
+		case int64:
+			formatted := strconv.FormatInt(typed, 10)
+			value.IntValue = &formatted
+		case bool:
+			value.BoolValue = &typed
+		case float64:
+			value.DoubleValue = &typed
+		default:
+			formatted := fmt.Sprint(typed)
+			value.StringValue = &formatted
+		}
+
+		converted = append(converted, otlpAttribute{Key: attribute.Key, Value: value})
+	}
+
+	return converted
+}
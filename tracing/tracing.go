@@ -0,0 +1,232 @@
+// Package tracing records spans following the OpenTelemetry model: spans of a trace share a
+// trace id, each one points to its parent and carries attributes. Nothing is recorded until an
+// exporter is set, ended spans are kept until Flush hands them to it.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felipefill/mutants/logging"
+)
+
+// Attribute is a key and a string, int64, bool or float64 value
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a string attribute
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int returns an integer attribute
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData is an ended span as exporters see it
+type SpanData struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Start        time.Time
+	End          time.Time
+	Attributes   []Attribute
+	// Root is set on the first span of this service in the trace, its parent is either none or remote
+	Root bool
+	// Error is the message of the error recorded on the span, if any
+	Error string
+}
+
+// Attribute returns the value of the attribute with given key, or nil
+func (data SpanData) Attribute(key string) interface{} {
+	for i := len(data.Attributes) - 1; i >= 0; i-- {
+		if data.Attributes[i].Key == key {
+			return data.Attributes[i].Value
+		}
+	}
+
+	return nil
+}
+
+// Exporter sends ended spans somewhere
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Span is a timed operation, a nil span is valid and records nothing so callers never have to
+// check whether tracing is enabled
+type Span struct {
+	data   SpanData
+	remote bool
+}
+
+type spanKey struct{}
+
+var (
+	mutex    sync.Mutex
+	exporter Exporter
+	ended    []SpanData
+)
+
+// maxBuffered bounds the spans kept between flushes, later ones are dropped
+const maxBuffered = 2048
+
+// SetExporter sets where spans go, nil disables tracing
+func SetExporter(newExporter Exporter) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	exporter = newExporter
+	ended = nil
+}
+
+func enabled() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return exporter != nil
+}
+
+// Start begins a span, child of the one in ctx if any, and returns a context carrying it
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	if !enabled() {
+		return ctx, nil
+	}
+
+	span := &Span{data: SpanData{Name: name, Start: time.Now(), SpanID: newID(8), Attributes: attributes}}
+
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		span.data.TraceID = parent.data.TraceID
+		span.data.ParentSpanID = parent.data.SpanID
+		span.data.Root = parent.remote
+	} else {
+		span.data.TraceID = newID(16)
+		span.data.Root = true
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Extract continues the trace of the W3C traceparent header in given request headers, such as
+// one sent by an upstream service, spans started from the returned context belong to it.
+// Missing or malformed headers are ignored.
+//
+// https://www.w3.org/TR/trace-context/#traceparent-header
+func Extract(ctx context.Context, headers map[string]string) context.Context {
+	traceparent := ""
+	for name, value := range headers {
+		if strings.EqualFold(name, "traceparent") {
+			traceparent = value
+		}
+	}
+
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 || !isHex(parts[1]) || !isHex(parts[2]) {
+		return ctx
+	}
+
+	if parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return ctx
+	}
+
+	remote := &Span{data: SpanData{TraceID: parts[1], SpanID: parts[2]}, remote: true}
+
+	return context.WithValue(ctx, spanKey{}, remote)
+}
+
+// FromContext returns the span started by this service that ctx carries, or nil
+func FromContext(ctx context.Context) *Span {
+	span, ok := ctx.Value(spanKey{}).(*Span)
+	if !ok || span.remote {
+		return nil
+	}
+
+	return span
+}
+
+// SetAttributes adds attributes, later values win over earlier ones with the same key
+func (span *Span) SetAttributes(attributes ...Attribute) {
+	if span == nil {
+		return
+	}
+
+	span.data.Attributes = append(span.data.Attributes, attributes...)
+}
+
+// RecordError marks the span as failed
+func (span *Span) RecordError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+
+	span.data.Error = err.Error()
+}
+
+// End stops the span and keeps it for the next Flush
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+
+	span.data.End = time.Now()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if exporter != nil && len(ended) < maxBuffered {
+		ended = append(ended, span.data)
+	}
+}
+
+// Flush exports the spans ended since the last call
+func Flush(ctx context.Context) error {
+	mutex.Lock()
+	spans, current := ended, exporter
+	ended = nil
+	mutex.Unlock()
+
+	if current == nil || len(spans) == 0 {
+		return nil
+	}
+
+	return current.Export(ctx, spans)
+}
+
+// Flushing wraps a handler so spans are exported once it returns
+func Flushing[Request, Response any](handler func(context.Context, Request) (Response, error)) func(context.Context, Request) (Response, error) {
+	return func(ctx context.Context, request Request) (Response, error) {
+		defer func() {
+			if err := Flush(ctx); err != nil {
+				logging.FromContext(ctx).Warn("Failed to export spans", "error", err.Error())
+			}
+		}()
+
+		return handler(ctx, request)
+	}
+}
+
+func newID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+func isHex(value string) bool {
+	_, err := hex.DecodeString(value)
+
+	return err == nil && strings.ToLower(value) == value
+}
@@ -0,0 +1,181 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useMemory(t *testing.T) *InMemoryExporter {
+	exporter := &InMemoryExporter{}
+
+	SetExporter(exporter)
+	t.Cleanup(func() { SetExporter(nil) })
+
+	return exporter
+}
+
+func TestSpansAreNotRecordedWithoutExporter(t *testing.T) {
+	SetExporter(nil)
+
+	ctx, span := Start(context.Background(), "ignored")
+	span.SetAttributes(Int("size", 6))
+	span.RecordError(errors.New("Boom"))
+	span.End()
+
+	assert.Nil(t, span)
+	assert.Equal(t, context.Background(), ctx)
+	assert.Nil(t, Flush(context.Background()))
+}
+
+func TestChildSpansShareTheTrace(t *testing.T) {
+	exporter := useMemory(t)
+
+	ctx, root := Start(context.Background(), "POST /mutant", String("http.route", "/mutant"))
+	_, child := Start(ctx, "dna.scan", Int("dna.size", 6))
+	child.SetAttributes(Bool("dna.mutant", true))
+	child.RecordError(errors.New("Boom"))
+	child.End()
+	root.End()
+
+	assert.Empty(t, exporter.Spans())
+	assert.Nil(t, Flush(ctx))
+
+	spans := exporter.Spans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "dna.scan", spans[0].Name)
+	assert.Equal(t, spans[1].TraceID, spans[0].TraceID)
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+	assert.Len(t, spans[1].TraceID, 32)
+	assert.Len(t, spans[1].SpanID, 16)
+	assert.True(t, spans[1].Root)
+	assert.False(t, spans[0].Root)
+	assert.Equal(t, int64(6), spans[0].Attribute("dna.size"))
+	assert.Equal(t, true, spans[0].Attribute("dna.mutant"))
+	assert.Equal(t, "Boom", spans[0].Error)
+	assert.Nil(t, spans[0].Attribute("missing"))
+	assert.False(t, spans[0].End.Before(spans[0].Start))
+}
+
+func TestExtractContinuesRemoteTrace(t *testing.T) {
+	exporter := useMemory(t)
+
+	headers := map[string]string{"Traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	ctx := Extract(context.Background(), headers)
+
+	assert.Nil(t, FromContext(ctx))
+
+	ctx, span := Start(ctx, "GET /stats")
+	assert.Equal(t, span, FromContext(ctx))
+	span.End()
+	Flush(ctx)
+
+	exported, _ := exporter.Named("GET /stats")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", exported.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", exported.ParentSpanID)
+	assert.True(t, exported.Root)
+}
+
+func TestExtractIgnoresMalformedHeaders(t *testing.T) {
+	for _, traceparent := range []string{"", "garbage", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		ctx := Extract(context.Background(), map[string]string{"traceparent": traceparent})
+
+		assert.Equal(t, context.Background(), ctx, traceparent)
+	}
+}
+
+func TestFlushingExportsAfterHandler(t *testing.T) {
+	exporter := useMemory(t)
+
+	handler := Flushing(func(ctx context.Context, name string) (string, error) {
+		_, span := Start(ctx, name)
+		span.End()
+
+		return "done", nil
+	})
+
+	response, err := handler(context.Background(), "job")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "done", response)
+	assert.Len(t, exporter.Spans(), 1)
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var buffer bytes.Buffer
+	SetExporter(NewStdoutExporter(&buffer))
+	defer SetExporter(nil)
+
+	_, span := Start(context.Background(), "dna.validate", Int("dna.size", 6))
+	span.RecordError(errors.New("DNA has invalid bases"))
+	span.End()
+	Flush(context.Background())
+
+	var line map[string]interface{}
+	assert.Nil(t, json.Unmarshal(buffer.Bytes(), &line))
+	assert.Equal(t, "dna.validate", line["name"])
+	assert.Equal(t, map[string]interface{}{"dna.size": float64(6)}, line["attributes"])
+	assert.Equal(t, "DNA has invalid bases", line["error"])
+	assert.Contains(t, line, "duration_ms")
+	assert.NotContains(t, line, "parent_span_id")
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &received)
+	}))
+	defer server.Close()
+
+	exporter := &OTLPExporter{Endpoint: server.URL + "/", Service: "mutants"}
+	SetExporter(exporter)
+	defer SetExporter(nil)
+
+	ctx, root := Start(context.Background(), "POST /mutant")
+	_, child := Start(ctx, "dna.save", String("dna.verdict", "mutant"), Int("dna.size", 6), Bool("dna.found", false))
+	child.RecordError(errors.New("Failed to store DNA"))
+	child.End()
+	root.End()
+
+	assert.Nil(t, Flush(ctx))
+
+	resourceSpans := received["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, []interface{}{map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "mutants"}}}, resourceSpans["resource"].(map[string]interface{})["attributes"])
+
+	spans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	save, post := spans[0].(map[string]interface{}), spans[1].(map[string]interface{})
+
+	assert.Equal(t, "dna.save", save["name"])
+	assert.Equal(t, float64(1), save["kind"])
+	assert.Equal(t, post["spanId"], save["parentSpanId"])
+	assert.Equal(t, map[string]interface{}{"code": float64(2), "message": "Failed to store DNA"}, save["status"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"key": "dna.verdict", "value": map[string]interface{}{"stringValue": "mutant"}},
+		map[string]interface{}{"key": "dna.size", "value": map[string]interface{}{"intValue": "6"}},
+		map[string]interface{}{"key": "dna.found", "value": map[string]interface{}{"boolValue": false}},
+	}, save["attributes"])
+	assert.Equal(t, float64(2), post["kind"])
+	assert.IsType(t, "", post["startTimeUnixNano"])
+}
+
+func TestOTLPExporterReportsCollectorErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	exporter := &OTLPExporter{Endpoint: server.URL}
+
+	assert.Equal(t, errors.New("Collector answered 503: Unavailable"), exporter.Export(context.Background(), []SpanData{{Name: "span"}}))
+}
@@ -1,49 +1,202 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"os"
+	"sync"
+	"time"
 
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/logging"
+	"github.com/felipefill/mutants/metrics"
+	"github.com/felipefill/mutants/retry"
 	_ "github.com/lib/pq" // Postgres driver for database/sql
 )
 
 var _db *sql.DB
+var dbMutex sync.Mutex
 
-// GetDB gets DB connection, in case of failure it will panic
+// queryTimeout bounds every database operation attempt and retryPolicy tells how transient
+// failures are retried, they come from configuration once the pool is opened so both are
+// guarded by dbMutex
+var queryTimeout = 3 * time.Second
+var retryPolicy = retry.Policy{MaxAttempts: 3, BaseDelay: 50 * time.Millisecond, MaxDelay: time.Second}
+
+// queryDuration times each attempt, so retries show up as separate queries
+var queryDuration = metrics.NewHistogram("mutants_db_query_duration_seconds", "Time spent on database operations, by outcome: ok, error or timeout", metrics.DefaultBuckets, "outcome")
+
+// ErrTimeout is returned when a database operation exceeds its deadline
+var ErrTimeout = errors.New("Database operation timed out")
+
+// ErrUnavailable is wrapped by the errors of operations that could not reach the database, so
+// callers can tell an outage from a failed query with errors.Is
+var ErrUnavailable = errors.New("Database is unavailable")
+
+// unavailableError keeps the message callers see while wrapping ErrUnavailable
+type unavailableError struct {
+	message string
+}
+
+func (err unavailableError) Error() string {
+	return err.message
+}
+
+func (err unavailableError) Unwrap() error {
+	return ErrUnavailable
+}
+
+// GetDB gets the connection pool, it is opened once and reused across warm invocations.
+// In case of failure it will panic
 func GetDB() *sql.DB {
+	db, err := ConnectDB()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return db
+}
+
+// ConnectDB is like GetDB but returns an error instead of panicking, callers that can keep
+// working without the database use it
+func ConnectDB() (*sql.DB, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
 	if _db != nil {
-		return _db
+		return _db, nil
 	}
 
-	host, name, user, pswd := getDatabaseInfo()
-	_db, err := sql.Open("postgres", fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=require", user, pswd, host, name))
+	// An invalid configuration is not an outage, degraded mode must not work around it
+	cfg, err := config.Get()
 	if err != nil {
-		panic(fmt.Sprintf("Could not connect to database: %s", err.Error()))
+		return nil, fmt.Errorf("Could not connect to database: %s", err.Error())
+	}
+
+	settings := cfg.Database
+
+	db, err := OpenDB(settings)
+	if err != nil {
+		return nil, unavailableError{"Could not connect to database: " + err.Error()}
+	}
+
+	_db = db
+	queryTimeout = time.Duration(settings.QueryTimeout)
+	retryPolicy = retry.Policy{
+		MaxAttempts: settings.RetryAttempts,
+		BaseDelay:   time.Duration(settings.RetryBaseDelay),
+		MaxDelay:    time.Duration(settings.RetryMaxDelay),
 	}
 
-	return _db
+	return _db, nil
 }
 
-// InjectDatabase uses given database
-func InjectDatabase(database *sql.DB) {
-	_db = database
+// OpenDB opens a connection pool limited by given settings, no connection is made until it is used
+func OpenDB(settings config.Database) (*sql.DB, error) {
+	db, err := sql.Open("postgres", settings.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(settings.MaxOpenConns)
+	db.SetMaxIdleConns(settings.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(settings.ConnMaxLifetime))
+	db.SetConnMaxIdleTime(time.Duration(settings.ConnMaxIdleTime))
+
+	return db, nil
 }
 
-// MustGetEnvVar tries to retrieve given environment variable, in case of failure it will panic
-func MustGetEnvVar(v string) string {
-	envVar := os.Getenv(v)
+// PingDB makes sure the database can be reached, entry points call it on startup
+func PingDB(ctx context.Context) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
 
-	if envVar == "" {
-		panic(fmt.Sprintf("Failed to retrieve %s environment variable", v))
+	if err := GetDB().PingContext(ctx); err != nil {
+		return fmt.Errorf("Could not reach database: %s", err.Error())
 	}
 
-	return envVar
+	return nil
+}
+
+// DBStats returns statistics of the connection pool, such as open and idle connections
+func DBStats() sql.DBStats {
+	return GetDB().Stats()
+}
+
+// WithQueryTimeout derives a context that expires after the configured query timeout
+func WithQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout, _ := querySettings()
+	return context.WithTimeout(ctx, timeout)
+}
+
+// querySettings reads the query timeout and retry policy, requests may be served while another
+// goroutine opens the pool and sets them
+func querySettings() (time.Duration, retry.Policy) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	return queryTimeout, retryPolicy
+}
+
+// WithRetry runs a database operation, retrying transient failures. Each attempt gets its own
+// query timeout, an attempt that runs out of time is not retried and ErrTimeout is returned.
+func WithRetry(ctx context.Context, operation func(ctx context.Context) error) error {
+	_, policy := querySettings()
+
+	return policy.Do(ctx, func() error {
+		attemptCtx, cancel := WithQueryTimeout(ctx)
+		defer cancel()
+
+		start := time.Now()
+		err := operation(attemptCtx)
+		if err != nil && attemptCtx.Err() == context.DeadlineExceeded {
+			err = ErrTimeout
+		}
+
+		queryDuration.Observe(time.Since(start).Seconds(), queryOutcome(err))
+
+		return err
+	})
 }
 
-func getDatabaseInfo() (host string, name string, user string, pswd string) {
-	return MustGetEnvVar("DB_HOST"),
-		MustGetEnvVar("DB_NAME"),
-		MustGetEnvVar("DB_USER"),
-		MustGetEnvVar("DB_PSWD")
+func queryOutcome(err error) string {
+	switch {
+	case err == nil || err == sql.ErrNoRows:
+		return "ok"
+	case err == ErrTimeout:
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+// DatabaseError logs the cause of a failed database operation and returns the error callers see:
+// ErrTimeout is kept so they can tell timeouts apart, any other error becomes one with given
+// message, which wraps ErrUnavailable when the database could not be reached
+func DatabaseError(ctx context.Context, err error, message string) error {
+	logging.FromContext(ctx).Error(message, "error", err.Error())
+
+	switch {
+	case err == ErrTimeout:
+		return ErrTimeout
+	case Unavailable(err):
+		return unavailableError{message}
+	default:
+		return errors.New(message)
+	}
+}
+
+// Unavailable tells whether an error means the database could not be reached, rather than that
+// it failed the operation
+func Unavailable(err error) bool {
+	return errors.Is(err, ErrUnavailable) || errors.Is(err, sql.ErrConnDone) || retry.Connection(err)
+}
+
+// InjectDatabase uses given database
+func InjectDatabase(database *sql.DB) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	_db = database
 }
@@ -1,63 +1,218 @@
 package utils
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/logging"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestMustGetEnvVar(t *testing.T) {
-	varName := "MUST_GET_ENV_VAR_TEST"
-	expected := "yay"
+func TestInjectDatabase(t *testing.T) {
+	db, _, _ := sqlmock.New()
+	defer db.Close()
 
-	os.Setenv(varName, expected)
+	InjectDatabase(db)
 
-	actual := MustGetEnvVar(varName)
+	assert.Equal(t, db, GetDB())
+}
+
+func TestGETDBPanics(t *testing.T) {
 	os.Clearenv()
+	config.Set(nil)
+	_db = nil
+	assert.Panics(t, func() { GetDB() })
+}
+
+func TestConnectDBFailsWithoutPanicking(t *testing.T) {
+	os.Clearenv()
+	config.Set(nil)
+	_db = nil
+
+	db, err := ConnectDB()
+
+	assert.Nil(t, db)
+	assert.Contains(t, err.Error(), "Could not connect to database: Invalid configuration")
+	assert.False(t, errors.Is(err, ErrUnavailable))
+}
 
-	if expected != actual {
-		t.Errorf("Expected %s and got %s", expected, actual)
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.Database.Host = "localhost"
+	cfg.Database.Name = "mutants"
+	cfg.Database.User = "felipefill"
+	cfg.Database.Password = "secret"
+	cfg.Database.MaxOpenConns = 3
+
+	return cfg
+}
+
+func TestGetDBReusesTheSameHandle(t *testing.T) {
+	config.Set(testConfig())
+	defer config.Set(nil)
+	_db = nil
+	defer func() { _db = nil }()
+
+	first := GetDB()
+	second := GetDB()
+
+	assert.NotNil(t, first)
+	assert.True(t, first == second, "GetDB should return the cached pool")
+}
+
+func TestGetDBIsSafeForConcurrentCalls(t *testing.T) {
+	config.Set(testConfig())
+	defer config.Set(nil)
+	_db = nil
+	defer func() { _db = nil }()
+
+	handles := make(chan *sql.DB, 10)
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handles <- GetDB()
+		}()
+	}
+
+	wg.Wait()
+	close(handles)
+
+	first := <-handles
+	for handle := range handles {
+		assert.True(t, first == handle, "Every call should get the same pool")
 	}
 }
 
-func TestMustGetEnvVarPanicsWhenFails(t *testing.T) {
-	os.Clearenv()
-	assert.Panics(t, func() { MustGetEnvVar("ANY_VAR") }, "Code should have panicked")
+func TestQuerySettingsAreSafeWhileConnecting(t *testing.T) {
+	config.Set(testConfig())
+	defer config.Set(nil)
+	_db = nil
+	defer func() { _db = nil }()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			GetDB()
+		}()
+		go func() {
+			defer wg.Done()
+			_, cancel := WithQueryTimeout(context.Background())
+			cancel()
+		}()
+	}
+
+	wg.Wait()
+
+	timeout, policy := querySettings()
+	assert.Equal(t, time.Duration(testConfig().Database.QueryTimeout), timeout)
+	assert.Equal(t, testConfig().Database.RetryAttempts, policy.MaxAttempts)
 }
 
-func TestInjectDatabase(t *testing.T) {
+func TestOpenDBAppliesPoolLimits(t *testing.T) {
+	db, err := OpenDB(testConfig().Database)
+	defer db.Close()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, db.Stats().MaxOpenConnections)
+}
+
+func TestPingDB(t *testing.T) {
+	db, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer db.Close()
+
+	InjectDatabase(db)
+
+	mock.ExpectPing()
+	assert.Nil(t, PingDB(context.Background()))
+
+	mock.ExpectPing().WillReturnError(sql.ErrConnDone)
+	assert.Equal(t, errors.New("Could not reach database: sql: connection is already closed"), PingDB(context.Background()))
+}
+
+func TestDBStats(t *testing.T) {
 	db, _, _ := sqlmock.New()
 	defer db.Close()
 
 	InjectDatabase(db)
+	db.SetMaxOpenConns(4)
 
-	assert.Equal(t, db, GetDB())
+	assert.Equal(t, 4, DBStats().MaxOpenConnections)
 }
 
-func TestGETDBPanics(t *testing.T) {
-	os.Clearenv()
-	_db = nil
-	assert.Panics(t, func() { GetDB() })
+func TestDatabaseError(t *testing.T) {
+	buffer := bytes.Buffer{}
+	ctx := logging.WithLogger(context.Background(), logging.New(&buffer, slog.LevelInfo))
+
+	assert.Equal(t, ErrTimeout, DatabaseError(ctx, ErrTimeout, "Failed"))
+	assert.Equal(t, errors.New("Failed"), DatabaseError(ctx, errors.New("syntax error"), "Failed"))
+	assert.Contains(t, buffer.String(), `"level":"ERROR","msg":"Failed","error":"syntax error"`)
+
+	// Connection failures keep the message and tell the database is unavailable
+	for _, cause := range []error{sql.ErrConnDone, driver.ErrBadConn, unavailableError{"Could not connect to database"}} {
+		err := DatabaseError(ctx, cause, "Failed")
+		assert.Equal(t, "Failed", err.Error())
+		assert.True(t, errors.Is(err, ErrUnavailable), cause.Error())
+	}
 }
 
-func TestGetDatabaseInfo(t *testing.T) {
-	expectedHost := "localhost"
-	expectedName := "mutants"
-	expectedUser := "felipefill"
-	expectedPswd := "secret"
+func TestWithRetryRetriesTransientErrors(t *testing.T) {
+	calls := 0
+	succeeded, failed := queryDuration.Count("ok"), queryDuration.Count("error")
 
-	os.Setenv("DB_HOST", expectedHost)
-	os.Setenv("DB_NAME", expectedName)
-	os.Setenv("DB_USER", expectedUser)
-	os.Setenv("DB_PSWD", expectedPswd)
+	err := WithRetry(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			return driver.ErrBadConn
+		}
 
-	actualHost, actualName, actualUser, actualPswd := getDatabaseInfo()
-	os.Clearenv()
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, succeeded+1, queryDuration.Count("ok"))
+	assert.Equal(t, failed+1, queryDuration.Count("error"))
+}
+
+func TestWithRetryGivesUpOnTimeout(t *testing.T) {
+	defer func(previous time.Duration) { queryTimeout = previous }(queryTimeout)
+	queryTimeout = time.Millisecond
+	calls := 0
+
+	err := WithRetry(context.Background(), func(ctx context.Context) error {
+		calls++
+		<-ctx.Done()
+
+		return ctx.Err()
+	})
+
+	assert.Equal(t, ErrTimeout, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, uint64(1), queryDuration.Count("timeout"))
+}
+
+func TestWithQueryTimeout(t *testing.T) {
+	ctx, cancel := WithQueryTimeout(context.Background())
+	defer cancel()
+
+	deadline, ok := ctx.Deadline()
 
-	assert.Equal(t, expectedHost, actualHost)
-	assert.Equal(t, expectedName, actualName)
-	assert.Equal(t, expectedUser, actualUser)
-	assert.Equal(t, expectedPswd, actualPswd)
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(queryTimeout), deadline, time.Second)
 }
@@ -0,0 +1,280 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Deliverer posts due deliveries to their webhooks
+type Deliverer struct {
+	DB *sql.DB
+	// Client sends the deliveries. The default one refuses to connect to private, loopback and
+	// link-local addresses, whatever the URL resolves to at the time and wherever it redirects to.
+	Client      *http.Client
+	BatchSize   int
+	MaxAttempts int
+	Now         func() time.Time
+}
+
+// body is what webhooks receive
+type body struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type dueDelivery struct {
+	id        int64
+	eventType string
+	payload   []byte
+	attempts  int
+	createdAt time.Time
+	url       string
+	secret    string
+}
+
+// lease is how long claimed deliveries are left alone by other deliverers, it outlasts a batch
+// attempted one after the other with the timeout of the default client
+const lease = 5 * time.Minute
+
+// dueQuery leaves out the deliveries of deactivated webhooks, they stay pending and are sent
+// nowhere
+const dueQuery = "select d.id, d.event_type, d.payload, d.attempts, d.created_at, w.url, w.secret " +
+	"from webhook_deliveries d join webhooks w on w.id = d.webhook_id " +
+	"where d.status = 'pending' and d.next_attempt_at <= now() and w.active "
+
+// DeliverPending attempts a batch of due deliveries and returns how many were attempted. Rows
+// are claimed before they are attempted, so several deliverers can run side by side.
+func (deliverer *Deliverer) DeliverPending(ctx context.Context) (int, error) {
+	return deliverer.deliver(ctx, dueQuery+"order by d.id limit $1 for update of d skip locked", deliverer.batchSize())
+}
+
+// Deliver attempts a single delivery right away if it is pending
+func (deliverer *Deliverer) Deliver(ctx context.Context, id int64) error {
+	attempted, err := deliverer.deliver(ctx, dueQuery+"and d.id = $1 for update of d skip locked", id)
+	if err == nil && attempted == 0 {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (deliverer *Deliverer) deliver(ctx context.Context, query string, args ...interface{}) (int, error) {
+	due, err := deliverer.claim(ctx, query, args...)
+	if err != nil || len(due) == 0 {
+		return 0, err
+	}
+
+	for _, delivery := range due {
+		statusCode, sendErr := deliverer.send(ctx, delivery)
+		if err := deliverer.record(ctx, delivery, statusCode, sendErr); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(due), nil
+}
+
+// claim locks due deliveries only long enough to push their next attempt past the lease, so no
+// transaction is open while webhooks are called. A delivery whose outcome could not be recorded
+// is attempted again once the lease is over.
+func (deliverer *Deliverer) claim(ctx context.Context, query string, args ...interface{}) ([]dueDelivery, error) {
+	tx, err := deliverer.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.New("Failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	due, err := dueDeliveries(ctx, tx, query, args...)
+	if err != nil || len(due) == 0 {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(due))
+	for _, delivery := range due {
+		ids = append(ids, delivery.id)
+	}
+
+	_, err = deliverer.DB.ExecContext(ctx,
+		"update webhook_deliveries set next_attempt_at = now() + $1 * interval '1 second' where id = any($2)",
+		int(lease.Seconds()), pq.Array(ids),
+	)
+	if err != nil {
+		return nil, errors.New("Failed to claim deliveries")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.New("Failed to claim deliveries")
+	}
+
+	return due, nil
+}
+
+func (deliverer *Deliverer) send(ctx context.Context, delivery dueDelivery) (int, error) {
+	payload, err := json.Marshal(body{ID: delivery.id, Type: delivery.eventType, CreatedAt: delivery.createdAt, Data: delivery.payload})
+	if err != nil {
+		return 0, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Mutants-Event", delivery.eventType)
+	request.Header.Set("X-Mutants-Delivery", fmt.Sprint(delivery.id))
+	request.Header.Set(SignatureHeader, SignatureHeaderValue(delivery.secret, deliverer.now().Unix(), payload))
+
+	response, err := deliverer.client().Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return response.StatusCode, fmt.Errorf("Webhook answered %d: %s", response.StatusCode, strings.TrimSpace(string(detail)))
+	}
+
+	return response.StatusCode, nil
+}
+
+// record stores the outcome of an attempt, failures are tried again later until attempts run out
+func (deliverer *Deliverer) record(ctx context.Context, delivery dueDelivery, statusCode int, sendErr error) error {
+	code := sql.NullInt64{Int64: int64(statusCode), Valid: statusCode != 0}
+	attempts := delivery.attempts + 1
+
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = deliverer.DB.ExecContext(ctx,
+			"update webhook_deliveries set status = $1, attempts = $2, last_status_code = $3, last_error = null, delivered_at = now() where id = $4",
+			StatusDelivered, attempts, code, delivery.id,
+		)
+	case attempts >= deliverer.maxAttempts():
+		_, err = deliverer.DB.ExecContext(ctx,
+			"update webhook_deliveries set status = $1, attempts = $2, last_status_code = $3, last_error = $4 where id = $5",
+			StatusFailed, attempts, code, sendErr.Error(), delivery.id,
+		)
+	default:
+		_, err = deliverer.DB.ExecContext(ctx,
+			"update webhook_deliveries set attempts = $1, last_status_code = $2, last_error = $3, "+
+				"next_attempt_at = now() + $4 * interval '1 second' where id = $5",
+			attempts, code, sendErr.Error(), int(Backoff(attempts).Seconds()), delivery.id,
+		)
+	}
+
+	if err != nil {
+		return errors.New("Failed to record delivery")
+	}
+
+	return nil
+}
+
+// Backoff is the wait after given number of failed attempts: 30s doubling up to an hour
+func Backoff(attempts int) time.Duration {
+	wait := 30 * time.Second
+	for i := 1; i < attempts && wait < time.Hour; i++ {
+		wait *= 2
+	}
+
+	if wait > time.Hour {
+		return time.Hour
+	}
+
+	return wait
+}
+
+func (deliverer *Deliverer) batchSize() int {
+	if deliverer.BatchSize < 1 {
+		return 20
+	}
+
+	return deliverer.BatchSize
+}
+
+func (deliverer *Deliverer) maxAttempts() int {
+	if deliverer.MaxAttempts < 1 {
+		return 8
+	}
+
+	return deliverer.MaxAttempts
+}
+
+func (deliverer *Deliverer) now() time.Time {
+	if deliverer.Now == nil {
+		return time.Now()
+	}
+
+	return deliverer.Now()
+}
+
+func (deliverer *Deliverer) client() *http.Client {
+	if deliverer.Client == nil {
+		return defaultClient
+	}
+
+	return deliverer.Client
+}
+
+// defaultClient checks every address it connects to, so a webhook registered with a public name
+// cannot be pointed at an internal address later on
+var defaultClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 5 * time.Second, Control: refuseInternal}).DialContext,
+	},
+}
+
+// refuseInternal runs before each connection is made, once the host is resolved
+func refuseInternal(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || internalIP(ip) {
+		return ErrPrivateURL
+	}
+
+	return nil
+}
+
+func dueDeliveries(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]dueDelivery, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.New("Failed to query deliveries")
+	}
+	defer rows.Close()
+
+	due := []dueDelivery{}
+	for rows.Next() {
+		var delivery dueDelivery
+		err := rows.Scan(&delivery.id, &delivery.eventType, &delivery.payload, &delivery.attempts, &delivery.createdAt, &delivery.url, &delivery.secret)
+		if err != nil {
+			return nil, errors.New("Failed to retrieve delivery")
+		}
+
+		due = append(due, delivery)
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.New("Failed to retrieve delivery")
+	}
+
+	return due, nil
+}
@@ -0,0 +1,168 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSecret = "a-secret-long-enough"
+
+// expectDue answers a due delivery and expects it to be claimed. Outcomes are recorded after
+// the claim is committed, so no transaction is open while webhooks are called.
+func expectDue(mock sqlmock.Sqlmock, url string, attempts int) {
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("select d.id, d.event_type, d.payload, d.attempts, d.created_at, w.url, w.secret from webhook_deliveries d join webhooks w " +
+			"on w.id = d.webhook_id where d.status = 'pending' and d.next_attempt_at <= now\\(\\) and w.active").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "event_type", "payload", "attempts", "created_at", "url", "secret"}).
+				AddRow(5, MutantDetected, []byte(`{"mutant":true}`), attempts, now, url, testSecret),
+		)
+	mock.
+		ExpectExec("update webhook_deliveries set next_attempt_at = now\\(\\) \\+ \\$1 \\* interval '1 second' where id = any\\(\\$2\\)").
+		WithArgs(300, pq.Array([]int64{5})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestDeliverPendingSendsSignedBody(t *testing.T) {
+	var received body
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := io.ReadAll(r.Body)
+
+		assert.Nil(t, Verify(testSecret, r.Header.Get(SignatureHeader), payload, time.Minute, now))
+		assert.Equal(t, MutantDetected, r.Header.Get("X-Mutants-Event"))
+		assert.Equal(t, "5", r.Header.Get("X-Mutants-Delivery"))
+
+		json.Unmarshal(payload, &received)
+	}))
+	defer server.Close()
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	expectDue(mock, server.URL, 0)
+	mock.
+		ExpectExec("update webhook_deliveries set status = \\$1, attempts = \\$2, last_status_code = \\$3, last_error = null, delivered_at = now\\(\\)").
+		WithArgs(StatusDelivered, 1, sql.NullInt64{Int64: 200, Valid: true}, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	deliverer := Deliverer{DB: db, Client: server.Client(), Now: func() time.Time { return now }}
+	attempted, err := deliverer.DeliverPending(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, attempted)
+	assert.Equal(t, body{ID: 5, Type: MutantDetected, CreatedAt: now, Data: json.RawMessage(`{"mutant":true}`)}, received)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDeliverPendingSchedulesRetry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Busy", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	expectDue(mock, server.URL, 1)
+	mock.
+		ExpectExec("update webhook_deliveries set attempts = \\$1, last_status_code = \\$2, last_error = \\$3, next_attempt_at = now\\(\\) \\+ \\$4 \\* interval '1 second'").
+		WithArgs(2, sql.NullInt64{Int64: 503, Valid: true}, "Webhook answered 503: Busy", 60, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	deliverer := Deliverer{DB: db, Client: server.Client()}
+	attempted, err := deliverer.DeliverPending(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, attempted)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDeliverPendingGivesUpAfterMaxAttempts(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	// Nothing listens there
+	expectDue(mock, "http://127.0.0.1:1/hook", 2)
+	mock.
+		ExpectExec("update webhook_deliveries set status = \\$1, attempts = \\$2, last_status_code = \\$3, last_error = \\$4").
+		WithArgs(StatusFailed, 3, sql.NullInt64{}, sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	deliverer := Deliverer{DB: db, MaxAttempts: 3}
+	_, err := deliverer.DeliverPending(context.Background())
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDeliverPendingRefusesInternalAddresses(t *testing.T) {
+	reached := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer server.Close()
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	expectDue(mock, server.URL, 0)
+	mock.
+		ExpectExec("update webhook_deliveries set attempts = \\$1").
+		WithArgs(1, sql.NullInt64{}, sqlmock.AnyArg(), 30, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	deliverer := Deliverer{DB: db}
+	_, err := deliverer.DeliverPending(context.Background())
+
+	assert.Nil(t, err)
+	assert.False(t, reached, "The default client should not connect to a loopback address")
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDeliverUnknownDelivery(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("select d.id").
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "payload", "attempts", "created_at", "url", "secret"}))
+	mock.ExpectRollback()
+
+	deliverer := Deliverer{DB: db}
+
+	assert.Equal(t, ErrNotFound, deliverer.Deliver(context.Background(), 42))
+}
+
+func TestDeliverPendingLeavesClaimedDeliveriesWhenRecordingFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	expectDue(mock, server.URL, 0)
+	mock.
+		ExpectExec("update webhook_deliveries set status = \\$1").
+		WillReturnError(sql.ErrConnDone)
+
+	deliverer := Deliverer{DB: db, Client: server.Client()}
+	attempted, err := deliverer.DeliverPending(context.Background())
+
+	assert.Equal(t, "Failed to record delivery", err.Error())
+	assert.Equal(t, 0, attempted)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
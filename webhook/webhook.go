@@ -0,0 +1,354 @@
+// Package webhook notifies registered URLs of new mutants. Every delivery is a JSON body signed
+// with the subscription secret, it is retried with backoff and its status is recorded so it can
+// be inspected and replayed.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MutantDetected is sent when a new DNA is classified as mutant
+const MutantDetected = "mutant.detected"
+
+// SignatureHeader carries the timestamp and signature of a delivery, as in "t=1700000000,v1=5257a8..."
+const SignatureHeader = "X-Mutants-Signature"
+
+// Errors callers can tell apart
+var (
+	ErrNotFound      = errors.New("Not found")
+	ErrInvalidURL    = errors.New("URL must be an absolute http or https URL")
+	ErrInvalidSecret = errors.New("Secret must have between 16 and 128 characters")
+	ErrPrivateURL    = errors.New("URL must not point to a private, loopback or link-local address")
+)
+
+// lookupHost resolves the host of a webhook when it is registered, tests replace it
+var lookupHost = net.DefaultResolver.LookupIPAddr
+
+// Webhook is a registered subscription, its secret is only shown when it is registered
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Delivery is an attempt, or series of attempts, to notify a webhook
+type Delivery struct {
+	ID             int64      `json:"id"`
+	WebhookID      int        `json:"webhook_id"`
+	EventType      string     `json:"event_type"`
+	Status         string     `json:"status" enum:"pending,delivered,failed"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	LastStatusCode *int       `json:"last_status_code"`
+	LastError      *string    `json:"last_error"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+}
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Execer runs statements, both *sql.DB and *sql.Tx are one
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Register adds a webhook, a secret is generated when none is given
+func Register(ctx context.Context, db *sql.DB, rawURL, secret string) (Webhook, error) {
+	if !validURL(rawURL) {
+		return Webhook{}, ErrInvalidURL
+	}
+
+	if !publicHost(ctx, rawURL) {
+		return Webhook{}, ErrPrivateURL
+	}
+
+	if secret == "" {
+		secret = generateSecret()
+	}
+
+	if len(secret) < 16 || len(secret) > 128 {
+		return Webhook{}, ErrInvalidSecret
+	}
+
+	webhook := Webhook{URL: rawURL, Secret: secret, Active: true}
+	err := db.QueryRowContext(ctx,
+		"insert into webhooks(url, secret) values($1, $2) returning id, created_at", rawURL, secret,
+	).Scan(&webhook.ID, &webhook.CreatedAt)
+	if err != nil {
+		return Webhook{}, errors.New("Failed to register webhook")
+	}
+
+	return webhook, nil
+}
+
+// List returns every webhook without its secret
+func List(ctx context.Context, db *sql.DB) ([]Webhook, error) {
+	rows, err := db.QueryContext(ctx, "select id, url, active, created_at from webhooks order by id")
+	if err != nil {
+		return nil, errors.New("Failed to query webhooks")
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		if err := rows.Scan(&webhook.ID, &webhook.URL, &webhook.Active, &webhook.CreatedAt); err != nil {
+			return nil, errors.New("Failed to retrieve webhook")
+		}
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.New("Failed to retrieve webhook")
+	}
+
+	return webhooks, nil
+}
+
+// Deactivate stops notifying a webhook, its deliveries are kept
+func Deactivate(ctx context.Context, db *sql.DB, id int) error {
+	result, err := db.ExecContext(ctx, "update webhooks set active = false where id = $1", id)
+	if err != nil {
+		return errors.New("Failed to deactivate webhook")
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// EnqueueDeliveries schedules a delivery of given event to every active webhook, pass the
+// transaction of the change so deliveries only exist for committed changes
+func EnqueueDeliveries(ctx context.Context, execer Execer, eventType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = execer.ExecContext(ctx,
+		"insert into webhook_deliveries(webhook_id, event_type, payload) select id, $1, $2 from webhooks where active",
+		eventType, data,
+	)
+
+	return err
+}
+
+// Deliveries returns the latest deliveries of a webhook, newest first
+func Deliveries(ctx context.Context, db *sql.DB, webhookID int, limit int) ([]Delivery, error) {
+	rows, err := db.QueryContext(ctx,
+		"select "+deliveryColumns+" from webhook_deliveries where webhook_id = $1 order by id desc limit $2", webhookID, limit,
+	)
+	if err != nil {
+		return nil, errors.New("Failed to query deliveries")
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.New("Failed to retrieve delivery")
+	}
+
+	return deliveries, nil
+}
+
+// GetDelivery returns a single delivery
+func GetDelivery(ctx context.Context, db *sql.DB, id int64) (Delivery, error) {
+	row := db.QueryRowContext(ctx, "select "+deliveryColumns+" from webhook_deliveries where id = $1", id)
+
+	delivery, err := scanDelivery(row)
+	if err == sql.ErrNoRows {
+		return Delivery{}, ErrNotFound
+	}
+
+	return delivery, err
+}
+
+// Replay makes a delivery pending again with a fresh set of attempts, whatever its status.
+// Deliveries of deactivated webhooks are not replayed, they are not found.
+func Replay(ctx context.Context, db *sql.DB, id int64) error {
+	result, err := db.ExecContext(ctx,
+		"update webhook_deliveries d set status = $1, attempts = 0, next_attempt_at = now() "+
+			"from webhooks w where w.id = d.webhook_id and w.active and d.id = $2", StatusPending, id,
+	)
+	if err != nil {
+		return errors.New("Failed to replay delivery")
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Sign returns the signature of a body sent at given unix time
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignatureHeaderValue formats the value of SignatureHeader
+func SignatureHeaderValue(secret string, timestamp int64, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, Sign(secret, timestamp, body))
+}
+
+// Verify checks a SignatureHeader value, receivers use it to make sure a delivery is genuine
+// and recent. Deliveries older than tolerance are rejected to prevent replay attacks.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp int64
+	var signature string
+
+	for _, part := range strings.Split(header, ",") {
+		pair := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+
+		switch pair[0] {
+		case "t":
+			timestamp, _ = strconv.ParseInt(pair[1], 10, 64)
+		case "v1":
+			signature = pair[1]
+		}
+	}
+
+	if timestamp == 0 || signature == "" {
+		return errors.New("Malformed signature header")
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return errors.New("Signature does not match")
+	}
+
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return errors.New("Signature is too old")
+	}
+
+	return nil
+}
+
+const deliveryColumns = "id, webhook_id, event_type, status, attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDelivery(row scanner) (Delivery, error) {
+	var delivery Delivery
+	var statusCode sql.NullInt64
+	var lastError sql.NullString
+	var deliveredAt sql.NullTime
+
+	err := row.Scan(
+		&delivery.ID, &delivery.WebhookID, &delivery.EventType, &delivery.Status, &delivery.Attempts,
+		&delivery.NextAttemptAt, &statusCode, &lastError, &delivery.CreatedAt, &deliveredAt,
+	)
+	if err == sql.ErrNoRows {
+		return Delivery{}, err
+	}
+
+	if err != nil {
+		return Delivery{}, errors.New("Failed to retrieve delivery")
+	}
+
+	if statusCode.Valid {
+		code := int(statusCode.Int64)
+		delivery.LastStatusCode = &code
+	}
+
+	if lastError.Valid {
+		delivery.LastError = &lastError.String
+	}
+
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+
+	return delivery, nil
+}
+
+func validURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// publicHost tells whether the host of a URL is neither a private, loopback nor link-local address,
+// nor a name resolving to one. A name that cannot be resolved yet passes, deliveries check the
+// address they connect to anyway.
+func publicHost(ctx context.Context, rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return !internalIP(ip)
+	}
+
+	addresses, err := lookupHost(ctx, host)
+	if err != nil {
+		return true
+	}
+
+	for _, address := range addresses {
+		if internalIP(address.IP) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// internalIP tells whether an address is only reachable from inside the network the function runs
+// in, such as the instance metadata endpoint at 169.254.169.254
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+func generateSecret() string {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	return hex.EncodeToString(secret)
+}
@@ -0,0 +1,178 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+func TestRegister(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.
+		ExpectQuery("insert into webhooks\\(url, secret\\) values\\(\\$1, \\$2\\) returning id, created_at").
+		WithArgs("https://alerts.example.com/mutants", "a-secret-long-enough").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, now))
+
+	webhook, err := Register(context.Background(), db, "https://alerts.example.com/mutants", "a-secret-long-enough")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Webhook{ID: 7, URL: "https://alerts.example.com/mutants", Secret: "a-secret-long-enough", Active: true, CreatedAt: now}, webhook)
+}
+
+func TestRegisterGeneratesSecret(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.
+		ExpectQuery("insert into webhooks").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, now))
+
+	webhook, err := Register(context.Background(), db, "https://203.0.113.10:8080/hook", "")
+
+	assert.Nil(t, err)
+	assert.Len(t, webhook.Secret, 64)
+}
+
+func TestRegisterValidates(t *testing.T) {
+	db, _, _ := sqlmock.New()
+	defer db.Close()
+
+	_, err := Register(context.Background(), db, "ftp://example.com", "")
+	assert.Equal(t, ErrInvalidURL, err)
+
+	_, err = Register(context.Background(), db, "/relative", "")
+	assert.Equal(t, ErrInvalidURL, err)
+
+	_, err = Register(context.Background(), db, "https://203.0.113.10", "short")
+	assert.Equal(t, ErrInvalidSecret, err)
+}
+
+func TestRegisterRefusesInternalHosts(t *testing.T) {
+	db, _, _ := sqlmock.New()
+	defer db.Close()
+
+	defer func(previous func(context.Context, string) ([]net.IPAddr, error)) { lookupHost = previous }(lookupHost)
+	lookupHost = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		if host == "metadata.internal.example" {
+			return []net.IPAddr{{IP: net.ParseIP("203.0.113.10")}, {IP: net.ParseIP("169.254.169.254")}}, nil
+		}
+
+		return nil, errors.New("no such host")
+	}
+
+	for _, rawURL := range []string{
+		"http://localhost:8080/hook",
+		"http://api.localhost/hook",
+		"http://127.0.0.1/hook",
+		"http://10.0.0.8/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://[fe80::1]/hook",
+		"http://0.0.0.0/hook",
+		"http://metadata.internal.example/hook",
+	} {
+		_, err := Register(context.Background(), db, rawURL, "")
+		assert.Equal(t, ErrPrivateURL, err, rawURL)
+	}
+}
+
+func TestDeactivateUnknownWebhook(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.
+		ExpectExec("update webhooks set active = false where id = \\$1").
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	assert.Equal(t, ErrNotFound, Deactivate(context.Background(), db, 3))
+}
+
+func TestEnqueueDeliveries(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.
+		ExpectExec("insert into webhook_deliveries\\(webhook_id, event_type, payload\\) select id, \\$1, \\$2 from webhooks where active").
+		WithArgs(MutantDetected, []byte(`{"mutant":true}`)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	err := EnqueueDeliveries(context.Background(), db, MutantDetected, map[string]bool{"mutant": true})
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDeliveries(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.
+		ExpectQuery("select id, webhook_id, event_type, status, attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at from webhook_deliveries where webhook_id = \\$1").
+		WithArgs(7, 100).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "webhook_id", "event_type", "status", "attempts", "next_attempt_at", "last_status_code", "last_error", "created_at", "delivered_at"}).
+				AddRow(2, 7, MutantDetected, StatusDelivered, 1, now, 200, nil, now, now).
+				AddRow(1, 7, MutantDetected, StatusPending, 2, now, 503, "Webhook answered 503", now, nil),
+		)
+
+	deliveries, err := Deliveries(context.Background(), db, 7, 100)
+
+	assert.Nil(t, err)
+	assert.Len(t, deliveries, 2)
+	assert.Equal(t, 200, *deliveries[0].LastStatusCode)
+	assert.Equal(t, now, *deliveries[0].DeliveredAt)
+	assert.Nil(t, deliveries[0].LastError)
+	assert.Equal(t, "Webhook answered 503", *deliveries[1].LastError)
+	assert.Nil(t, deliveries[1].DeliveredAt)
+}
+
+func TestReplay(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.
+		ExpectExec("update webhook_deliveries d set status = \\$1, attempts = 0, next_attempt_at = now\\(\\) from webhooks w where w.id = d.webhook_id and w.active and d.id = \\$2").
+		WithArgs(StatusPending, 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("update webhook_deliveries").
+		WithArgs(StatusPending, 10).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	assert.Nil(t, Replay(context.Background(), db, 9))
+	assert.Equal(t, ErrNotFound, Replay(context.Background(), db, 10))
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	header := SignatureHeaderValue("secret", now.Unix(), body)
+
+	assert.Nil(t, Verify("secret", header, body, 5*time.Minute, now.Add(time.Minute)))
+	assert.Equal(t, errors.New("Signature does not match"), Verify("other", header, body, 5*time.Minute, now))
+	assert.Equal(t, errors.New("Signature does not match"), Verify("secret", header, []byte(`{"id":2}`), 5*time.Minute, now))
+	assert.Equal(t, errors.New("Signature is too old"), Verify("secret", header, body, 5*time.Minute, now.Add(time.Hour)))
+	assert.Equal(t, errors.New("Malformed signature header"), Verify("secret", "garbage", body, 5*time.Minute, now))
+}
+
+// Computed with: printf '1760788800.{"id":1}' | openssl dgst -sha256 -hmac secret
+func TestSignMatchesHMACSHA256(t *testing.T) {
+	assert.Equal(t, "348fbbf030d46118f0bdfeca2de35d918380e8875b6d6395088a26e20446e71c", Sign("secret", 1760788800, []byte(`{"id":1}`)))
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, Backoff(1))
+	assert.Equal(t, time.Minute, Backoff(2))
+	assert.Equal(t, 4*time.Minute, Backoff(4))
+	assert.Equal(t, time.Hour, Backoff(20))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/openapi"
+	"github.com/felipefill/mutants/utils"
+	"github.com/felipefill/mutants/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+// The contract tests run the handler and check its responses against the OpenAPI document
+
+func TestContract(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("insert into webhooks").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, createdAt))
+	mock.
+		ExpectQuery("select id, url, active, created_at from webhooks").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "active", "created_at"}).AddRow(1, "https://alerts.example.com", true, createdAt))
+	mock.
+		ExpectQuery("select id, url, active, created_at from webhooks").
+		WillReturnError(errors.New("connection refused"))
+	mock.
+		ExpectExec("update webhooks set active = false").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("update webhooks set active = false").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectQuery("select .+ from webhook_deliveries where webhook_id = \\$1").
+		WithArgs(1, 100).
+		WillReturnRows(
+			sqlmock.NewRows(deliveryColumns).
+				AddRow(3, 1, webhook.MutantDetected, webhook.StatusFailed, 8, createdAt, 500, "Webhook answered 500", createdAt, nil).
+				AddRow(2, 1, webhook.MutantDetected, webhook.StatusDelivered, 1, createdAt, 200, nil, createdAt, createdAt).
+				AddRow(1, 1, webhook.MutantDetected, webhook.StatusPending, 0, createdAt, nil, nil, createdAt, nil),
+		)
+
+	for _, test := range []struct {
+		request    events.APIGatewayProxyRequest
+		statusCode int
+	}{
+		{events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/webhooks", Body: `{"url": "https://alerts.example.com/mutants", "secret": "a-secret-long-enough"}`}, 201},
+		{events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/webhooks", Body: `{"url": "not a url"}`}, 400},
+		{events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/webhooks", Body: `{`}, 400},
+		{events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/webhooks"}, 200},
+		{events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/webhooks"}, 500},
+		{events.APIGatewayProxyRequest{HTTPMethod: "DELETE", Resource: "/webhooks/{id}", PathParameters: map[string]string{"id": "1"}}, 204},
+		{events.APIGatewayProxyRequest{HTTPMethod: "DELETE", Resource: "/webhooks/{id}", PathParameters: map[string]string{"id": "2"}}, 404},
+		{events.APIGatewayProxyRequest{HTTPMethod: "DELETE", Resource: "/webhooks/{id}", PathParameters: map[string]string{"id": "two"}}, 400},
+		{events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/webhooks/{id}/deliveries", PathParameters: map[string]string{"id": "1"}}, 200},
+		{events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/webhooks/{id}/deliveries", PathParameters: map[string]string{"id": "one"}}, 400},
+		{events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/webhooks/deliveries/{id}/replay", PathParameters: map[string]string{"id": "three"}}, 400},
+	} {
+		response, err := Handler(adminContext(), test.request)
+
+		assert.Nil(t, err)
+		assert.Equal(t, test.statusCode, response.StatusCode, response.Body)
+		assert.Nil(t, openapi.Check(test.request.HTTPMethod, test.request.Resource, response))
+	}
+
+	forbidden := events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/webhooks"}
+	response, _ := Handler(context.Background(), forbidden)
+	assert.Equal(t, 403, response.StatusCode)
+	assert.Nil(t, openapi.Check(forbidden.HTTPMethod, forbidden.Resource, response))
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+// The OpenAPI document is generated from the route table, its fragment must be written again
+// whenever the routes or the types they answer change
+func TestContractDocument(t *testing.T) {
+	openapi.CheckGolden(t, "webhooks", routes)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/felipefill/mutants/apikey"
+	"github.com/felipefill/mutants/bearer"
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/logging"
+	"github.com/felipefill/mutants/metrics"
+	"github.com/felipefill/mutants/openapi"
+	"github.com/felipefill/mutants/utils"
+	"github.com/felipefill/mutants/webhook"
+)
+
+// Response is of type APIGatewayProxyResponse since we're leveraging the
+// AWS Lambda Proxy Request functionality (default behavior)
+//
+// https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
+type Response events.APIGatewayProxyResponse
+
+type registration struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty" doc:"Signs deliveries, one is generated when empty"`
+}
+
+// client sends replayed deliveries, nil is the default one that refuses internal addresses
+var client *http.Client
+
+// Handler manages webhook subscriptions and their deliveries, routes are told apart by the
+// API Gateway resource they were declared with. Webhooks make the function send requests, so
+// like admin operations they are only managed with a token granting the admin scope.
+func Handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !bearer.Granted(ctx, bearer.ScopeAdmin) {
+		return events.APIGatewayProxyResponse{Body: "Admin scope required", StatusCode: 403}, nil
+	}
+
+	route := request.HTTPMethod + " " + request.Resource
+
+	switch route {
+	case "POST /webhooks":
+		return register(ctx, request)
+	case "GET /webhooks":
+		return list(ctx)
+	case "DELETE /webhooks/{id}":
+		return deactivate(ctx, request)
+	case "GET /webhooks/{id}/deliveries":
+		return deliveries(ctx, request)
+	case "POST /webhooks/deliveries/{id}/replay":
+		return replay(ctx, request)
+	default:
+		return events.APIGatewayProxyResponse{Body: "Not found", StatusCode: 404}, nil
+	}
+}
+
+func register(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var body registration
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return events.APIGatewayProxyResponse{Body: "Could not parse webhook", StatusCode: 400}, nil
+	}
+
+	db, err := utils.ConnectDB()
+	if err != nil {
+		return unavailable(ctx, err), nil
+	}
+
+	created, err := webhook.Register(ctx, db, body.URL, body.Secret)
+	if err == webhook.ErrInvalidURL || err == webhook.ErrPrivateURL || err == webhook.ErrInvalidSecret {
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: 400}, nil
+	}
+
+	if err != nil {
+		return errorResponse(err), nil
+	}
+
+	return jsonResponse(201, created), nil
+}
+
+func list(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+	db, err := utils.ConnectDB()
+	if err != nil {
+		return unavailable(ctx, err), nil
+	}
+
+	webhooks, err := webhook.List(ctx, db)
+	if err != nil {
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: 500}, nil
+	}
+
+	return jsonResponse(200, webhooks), nil
+}
+
+func deactivate(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id, err := strconv.Atoi(request.PathParameters["id"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{Body: "Invalid webhook id", StatusCode: 400}, nil
+	}
+
+	db, err := utils.ConnectDB()
+	if err != nil {
+		return unavailable(ctx, err), nil
+	}
+
+	if err := webhook.Deactivate(ctx, db, id); err != nil {
+		return errorResponse(err), nil
+	}
+
+	return events.APIGatewayProxyResponse{Body: "", StatusCode: 204}, nil
+}
+
+func deliveries(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id, err := strconv.Atoi(request.PathParameters["id"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{Body: "Invalid webhook id", StatusCode: 400}, nil
+	}
+
+	db, err := utils.ConnectDB()
+	if err != nil {
+		return unavailable(ctx, err), nil
+	}
+
+	found, err := webhook.Deliveries(ctx, db, id, 100)
+	if err != nil {
+		return errorResponse(err), nil
+	}
+
+	return jsonResponse(200, found), nil
+}
+
+// replay makes a delivery pending again and attempts it right away, the outcome is returned
+func replay(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id, err := strconv.ParseInt(request.PathParameters["id"], 10, 64)
+	if err != nil {
+		return events.APIGatewayProxyResponse{Body: "Invalid delivery id", StatusCode: 400}, nil
+	}
+
+	db, err := utils.ConnectDB()
+	if err != nil {
+		return unavailable(ctx, err), nil
+	}
+
+	if err := webhook.Replay(ctx, db, id); err != nil {
+		return errorResponse(err), nil
+	}
+
+	deliverer := webhook.Deliverer{DB: db, Client: client}
+	if err := deliverer.Deliver(ctx, id); err != nil && err != webhook.ErrNotFound {
+		return errorResponse(err), nil
+	}
+
+	delivery, err := webhook.GetDelivery(ctx, db, id)
+	if err != nil {
+		return errorResponse(err), nil
+	}
+
+	return jsonResponse(200, delivery), nil
+}
+
+func jsonResponse(statusCode int, value interface{}) events.APIGatewayProxyResponse {
+	body, _ := json.Marshal(value)
+
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}
+}
+
+// unavailable answers a request that could not get the database, the cause is only logged
+func unavailable(ctx context.Context, err error) events.APIGatewayProxyResponse {
+	logging.FromContext(ctx).Error("Could not connect to database", "error", err.Error())
+
+	return events.APIGatewayProxyResponse{Body: "Database is unavailable", StatusCode: 503}
+}
+
+func errorResponse(err error) events.APIGatewayProxyResponse {
+	if err == webhook.ErrNotFound {
+		return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: 404}
+	}
+
+	return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: 500}
+}
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	config.Set(cfg)
+	logging.Configure(cfg.Logging.Level)
+
+	if err := utils.PingDB(context.Background()); err != nil {
+		logging.Default().Error("Startup check failed", "error", err.Error())
+		os.Exit(1)
+	}
+
+	keys, err := bearer.Load(cfg.Auth.JWKS, cfg.Auth.JWKSFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	// Bearer tokens are checked first, requests without one fall back to API keys, which never
+	// grant the admin scope but tell who was refused. Without a key set webhooks cannot be managed.
+	handler := apikey.Require(&apikey.Authenticator{TTL: time.Duration(cfg.Auth.CacheTTL)}, Handler)
+	if keys != nil {
+		validator := &bearer.Validator{Keys: keys, Issuer: cfg.Auth.Issuer, Audience: cfg.Auth.Audience}
+		handler = bearer.Require(validator, openapi.Scopes(routes), Handler, handler)
+	}
+
+	lambda.Start(metrics.WithEMF(handler))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/felipefill/mutants/bearer"
+	"github.com/felipefill/mutants/openapi"
+	"github.com/felipefill/mutants/webhook"
+)
+
+// admin is the security of every route, webhooks make the function send requests
+var admin = openapi.Bearer(bearer.ScopeAdmin)
+
+// routes are the operations of the function, the OpenAPI document is generated from them. Run
+// go test -update after changing them or the types they answer.
+var routes = []openapi.Operation{
+	{
+		Route:    "POST /webhooks",
+		Summary:  "Subscribe a webhook to mutant.detected events",
+		Security: admin,
+		Request:  &openapi.Request{Required: true, Content: map[string]interface{}{"application/json": registration{}}},
+		Responses: map[int]openapi.Response{
+			201: {Description: "The webhook, its secret is only shown here", Body: webhook.Webhook{}},
+			400: openapi.BadRequest,
+			401: openapi.Unauthorized,
+			403: openapi.Forbidden,
+			500: openapi.Error,
+			503: openapi.Error,
+		},
+	},
+	{
+		Route:    "GET /webhooks",
+		Summary:  "List webhooks",
+		Security: admin,
+		Responses: map[int]openapi.Response{
+			200: {Description: "Every webhook", Body: []webhook.Webhook{}},
+			401: openapi.Unauthorized,
+			403: openapi.Forbidden,
+			500: openapi.Error,
+			503: openapi.Error,
+		},
+	},
+	{
+		Route:      "DELETE /webhooks/{id}",
+		Summary:    "Deactivate a webhook",
+		Security:   admin,
+		Parameters: map[string]interface{}{"id": 0},
+		Responses: map[int]openapi.Response{
+			204: {Description: "The webhook no longer gets deliveries"},
+			400: openapi.BadRequest,
+			401: openapi.Unauthorized,
+			403: openapi.Forbidden,
+			404: openapi.NotFound,
+			500: openapi.Error,
+			503: openapi.Error,
+		},
+	},
+	{
+		Route:      "GET /webhooks/{id}/deliveries",
+		Summary:    "List the latest deliveries of a webhook",
+		Security:   admin,
+		Parameters: map[string]interface{}{"id": 0},
+		Responses: map[int]openapi.Response{
+			200: {Description: "Up to 100 deliveries, newest first", Body: []webhook.Delivery{}},
+			400: openapi.BadRequest,
+			401: openapi.Unauthorized,
+			403: openapi.Forbidden,
+			404: openapi.NotFound,
+			500: openapi.Error,
+			503: openapi.Error,
+		},
+	},
+	{
+		Route:      "POST /webhooks/deliveries/{id}/replay",
+		Summary:    "Attempt a delivery again right away",
+		Security:   admin,
+		Parameters: map[string]interface{}{"id": int64(0)},
+		Responses: map[int]openapi.Response{
+			200: {Description: "The delivery after the attempt", Body: webhook.Delivery{}},
+			400: openapi.BadRequest,
+			401: openapi.Unauthorized,
+			403: openapi.Forbidden,
+			404: openapi.NotFound,
+			500: openapi.Error,
+			503: openapi.Error,
+		},
+	},
+}
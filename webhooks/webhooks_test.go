@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/felipefill/mutants/apikey"
+	"github.com/felipefill/mutants/bearer"
+	"github.com/felipefill/mutants/config"
+	"github.com/felipefill/mutants/openapi"
+	"github.com/felipefill/mutants/utils"
+	"github.com/felipefill/mutants/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+var createdAt = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+var deliveryColumns = []string{"id", "webhook_id", "event_type", "status", "attempts", "next_attempt_at", "last_status_code", "last_error", "created_at", "delivered_at"}
+
+func adminContext() context.Context {
+	return bearer.WithClaims(context.Background(), bearer.Claims{Subject: "ops", Scopes: []string{bearer.ScopeAdmin}})
+}
+
+func TestWebhooksRequireTheAdminScope(t *testing.T) {
+	for _, ctx := range []context.Context{
+		context.Background(),
+		apikey.WithClient(context.Background(), apikey.Client{ID: 4, Name: "acme"}),
+		bearer.WithClaims(context.Background(), bearer.Claims{Subject: "app", Scopes: []string{bearer.ScopeClassify}}),
+	} {
+		response, err := Handler(ctx, events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/webhooks", Body: `{"url": "https://alerts.example.com/mutants"}`})
+
+		assert.Nil(t, err)
+		assert.Equal(t, events.APIGatewayProxyResponse{Body: "Admin scope required", StatusCode: 403}, response)
+	}
+}
+
+func TestWebhooksAnswer503WithoutDatabase(t *testing.T) {
+	t.Setenv("DB_HOST", "")
+	config.Set(nil)
+	utils.InjectDatabase(nil)
+
+	for _, request := range []events.APIGatewayProxyRequest{
+		{HTTPMethod: "POST", Resource: "/webhooks", Body: `{"url": "https://alerts.example.com/mutants"}`},
+		{HTTPMethod: "GET", Resource: "/webhooks"},
+		{HTTPMethod: "DELETE", Resource: "/webhooks/{id}", PathParameters: map[string]string{"id": "1"}},
+		{HTTPMethod: "GET", Resource: "/webhooks/{id}/deliveries", PathParameters: map[string]string{"id": "1"}},
+		{HTTPMethod: "POST", Resource: "/webhooks/deliveries/{id}/replay", PathParameters: map[string]string{"id": "1"}},
+	} {
+		response, err := Handler(adminContext(), request)
+
+		assert.Nil(t, err)
+		assert.Equal(t, events.APIGatewayProxyResponse{Body: "Database is unavailable", StatusCode: 503}, response)
+		assert.Nil(t, openapi.Check(request.HTTPMethod, request.Resource, response))
+	}
+}
+
+func TestRegisterWebhook(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("insert into webhooks").
+		WithArgs("https://alerts.example.com/mutants", "a-secret-long-enough").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, createdAt))
+
+	request := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Resource:   "/webhooks",
+		Body:       `{"url": "https://alerts.example.com/mutants", "secret": "a-secret-long-enough"}`,
+	}
+
+	response, err := Handler(adminContext(), request)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 201, response.StatusCode)
+	assert.JSONEq(t, `{"id":1,"url":"https://alerts.example.com/mutants","secret":"a-secret-long-enough","active":true,"created_at":"2026-10-18T12:00:00Z"}`, response.Body)
+}
+
+func TestRegisterInvalidWebhook(t *testing.T) {
+	request := events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/webhooks", Body: `{"url": "not a url"}`}
+
+	response, err := Handler(adminContext(), request)
+
+	assert.Nil(t, err)
+	assert.Equal(t, events.APIGatewayProxyResponse{Body: webhook.ErrInvalidURL.Error(), StatusCode: 400}, response)
+
+	request.Body = "{"
+	response, _ = Handler(adminContext(), request)
+
+	assert.Equal(t, events.APIGatewayProxyResponse{Body: "Could not parse webhook", StatusCode: 400}, response)
+}
+
+func TestListWebhooks(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select id, url, active, created_at from webhooks").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "active", "created_at"}).AddRow(1, "https://alerts.example.com", true, createdAt))
+
+	response, err := Handler(adminContext(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/webhooks"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.JSONEq(t, `[{"id":1,"url":"https://alerts.example.com","active":true,"created_at":"2026-10-18T12:00:00Z"}]`, response.Body)
+}
+
+func TestDeactivateWebhook(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectExec("update webhooks set active = false").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("update webhooks set active = false").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	request := events.APIGatewayProxyRequest{HTTPMethod: "DELETE", Resource: "/webhooks/{id}", PathParameters: map[string]string{"id": "1"}}
+	response, _ := Handler(adminContext(), request)
+	assert.Equal(t, 204, response.StatusCode)
+
+	request.PathParameters["id"] = "2"
+	response, _ = Handler(adminContext(), request)
+	assert.Equal(t, 404, response.StatusCode)
+
+	request.PathParameters["id"] = "two"
+	response, _ = Handler(adminContext(), request)
+	assert.Equal(t, 400, response.StatusCode)
+}
+
+func TestListDeliveries(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectQuery("select .+ from webhook_deliveries where webhook_id = \\$1").
+		WithArgs(1, 100).
+		WillReturnRows(sqlmock.NewRows(deliveryColumns).AddRow(3, 1, webhook.MutantDetected, webhook.StatusFailed, 8, createdAt, 500, "Webhook answered 500", createdAt, nil))
+
+	request := events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/webhooks/{id}/deliveries", PathParameters: map[string]string{"id": "1"}}
+	response, err := Handler(adminContext(), request)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Contains(t, response.Body, `"status":"failed"`)
+}
+
+func TestReplayDelivery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// The default client refuses the loopback address the test server listens on
+	client = server.Client()
+	defer func() { client = nil }()
+
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	utils.InjectDatabase(db)
+
+	mock.
+		ExpectExec("update webhook_deliveries d set status = \\$1, attempts = 0").
+		WithArgs(webhook.StatusPending, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("select d.id").
+		WithArgs(3).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "event_type", "payload", "attempts", "created_at", "url", "secret"}).
+				AddRow(3, webhook.MutantDetected, []byte(`{}`), 0, createdAt, server.URL, "a-secret-long-enough"),
+		)
+	mock.
+		ExpectExec("update webhook_deliveries set next_attempt_at").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.
+		ExpectExec("update webhook_deliveries set status = \\$1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectQuery("select .+ from webhook_deliveries where id = \\$1").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(deliveryColumns).AddRow(3, 1, webhook.MutantDetected, webhook.StatusDelivered, 1, createdAt, 200, nil, createdAt, createdAt))
+
+	request := events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/webhooks/deliveries/{id}/replay", PathParameters: map[string]string{"id": "3"}}
+	response, err := Handler(adminContext(), request)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Contains(t, response.Body, `"status":"delivered"`)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestUnknownRoute(t *testing.T) {
+	response, err := Handler(adminContext(), events.APIGatewayProxyRequest{HTTPMethod: "PUT", Resource: "/webhooks"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 404, response.StatusCode)
+}